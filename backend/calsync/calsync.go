@@ -0,0 +1,167 @@
+// Package calsync fetches busy times from external calendars: a plain
+// ICS URL (e.g. a Google/Outlook "secret address") or a CalDAV calendar
+// collection.
+package calsync
+
+import (
+	"bytes"
+	"context"
+	"counseling-webrtc/ical"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Source kinds
+const (
+	KindICS    = "ics"
+	KindCalDAV = "caldav"
+)
+
+// Largest calendar document accepted
+const maxBodySize = 10 << 20
+
+// Source is an external calendar
+type Source struct {
+	Kind     string
+	URL      string
+	Username string // Optional basic auth
+	Password string
+}
+
+// Fetcher fetches sources over HTTP
+type Fetcher struct {
+	Client *http.Client
+	// Location for floating times and unknown TZIDs
+	Location *time.Location
+}
+
+// NewFetcher returns a Fetcher with a 20 second timeout
+func NewFetcher(loc *time.Location) *Fetcher {
+	return &Fetcher{Client: &http.Client{Timeout: 20 * time.Second}, Location: loc}
+}
+
+// Busy returns the busy blocks of src within [from, to)
+func (f *Fetcher) Busy(ctx context.Context, src Source, from, to time.Time) ([]ical.Period, error) {
+	var events []ical.Event
+	var err error
+	switch src.Kind {
+	case KindICS:
+		events, err = f.fetchICS(ctx, src)
+	case KindCalDAV:
+		events, err = f.fetchCalDAV(ctx, src, from, to)
+	default:
+		err = fmt.Errorf("unknown calendar kind %q", src.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ical.BusyPeriods(events, from, to), nil
+}
+
+func (f *Fetcher) fetchICS(ctx context.Context, src Source) ([]ical.Event, error) {
+	// webcal:// is what calendar apps hand out for subscriptions
+	url := src.URL
+	if strings.HasPrefix(url, "webcal://") {
+		url = "https://" + strings.TrimPrefix(url, "webcal://")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+
+	body, err := f.do(req, src)
+	if err != nil {
+		return nil, err
+	}
+	return ical.Parse(bytes.NewReader(body), f.Location)
+}
+
+// calendarQuery asks the server for the events overlapping the window.
+// Recurring events come back as their master and are expanded locally.
+const calendarQuery = `<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <C:calendar-data/>
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="%s" end="%s"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`
+
+type multistatus struct {
+	Responses []struct {
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+func (f *Fetcher) fetchCalDAV(ctx context.Context, src Source, from, to time.Time) ([]ical.Event, error) {
+	query := fmt.Sprintf(calendarQuery, ical.FormatTime(from), ical.FormatTime(to))
+	req, err := http.NewRequestWithContext(ctx, "REPORT", src.URL, strings.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+
+	body, err := f.do(req, src)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms multistatus
+	if err := xml.Unmarshal(body, &ms); err != nil {
+		return nil, fmt.Errorf("invalid CalDAV response: %w", err)
+	}
+
+	var events []ical.Event
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if ps.Prop.CalendarData == "" || (ps.Status != "" && !strings.Contains(ps.Status, " 200 ")) {
+				continue
+			}
+			parsed, err := ical.Parse(strings.NewReader(ps.Prop.CalendarData), f.Location)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, parsed...)
+		}
+	}
+	return events, nil
+}
+
+func (f *Fetcher) do(req *http.Request, src Source) ([]byte, error) {
+	if src.Username != "" {
+		req.SetBasicAuth(src.Username, src.Password)
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("calendar server returned %s", resp.Status)
+	}
+	if len(body) > maxBodySize {
+		return nil, fmt.Errorf("calendar larger than %d bytes", maxBodySize)
+	}
+	return body, nil
+}
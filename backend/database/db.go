@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"time"
 
@@ -65,4 +66,41 @@ func ConnectDB() {
 			log.Println("Added session_notes column to bookings table")
 		}
 	}
+
+	// Feature tables (see migrations.go)
+	for _, stmt := range tableMigrations {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Println("Auto-migration failed:", err)
+		}
+	}
+	for _, m := range columnMigrations {
+		if _, err := db.Exec(fmt.Sprintf("SELECT %s FROM %s LIMIT 1", m.Column, m.Table)); err == nil {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.Table, m.Column, m.Definition)); err != nil {
+			log.Printf("Failed to add %s.%s column: %v", m.Table, m.Column, err)
+		} else {
+			log.Printf("Added %s column to %s table", m.Column, m.Table)
+		}
+	}
+	for _, m := range keyMigrations {
+		var n int
+		err := db.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?)
+				+ (SELECT COUNT(*) FROM information_schema.TABLE_CONSTRAINTS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = ?)
+		`, m.Table, m.Name, m.Table, m.Name).Scan(&n)
+		if err != nil || n > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD %s", m.Table, m.Definition)); err != nil {
+			log.Printf("Failed to add %s to %s table: %v", m.Name, m.Table, err)
+		} else {
+			log.Printf("Added %s to %s table", m.Name, m.Table)
+		}
+	}
+	for _, m := range modifyMigrations {
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", m.Table, m.Column, m.Definition)); err != nil {
+			log.Printf("Failed to modify %s.%s column: %v", m.Table, m.Column, err)
+		}
+	}
 }
@@ -0,0 +1,353 @@
+package database
+
+// tableMigrations are applied on startup by ConnectDB. Keep them in sync
+// with schema.sql and idempotent (CREATE TABLE IF NOT EXISTS).
+var tableMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS chat_messages (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		booking_id INT NOT NULL,
+		sender_role ENUM('client', 'expert') NOT NULL,
+		sender_email VARCHAR(100) NOT NULL,
+		body TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		delivered_at DATETIME NULL,
+		INDEX idx_chat_booking (booking_id, id),
+		FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		recipient_email VARCHAR(100) NOT NULL,
+		type VARCHAR(50) NOT NULL,
+		message TEXT,
+		payload TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		delivered_at DATETIME NULL,
+		read_at DATETIME NULL,
+		INDEX idx_notifications_recipient (recipient_email, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS notification_preferences (
+		email VARCHAR(100) PRIMARY KEY,
+		language VARCHAR(5) NOT NULL DEFAULT 'id',
+		disabled_channels VARCHAR(255) NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_reminders (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		booking_id INT NOT NULL,
+		kind VARCHAR(10) NOT NULL,
+		schedule_time DATETIME NOT NULL,
+		remind_at DATETIME NOT NULL,
+		status ENUM('pending', 'sent', 'cancelled', 'skipped') NOT NULL DEFAULT 'pending',
+		processed_at DATETIME NULL,
+		UNIQUE KEY uniq_reminder (booking_id, kind, schedule_time),
+		INDEX idx_reminders_due (status, remind_at),
+		FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS messaging_opt_ins (
+		phone VARCHAR(20) NOT NULL,
+		channel VARCHAR(20) NOT NULL,
+		opted_in BOOLEAN NOT NULL DEFAULT FALSE,
+		source VARCHAR(20) NOT NULL DEFAULT '',
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (phone, channel)
+	)`,
+	`CREATE TABLE IF NOT EXISTS vapid_keys (
+		id TINYINT PRIMARY KEY,
+		public_key VARCHAR(255) NOT NULL,
+		private_key VARCHAR(255) NOT NULL,
+		created_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS push_subscriptions (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		email VARCHAR(100) NOT NULL,
+		endpoint_hash CHAR(64) NOT NULL UNIQUE,
+		endpoint TEXT NOT NULL,
+		p256dh VARCHAR(255) NOT NULL,
+		auth VARCHAR(255) NOT NULL,
+		user_agent VARCHAR(255),
+		created_at DATETIME NOT NULL,
+		INDEX idx_push_email (email)
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		url VARCHAR(500) NOT NULL,
+		secret VARCHAR(100) NOT NULL,
+		events VARCHAR(255) NOT NULL DEFAULT '*',
+		description VARCHAR(255),
+		is_active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		subscription_id INT NOT NULL,
+		event_id CHAR(36) NOT NULL,
+		event VARCHAR(50) NOT NULL,
+		payload MEDIUMTEXT NOT NULL,
+		status ENUM('pending', 'delivered', 'failed') NOT NULL DEFAULT 'pending',
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NULL,
+		last_status_code INT NULL,
+		last_error TEXT,
+		created_at DATETIME NOT NULL,
+		delivered_at DATETIME NULL,
+		UNIQUE KEY uniq_webhook_event (subscription_id, event_id),
+		INDEX idx_webhook_due (status, next_attempt_at),
+		INDEX idx_webhook_log (subscription_id, id),
+		FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS outbox (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		booking_id INT NOT NULL,
+		kind VARCHAR(20) NOT NULL,
+		payload TEXT NOT NULL,
+		status ENUM('pending', 'done', 'failed') NOT NULL DEFAULT 'pending',
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NULL,
+		last_error TEXT,
+		created_at DATETIME NOT NULL,
+		processed_at DATETIME NULL,
+		INDEX idx_outbox_due (status, next_attempt_at, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS booking_calendar (
+		booking_id INT PRIMARY KEY,
+		sequence INT NOT NULL DEFAULT 0,
+		published BOOLEAN NOT NULL DEFAULT FALSE,
+		updated_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS calendar_feeds (
+		email VARCHAR(100) NOT NULL,
+		role ENUM('client', 'expert') NOT NULL,
+		token_hash CHAR(64) NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (email, role),
+		UNIQUE KEY uq_calendar_feed_token (token_hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS calendar_sources (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		psychologist_id INT NOT NULL,
+		kind ENUM('ics', 'caldav') NOT NULL,
+		url VARCHAR(1000) NOT NULL,
+		username VARCHAR(255) NULL,
+		password VARCHAR(255) NULL,
+		last_synced_at DATETIME NULL,
+		last_error TEXT,
+		next_sync_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_calendar_sources_due (next_sync_at),
+		FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS external_busy_times (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		source_id INT NOT NULL,
+		psychologist_id INT NOT NULL,
+		start_time DATETIME NOT NULL,
+		end_time DATETIME NOT NULL,
+		INDEX idx_busy_psychologist (psychologist_id, start_time),
+		FOREIGN KEY (source_id) REFERENCES calendar_sources(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_types (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		psychologist_id INT NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		duration_minutes INT NOT NULL,
+		price INT NOT NULL DEFAULT 0,
+		modality ENUM('video', 'audio', 'chat') NOT NULL DEFAULT 'video',
+		is_active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at DATETIME NOT NULL,
+		INDEX idx_session_types_psychologist (psychologist_id, is_active),
+		FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_packages (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		psychologist_id INT NOT NULL,
+		session_type_id INT NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		session_count INT NOT NULL,
+		price INT NOT NULL,
+		validity_days INT NOT NULL,
+		is_active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at DATETIME NOT NULL,
+		INDEX idx_session_packages_psychologist (psychologist_id, is_active),
+		FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE,
+		FOREIGN KEY (session_type_id) REFERENCES session_types(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS package_purchases (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		package_id INT NOT NULL,
+		session_type_id INT NOT NULL,
+		client_name VARCHAR(100) NOT NULL,
+		client_contact VARCHAR(100) NOT NULL,
+		sessions_total INT NOT NULL,
+		sessions_used INT NOT NULL DEFAULT 0,
+		sessions_reserved INT NOT NULL DEFAULT 0,
+		price INT NOT NULL,
+		status ENUM('unpaid', 'paid') NOT NULL DEFAULT 'unpaid',
+		paid_at DATETIME NULL,
+		expires_at DATETIME NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_package_purchases_client (client_contact, session_type_id, status),
+		FOREIGN KEY (package_id) REFERENCES session_packages(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS payments (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		booking_id INT NULL,
+		purchase_id INT NULL,
+		order_id VARCHAR(64) NOT NULL UNIQUE,
+		provider VARCHAR(32) NOT NULL,
+		provider_ref VARCHAR(255) NULL,
+		amount INT NOT NULL,
+		status ENUM('pending', 'paid', 'failed', 'expired') NOT NULL DEFAULT 'pending',
+		payment_url VARCHAR(1000) NOT NULL,
+		expires_at DATETIME NOT NULL,
+		paid_at DATETIME NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		INDEX idx_payments_booking (booking_id),
+		INDEX idx_payments_purchase (purchase_id),
+		FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE,
+		CONSTRAINT fk_payments_purchase FOREIGN KEY (purchase_id) REFERENCES package_purchases(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS refunds (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		booking_id INT NULL,
+		payment_id INT NOT NULL,
+		amount INT NOT NULL,
+		fee INT NOT NULL DEFAULT 0,
+		reason VARCHAR(20) NOT NULL,
+		status ENUM('pending', 'succeeded', 'failed') NOT NULL DEFAULT 'pending',
+		refund_key VARCHAR(64) NOT NULL UNIQUE,
+		provider_ref VARCHAR(255) NULL,
+		last_error TEXT,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		INDEX idx_refunds_status (status),
+		FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE,
+		FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS invoice_sequences (
+		year INT PRIMARY KEY,
+		last_number INT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS invoices (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		invoice_number VARCHAR(32) NOT NULL UNIQUE,
+		booking_id INT NOT NULL UNIQUE,
+		payment_id INT NOT NULL,
+		amount INT NOT NULL,
+		client_name VARCHAR(100) NOT NULL,
+		client_contact VARCHAR(100),
+		psychologist_name VARCHAR(100) NOT NULL,
+		license_number VARCHAR(50),
+		description VARCHAR(255) NOT NULL,
+		schedule_time DATETIME NOT NULL,
+		duration_minutes INT NOT NULL,
+		modality VARCHAR(10) NOT NULL,
+		payment_provider VARCHAR(32) NOT NULL,
+		order_id VARCHAR(64) NOT NULL,
+		paid_at DATETIME NOT NULL,
+		issued_at DATETIME NOT NULL,
+		INDEX idx_invoices_issued (issued_at),
+		FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE,
+		FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS sponsors (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(150) NOT NULL,
+		voucher_code VARCHAR(40) NULL UNIQUE,
+		email_domain VARCHAR(150) NULL UNIQUE,
+		sessions_per_employee INT NOT NULL,
+		valid_until DATE NULL,
+		is_active BOOLEAN NOT NULL DEFAULT TRUE,
+		report_token_hash CHAR(64) NULL,
+		created_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS sponsor_email_codes (
+		email VARCHAR(255) PRIMARY KEY,
+		code_hash CHAR(64) NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS earnings (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		booking_id INT NOT NULL UNIQUE,
+		psychologist_id INT NOT NULL,
+		session_time DATETIME NOT NULL,
+		description VARCHAR(255) NOT NULL,
+		source VARCHAR(20) NOT NULL,
+		gross INT NOT NULL,
+		commission_percent INT NOT NULL,
+		commission INT NOT NULL,
+		net INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_earnings_psychologist (psychologist_id, session_time),
+		FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE,
+		FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS payouts (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		psychologist_id INT NOT NULL,
+		amount INT NOT NULL,
+		reference VARCHAR(100) NOT NULL,
+		note TEXT,
+		paid_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_payouts_psychologist (psychologist_id, paid_at),
+		FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS booking_questionnaires (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		booking_id INT NOT NULL,
+		code VARCHAR(30) NOT NULL,
+		version INT NOT NULL,
+		answers TEXT NOT NULL,
+		score INT NOT NULL,
+		severity VARCHAR(30) NOT NULL,
+		created_at DATETIME NOT NULL,
+		UNIQUE KEY uniq_booking_questionnaire (booking_id, code),
+		FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE
+	)`,
+}
+
+// columnMigrations add columns to tables created before them. Each one is
+// applied when selecting the column fails (see session_notes in db.go).
+var columnMigrations = []struct {
+	Table, Column, Definition string
+}{
+	{"bookings", "session_type_id", "INT NULL"},
+	{"bookings", "duration_minutes", "INT NOT NULL DEFAULT 60"},
+	{"bookings", "price", "INT NOT NULL DEFAULT 0"},
+	{"bookings", "modality", "ENUM('video', 'audio', 'chat') NOT NULL DEFAULT 'video'"},
+	{"bookings", "payment_status", "VARCHAR(20) NOT NULL DEFAULT 'not_required'"},
+	{"bookings", "refund_status", "VARCHAR(20) NOT NULL DEFAULT 'none'"},
+	{"bookings", "refund_amount", "INT NOT NULL DEFAULT 0"},
+	{"bookings", "cancellation_fee", "INT NOT NULL DEFAULT 0"},
+	{"bookings", "cancellation_reason", "TEXT NULL"},
+	{"bookings", "cancelled_at", "DATETIME NULL"},
+	{"psychologists", "license_number", "VARCHAR(50) NULL"},
+	{"payments", "purchase_id", "INT NULL"},
+	{"bookings", "credit_purchase_id", "INT NULL"},
+	{"bookings", "credit_status", "VARCHAR(20) NOT NULL DEFAULT 'none'"},
+	{"bookings", "sponsor_id", "INT NULL"},
+	{"bookings", "sponsor_status", "VARCHAR(20) NOT NULL DEFAULT 'none'"},
+	{"bookings", "risk_level", "VARCHAR(10) NOT NULL DEFAULT 'none'"},
+	{"bookings", "risk_reasons", "TEXT NULL"},
+}
+
+// keyMigrations add indexes and foreign keys to tables created before
+// them. Each one is applied when information_schema doesn't list its name.
+var keyMigrations = []struct {
+	Table, Name, Definition string
+}{
+	{"payments", "idx_payments_purchase", "INDEX idx_payments_purchase (purchase_id)"},
+	{"payments", "fk_payments_purchase", "CONSTRAINT fk_payments_purchase FOREIGN KEY (purchase_id) REFERENCES package_purchases(id) ON DELETE CASCADE"},
+}
+
+// modifyMigrations redefine existing columns. MODIFY is idempotent, so
+// they run on every start.
+var modifyMigrations = []struct {
+	Table, Column, Definition string
+}{
+	{"bookings", "status", "ENUM('pending', 'approved', 'rejected', 'completed', 'cancelled') DEFAULT 'pending'"},
+	{"payments", "booking_id", "INT NULL"},
+	{"refunds", "booking_id", "INT NULL"},
+}
@@ -2,6 +2,31 @@
 SET FOREIGN_KEY_CHECKS = 0;
 
 -- Drop tables if they exist (Reset)
+DROP TABLE IF EXISTS booking_questionnaires;
+DROP TABLE IF EXISTS payouts;
+DROP TABLE IF EXISTS earnings;
+DROP TABLE IF EXISTS sponsors;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS invoice_sequences;
+DROP TABLE IF EXISTS refunds;
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS package_purchases;
+DROP TABLE IF EXISTS session_packages;
+DROP TABLE IF EXISTS session_types;
+DROP TABLE IF EXISTS external_busy_times;
+DROP TABLE IF EXISTS calendar_sources;
+DROP TABLE IF EXISTS calendar_feeds;
+DROP TABLE IF EXISTS booking_calendar;
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
+DROP TABLE IF EXISTS push_subscriptions;
+DROP TABLE IF EXISTS vapid_keys;
+DROP TABLE IF EXISTS messaging_opt_ins;
+DROP TABLE IF EXISTS session_reminders;
+DROP TABLE IF EXISTS notification_preferences;
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS chat_messages;
 DROP TABLE IF EXISTS bookings;
 DROP TABLE IF EXISTS psychologist_categories;
 DROP TABLE IF EXISTS psychologists;
@@ -44,6 +69,7 @@ CREATE TABLE IF NOT EXISTS psychologists (
     email VARCHAR(100) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
     bio TEXT,
+    license_number VARCHAR(50) NULL,          -- SIPP, printed on invoices
     is_available BOOLEAN DEFAULT TRUE,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -94,16 +120,429 @@ CREATE TABLE IF NOT EXISTS bookings (
     complaint TEXT,                           -- Additional details from client
     psychologist_id INT NOT NULL,
     schedule_time DATETIME NOT NULL,
-    status ENUM('pending', 'approved', 'rejected', 'completed') DEFAULT 'pending',
+    status ENUM('pending', 'approved', 'rejected', 'completed', 'cancelled') DEFAULT 'pending',
     room_id VARCHAR(100),
     session_notes TEXT,
     chat_history TEXT,
     session_notes TEXT,
+    session_type_id INT NULL,                 -- Chosen session type (NULL: default 60-min video)
+    duration_minutes INT NOT NULL DEFAULT 60, -- Copied from the session type at booking time
+    price INT NOT NULL DEFAULT 0,             -- Rupiah
+    modality ENUM('video', 'audio', 'chat') NOT NULL DEFAULT 'video',
+    payment_status VARCHAR(20) NOT NULL DEFAULT 'not_required', -- not_required, unpaid, paid, credit, sponsored
+    refund_status VARCHAR(20) NOT NULL DEFAULT 'none', -- none, pending, refunded, failed
+    refund_amount INT NOT NULL DEFAULT 0,     -- Rupiah returned to the client
+    cancellation_fee INT NOT NULL DEFAULT 0,  -- Rupiah kept under the cancellation policy
+    cancellation_reason TEXT NULL,            -- Given by the client when cancelling
+    cancelled_at DATETIME NULL,
+    credit_purchase_id INT NULL,              -- Package purchase whose credit pays for this booking
+    credit_status VARCHAR(20) NOT NULL DEFAULT 'none', -- none, reserved, used, returned, forfeited, released
+    sponsor_id INT NULL,                      -- Sponsor (employer/EAP) covering this booking
+    sponsor_status VARCHAR(20) NOT NULL DEFAULT 'none', -- none, reserved, used, released, forfeited
+    risk_level VARCHAR(10) NOT NULL DEFAULT 'none', -- none, high (crisis screening at booking)
+    risk_reasons TEXT NULL,                   -- JSON array: what flagged the booking
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE,
     FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
 );
 
+-- =============================================
+-- CHAT_MESSAGES (In-session chat, persisted per booking)
+-- =============================================
+CREATE TABLE IF NOT EXISTS chat_messages (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    booking_id INT NOT NULL,
+    sender_role ENUM('client', 'expert') NOT NULL,
+    sender_email VARCHAR(100) NOT NULL,
+    body TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    delivered_at DATETIME NULL,               -- NULL until the other participant received it
+    INDEX idx_chat_booking (booking_id, id),
+    FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- NOTIFICATIONS (Persistent inbox, replayed on reconnect)
+-- =============================================
+CREATE TABLE IF NOT EXISTS notifications (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    recipient_email VARCHAR(100) NOT NULL,    -- Client contact or psychologist email
+    type VARCHAR(50) NOT NULL,                -- new_booking, booking_updated, ...
+    message TEXT,
+    payload TEXT NOT NULL,                    -- JSON frame as sent over /api/notify
+    created_at DATETIME NOT NULL,
+    delivered_at DATETIME NULL,               -- NULL until pushed to a live connection
+    read_at DATETIME NULL,
+    INDEX idx_notifications_recipient (recipient_email, id)
+);
+
+-- =============================================
+-- NOTIFICATION PREFERENCES (Channels & language per user)
+-- =============================================
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    email VARCHAR(100) PRIMARY KEY,           -- Client contact or psychologist email
+    language VARCHAR(5) NOT NULL DEFAULT 'id', -- Template language: id, en
+    disabled_channels VARCHAR(255) NOT NULL DEFAULT '' -- Comma separated, e.g. "email"
+);
+
+-- =============================================
+-- SESSION REMINDERS (24h / 15m before approved sessions)
+-- =============================================
+CREATE TABLE IF NOT EXISTS session_reminders (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    booking_id INT NOT NULL,
+    kind VARCHAR(10) NOT NULL,                -- 24h, 15m
+    schedule_time DATETIME NOT NULL,          -- bookings.schedule_time it was created for
+    remind_at DATETIME NOT NULL,              -- UTC
+    status ENUM('pending', 'sent', 'cancelled', 'skipped') NOT NULL DEFAULT 'pending',
+    processed_at DATETIME NULL,
+    UNIQUE KEY uniq_reminder (booking_id, kind, schedule_time),
+    INDEX idx_reminders_due (status, remind_at),
+    FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- MESSAGING OPT-INS (WhatsApp/SMS consent per phone number)
+-- =============================================
+CREATE TABLE IF NOT EXISTS messaging_opt_ins (
+    phone VARCHAR(20) NOT NULL,               -- E.164, e.g. +628123456789
+    channel VARCHAR(20) NOT NULL,             -- whatsapp, sms
+    opted_in BOOLEAN NOT NULL DEFAULT FALSE,
+    source VARCHAR(20) NOT NULL DEFAULT '',   -- booking, api
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (phone, channel)
+);
+
+-- =============================================
+-- WEB PUSH (VAPID key pair & browser subscriptions)
+-- =============================================
+CREATE TABLE IF NOT EXISTS vapid_keys (
+    id TINYINT PRIMARY KEY,                   -- Always 1
+    public_key VARCHAR(255) NOT NULL,         -- base64url, served to browsers
+    private_key VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    email VARCHAR(100) NOT NULL,              -- Client contact or psychologist email
+    endpoint_hash CHAR(64) NOT NULL UNIQUE,   -- SHA-256 of endpoint
+    endpoint TEXT NOT NULL,
+    p256dh VARCHAR(255) NOT NULL,
+    auth VARCHAR(255) NOT NULL,
+    user_agent VARCHAR(255),
+    created_at DATETIME NOT NULL,
+    INDEX idx_push_email (email)
+);
+
+-- =============================================
+-- WEBHOOKS (Booking events for external systems, managed via /api/admin)
+-- =============================================
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    url VARCHAR(500) NOT NULL,
+    secret VARCHAR(100) NOT NULL,             -- HMAC-SHA256 signing key
+    events VARCHAR(255) NOT NULL DEFAULT '*', -- Comma separated (booking.created, ...) or *
+    description VARCHAR(255),
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    subscription_id INT NOT NULL,
+    event_id CHAR(36) NOT NULL,               -- Stable across retries/replays
+    event VARCHAR(50) NOT NULL,
+    payload MEDIUMTEXT NOT NULL,
+    status ENUM('pending', 'delivered', 'failed') NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NULL,            -- UTC; NULL once delivered/failed
+    last_status_code INT NULL,
+    last_error TEXT,
+    created_at DATETIME NOT NULL,
+    delivered_at DATETIME NULL,
+    UNIQUE KEY uniq_webhook_event (subscription_id, event_id),
+    INDEX idx_webhook_due (status, next_attempt_at),
+    INDEX idx_webhook_log (subscription_id, id),
+    FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- OUTBOX (Booking side effects, written in the booking's transaction)
+-- =============================================
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    booking_id INT NOT NULL,
+    kind VARCHAR(20) NOT NULL,                -- notify, webhook, reminders
+    payload TEXT NOT NULL,                    -- JSON, depends on kind
+    status ENUM('pending', 'done', 'failed') NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NULL,            -- UTC; NULL once failed
+    last_error TEXT,
+    created_at DATETIME NOT NULL,
+    processed_at DATETIME NULL,
+    INDEX idx_outbox_due (status, next_attempt_at, id)
+);
+
+-- =============================================
+-- BOOKING_CALENDAR (iCalendar SEQUENCE per booking)
+-- =============================================
+CREATE TABLE IF NOT EXISTS booking_calendar (
+    booking_id INT PRIMARY KEY,
+    sequence INT NOT NULL DEFAULT 0,          -- Bumped on every status change
+    published BOOLEAN NOT NULL DEFAULT FALSE, -- Was approved, so stays in feeds (as cancelled)
+    updated_at DATETIME NOT NULL
+);
+
+-- =============================================
+-- CALENDAR_FEEDS (Secret iCal feed URL per user)
+-- =============================================
+CREATE TABLE IF NOT EXISTS calendar_feeds (
+    email VARCHAR(100) NOT NULL,
+    role ENUM('client', 'expert') NOT NULL,
+    token_hash CHAR(64) NOT NULL,             -- sha256 of the token in the URL
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (email, role),
+    UNIQUE KEY uq_calendar_feed_token (token_hash)
+);
+
+-- =============================================
+-- CALENDAR_SOURCES (Psychologists' external ICS/CalDAV calendars)
+-- =============================================
+CREATE TABLE IF NOT EXISTS calendar_sources (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    psychologist_id INT NOT NULL,
+    kind ENUM('ics', 'caldav') NOT NULL,
+    url VARCHAR(1000) NOT NULL,
+    username VARCHAR(255) NULL,               -- Optional basic auth (CalDAV)
+    password VARCHAR(255) NULL,
+    last_synced_at DATETIME NULL,             -- UTC
+    last_error TEXT,
+    next_sync_at DATETIME NOT NULL,           -- UTC
+    created_at DATETIME NOT NULL,
+    INDEX idx_calendar_sources_due (next_sync_at),
+    FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- EXTERNAL_BUSY_TIMES (Imported busy blocks, excluded from booking)
+-- =============================================
+CREATE TABLE IF NOT EXISTS external_busy_times (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    source_id INT NOT NULL,
+    psychologist_id INT NOT NULL,
+    start_time DATETIME NOT NULL,             -- WIB wall clock, like schedule_time
+    end_time DATETIME NOT NULL,
+    INDEX idx_busy_psychologist (psychologist_id, start_time),
+    FOREIGN KEY (source_id) REFERENCES calendar_sources(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- SESSION_TYPES (Psychologist-defined duration, price and modality)
+-- =============================================
+CREATE TABLE IF NOT EXISTS session_types (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    psychologist_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,               -- e.g. "Intake 30 menit", "Sesi Lanjutan"
+    duration_minutes INT NOT NULL,
+    price INT NOT NULL DEFAULT 0,             -- Rupiah
+    modality ENUM('video', 'audio', 'chat') NOT NULL DEFAULT 'video',
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,  -- Retired types stay for existing bookings
+    created_at DATETIME NOT NULL,
+    INDEX idx_session_types_psychologist (psychologist_id, is_active),
+    FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- SESSION PACKAGES (Prepaid bundles of one session type)
+-- =============================================
+CREATE TABLE IF NOT EXISTS session_packages (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    psychologist_id INT NOT NULL,
+    session_type_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,               -- e.g. "Paket 4 Sesi"
+    session_count INT NOT NULL,               -- Credits granted
+    price INT NOT NULL,                       -- Rupiah for the whole package
+    validity_days INT NOT NULL,               -- Credits expire this long after payment
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,  -- Retired packages stay for existing purchases
+    created_at DATETIME NOT NULL,
+    INDEX idx_session_packages_psychologist (psychologist_id, is_active),
+    FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE,
+    FOREIGN KEY (session_type_id) REFERENCES session_types(id) ON DELETE CASCADE
+);
+
+-- One purchase is one balance of credits
+CREATE TABLE IF NOT EXISTS package_purchases (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    package_id INT NOT NULL,
+    session_type_id INT NOT NULL,             -- Copied from the package
+    client_name VARCHAR(100) NOT NULL,
+    client_contact VARCHAR(100) NOT NULL,     -- Matched against bookings.client_contact
+    sessions_total INT NOT NULL,
+    sessions_used INT NOT NULL DEFAULT 0,     -- Consumed by approved bookings
+    sessions_reserved INT NOT NULL DEFAULT 0, -- Held by pending bookings
+    price INT NOT NULL,
+    status ENUM('unpaid', 'paid') NOT NULL DEFAULT 'unpaid',
+    paid_at DATETIME NULL,
+    expires_at DATETIME NULL,                 -- Set when paid
+    created_at DATETIME NOT NULL,
+    INDEX idx_package_purchases_client (client_contact, session_type_id, status),
+    FOREIGN KEY (package_id) REFERENCES session_packages(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- PAYMENTS (Payment intents for priced bookings)
+-- =============================================
+CREATE TABLE IF NOT EXISTS payments (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    booking_id INT NULL,                      -- Either a booking...
+    purchase_id INT NULL,                     -- ...or a package purchase
+    order_id VARCHAR(64) NOT NULL UNIQUE,     -- Our reference at the provider; new one per attempt
+    provider VARCHAR(32) NOT NULL,            -- midtrans, fake
+    provider_ref VARCHAR(255) NULL,           -- Provider's transaction/token id
+    amount INT NOT NULL,                      -- Rupiah
+    status ENUM('pending', 'paid', 'failed', 'expired') NOT NULL DEFAULT 'pending',
+    payment_url VARCHAR(1000) NOT NULL,       -- Checkout page the client is sent to
+    expires_at DATETIME NOT NULL,
+    paid_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    INDEX idx_payments_booking (booking_id),
+    INDEX idx_payments_purchase (purchase_id),
+    FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE,
+    CONSTRAINT fk_payments_purchase FOREIGN KEY (purchase_id) REFERENCES package_purchases(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- REFUNDS (Money returned for rejected/cancelled bookings)
+-- =============================================
+CREATE TABLE IF NOT EXISTS refunds (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    booking_id INT NULL,                      -- NULL for package purchases
+    payment_id INT NOT NULL,                  -- The paid intent being refunded
+    amount INT NOT NULL,                      -- Rupiah refunded
+    fee INT NOT NULL DEFAULT 0,               -- Rupiah kept (cancellation fee)
+    reason VARCHAR(20) NOT NULL,              -- rejected, cancelled, duplicate, mismatch
+    status ENUM('pending', 'succeeded', 'failed') NOT NULL DEFAULT 'pending',
+    refund_key VARCHAR(64) NOT NULL UNIQUE,   -- Idempotency key at the provider
+    provider_ref VARCHAR(255) NULL,
+    last_error TEXT,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    INDEX idx_refunds_status (status),
+    FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE,
+    FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- INVOICES (One per paid booking, numbered INV/<year>/<seq>)
+-- =============================================
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+    year INT PRIMARY KEY,
+    last_number INT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    invoice_number VARCHAR(32) NOT NULL UNIQUE,
+    booking_id INT NOT NULL UNIQUE,
+    payment_id INT NOT NULL,
+    amount INT NOT NULL,                      -- Rupiah
+    -- Snapshot at issue time; later profile/booking edits don't change it
+    client_name VARCHAR(100) NOT NULL,
+    client_contact VARCHAR(100),
+    psychologist_name VARCHAR(100) NOT NULL,
+    license_number VARCHAR(50),
+    description VARCHAR(255) NOT NULL,        -- Session type name
+    schedule_time DATETIME NOT NULL,
+    duration_minutes INT NOT NULL,
+    modality VARCHAR(10) NOT NULL,
+    payment_provider VARCHAR(32) NOT NULL,
+    order_id VARCHAR(64) NOT NULL,
+    paid_at DATETIME NOT NULL,
+    issued_at DATETIME NOT NULL,
+    INDEX idx_invoices_issued (issued_at),
+    FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE,
+    FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- SPONSORS (Employers/EAP covering sessions for their employees)
+-- =============================================
+CREATE TABLE IF NOT EXISTS sponsors (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(150) NOT NULL,
+    voucher_code VARCHAR(40) NULL UNIQUE,     -- Upper case; entered by the employee when booking
+    email_domain VARCHAR(150) NULL UNIQUE,    -- Lower case; addresses verified by email code, and restricts voucher_code
+    sessions_per_employee INT NOT NULL,       -- Quota per client contact over the contract
+    valid_until DATE NULL,                    -- Last session date covered (WIB)
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    report_token_hash CHAR(64) NULL,          -- SHA-256 of the sponsor's report token
+    created_at DATETIME NOT NULL
+);
+
+-- One-time codes proving a client reads an address of a sponsored domain
+CREATE TABLE IF NOT EXISTS sponsor_email_codes (
+    email VARCHAR(255) PRIMARY KEY,           -- Lower case
+    code_hash CHAR(64) NOT NULL,              -- SHA-256 of the six-digit code
+    attempts INT NOT NULL DEFAULT 0,          -- Wrong guesses; void after 5
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL              -- When it was mailed; no new code within a minute
+);
+
+-- =============================================
+-- EARNINGS & PAYOUTS (What the platform owes psychologists)
+-- =============================================
+-- One entry per completed session that was paid (money, package credit
+-- or sponsor), with the commission that applied when it was recorded
+CREATE TABLE IF NOT EXISTS earnings (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    booking_id INT NOT NULL UNIQUE,
+    psychologist_id INT NOT NULL,
+    session_time DATETIME NOT NULL,           -- Wall clock WIB, copied from the booking
+    description VARCHAR(255) NOT NULL,        -- Session type name
+    source VARCHAR(20) NOT NULL,              -- paid, credit, sponsored
+    gross INT NOT NULL,                       -- Rupiah; package credits count their share of the package price
+    commission_percent INT NOT NULL,
+    commission INT NOT NULL,
+    net INT NOT NULL,                         -- Owed to the psychologist
+    created_at DATETIME NOT NULL,
+    INDEX idx_earnings_psychologist (psychologist_id, session_time),
+    FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE,
+    FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE
+);
+
+-- Transfers to psychologists, recorded by admins
+CREATE TABLE IF NOT EXISTS payouts (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    psychologist_id INT NOT NULL,
+    amount INT NOT NULL,                      -- Rupiah
+    reference VARCHAR(100) NOT NULL,          -- Bank transfer reference
+    note TEXT,
+    paid_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_payouts_psychologist (psychologist_id, paid_at),
+    FOREIGN KEY (psychologist_id) REFERENCES psychologists(id) ON DELETE CASCADE
+);
+
+-- =============================================
+-- INTAKE QUESTIONNAIRES (PHQ-9, GAD-7, ...)
+-- =============================================
+-- Answers submitted with a booking, scored when it was created. Definitions
+-- live in backend/questionnaire/definitions.
+CREATE TABLE IF NOT EXISTS booking_questionnaires (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    booking_id INT NOT NULL,
+    code VARCHAR(30) NOT NULL,                -- phq9, gad7
+    version INT NOT NULL,                     -- Definition version used for scoring
+    answers TEXT NOT NULL,                    -- JSON array, one value per item
+    score INT NOT NULL,
+    severity VARCHAR(30) NOT NULL,            -- Band at scoring time, e.g. moderate
+    created_at DATETIME NOT NULL,
+    UNIQUE KEY uniq_booking_questionnaire (booking_id, code),
+    FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE
+);
+
 -- =============================================
 -- SEED DATA
 -- =============================================
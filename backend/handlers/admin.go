@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth guards /api/admin with a shared token from ADMIN_TOKEN, sent as
+// "X-Admin-Token". Admin routes are disabled when ADMIN_TOKEN is not set.
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := os.Getenv("ADMIN_TOKEN")
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Admin API is disabled (ADMIN_TOKEN not set)"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Token")), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
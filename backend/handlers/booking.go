@@ -3,9 +3,12 @@ package handlers
 import (
 	"counseling-webrtc/database"
 	"counseling-webrtc/models"
+	"counseling-webrtc/risk"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -57,27 +60,12 @@ func GetPsychologists(c *gin.Context) {
 	dateParam := c.Query("date")         // YYYY-MM-DD
 	timeParam := c.Query("time")         // HH:MM
 
-	// Pre-fetch booked psychologists for this slot if date/time provided
-	bookedPsychologists := make(map[int]bool)
+	// Availability of each session type is checked when date/time provided
+	var slotStart time.Time
+	hasSlot := false
 	if dateParam != "" && timeParam != "" {
-		// Construct format matching DB (assuming ISO string from frontend ends up as DATETIME)
-		// Frontend sends: 2026-02-13T10:00:00Z
-		// We need to match precise string or range?
-		// Best to construct the string exactly as frontend sends it or handle generic DATETIME match
-		// Since frontend sends `${dateStr}T${data.selectedTime}:00Z`, let's construct that
-		targetTime := fmt.Sprintf("%sT%s:00Z", dateParam, timeParam)
-
-		rows, err := database.DB.Query(`
-			SELECT psychologist_id FROM bookings 
-			WHERE schedule_time = ? AND status IN ('pending', 'approved')
-		`, targetTime)
-		if err == nil {
-			defer rows.Close()
-			for rows.Next() {
-				var pid int
-				rows.Scan(&pid)
-				bookedPsychologists[pid] = true
-			}
+		if t, err := parseScheduleTime(dateParam + "T" + timeParam); err == nil {
+			slotStart, hasSlot = t, true
 		}
 	}
 
@@ -121,10 +109,21 @@ func GetPsychologists(c *gin.Context) {
 
 		// Fetch schedules
 		p.Schedules = getPsychologistSchedules(p.ID)
+		p.SessionTypes = offeredSessionTypes(p.ID)
 
-		// Check conflict
-		if bookedPsychologists[p.ID] {
+		// Check conflict: booked when no session type fits the slot
+		if hasSlot {
 			p.IsBooked = true
+			for i := range p.SessionTypes {
+				available, err := slotAvailable(p.ID, p.Schedules, slotStart, p.SessionTypes[i].DurationMinutes)
+				if err != nil {
+					fmt.Println("Availability error:", err)
+				}
+				p.SessionTypes[i].Available = &available
+				if available {
+					p.IsBooked = false
+				}
+			}
 		}
 
 		psychologists = append(psychologists, p)
@@ -214,12 +213,18 @@ func formatSpecialties(categories []models.Category) string {
 // CreateBooking handles booking requests
 func CreateBooking(c *gin.Context) {
 	var input struct {
-		ClientName     string `json:"client_name" binding:"required"`
-		ClientContact  string `json:"client_contact" binding:"required"`
-		CategoryID     int    `json:"category_id" binding:"required"`
-		Complaint      string `json:"complaint"` // Additional details (optional)
-		PsychologistID int    `json:"psychologist_id" binding:"required"`
-		ScheduleTime   string `json:"schedule_time" binding:"required"`
+		ClientName       string `json:"client_name" binding:"required"`
+		ClientContact    string `json:"client_contact" binding:"required"`
+		CategoryID       int    `json:"category_id" binding:"required"`
+		Complaint        string `json:"complaint"` // Additional details (optional)
+		PsychologistID   int    `json:"psychologist_id" binding:"required"`
+		ScheduleTime     string `json:"schedule_time" binding:"required"`
+		SessionTypeID    int    `json:"session_type_id"`    // Optional when the psychologist offers a single type
+		WhatsAppOptIn    bool   `json:"whatsapp_opt_in"`    // Consent to WhatsApp notifications (phone contacts only)
+		SponsorCode      string `json:"sponsor_code"`       // Employer/EAP voucher
+		SponsorEmailCode string `json:"sponsor_email_code"` // Code mailed to a sponsored email domain, instead of a voucher
+
+		Questionnaires []questionnaireAnswers `json:"questionnaires"` // Optional intake questionnaires (GET /api/public/questionnaires)
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -227,35 +232,150 @@ func CreateBooking(c *gin.Context) {
 		return
 	}
 
-	// Check for existing booking (conflict check)
-	var existingID int
-	err := database.DB.QueryRow("SELECT id FROM bookings WHERE psychologist_id = ? AND schedule_time = ? AND status IN ('pending', 'approved')", input.PsychologistID, input.ScheduleTime).Scan(&existingID)
-	if err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "Jadwal ini sudah dibooking oleh orang lain."})
+	start, err := parseScheduleTime(input.ScheduleTime)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule_time"})
+		return
+	}
+	sessionType, err := resolveSessionType(input.PsychologistID, input.SessionTypeID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Jenis sesi tidak valid: " + err.Error()})
+		return
+	}
+	intake, err := scoreQuestionnaires(input.Questionnaires)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Kuesioner tidak valid: " + err.Error()})
+		return
+	}
+	// Crisis screening (self-harm keywords, risk items such as PHQ-9 item 9)
+	assessment := risk.Screen(input.Complaint, intake)
+
+	// From here on a flagged client gets crisis support and the team is
+	// alerted even when the booking fails
+	fail := func(code int, message string) {
+		response := gin.H{"error": message}
+		if assessment.High() {
+			response["crisis"] = crisisSupport()
+			alertHighRiskRequest(input.PsychologistID, input.ClientName, input.ClientContact, input.ScheduleTime, assessment)
+		}
+		c.JSON(code, response)
+	}
+
+	// Check for overlapping bookings, busy times and practice hours (conflict check)
+	available, err := slotAvailable(input.PsychologistID, getPsychologistSchedules(input.PsychologistID), start, sessionType.DurationMinutes)
+	if err != nil {
+		fail(http.StatusInternalServerError, "Failed to create booking: "+err.Error())
+		return
+	}
+	if !available {
+		fail(http.StatusConflict, "Jadwal ini sudah dibooking oleh orang lain atau psikolog tidak tersedia.")
 		return
 	}
 
-	query := `INSERT INTO bookings (client_name, client_contact, category_id, complaint, psychologist_id, schedule_time, status) 
-			  VALUES (?, ?, ?, ?, ?, ?, 'pending')`
-	res, err := database.DB.Exec(query, input.ClientName, input.ClientContact, input.CategoryID, input.Complaint, input.PsychologistID, input.ScheduleTime)
+	// Booking and its side effects (outbox) are committed together
+	tx, err := database.DB.Begin()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking: " + err.Error()})
+		fail(http.StatusInternalServerError, "Failed to create booking: "+err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	var sessionTypeID interface{}
+	if sessionType.ID != 0 {
+		sessionTypeID = sessionType.ID
+	}
+
+	// A priced session is covered by the client's sponsor, or else paid with
+	// a package credit they hold
+	paymentStatus, creditStatus, sponsorStatus := initialPaymentStatus(sessionType.Price), creditNone, sponsorNone
+	var creditPurchaseID, sponsorID interface{}
+	if sessionType.ID != 0 && sessionType.Price > 0 {
+		sponsor, err := reserveSponsorship(tx, input.ClientContact, input.SponsorCode, input.SponsorEmailCode, start)
+		if isSponsorRefusal(err) {
+			fail(http.StatusBadRequest, "Kode sponsor tidak dapat digunakan: "+err.Error())
+			return
+		}
+		if err != nil {
+			fail(http.StatusInternalServerError, "Failed to create booking: "+err.Error())
+			return
+		}
+		if sponsor != 0 {
+			paymentStatus, sponsorStatus, sponsorID = paymentSponsored, sponsorReserved, sponsor
+		}
+	}
+	if paymentStatus == paymentUnpaid {
+		purchaseID, err := reserveCredit(tx, input.ClientContact, sessionType.ID, start)
+		if err != nil {
+			fail(http.StatusInternalServerError, "Failed to create booking: "+err.Error())
+			return
+		}
+		if purchaseID != 0 {
+			paymentStatus, creditStatus, creditPurchaseID = paymentCredit, creditReserved, purchaseID
+		}
+	}
+
+	query := `INSERT INTO bookings (client_name, client_contact, category_id, complaint, psychologist_id, schedule_time, status,
+				session_type_id, duration_minutes, price, modality, payment_status, credit_purchase_id, credit_status, sponsor_id, sponsor_status,
+				risk_level, risk_reasons) 
+			  VALUES (?, ?, ?, ?, ?, ?, 'pending', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := tx.Exec(query, input.ClientName, input.ClientContact, input.CategoryID, input.Complaint, input.PsychologistID, start.Format(wallClockFormat),
+		sessionTypeID, sessionType.DurationMinutes, sessionType.Price, sessionType.Modality, paymentStatus, creditPurchaseID, creditStatus,
+		sponsorID, sponsorStatus, assessment.Level, riskReasonsValue(assessment))
+	if err != nil {
+		fail(http.StatusInternalServerError, "Failed to create booking: "+err.Error())
 		return
 	}
 
 	id, _ := res.LastInsertId()
 
+	if err := saveQuestionnaires(tx, id, intake); err != nil {
+		fail(http.StatusInternalServerError, "Failed to create booking: "+err.Error())
+		return
+	}
+
 	// Notify Psychologist
 	var psychoEmail string
-	err = database.DB.QueryRow("SELECT email FROM psychologists WHERE id = ?", input.PsychologistID).Scan(&psychoEmail)
-	if err == nil && psychoEmail != "" {
-		SendNotification(psychoEmail, gin.H{
-			"type":    "new_booking",
-			"message": fmt.Sprintf("New booking request from %s", input.ClientName),
-		})
+	tx.QueryRow("SELECT email FROM psychologists WHERE id = ?", input.PsychologistID).Scan(&psychoEmail)
+	err = enqueueNotification(tx, id, psychoEmail, gin.H{
+		"type":          "new_booking",
+		"message":       fmt.Sprintf("New booking request from %s", input.ClientName),
+		"booking_id":    id,
+		"client_name":   input.ClientName,
+		"schedule_time": input.ScheduleTime,
+	})
+	if err == nil {
+		err = enqueueWebhook(tx, id, EventBookingCreated)
+	}
+	if err == nil && assessment.High() {
+		err = alertHighRisk(tx, id, psychoEmail, input.ClientName, input.ScheduleTime, assessment)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fail(http.StatusInternalServerError, "Failed to create booking: "+err.Error())
+		return
 	}
+	kickOutbox()
 
-	c.JSON(http.StatusOK, gin.H{"message": "Booking request sent", "booking_id": id})
+	if input.WhatsAppOptIn {
+		recordBookingOptIn(input.ClientContact)
+	}
+
+	// Priced sessions are paid next (POST /api/public/bookings/:id/payment)
+	response := gin.H{
+		"message":          "Booking request sent",
+		"booking_id":       id,
+		"payment_required": paymentStatus == paymentUnpaid,
+		"paid_with_credit": paymentStatus == paymentCredit,
+		"sponsored":        paymentStatus == paymentSponsored,
+		"price":            sessionType.Price,
+		"risk_level":       assessment.Level,
+	}
+	if assessment.High() {
+		response["crisis"] = crisisSupport()
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // GetExpertBookings returns bookings for a specific psychologist
@@ -268,10 +388,13 @@ func GetExpertBookings(c *gin.Context) {
 	}
 
 	rows, err := database.DB.Query(`
-		SELECT b.id, b.client_name, b.client_contact, b.complaint, cat.name, DATE_FORMAT(b.schedule_time, '%Y-%m-%dT%H:%i:%s'), b.status, b.session_notes, b.room_id, p.name 
+		SELECT b.id, b.client_name, b.client_contact, b.complaint, cat.name, DATE_FORMAT(b.schedule_time, '%Y-%m-%dT%H:%i:%s'), b.status, b.session_notes, b.room_id, p.name,
+			IFNULL(b.session_type_id, 0), IFNULL(st.name, ''), b.duration_minutes, b.price, b.modality, b.payment_status,
+			b.risk_level, IFNULL(b.risk_reasons, '')
 		FROM bookings b
 		JOIN psychologists p ON b.psychologist_id = p.id
 		JOIN categories cat ON b.category_id = cat.id
+		LEFT JOIN session_types st ON b.session_type_id = st.id
 		WHERE p.email = ?
 		ORDER BY b.schedule_time ASC
 	`, email)
@@ -285,11 +408,17 @@ func GetExpertBookings(c *gin.Context) {
 	for rows.Next() {
 		var b models.Booking
 		var notes, roomID sql.NullString
+		var riskReasons string
 
-		if err := rows.Scan(&b.ID, &b.ClientName, &b.ClientContact, &b.Complaint, &b.CategoryName, &b.ScheduleTime, &b.Status, &notes, &roomID, &b.PsychologistName); err != nil {
+		if err := rows.Scan(&b.ID, &b.ClientName, &b.ClientContact, &b.Complaint, &b.CategoryName, &b.ScheduleTime, &b.Status, &notes, &roomID, &b.PsychologistName,
+			&b.SessionTypeID, &b.SessionTypeName, &b.DurationMinutes, &b.Price, &b.Modality, &b.PaymentStatus,
+			&b.RiskLevel, &riskReasons); err != nil {
 			fmt.Println("Scan error:", err)
 			continue
 		}
+		if riskReasons != "" {
+			json.Unmarshal([]byte(riskReasons), &b.RiskReasons)
+		}
 		if notes.Valid {
 			b.SessionNotes = notes.String
 		}
@@ -299,6 +428,16 @@ func GetExpertBookings(c *gin.Context) {
 		bookings = append(bookings, b)
 	}
 
+	// Intake questionnaires answered at booking
+	intake, err := loadQuestionnaires("p.email = ?", email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	for i := range bookings {
+		bookings[i].Questionnaires = intake[bookings[i].ID]
+	}
+
 	if bookings == nil {
 		bookings = []models.Booking{}
 	}
@@ -306,7 +445,47 @@ func GetExpertBookings(c *gin.Context) {
 	c.JSON(http.StatusOK, bookings)
 }
 
-// UpdateBookingStatus (Approve/Reject)
+// bookingTransitions are the status changes a psychologist may make.
+// Cancelling is the client's (CancelBooking); rejected, completed and
+// cancelled bookings are final.
+var bookingTransitions = map[string][]string{
+	"pending":  {"approved", "rejected"},
+	"approved": {"completed", "rejected"},
+}
+
+// lockBookingForTransition locks the booking in tx and checks that it may
+// move to status. It returns the HTTP status and message to send if not.
+func lockBookingForTransition(tx *sql.Tx, id, status string) (int, string) {
+	var current, paymentStatus string
+	err := tx.QueryRow("SELECT status, payment_status FROM bookings WHERE id = ? FOR UPDATE", id).Scan(&current, &paymentStatus)
+	if err == sql.ErrNoRows {
+		return http.StatusNotFound, "Booking not found"
+	} else if err != nil {
+		return http.StatusInternalServerError, "Database error: " + err.Error()
+	}
+
+	allowed := false
+	for _, next := range bookingTransitions[current] {
+		allowed = allowed || next == status
+	}
+	if !allowed {
+		return http.StatusConflict, fmt.Sprintf("Booking berstatus %s tidak dapat diubah menjadi %s", current, status)
+	}
+
+	// Priced sessions are confirmed only once paid, and never after the
+	// money went back
+	if status == "approved" || status == "completed" {
+		switch paymentStatus {
+		case paymentUnpaid:
+			return http.StatusPaymentRequired, "Booking ini belum dibayar oleh klien"
+		case paymentRefunded:
+			return http.StatusConflict, "Pembayaran booking ini sudah dikembalikan"
+		}
+	}
+	return 0, ""
+}
+
+// UpdateBookingStatus (Approve/Reject/Complete)
 func UpdateBookingStatus(c *gin.Context) {
 	id := c.Param("id")
 	var input struct {
@@ -317,33 +496,90 @@ func UpdateBookingStatus(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	switch input.Status {
+	case "approved", "rejected", "completed":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be 'approved', 'rejected' or 'completed'"})
+		return
+	}
 
 	var roomID string
 	if input.Status == "approved" {
 		roomID = uuid.New().String()
 	}
 
-	_, err := database.DB.Exec("UPDATE bookings SET status = ?, room_id = ? WHERE id = ?", input.Status, roomID, id)
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	if code, msg := lockBookingForTransition(tx, id, input.Status); code != 0 {
+		c.JSON(code, gin.H{"error": msg})
+		return
+	}
+
+	_, err = tx.Exec("UPDATE bookings SET status = ?, room_id = ? WHERE id = ?", input.Status, roomID, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
 		return
 	}
 
+	// Side effects: refund, package credit or sponsorship, earnings, calendar entry, reminders, webhook, client notification
+	switch input.Status {
+	case "approved":
+		err = consumeBookingCredit(tx, id)
+		if err == nil {
+			err = consumeSponsorship(tx, id)
+		}
+	case "rejected":
+		_, _, err = queueBookingRefund(tx, id, 100, refundReasonRejected)
+		if err == nil {
+			err = releaseBookingCredit(tx, id, true)
+		}
+		if err == nil {
+			err = releaseSponsorship(tx, id, true)
+		}
+	case "completed":
+		err = recordEarnings(tx, "b.id = ?", id)
+	}
+	if err == nil {
+		err = bumpCalendarSequence(tx, id, input.Status == "approved")
+	}
+	if err == nil {
+		err = enqueueReminderSync(tx, id)
+	}
+	statusEvents := map[string]string{
+		"approved":  EventBookingApproved,
+		"rejected":  EventBookingRejected,
+		"completed": EventBookingCompleted,
+	}
+	if event, ok := statusEvents[input.Status]; ok && err == nil {
+		err = enqueueWebhook(tx, id, event)
+	}
+
 	// Notify Client
 	var clientContact string
-	err = database.DB.QueryRow("SELECT client_contact FROM bookings WHERE id = ?", id).Scan(&clientContact)
+	tx.QueryRow("SELECT IFNULL(client_contact, '') FROM bookings WHERE id = ?", id).Scan(&clientContact)
 	fmt.Printf("[NOTIFY DEBUG] Booking ID: %s, Status: %s, Client Contact: '%s'\n", id, input.Status, clientContact)
-	if err == nil && clientContact != "" {
-		fmt.Printf("[NOTIFY DEBUG] Sending notification to: '%s'\n", clientContact)
-		SendNotification(clientContact, gin.H{
-			"type":    "booking_updated",
-			"status":  input.Status,
-			"room_id": roomID,
-			"message": fmt.Sprintf("Your booking has been %s", input.Status),
+	if err == nil {
+		err = enqueueNotification(tx, id, clientContact, gin.H{
+			"type":       "booking_updated",
+			"status":     input.Status,
+			"room_id":    roomID,
+			"booking_id": id,
+			"message":    fmt.Sprintf("Your booking has been %s", input.Status),
 		})
-	} else if err != nil {
-		fmt.Printf("[NOTIFY DEBUG] Error getting client contact: %v\n", err)
 	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+	kickOutbox()
 
 	c.JSON(http.StatusOK, gin.H{"message": "Status updated", "room_id": roomID})
 }
@@ -360,29 +596,67 @@ func RejectBooking(c *gin.Context) {
 		return
 	}
 
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject booking"})
+		return
+	}
+	defer tx.Rollback()
+
+	if code, msg := lockBookingForTransition(tx, id, "rejected"); code != 0 {
+		c.JSON(code, gin.H{"error": msg})
+		return
+	}
+
 	// Get client contact before updating
 	var clientContact, clientName string
-	err := database.DB.QueryRow("SELECT client_contact, client_name FROM bookings WHERE id = ?", id).Scan(&clientContact, &clientName)
+	err = tx.QueryRow("SELECT IFNULL(client_contact, ''), client_name FROM bookings WHERE id = ?", id).Scan(&clientContact, &clientName)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
 		return
 	}
 
 	// Update the booking status and store rejection reason
-	_, err = database.DB.Exec("UPDATE bookings SET status = 'rejected', rejection_reason = ? WHERE id = ?", input.Reason, id)
+	_, err = tx.Exec("UPDATE bookings SET status = 'rejected', rejection_reason = ? WHERE id = ?", input.Reason, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject booking"})
 		return
 	}
 
-	// Notify Client with rejection reason
-	if clientContact != "" {
-		SendNotification(clientContact, gin.H{
-			"type":    "booking_rejected",
-			"message": fmt.Sprintf("Booking Anda ditolak. Alasan: %s", input.Reason),
-			"reason":  input.Reason,
+	// Refund in full (or give the credit or sponsored session back), cancel calendar entry and reminders, tell external systems and notify client with rejection reason
+	_, _, err = queueBookingRefund(tx, id, 100, refundReasonRejected)
+	if err == nil {
+		err = releaseBookingCredit(tx, id, true)
+	}
+	if err == nil {
+		err = releaseSponsorship(tx, id, true)
+	}
+	if err == nil {
+		err = bumpCalendarSequence(tx, id, false)
+	}
+	if err == nil {
+		err = enqueueReminderSync(tx, id)
+	}
+	if err == nil {
+		err = enqueueWebhook(tx, id, EventBookingRejected)
+	}
+	if err == nil {
+		err = enqueueNotification(tx, id, clientContact, gin.H{
+			"type":        "booking_rejected",
+			"message":     fmt.Sprintf("Booking Anda ditolak. Alasan: %s", input.Reason),
+			"reason":      input.Reason,
+			"booking_id":  id,
+			"client_name": clientName,
 		})
 	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject booking"})
+		return
+	}
+	kickOutbox()
 
 	fmt.Printf("[REJECT] Booking ID: %s for %s rejected. Reason: %s\n", id, clientName, input.Reason)
 	c.JSON(http.StatusOK, gin.H{"message": "Booking rejected"})
@@ -462,9 +736,13 @@ func GetClientBookings(c *gin.Context) {
 
 	// Fetch bookings
 	rows, err := database.DB.Query(`
-		SELECT b.id, b.client_name, b.complaint, DATE_FORMAT(b.schedule_time, '%Y-%m-%dT%H:%i:%s'), b.status, IFNULL(b.room_id, ''), IFNULL(b.session_notes, ''), IFNULL(b.rejection_reason, ''), IFNULL(p.name, 'Unknown Psychologist')
+		SELECT b.id, b.client_name, b.complaint, DATE_FORMAT(b.schedule_time, '%Y-%m-%dT%H:%i:%s'), b.status, IFNULL(b.room_id, ''), IFNULL(b.session_notes, ''), IFNULL(b.rejection_reason, ''), IFNULL(p.name, 'Unknown Psychologist'),
+			IFNULL(b.session_type_id, 0), IFNULL(st.name, ''), b.duration_minutes, b.price, b.modality, b.payment_status,
+			b.refund_status, b.refund_amount, b.cancellation_fee, b.credit_status, b.sponsor_status, IFNULL(sp.name, '')
 		FROM bookings b
 		LEFT JOIN psychologists p ON b.psychologist_id = p.id
+		LEFT JOIN session_types st ON b.session_type_id = st.id
+		LEFT JOIN sponsors sp ON b.sponsor_id = sp.id
 		WHERE b.client_contact = ?
 		ORDER BY b.schedule_time DESC
 	`, email)
@@ -477,7 +755,9 @@ func GetClientBookings(c *gin.Context) {
 	var bookings []models.Booking
 	for rows.Next() {
 		var b models.Booking
-		if err := rows.Scan(&b.ID, &b.ClientName, &b.Complaint, &b.ScheduleTime, &b.Status, &b.RoomID, &b.SessionNotes, &b.RejectionReason, &b.PsychologistName); err != nil {
+		if err := rows.Scan(&b.ID, &b.ClientName, &b.Complaint, &b.ScheduleTime, &b.Status, &b.RoomID, &b.SessionNotes, &b.RejectionReason, &b.PsychologistName,
+			&b.SessionTypeID, &b.SessionTypeName, &b.DurationMinutes, &b.Price, &b.Modality, &b.PaymentStatus,
+			&b.RefundStatus, &b.RefundAmount, &b.CancellationFee, &b.CreditStatus, &b.SponsorStatus, &b.SponsorName); err != nil {
 			fmt.Println("Scan error:", err)
 			continue
 		}
@@ -503,7 +783,14 @@ func UpdateSessionNotes(c *gin.Context) {
 		return
 	}
 
-	_, err := database.DB.Exec("UPDATE bookings SET session_notes = ? WHERE id = ?", input.Notes, id)
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE bookings SET session_notes = ? WHERE id = ?", input.Notes, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
 		return
@@ -511,19 +798,30 @@ func UpdateSessionNotes(c *gin.Context) {
 
 	// Notify Client
 	var clientContact string
-	database.DB.QueryRow("SELECT client_contact FROM bookings WHERE id = ?", id).Scan(&clientContact)
-	if clientContact != "" {
-		SendNotification(clientContact, gin.H{
-			"type":    "booking_updated",
-			"status":  "notes_added",
-			"message": "Psikolog telah menambahkan catatan sesi.",
+	tx.QueryRow("SELECT IFNULL(client_contact, '') FROM bookings WHERE id = ?", id).Scan(&clientContact)
+	err = enqueueWebhook(tx, id, EventBookingNotesUpdated)
+	if err == nil {
+		err = enqueueNotification(tx, id, clientContact, gin.H{
+			"type":       "booking_updated",
+			"status":     "notes_added",
+			"booking_id": id,
+			"message":    "Psikolog telah menambahkan catatan sesi.",
 		})
 	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+	kickOutbox()
 
 	c.JSON(http.StatusOK, gin.H{"message": "Notes updated"})
 }
 
-// CheckRoomStatus checks if a room session is still valid (within 1 hour of scheduled time)
+// CheckRoomStatus checks if a room session is still valid (until the end of
+// the booked session type's duration)
 func CheckRoomStatus(c *gin.Context) {
 	roomID := c.Param("roomId")
 	if roomID == "" {
@@ -531,37 +829,35 @@ func CheckRoomStatus(c *gin.Context) {
 		return
 	}
 
-	var scheduleTime string
-	var status string
-	err := database.DB.QueryRow(`
-		SELECT DATE_FORMAT(schedule_time, '%Y-%m-%dT%H:%i:%s'), status 
-		FROM bookings 
-		WHERE room_id = ?
-	`, roomID).Scan(&scheduleTime, &status)
-
+	rb, err := getRoomBooking(roomID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found", "valid": false})
 		return
 	}
 
+	session := gin.H{
+		"status":           rb.Status,
+		"schedule_time":    rb.ScheduleTime.Format("2006-01-02T15:04:05"),
+		"valid_until":      rb.validUntil().Format(time.RFC3339),
+		"duration_minutes": rb.DurationMinutes,
+		"modality":         rb.Modality,
+	}
+
 	// Check if booking is approved
-	if status != "approved" {
-		c.JSON(http.StatusOK, gin.H{
-			"valid":  false,
-			"reason": "Booking belum disetujui atau sudah selesai",
-			"status": status,
-		})
+	if rb.Status != "approved" {
+		session["valid"] = false
+		session["reason"] = "Booking belum disetujui atau sudah selesai"
+		c.JSON(http.StatusOK, session)
 		return
 	}
 
-	// Parse schedule time and check if within 1 hour window
-	// We need to import "time" package
-	// For now, we'll return the schedule_time and let frontend handle the logic
-	// OR we can do server-side check
+	if time.Now().After(rb.validUntil()) {
+		session["valid"] = false
+		session["reason"] = "Waktu sesi sudah berakhir"
+		c.JSON(http.StatusOK, session)
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"valid":         true,
-		"schedule_time": scheduleTime,
-		"status":        status,
-	})
+	session["valid"] = true
+	c.JSON(http.StatusOK, session)
 }
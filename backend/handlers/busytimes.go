@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"counseling-webrtc/calsync"
+	"counseling-webrtc/database"
+	"counseling-webrtc/ical"
+	"counseling-webrtc/models"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// EXTERNAL BUSY TIMES
+// =============================================
+//
+// Psychologists link the calendars where they keep their in-person
+// appointments (an ICS URL or a CalDAV collection). The busy blocks are
+// imported into external_busy_times every calendarSyncInterval, and a slot
+// that overlaps one is neither offered nor bookable (see busyIntervals).
+// Busy times are stored as WIB wall clock, like bookings.schedule_time.
+
+const (
+	// How often each linked calendar is re-imported
+	calendarSyncInterval = 15 * time.Minute
+	// How often the worker looks for calendars due for a sync
+	calendarSyncPoll = time.Minute
+	// Imported window, relative to now
+	calendarSyncPast   = 24 * time.Hour
+	calendarSyncFuture = 90 * 24 * time.Hour
+)
+
+var calendarFetcher = calsync.NewFetcher(sessionLocation)
+
+const wallClockFormat = "2006-01-02 15:04:05"
+
+// StartCalendarSync starts the import loop for linked calendars
+func StartCalendarSync() {
+	go func() {
+		ticker := time.NewTicker(calendarSyncPoll)
+		defer ticker.Stop()
+		for {
+			syncDueCalendars()
+			<-ticker.C
+		}
+	}()
+}
+
+type dueCalendar struct {
+	ID             int
+	PsychologistID int
+	Source         calsync.Source
+	NextSyncAt     time.Time
+}
+
+// syncDueCalendars claims the calendars whose next_sync_at has passed by
+// moving it one interval ahead, then imports them
+func syncDueCalendars() {
+	now := time.Now().UTC().Truncate(time.Second)
+	rows, err := database.DB.Query(`
+		SELECT id, psychologist_id, kind, url, IFNULL(username, ''), IFNULL(password, ''), next_sync_at
+		FROM calendar_sources WHERE next_sync_at <= ?
+	`, now)
+	if err != nil {
+		log.Printf("[CALSYNC] Failed to load due calendars: %v", err)
+		return
+	}
+
+	var due []dueCalendar
+	for rows.Next() {
+		var d dueCalendar
+		if err := rows.Scan(&d.ID, &d.PsychologistID, &d.Source.Kind, &d.Source.URL, &d.Source.Username, &d.Source.Password, &d.NextSyncAt); err != nil {
+			continue
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	for _, d := range due {
+		res, err := database.DB.Exec("UPDATE calendar_sources SET next_sync_at = ? WHERE id = ? AND next_sync_at = ?",
+			now.Add(calendarSyncInterval), d.ID, d.NextSyncAt)
+		if err != nil {
+			continue
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			continue
+		}
+		syncCalendar(d.ID, d.PsychologistID, d.Source)
+	}
+}
+
+// syncCalendar replaces the busy blocks of one source. When the calendar
+// can't be fetched the previous blocks are kept, so an outage doesn't
+// suddenly open up slots the psychologist isn't free for.
+func syncCalendar(sourceID, psychologistID int, src calsync.Source) error {
+	now := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	busy, err := calendarFetcher.Busy(ctx, src, now.Add(-calendarSyncPast), now.Add(calendarSyncFuture))
+	if err == nil {
+		err = storeBusyTimes(sourceID, psychologistID, busy)
+	}
+	if err != nil {
+		database.DB.Exec("UPDATE calendar_sources SET last_error = ? WHERE id = ?", err.Error(), sourceID)
+		log.Printf("[CALSYNC] Calendar %d of psychologist %d failed: %v", sourceID, psychologistID, err)
+		return err
+	}
+
+	database.DB.Exec("UPDATE calendar_sources SET last_synced_at = ?, last_error = NULL WHERE id = ?",
+		time.Now().UTC().Truncate(time.Second), sourceID)
+	return nil
+}
+
+func storeBusyTimes(sourceID, psychologistID int, busy []ical.Period) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM external_busy_times WHERE source_id = ?", sourceID); err != nil {
+		return err
+	}
+	for _, p := range busy {
+		_, err := tx.Exec("INSERT INTO external_busy_times (source_id, psychologist_id, start_time, end_time) VALUES (?, ?, ?, ?)",
+			sourceID, psychologistID, p.Start.In(sessionLocation).Format(wallClockFormat), p.End.In(sessionLocation).Format(wallClockFormat))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// psychologistIDByEmail resolves the ?email= of expert endpoints
+func psychologistIDByEmail(email string) (int, bool) {
+	var id int
+	err := database.DB.QueryRow("SELECT id FROM psychologists WHERE email = ?", email).Scan(&id)
+	return id, err == nil
+}
+
+// GetCalendarSources lists the calendars a psychologist linked
+func GetCalendarSources(c *gin.Context) {
+	psychoID, ok := psychologistIDByEmail(c.Query("email"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT s.id, s.psychologist_id, s.kind, s.url, IFNULL(s.username, ''), s.last_synced_at, IFNULL(s.last_error, ''), s.created_at,
+			(SELECT COUNT(*) FROM external_busy_times b WHERE b.source_id = s.id)
+		FROM calendar_sources s WHERE s.psychologist_id = ? ORDER BY s.id
+	`, psychoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	sources := []models.CalendarSource{}
+	for rows.Next() {
+		var s models.CalendarSource
+		var synced sql.NullTime
+		if err := rows.Scan(&s.ID, &s.PsychologistID, &s.Kind, &s.URL, &s.Username, &synced, &s.LastError, &s.CreatedAt, &s.BusyCount); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		if synced.Valid {
+			s.LastSyncedAt = &synced.Time
+		}
+		sources = append(sources, s)
+	}
+
+	c.JSON(http.StatusOK, sources)
+}
+
+// AddCalendarSource links a calendar and imports it right away; a
+// calendar that can't be read is not saved
+func AddCalendarSource(c *gin.Context) {
+	var input struct {
+		Email    string `json:"email" binding:"required"`
+		Kind     string `json:"kind" binding:"required"` // ics, caldav
+		URL      string `json:"url" binding:"required"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Kind != calsync.KindICS && input.Kind != calsync.KindCalDAV {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be 'ics' or 'caldav'"})
+		return
+	}
+	if !validWebhookURL(input.URL) && !(input.Kind == calsync.KindICS && strings.HasPrefix(input.URL, "webcal://")) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http(s) URL"})
+		return
+	}
+	psychoID, ok := psychologistIDByEmail(input.Email)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	res, err := database.DB.Exec(`
+		INSERT INTO calendar_sources (psychologist_id, kind, url, username, password, next_sync_at, created_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?)
+	`, psychoID, input.Kind, input.URL, input.Username, input.Password, now.Add(calendarSyncInterval), now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add calendar"})
+		return
+	}
+	id, _ := res.LastInsertId()
+
+	src := calsync.Source{Kind: input.Kind, URL: input.URL, Username: input.Username, Password: input.Password}
+	if err := syncCalendar(int(id), psychoID, src); err != nil {
+		database.DB.Exec("DELETE FROM calendar_sources WHERE id = ?", id)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Kalender tidak dapat dibaca: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar linked", "id": id})
+}
+
+// SyncCalendarSource re-imports a linked calendar now
+func SyncCalendarSource(c *gin.Context) {
+	var d dueCalendar
+	err := database.DB.QueryRow(`
+		SELECT s.id, s.psychologist_id, s.kind, s.url, IFNULL(s.username, ''), IFNULL(s.password, '')
+		FROM calendar_sources s JOIN psychologists p ON p.id = s.psychologist_id
+		WHERE s.id = ? AND p.email = ?
+	`, c.Param("id"), c.Query("email")).Scan(&d.ID, &d.PsychologistID, &d.Source.Kind, &d.Source.URL, &d.Source.Username, &d.Source.Password)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+
+	if err := syncCalendar(d.ID, d.PsychologistID, d.Source); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Kalender tidak dapat dibaca: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar synced"})
+}
+
+// DeleteCalendarSource unlinks a calendar and drops its busy times
+func DeleteCalendarSource(c *gin.Context) {
+	res, err := database.DB.Exec(`
+		DELETE s FROM calendar_sources s JOIN psychologists p ON p.id = s.psychologist_id
+		WHERE s.id = ? AND p.email = ?
+	`, c.Param("id"), c.Query("email"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete calendar"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar unlinked"})
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/ical"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// CALENDAR EXPORT (iCalendar)
+// =============================================
+//
+// Every booking becomes one VEVENT with a stable UID. booking_calendar
+// keeps its SEQUENCE, bumped by every status change, so a re-downloaded
+// .ics or a refreshed feed updates the entry already in the user's
+// calendar, and a rejected booking shows up there as cancelled.
+
+// How long subscribing clients should wait between refreshes
+const calendarRefreshInterval = time.Hour
+
+// Feeds leave out sessions older than this
+const calendarFeedHistory = 90 * 24 * time.Hour
+
+const (
+	calendarRoleClient = "client"
+	calendarRoleExpert = "expert"
+)
+
+// calendarUID is the UID of a booking's event; never change its format
+func calendarUID(bookingID int) string {
+	return fmt.Sprintf("booking-%d@safespace", bookingID)
+}
+
+// bumpCalendarSequence records a change to a booking inside tx. published
+// marks the booking as having been in calendars (approved), after which
+// it stays in feeds even when it is rejected, so the event gets cancelled.
+func bumpCalendarSequence(tx *sql.Tx, bookingID interface{}, published bool) error {
+	_, err := tx.Exec(`
+		INSERT INTO booking_calendar (booking_id, sequence, published, updated_at)
+		VALUES (?, 0, ?, ?)
+		ON DUPLICATE KEY UPDATE sequence = sequence + 1, published = published OR VALUES(published), updated_at = VALUES(updated_at)
+	`, bookingID, published, time.Now().UTC().Truncate(time.Second))
+	return err
+}
+
+// calendarQuery selects what calendarEvent needs
+const calendarQuery = `
+	SELECT b.id, b.client_name, b.client_contact, IFNULL(cat.name, ''), b.schedule_time, b.duration_minutes, b.modality, b.status,
+		IFNULL(p.name, ''), IFNULL(p.email, ''), IFNULL(bc.sequence, 0), IFNULL(bc.published, FALSE)
+	FROM bookings b
+	LEFT JOIN psychologists p ON b.psychologist_id = p.id
+	LEFT JOIN categories cat ON b.category_id = cat.id
+	LEFT JOIN booking_calendar bc ON bc.booking_id = b.id
+`
+
+type calendarBooking struct {
+	ID               int
+	ClientName       string
+	ClientContact    string
+	CategoryName     string
+	ScheduleTime     time.Time
+	DurationMinutes  int
+	Modality         string
+	Status           string
+	PsychologistName string
+	PsychologistMail string
+	Sequence         int
+	Published        bool
+}
+
+func scanCalendarBooking(row interface{ Scan(...interface{}) error }) (calendarBooking, error) {
+	var b calendarBooking
+	var contact sql.NullString
+	err := row.Scan(&b.ID, &b.ClientName, &contact, &b.CategoryName, &b.ScheduleTime, &b.DurationMinutes, &b.Modality, &b.Status,
+		&b.PsychologistName, &b.PsychologistMail, &b.Sequence, &b.Published)
+	b.ClientContact = contact.String
+	return b, err
+}
+
+// calendarEvent builds the event as seen by role
+func calendarEvent(b calendarBooking, role string, stamp time.Time) ical.Event {
+	start := sessionTime(b.ScheduleTime)
+	e := ical.Event{
+		UID:      calendarUID(b.ID),
+		Sequence: b.Sequence,
+		Stamp:    stamp,
+		Start:    start,
+		End:      start.Add(time.Duration(b.DurationMinutes) * time.Minute),
+		Location: "SafeSpace Counseling (sesi online, " + modalityLabels[b.Modality] + ")",
+	}
+
+	if role == calendarRoleExpert {
+		e.Summary = "Sesi Konseling: " + b.ClientName
+	} else {
+		e.Summary = "Sesi Konseling dengan " + b.PsychologistName
+	}
+	e.Description = fmt.Sprintf("Booking #%d\nKategori: %s\nPsikolog: %s\nKlien: %s\n\nMasuk ke ruang sesi melalui dashboard SafeSpace.",
+		b.ID, b.CategoryName, b.PsychologistName, b.ClientName)
+
+	switch b.Status {
+	case "approved", "completed":
+		e.Status = ical.StatusConfirmed
+	case "pending":
+		e.Status = ical.StatusTentative
+	default:
+		e.Status = ical.StatusCancelled
+	}
+	return e
+}
+
+func writeCalendar(c *gin.Context, cal *ical.Calendar, filename string) {
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Cache-Control", "no-cache, private")
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", cal.Bytes())
+}
+
+// DownloadBookingICS serves a single booking as an .ics file. email must
+// be the booking's client or psychologist.
+func DownloadBookingICS(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+
+	b, err := scanCalendarBooking(database.DB.QueryRow(calendarQuery+" WHERE b.id = ?", c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
+
+	var role string
+	switch {
+	case strings.EqualFold(email, b.PsychologistMail):
+		role = calendarRoleExpert
+	case strings.EqualFold(email, b.ClientContact):
+		role = calendarRoleClient
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
+
+	cal := &ical.Calendar{Method: "PUBLISH", Events: []ical.Event{calendarEvent(b, role, time.Now())}}
+	writeCalendar(c, cal, fmt.Sprintf("booking-%d.ics", b.ID))
+}
+
+// =============================================
+// CALENDAR FEEDS
+// =============================================
+//
+// A feed is a secret URL calendar apps subscribe to. Only a hash of the
+// token is stored; creating a new feed URL replaces (revokes) the old one.
+
+func newCalendarToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// feedURL is the absolute URL of a feed, as seen by the caller
+func feedURL(c *gin.Context, token string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s/api/calendar/feed/%s.ics", scheme, c.Request.Host, token)
+}
+
+// CreateCalendarFeed creates (or rotates) the feed of a client or
+// psychologist and returns its URL. The URL is only shown here.
+func CreateCalendarFeed(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required"`
+		Role  string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Role != calendarRoleClient && input.Role != calendarRoleExpert {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be 'client' or 'expert'"})
+		return
+	}
+	if input.Role == calendarRoleExpert {
+		var exists int
+		if err := database.DB.QueryRow("SELECT COUNT(*) FROM psychologists WHERE email = ?", input.Email).Scan(&exists); err != nil || exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+			return
+		}
+	}
+
+	token, err := newCalendarToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create feed"})
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO calendar_feeds (email, role, token_hash, created_at)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE token_hash = VALUES(token_hash), created_at = VALUES(created_at)
+	`, input.Email, input.Role, endpointHash(token), time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create feed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": feedURL(c, token)})
+}
+
+// DeleteCalendarFeed revokes the feed of a client or psychologist
+func DeleteCalendarFeed(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required"`
+		Role  string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := database.DB.Exec("DELETE FROM calendar_feeds WHERE email = ? AND role = ?", input.Email, input.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete feed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Feed deleted"})
+}
+
+// GetCalendarFeed serves /api/calendar/feed/<token>.ics: approved and
+// completed sessions, plus cancelled ones that were approved before
+func GetCalendarFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	var email, role string
+	err := database.DB.QueryRow("SELECT email, role FROM calendar_feeds WHERE token_hash = ?", endpointHash(token)).Scan(&email, &role)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+		return
+	}
+
+	owner := "b.client_contact = ?"
+	name := "SafeSpace - Sesi Konseling"
+	if role == calendarRoleExpert {
+		owner = "p.email = ?"
+		name = "SafeSpace - Jadwal Praktik"
+	}
+	since := time.Now().In(sessionLocation).Add(-calendarFeedHistory).Format("2006-01-02 15:04:05")
+
+	rows, err := database.DB.Query(calendarQuery+`
+		WHERE `+owner+` AND b.schedule_time >= ?
+			AND (b.status IN ('approved', 'completed') OR bc.published = TRUE)
+		ORDER BY b.schedule_time ASC
+	`, email, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	cal := &ical.Calendar{Name: name, Method: "PUBLISH", Refresh: calendarRefreshInterval}
+	for rows.Next() {
+		b, err := scanCalendarBooking(rows)
+		if err != nil {
+			continue
+		}
+		cal.Events = append(cal.Events, calendarEvent(b, role, now))
+	}
+
+	writeCalendar(c, cal, "safespace.ics")
+}
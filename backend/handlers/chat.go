@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/models"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// IN-SESSION CHAT
+// =============================================
+
+const (
+	RoleClient = "client"
+	RoleExpert = "expert"
+)
+
+// participant identifies who is connected to a room and which booking the
+// room belongs to. It is resolved once at join.
+type participant struct {
+	BookingID int
+	Role      string
+	Email     string
+}
+
+// roomBooking is the booking that owns a signaling room
+type roomBooking struct {
+	BookingID         int
+	ClientName        string
+	ClientContact     string
+	PsychologistEmail string
+	Status            string
+	ScheduleTime      time.Time // WIB wall clock, see sessionTime
+	DurationMinutes   int
+	Modality          string
+}
+
+// validUntil is when the room closes: the end of the booked session
+func (rb roomBooking) validUntil() time.Time {
+	return sessionTime(rb.ScheduleTime).Add(time.Duration(rb.DurationMinutes) * time.Minute)
+}
+
+// open reports whether the room can be entered: only approved bookings
+// have a session, while rejected or cancelled ones keep their room_id
+func (rb roomBooking) open() bool {
+	return rb.Status == "approved"
+}
+
+// getRoomBooking looks up the booking that owns roomID
+func getRoomBooking(roomID string) (roomBooking, error) {
+	var rb roomBooking
+	err := database.DB.QueryRow(`
+		SELECT b.id, b.client_name, b.client_contact, p.email, b.status, b.schedule_time, b.duration_minutes, b.modality
+		FROM bookings b
+		JOIN psychologists p ON b.psychologist_id = p.id
+		WHERE b.room_id = ?
+	`, roomID).Scan(&rb.BookingID, &rb.ClientName, &rb.ClientContact, &rb.PsychologistEmail, &rb.Status, &rb.ScheduleTime, &rb.DurationMinutes, &rb.Modality)
+	return rb, err
+}
+
+// resolveParticipant checks that email is the client or psychologist of the
+// booking that owns roomID.
+func resolveParticipant(roomID, role, email string) (*participant, roomBooking, error) {
+	rb, err := getRoomBooking(roomID)
+	if err != nil {
+		return nil, rb, err
+	}
+
+	switch {
+	case role == RoleClient && email == rb.ClientContact:
+	case role == RoleExpert && email == rb.PsychologistEmail:
+	default:
+		return nil, rb, fmt.Errorf("%s %q is not a participant of room %s", role, email, roomID)
+	}
+
+	return &participant{BookingID: rb.BookingID, Role: role, Email: email}, rb, nil
+}
+
+// saveChatMessage stores a chat message sent by p
+func saveChatMessage(p *participant, text string) (models.ChatMessage, error) {
+	msg := models.ChatMessage{
+		BookingID:  p.BookingID,
+		SenderRole: p.Role,
+		Text:       text,
+		Status:     "sent",
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+
+	res, err := database.DB.Exec(`
+		INSERT INTO chat_messages (booking_id, sender_role, sender_email, body, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, msg.BookingID, msg.SenderRole, p.Email, msg.Text, msg.CreatedAt)
+	if err != nil {
+		return msg, err
+	}
+	msg.ID, _ = res.LastInsertId()
+	return msg, nil
+}
+
+// markChatDelivered flags a single message as received by the other participant
+func markChatDelivered(msg *models.ChatMessage) {
+	now := time.Now().UTC().Truncate(time.Second)
+	if _, err := database.DB.Exec("UPDATE chat_messages SET delivered_at = ? WHERE id = ? AND delivered_at IS NULL", now, msg.ID); err != nil {
+		fmt.Println("[CHAT] Failed to mark message delivered:", err)
+		return
+	}
+	msg.Status = "delivered"
+	msg.DeliveredAt = &now
+}
+
+// replayChatHistory sends the stored transcript to a peer that just joined
+// and marks what it received as delivered
+func replayChatHistory(c *wsClient, p *participant) {
+	history, err := getChatHistory(p.BookingID)
+	if err != nil {
+		fmt.Println("[CHAT] Failed to load history:", err)
+		return
+	}
+	c.enqueue(newMessage(MsgChatHistory, ChatHistoryData{Messages: history}))
+	markChatHistoryDelivered(p)
+}
+
+// markChatHistoryDelivered flags every message sent to p (i.e. by the other
+// participant) as delivered. Called after the history was replayed to p.
+func markChatHistoryDelivered(p *participant) {
+	_, err := database.DB.Exec(`
+		UPDATE chat_messages SET delivered_at = ?
+		WHERE booking_id = ? AND sender_role <> ? AND delivered_at IS NULL
+	`, time.Now().UTC().Truncate(time.Second), p.BookingID, p.Role)
+	if err != nil {
+		fmt.Println("[CHAT] Failed to mark history delivered:", err)
+	}
+}
+
+// getChatHistory returns all chat messages of a booking in send order
+func getChatHistory(bookingID int) ([]models.ChatMessage, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, booking_id, sender_role, body, created_at, delivered_at
+		FROM chat_messages
+		WHERE booking_id = ?
+		ORDER BY id ASC
+	`, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.ChatMessage{}
+	for rows.Next() {
+		var m models.ChatMessage
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.BookingID, &m.SenderRole, &m.Text, &m.CreatedAt, &deliveredAt); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		m.Status = "sent"
+		if deliveredAt.Valid {
+			m.Status = "delivered"
+			m.DeliveredAt = &deliveredAt.Time
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// GetClientChatHistory returns the chat transcript of a booking for its client
+func GetClientChatHistory(c *gin.Context) {
+	getBookingChat(c, `
+		SELECT id FROM bookings WHERE id = ? AND client_contact = ?
+	`)
+}
+
+// GetExpertChatHistory returns the chat transcript of a booking for its psychologist
+func GetExpertChatHistory(c *gin.Context) {
+	getBookingChat(c, `
+		SELECT b.id FROM bookings b
+		JOIN psychologists p ON b.psychologist_id = p.id
+		WHERE b.id = ? AND p.email = ?
+	`)
+}
+
+// getBookingChat checks ownership with ownerQuery (booking id, email) and
+// returns the transcript
+func getBookingChat(c *gin.Context, ownerQuery string) {
+	email := c.Query("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+
+	var bookingID int
+	err := database.DB.QueryRow(ownerQuery, c.Param("id"), email).Scan(&bookingID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	messages, err := getChatHistory(bookingID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
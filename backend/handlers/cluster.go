@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"counseling-webrtc/pubsub"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// =============================================
+// MULTI-INSTANCE FAN-OUT
+// =============================================
+//
+// Room membership, room traffic, waiting room state and notifications go
+// through the pubsub broker so several backend instances behind a load
+// balancer behave like one. Every instance delivers to its own sockets and
+// ignores events it published itself.
+
+// Time allowed for a single broker operation
+const brokerTimeout = 2 * time.Second
+
+// Room members expire unless refreshed, so the peers of an instance that
+// died without cleaning up stop counting against capacity within a minute
+// or two. Live instances refresh theirs every roomMemberRefresh.
+const (
+	roomMemberTTL     = 90 * time.Second
+	roomMemberRefresh = 30 * time.Second
+)
+
+// Topic carrying notifications for users connected to any instance
+const notifyTopic = "notify"
+
+var (
+	broker     pubsub.Broker = pubsub.NewMemoryBroker()
+	instanceID               = uuid.New().String()
+)
+
+// SetBroker installs the shared backend. Call it once at startup, before
+// serving requests.
+func SetBroker(b pubsub.Broker) error {
+	broker = b
+	_, err := broker.Subscribe(notifyTopic, handleNotifyEvent)
+	return err
+}
+
+// StartRoomHeartbeat keeps the shared room membership of this instance's
+// peers alive. Call it once at startup.
+func StartRoomHeartbeat() {
+	go func() {
+		ticker := time.NewTicker(roomMemberRefresh)
+		defer ticker.Stop()
+		for range ticker.C {
+			manager.refreshMembers()
+		}
+	}()
+}
+
+func brokerCtx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), brokerTimeout)
+}
+
+func roomTopic(roomID string) string    { return "room:" + roomID }
+func roomPeersKey(roomID string) string { return "room:" + roomID + ":members" }
+
+// Kinds of room events
+const (
+	roomEventRelay     = "relay"
+	roomEventAdmission = "admission"
+)
+
+// roomEvent is published on a room topic for the other instances
+type roomEvent struct {
+	Origin string          `json:"origin"`
+	Kind   string          `json:"kind"`
+	Role   string          `json:"role,omitempty"` // relay only to peers with this role
+	Admit  bool            `json:"admit,omitempty"`
+	Frame  json.RawMessage `json:"frame,omitempty"`
+}
+
+// publishRoomEvent sends ev to the other instances serving roomID
+func publishRoomEvent(roomID string, ev roomEvent) {
+	ev.Origin = instanceID
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := brokerCtx()
+	defer cancel()
+	if err := broker.Publish(ctx, roomTopic(roomID), payload); err != nil {
+		log.Printf("[PUBSUB] Failed to publish to room %s: %v", roomID, err)
+	}
+}
+
+// roomEventHandler applies events published by other instances to the
+// local sockets of roomID
+func roomEventHandler(roomID string) pubsub.Handler {
+	return func(payload []byte) {
+		var ev roomEvent
+		if err := json.Unmarshal(payload, &ev); err != nil || ev.Origin == instanceID {
+			return
+		}
+
+		room := manager.get(roomID)
+		if room == nil {
+			return
+		}
+
+		switch ev.Kind {
+		case roomEventRelay:
+			room.deliverLocal(nil, ev.Frame, ev.Role)
+		case roomEventAdmission:
+			manager.resolveLocalLobby(room, ev.Admit)
+		}
+	}
+}
+
+// notifyEvent is published on notifyTopic
+type notifyEvent struct {
+	Origin  string          `json:"origin"`
+	ID      int64           `json:"id"` // Inbox id, 0 if it could not be stored
+	Email   string          `json:"email"`
+	Message json.RawMessage `json:"message"`
+}
+
+// publishNotification forwards a notification to the other instances
+func publishNotification(id int64, email string, payload []byte) {
+	data, err := json.Marshal(notifyEvent{Origin: instanceID, ID: id, Email: email, Message: payload})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := brokerCtx()
+	defer cancel()
+	if err := broker.Publish(ctx, notifyTopic, data); err != nil {
+		log.Printf("[PUBSUB] Failed to publish notification: %v", err)
+	}
+}
+
+func handleNotifyEvent(payload []byte) {
+	var ev notifyEvent
+	if err := json.Unmarshal(payload, &ev); err != nil || ev.Origin == instanceID {
+		return
+	}
+	sendLocalNotification(ev.Email, ev.Message)
+}
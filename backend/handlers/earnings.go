@@ -0,0 +1,497 @@
+package handlers
+
+import (
+	"bytes"
+	"counseling-webrtc/database"
+	"counseling-webrtc/invoice"
+	"counseling-webrtc/models"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// EARNINGS & PAYOUTS
+// =============================================
+//
+// A completed session that was paid, by the client, a package credit or a
+// sponsor, earns the psychologist its price minus the platform commission.
+// The ledger entry is written when the booking is completed, with the
+// commission of that moment; bookings completed before the ledger existed
+// get theirs the first time it is read. Admins record payouts, and what is
+// owed is all net earnings minus all payouts.
+
+var commissionPercent = 20
+
+// SetCommissionPercent sets the platform commission on sessions completed
+// from now on. Call it once at startup.
+func SetCommissionPercent(p int) {
+	commissionPercent = p
+}
+
+// recordEarnings adds ledger entries for the completed, paid bookings
+// matching cond that don't have one yet
+func recordEarnings(db interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, cond string, args ...interface{}) error {
+	rows, err := db.Query(`
+		SELECT b.id, b.psychologist_id, DATE_FORMAT(b.schedule_time, '%Y-%m-%d %H:%i:%s'), IFNULL(st.name, 'Sesi Konseling'),
+			b.payment_status, b.price, IFNULL(pp.price, 0), IFNULL(pp.sessions_total, 0)
+		FROM bookings b
+		LEFT JOIN session_types st ON st.id = b.session_type_id
+		LEFT JOIN package_purchases pp ON pp.id = b.credit_purchase_id
+		LEFT JOIN earnings e ON e.booking_id = b.id
+		WHERE b.status = 'completed' AND b.payment_status IN (?, ?, ?) AND e.id IS NULL AND `+cond,
+		append([]interface{}{paymentPaid, paymentCredit, paymentSponsored}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		bookingID, psychologistID    int
+		sessionTime, desc, source    string
+		price, packagePrice, credits int
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.bookingID, &e.psychologistID, &e.sessionTime, &e.desc, &e.source, &e.price, &e.packagePrice, &e.credits); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, e := range entries {
+		// A package credit is worth its share of what the client paid for the package
+		gross := e.price
+		if e.source == paymentCredit && e.credits > 0 {
+			gross = e.packagePrice / e.credits
+		}
+		commission := gross * commissionPercent / 100
+		_, err := db.Exec(`
+			INSERT IGNORE INTO earnings (booking_id, psychologist_id, session_time, description, source, gross, commission_percent, commission, net, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, e.bookingID, e.psychologistID, e.sessionTime, e.desc, e.source, gross, commissionPercent, commission, gross-commission, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// earningsStatement is a psychologist's ledger over a period
+type earningsStatement struct {
+	From           string           `json:"from"` // YYYY-MM-DD, session dates in WIB
+	To             string           `json:"to"`
+	Sessions       int              `json:"sessions"`
+	Gross          int              `json:"gross"`
+	Commission     int              `json:"commission"`
+	Net            int              `json:"net"`
+	OpeningBalance int              `json:"opening_balance"` // Owed before the period
+	PaidOut        int              `json:"paid_out"`
+	ClosingBalance int              `json:"closing_balance"` // Owed at the end of the period
+	Entries        []models.Earning `json:"entries"`
+	Payouts        []models.Payout  `json:"payouts"`
+}
+
+// statementPeriod reads ?from=&to= (YYYY-MM-DD, inclusive). The default
+// is the current month in WIB.
+func statementPeriod(c *gin.Context) (from, to time.Time, err error) {
+	now := time.Now().In(sessionLocation)
+	from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, sessionLocation)
+	to = from.AddDate(0, 1, -1)
+	if s := c.Query("from"); s != "" {
+		if from, err = time.ParseInLocation("2006-01-02", s, sessionLocation); err != nil {
+			return from, to, fmt.Errorf("from must be YYYY-MM-DD")
+		}
+	}
+	if s := c.Query("to"); s != "" {
+		if to, err = time.ParseInLocation("2006-01-02", s, sessionLocation); err != nil {
+			return from, to, fmt.Errorf("to must be YYYY-MM-DD")
+		}
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to must not be before from")
+	}
+	return from, to, nil
+}
+
+const payoutColumns = `po.id, po.psychologist_id, IFNULL(p.name, ''), po.amount, po.reference, IFNULL(po.note, ''), po.paid_at, po.created_at`
+
+func scanPayout(row interface{ Scan(...interface{}) error }) (models.Payout, error) {
+	var p models.Payout
+	err := row.Scan(&p.ID, &p.PsychologistID, &p.PsychologistName, &p.Amount, &p.Reference, &p.Note, &p.PaidAt, &p.CreatedAt)
+	return p, err
+}
+
+// buildStatement reads a psychologist's ledger for the days from..to
+func buildStatement(psychologistID int, from, to time.Time) (earningsStatement, error) {
+	s := earningsStatement{
+		From:    from.Format("2006-01-02"),
+		To:      to.Format("2006-01-02"),
+		Entries: []models.Earning{},
+		Payouts: []models.Payout{},
+	}
+	if err := recordEarnings(database.DB, "b.psychologist_id = ?", psychologistID); err != nil {
+		return s, err
+	}
+
+	// session_time is wall-clock WIB, paid_at is UTC
+	end := to.AddDate(0, 0, 1)
+	start, stop := from.Format(wallClockFormat), end.Format(wallClockFormat)
+
+	var earnedBefore, paidBefore int
+	database.DB.QueryRow("SELECT IFNULL(SUM(net), 0) FROM earnings WHERE psychologist_id = ? AND session_time < ?", psychologistID, start).Scan(&earnedBefore)
+	database.DB.QueryRow("SELECT IFNULL(SUM(amount), 0) FROM payouts WHERE psychologist_id = ? AND paid_at < ?", psychologistID, from.UTC()).Scan(&paidBefore)
+	s.OpeningBalance = earnedBefore - paidBefore
+
+	rows, err := database.DB.Query(`
+		SELECT id, booking_id, DATE_FORMAT(session_time, '%Y-%m-%dT%H:%i:%s'), description, source, gross, commission_percent, commission, net
+		FROM earnings
+		WHERE psychologist_id = ? AND session_time >= ? AND session_time < ?
+		ORDER BY session_time, id
+	`, psychologistID, start, stop)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.Earning
+		if err := rows.Scan(&e.ID, &e.BookingID, &e.SessionTime, &e.Description, &e.Source, &e.Gross, &e.CommissionPercent, &e.Commission, &e.Net); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		s.Entries = append(s.Entries, e)
+		s.Sessions++
+		s.Gross += e.Gross
+		s.Commission += e.Commission
+		s.Net += e.Net
+	}
+
+	payoutRows, err := database.DB.Query(`SELECT `+payoutColumns+`
+		FROM payouts po LEFT JOIN psychologists p ON p.id = po.psychologist_id
+		WHERE po.psychologist_id = ? AND po.paid_at >= ? AND po.paid_at < ?
+		ORDER BY po.paid_at, po.id`, psychologistID, from.UTC(), end.UTC())
+	if err != nil {
+		return s, err
+	}
+	defer payoutRows.Close()
+	for payoutRows.Next() {
+		p, err := scanPayout(payoutRows)
+		if err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		s.Payouts = append(s.Payouts, p)
+		s.PaidOut += p.Amount
+	}
+
+	s.ClosingBalance = s.OpeningBalance + s.Net - s.PaidOut
+	return s, nil
+}
+
+// expertStatement answers errors itself; ok is false when it did
+func expertStatement(c *gin.Context) (psychologistID int, from, to time.Time, s earningsStatement, ok bool) {
+	psychologistID, found := psychologistIDByEmail(c.Query("email"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+		return
+	}
+	from, to, err := statementPeriod(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s, err = buildStatement(psychologistID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	return psychologistID, from, to, s, true
+}
+
+// =============================================
+// EXPERT: EARNINGS
+// =============================================
+
+// GetExpertEarnings returns the psychologist's statement for ?from=&to=
+// (default: this month)
+func GetExpertEarnings(c *gin.Context) {
+	if _, _, _, s, ok := expertStatement(c); ok {
+		c.JSON(http.StatusOK, s)
+	}
+}
+
+// earningSources names payment statuses on statements
+var earningSources = map[string]string{
+	paymentPaid:      "Dibayar klien",
+	paymentCredit:    "Kredit paket",
+	paymentSponsored: "Sponsor",
+}
+
+// DownloadEarningsCSV serves the statement as CSV: one row per session,
+// then one per payout (negative net)
+func DownloadEarningsCSV(c *gin.Context) {
+	_, _, _, s, ok := expertStatement(c)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"jenis", "tanggal", "referensi", "keterangan", "sumber", "bruto", "komisi_persen", "komisi", "neto"})
+	for _, e := range s.Entries {
+		w.Write([]string{"sesi", strings.Replace(e.SessionTime, "T", " ", 1), strconv.Itoa(e.BookingID), e.Description, earningSources[e.Source],
+			strconv.Itoa(e.Gross), strconv.Itoa(e.CommissionPercent), strconv.Itoa(e.Commission), strconv.Itoa(e.Net)})
+	}
+	for _, p := range s.Payouts {
+		w.Write([]string{"pembayaran", p.PaidAt.In(sessionLocation).Format(wallClockFormat), p.Reference, p.Note, "", "", "", "", strconv.Itoa(-p.Amount)})
+	}
+	w.Flush()
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="pendapatan-%s-%s.csv"`, s.From, s.To))
+	c.Header("Cache-Control", "no-cache, private")
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// DownloadEarningsPDF serves the statement as a PDF
+func DownloadEarningsPDF(c *gin.Context) {
+	psychologistID, from, to, s, ok := expertStatement(c)
+	if !ok {
+		return
+	}
+
+	doc := &invoice.Statement{
+		Clinic:         clinic,
+		IssuedAt:       time.Now().In(sessionLocation),
+		From:           from,
+		To:             to,
+		OpeningBalance: s.OpeningBalance,
+	}
+	var license sql.NullString
+	database.DB.QueryRow("SELECT name, license_number FROM psychologists WHERE id = ?", psychologistID).Scan(&doc.PsychologistName, &license)
+	doc.LicenseNumber = license.String
+	percents := map[int]bool{}
+	for _, e := range s.Entries {
+		percents[e.CommissionPercent] = true
+		date, _ := time.Parse("2006-01-02T15:04:05", e.SessionTime)
+		doc.Lines = append(doc.Lines, invoice.StatementLine{
+			Date:        date,
+			Reference:   "#" + strconv.Itoa(e.BookingID),
+			Description: e.Description,
+			Source:      earningSources[e.Source],
+			Gross:       e.Gross,
+			Commission:  e.Commission,
+			Net:         e.Net,
+		})
+	}
+	if len(percents) == 1 {
+		doc.CommissionNote = fmt.Sprintf("%d%% dari bruto", s.Entries[0].CommissionPercent)
+	} else if len(percents) > 1 {
+		doc.CommissionNote = "Sesuai tarif saat sesi selesai"
+	}
+	for _, p := range s.Payouts {
+		doc.Payouts = append(doc.Payouts, invoice.Payout{Date: p.PaidAt.In(sessionLocation), Reference: p.Reference, Amount: p.Amount})
+	}
+
+	var buf bytes.Buffer
+	if err := invoice.RenderStatement(&buf, doc); err != nil {
+		log.Printf("[EARNINGS] Rendering statement for psychologist %d failed: %v", psychologistID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render statement"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="pendapatan-%s-%s.pdf"`, s.From, s.To))
+	c.Header("Cache-Control", "no-cache, private")
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+// =============================================
+// ADMIN: PAYOUTS
+// =============================================
+
+// owedTo is the balance owed to a psychologist now
+func owedTo(db interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, psychologistID int) (int, error) {
+	var owed int
+	err := db.QueryRow(`
+		SELECT (SELECT IFNULL(SUM(net), 0) FROM earnings WHERE psychologist_id = ?)
+			- (SELECT IFNULL(SUM(amount), 0) FROM payouts WHERE psychologist_id = ?)
+	`, psychologistID, psychologistID).Scan(&owed)
+	return owed, err
+}
+
+// ListEarnings summarises every psychologist's earnings for ?from=&to=
+// (default: this month) with what they are owed now, for payout runs
+func ListEarnings(c *gin.Context) {
+	from, to, err := statementPeriod(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordEarnings(database.DB, "TRUE"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT p.id, p.name,
+			COUNT(e.id), IFNULL(SUM(e.gross), 0), IFNULL(SUM(e.commission), 0), IFNULL(SUM(e.net), 0),
+			(SELECT IFNULL(SUM(net), 0) FROM earnings WHERE psychologist_id = p.id)
+				- (SELECT IFNULL(SUM(amount), 0) FROM payouts WHERE psychologist_id = p.id)
+		FROM psychologists p
+		LEFT JOIN earnings e ON e.psychologist_id = p.id AND e.session_time >= ? AND e.session_time < ?
+		GROUP BY p.id, p.name
+		ORDER BY p.name
+	`, from.Format(wallClockFormat), to.AddDate(0, 0, 1).Format(wallClockFormat))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type summary struct {
+		PsychologistID   int    `json:"psychologist_id"`
+		PsychologistName string `json:"psychologist_name"`
+		Sessions         int    `json:"sessions"`
+		Gross            int    `json:"gross"`
+		Commission       int    `json:"commission"`
+		Net              int    `json:"net"`
+		Owed             int    `json:"owed"` // All time, now
+	}
+	summaries := []summary{}
+	for rows.Next() {
+		var s summary
+		if err := rows.Scan(&s.PsychologistID, &s.PsychologistName, &s.Sessions, &s.Gross, &s.Commission, &s.Net, &s.Owed); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		summaries = append(summaries, s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"from": from.Format("2006-01-02"), "to": to.Format("2006-01-02"), "psychologists": summaries})
+}
+
+// ListPayouts lists payouts, newest first, optionally of one psychologist
+func ListPayouts(c *gin.Context) {
+	query := `SELECT ` + payoutColumns + ` FROM payouts po LEFT JOIN psychologists p ON p.id = po.psychologist_id`
+	var args []interface{}
+	if id := c.Query("psychologist_id"); id != "" {
+		query += " WHERE po.psychologist_id = ?"
+		args = append(args, id)
+	}
+
+	rows, err := database.DB.Query(query+" ORDER BY po.paid_at DESC, po.id DESC LIMIT 500", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	payouts := []models.Payout{}
+	for rows.Next() {
+		p, err := scanPayout(rows)
+		if err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		payouts = append(payouts, p)
+	}
+
+	c.JSON(http.StatusOK, payouts)
+}
+
+// CreatePayout records a transfer to a psychologist. It can't exceed what
+// they are owed.
+func CreatePayout(c *gin.Context) {
+	var input struct {
+		PsychologistID int    `json:"psychologist_id" binding:"required"`
+		Amount         int    `json:"amount" binding:"required"`
+		Reference      string `json:"reference" binding:"required"` // Bank transfer reference
+		Note           string `json:"note"`
+		PaidAt         string `json:"paid_at"` // RFC 3339; default now
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	paidAt := now
+	if input.PaidAt != "" {
+		t, err := time.Parse(time.RFC3339, input.PaidAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "paid_at must be RFC 3339"})
+			return
+		}
+		paidAt = t.UTC().Truncate(time.Second)
+	}
+	if err := recordEarnings(database.DB, "b.psychologist_id = ?", input.PsychologistID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payout"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the psychologist so two payouts can't both pass the balance check
+	var name string
+	if err := tx.QueryRow("SELECT name FROM psychologists WHERE id = ? FOR UPDATE", input.PsychologistID).Scan(&name); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+		return
+	}
+	owed, err := owedTo(tx, input.PsychologistID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payout"})
+		return
+	}
+	if input.Amount > owed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("amount exceeds the balance owed (%d)", owed)})
+		return
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO payouts (psychologist_id, amount, reference, note, paid_at, created_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, ?)
+	`, input.PsychologistID, input.Amount, input.Reference, input.Note, paidAt, now)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payout"})
+		return
+	}
+	id, _ := res.LastInsertId()
+	log.Printf("[EARNINGS] Payout %d of %d to psychologist %d (%s)", id, input.Amount, input.PsychologistID, input.Reference)
+
+	c.JSON(http.StatusOK, models.Payout{
+		ID:               int(id),
+		PsychologistID:   input.PsychologistID,
+		PsychologistName: name,
+		Amount:           input.Amount,
+		Reference:        input.Reference,
+		Note:             input.Note,
+		PaidAt:           paidAt,
+		CreatedAt:        now,
+	})
+}
@@ -0,0 +1,510 @@
+package handlers
+
+import (
+	"counseling-webrtc/pubsub"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+	// Time allowed to read the next pong message from the peer
+	pongWait = 60 * time.Second
+	// Send pings to peer with this period (must be less than pongWait)
+	pingPeriod = (pongWait * 9) / 10
+	// Maximum message size allowed from peer (SDP offers can be a few KB)
+	maxMessageSize = 64 * 1024
+	// Time allowed for a new connection to send its join frame
+	joinWait = 10 * time.Second
+	// Outgoing messages buffered per connection before it is considered too slow
+	sendBufferSize = 64
+)
+
+// wsClient wraps a websocket connection with a buffered send queue.
+// All writes to the connection happen on its own writer goroutine, so a
+// slow or dead client never blocks whoever is sending to it.
+type wsClient struct {
+	id        string
+	conn      *websocket.Conn
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	written   func(payload []byte) // Called after each frame is written, if set
+	admitted  func()               // Called when the client leaves the lobby as a peer, if set
+}
+
+// newWSClient wraps a signaling connection
+func newWSClient(conn *websocket.Conn) *wsClient {
+	return startWSClient(conn, sendBufferSize, nil)
+}
+
+// newNotifyWSClient wraps a /api/notify connection. It has room for a
+// full inbox replay on top of live traffic, and marks notifications
+// delivered once they are written.
+func newNotifyWSClient(conn *websocket.Conn) *wsClient {
+	return startWSClient(conn, sendBufferSize+maxInboxItems, markFrameDelivered)
+}
+
+func startWSClient(conn *websocket.Conn, buffer int, written func(payload []byte)) *wsClient {
+	c := &wsClient{
+		id:      uuid.New().String(),
+		conn:    conn,
+		send:    make(chan []byte, buffer),
+		done:    make(chan struct{}),
+		written: written,
+	}
+
+	// Read side: size limit, deadline refreshed on every pong
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	go c.writePump()
+	return c
+}
+
+// member is the id of the connection in shared room membership sets
+func (c *wsClient) member() string {
+	return instanceID + "/" + c.id
+}
+
+// enqueue marshals v and queues it for delivery without blocking.
+// If the send buffer is full the client is dropped as too slow.
+func (c *wsClient) enqueue(v interface{}) bool {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[WS] Failed to marshal message: %v", err)
+		return false
+	}
+	return c.enqueueRaw(payload)
+}
+
+// enqueueRaw queues an already encoded frame (used for fan-out so the
+// message is marshalled once per broadcast, not once per peer).
+func (c *wsClient) enqueueRaw(payload []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- payload:
+		return true
+	case <-c.done:
+		return false
+	default:
+		log.Printf("[WS] Send buffer full for %s, dropping connection", c.conn.RemoteAddr())
+		c.close()
+		return false
+	}
+}
+
+// close stops the writer goroutine and closes the underlying connection.
+// Safe to call multiple times and from any goroutine.
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// writePump drains the send queue and keeps the connection alive with pings.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case payload := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				log.Printf("[WS] Write error: %v", err)
+				c.close()
+				return
+			}
+			c.wrote(payload)
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			// Flush whatever is still queued (e.g. "full") before closing
+			for {
+				select {
+				case payload := <-c.send:
+					c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+					if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+						return
+					}
+					c.wrote(payload)
+				default:
+					c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+					c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *wsClient) wrote(payload []byte) {
+	if c.written != nil {
+		c.written(payload)
+	}
+}
+
+// Room is a single signaling room as seen by this instance. Each room has
+// its own lock so traffic in one room never waits on another. Clients that
+// still need to be admitted by the psychologist sit in the lobby and
+// receive no signaling. Peers connected to other instances are reached
+// through the broker (see cluster.go).
+//
+// mutex guards the maps and is never held during broker calls. admission
+// serializes joins, lobby decisions and leaves of this room, which do call
+// the broker; it is always taken before mutex.
+type Room struct {
+	id        string
+	mutex     sync.Mutex
+	admission sync.Mutex
+	peers     map[*wsClient]*participant
+	lobby     map[*wsClient]*participant
+	sub       pubsub.Subscription
+	subscribe sync.Once
+	joining   int // Joins in progress; guarded by the manager lock
+}
+
+// deliverLocal queues an encoded frame for the local peers except from,
+// optionally only those with role. It returns how many peers got it.
+func (r *Room) deliverLocal(from *wsClient, payload []byte, role string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sent := 0
+	for peer, p := range r.peers {
+		if peer == from || (role != "" && p.Role != role) {
+			continue
+		}
+		if peer.enqueueRaw(payload) {
+			sent++
+		}
+	}
+	return sent
+}
+
+// broadcast queues msg for every peer in the room except from, on this and
+// every other instance.
+func (r *Room) broadcast(from *wsClient, msg interface{}) {
+	r.broadcastRole("", from, msg)
+}
+
+// broadcastRole queues msg for every admitted peer with the given role
+// (all peers if role is empty) except from.
+func (r *Room) broadcastRole(role string, from *wsClient, msg interface{}) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[WS] Failed to marshal message: %v", err)
+		return
+	}
+
+	r.deliverLocal(from, payload, role)
+	publishRoomEvent(r.id, roomEvent{Kind: roomEventRelay, Role: role, Frame: payload})
+}
+
+// sendToRoom queues msg for the peers of roomID with role on every
+// instance, whether or not anyone on this instance is in the room
+func sendToRoom(roomID, role string, msg interface{}) {
+	if room := manager.get(roomID); room != nil {
+		room.broadcastRole(role, nil, msg)
+		return
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[WS] Failed to marshal message: %v", err)
+		return
+	}
+	publishRoomEvent(roomID, roomEvent{Kind: roomEventRelay, Role: role, Frame: payload})
+}
+
+// size returns the number of admitted peers in the room across instances
+func (r *Room) size() int {
+	ctx, cancel := brokerCtx()
+	defer cancel()
+	members, err := broker.SetMembers(ctx, roomPeersKey(r.id))
+	if err != nil {
+		log.Printf("[PUBSUB] Failed to read members of room %s: %v", r.id, err)
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return len(r.peers)
+	}
+	return len(members)
+}
+
+// isPeer reports whether c has been admitted to the room
+func (r *Room) isPeer(c *wsClient) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, ok := r.peers[c]
+	return ok
+}
+
+// addPeer registers c as a peer, taking it out of the lobby, if the room
+// (across instances) has space. Caller must hold the admission lock but not
+// the room lock.
+func (r *Room) addPeer(c *wsClient, p *participant) bool {
+	ctx, cancel := brokerCtx()
+	defer cancel()
+
+	count, err := broker.SetAdd(ctx, roomPeersKey(r.id), c.member(), roomMemberTTL)
+
+	r.mutex.Lock()
+	if err != nil {
+		log.Printf("[PUBSUB] Failed to add member to room %s: %v", r.id, err)
+		count = int64(len(r.peers) + 1)
+	}
+	full := count > roomCapacity
+	if !full {
+		delete(r.lobby, c)
+		r.peers[c] = p
+	}
+	r.mutex.Unlock()
+
+	if full {
+		broker.SetRemove(ctx, roomPeersKey(r.id), c.member())
+	}
+	return !full
+}
+
+// refreshMembers re-adds the local peers to the shared membership set
+// before their entries expire (see roomMemberTTL)
+func (r *Room) refreshMembers() {
+	r.admission.Lock()
+	defer r.admission.Unlock()
+
+	r.mutex.Lock()
+	members := make([]string, 0, len(r.peers))
+	for c := range r.peers {
+		members = append(members, c.member())
+	}
+	r.mutex.Unlock()
+
+	for _, member := range members {
+		ctx, cancel := brokerCtx()
+		if _, err := broker.SetAdd(ctx, roomPeersKey(r.id), member, roomMemberTTL); err != nil {
+			log.Printf("[PUBSUB] Failed to refresh member of room %s: %v", r.id, err)
+		}
+		cancel()
+	}
+}
+
+// RoomManager handles the state of chat rooms (Signaling).
+// The manager lock only guards the rooms map and is never held during
+// broker calls. Lock order is manager -> room mutex.
+type RoomManager struct {
+	rooms map[string]*Room
+	mutex sync.Mutex
+}
+
+var manager = RoomManager{
+	rooms: make(map[string]*Room),
+}
+
+// Calls are peer-to-peer between two connections: every room is one
+// client and one psychologist
+const roomCapacity = 2
+
+// Result of RoomManager.join
+const (
+	joinAdmitted = "joined"
+	joinWaiting  = "waiting"
+	joinFull     = "full"
+)
+
+// get returns the room with roomID, or nil if nobody on this instance is
+// connected to it
+func (m *RoomManager) get(roomID string) *Room {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.rooms[roomID]
+}
+
+// acquire returns the room with roomID, creating and subscribing it if
+// needed. The room is not dropped before the matching release.
+func (m *RoomManager) acquire(roomID string) *Room {
+	m.mutex.Lock()
+	room, ok := m.rooms[roomID]
+	if !ok {
+		room = &Room{
+			id:    roomID,
+			peers: make(map[*wsClient]*participant),
+			lobby: make(map[*wsClient]*participant),
+		}
+		m.rooms[roomID] = room
+	}
+	room.joining++
+	m.mutex.Unlock()
+
+	// Outside the manager lock: with Redis this is a network round trip.
+	// Later joiners wait here until the first one has subscribed.
+	room.subscribe.Do(func() {
+		sub, err := broker.Subscribe(roomTopic(roomID), roomEventHandler(roomID))
+		if err != nil {
+			log.Printf("[PUBSUB] Failed to subscribe to room %s: %v", roomID, err)
+		}
+		room.sub = sub
+	})
+	return room
+}
+
+// release ends an acquire and drops the room if nobody ended up in it
+func (m *RoomManager) release(room *Room) {
+	m.mutex.Lock()
+	room.joining--
+	m.mutex.Unlock()
+	m.dropIfEmpty(room)
+}
+
+// dropIfEmpty forgets the room once it has no peers, lobby or joins in
+// progress on this instance
+func (m *RoomManager) dropIfEmpty(room *Room) {
+	m.mutex.Lock()
+	room.mutex.Lock()
+	empty := len(room.peers) == 0 && len(room.lobby) == 0 && room.joining == 0
+	room.mutex.Unlock()
+	drop := empty && m.rooms[room.id] == room
+	if drop {
+		delete(m.rooms, room.id)
+	}
+	m.mutex.Unlock()
+
+	if drop && room.sub != nil {
+		room.sub.Close()
+	}
+}
+
+// join adds c to the room, creating it if needed, admitting at most
+// roomCapacity peers. Clients that have not been admitted by the psychologist
+// yet are placed in the lobby.
+func (m *RoomManager) join(roomID string, c *wsClient, p *participant) (*Room, string) {
+	room := m.acquire(roomID)
+	defer m.release(room)
+
+	// Holding admission, a decision can't slip in between the status check
+	// and entering the lobby
+	room.admission.Lock()
+	defer room.admission.Unlock()
+
+	admitted := p.Role != RoleClient || waitingRoom.status(roomID) == admissionAdmitted
+	if !admitted {
+		room.mutex.Lock()
+		room.lobby[c] = p
+		room.mutex.Unlock()
+		return room, joinWaiting
+	}
+	if !room.addPeer(c, p) {
+		return room, joinFull
+	}
+	return room, joinAdmitted
+}
+
+// leave removes c from the room (or its lobby) and drops the room once it
+// is empty on this instance. It returns the number of peers still in the
+// room across instances and whether c was one of them (lobby and rejected
+// connections never were, so the peers are not told they left).
+func (m *RoomManager) leave(room *Room, c *wsClient) (int, bool) {
+	room.admission.Lock()
+	room.mutex.Lock()
+	_, wasPeer := room.peers[c]
+	delete(room.peers, c)
+	delete(room.lobby, c)
+	remaining := len(room.peers)
+	room.mutex.Unlock()
+
+	if wasPeer {
+		ctx, cancel := brokerCtx()
+		if count, err := broker.SetRemove(ctx, roomPeersKey(room.id), c.member()); err == nil {
+			remaining = int(count)
+		} else {
+			log.Printf("[PUBSUB] Failed to remove member from room %s: %v", room.id, err)
+		}
+		cancel()
+	}
+	room.admission.Unlock()
+
+	m.dropIfEmpty(room)
+	return remaining, wasPeer
+}
+
+// refreshMembers keeps the shared membership of every local peer alive
+func (m *RoomManager) refreshMembers() {
+	m.mutex.Lock()
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	m.mutex.Unlock()
+
+	for _, room := range rooms {
+		room.refreshMembers()
+	}
+}
+
+// resolveLobby admits or denies every client waiting in the lobby of
+// roomID, on this and every other instance.
+func (m *RoomManager) resolveLobby(roomID string, admit bool) {
+	if room := m.get(roomID); room != nil {
+		m.resolveLocalLobby(room, admit)
+	}
+	publishRoomEvent(roomID, roomEvent{Kind: roomEventAdmission, Admit: admit})
+}
+
+// resolveLocalLobby applies an admission decision to the lobby of this
+// instance. Admitted clients become peers and the others are told someone
+// joined; denied clients are told so and disconnected.
+func (m *RoomManager) resolveLocalLobby(room *Room, admit bool) {
+	var admitted []*wsClient
+
+	room.admission.Lock()
+	room.mutex.Lock()
+	waiting := make(map[*wsClient]*participant, len(room.lobby))
+	for c, p := range room.lobby {
+		if !admit {
+			delete(room.lobby, c)
+			c.enqueue(newMessage(MsgDenied, nil))
+			c.close()
+			continue
+		}
+		waiting[c] = p
+	}
+	room.mutex.Unlock()
+
+	// Nobody leaves while we hold admission, so the lobby snapshot holds
+	for c, p := range waiting {
+		if !room.addPeer(c, p) {
+			break
+		}
+		admitted = append(admitted, c)
+	}
+	room.admission.Unlock()
+
+	// Broadcast without the room lock held
+	for _, c := range admitted {
+		c.enqueue(newMessage(MsgAdmitted, AdmittedData{Peers: room.size()}))
+		room.broadcast(c, newMessage(MsgPeerJoined, nil))
+		if c.admitted != nil {
+			c.admitted()
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"context"
+	"counseling-webrtc/pubsub"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Run with -race: these exercise the room and manager locks concurrently.
+
+// resetHub gives the test an empty in-memory broker and room manager
+func resetHub(t *testing.T) {
+	t.Helper()
+	broker = pubsub.NewMemoryBroker()
+	manager.mutex.Lock()
+	manager.rooms = make(map[string]*Room)
+	manager.mutex.Unlock()
+}
+
+var testClientSeq int64
+
+// newTestClient returns a connection without a socket: frames queued for
+// it stay in send for the test to read
+func newTestClient() *wsClient {
+	return newBufferedTestClient(sendBufferSize)
+}
+
+// newBufferedTestClient is newTestClient with room for size frames
+func newBufferedTestClient(size int) *wsClient {
+	return &wsClient{
+		id:   fmt.Sprintf("test-%d", atomic.AddInt64(&testClientSeq, 1)),
+		send: make(chan []byte, size),
+		done: make(chan struct{}),
+	}
+}
+
+// expectFrame waits for the next frame queued for c and checks its type
+func expectFrame(t *testing.T, c *wsClient, msgType string) {
+	t.Helper()
+	select {
+	case payload := <-c.send:
+		var msg Message
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Fatalf("bad frame %s: %v", payload, err)
+		}
+		if msg.Type != msgType {
+			t.Fatalf("frame type = %q, want %q", msg.Type, msgType)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no %q frame", msgType)
+	}
+}
+
+// expectNoFrame checks that nothing is queued for c
+func expectNoFrame(t *testing.T, c *wsClient) {
+	t.Helper()
+	select {
+	case payload := <-c.send:
+		t.Fatalf("unexpected frame %s", payload)
+	default:
+	}
+}
+
+func roomMembers(t *testing.T, roomID string) []string {
+	t.Helper()
+	members, err := broker.SetMembers(context.Background(), roomPeersKey(roomID))
+	if err != nil {
+		t.Fatalf("SetMembers: %v", err)
+	}
+	return members
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	resetHub(t)
+	expert := &participant{Role: RoleExpert}
+
+	tests := []struct {
+		name       string
+		joins      int
+		wantJoined int
+	}{
+		{"alone", 1, 1},
+		{"one-to-one", 2, 2},
+		{"third connection refused", 3, 2},
+		{"more refused", 5, 2},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomID := fmt.Sprintf("join-%d", i)
+			var clients []*wsClient
+			var room *Room
+			joined := 0
+			for j := 0; j < tt.joins; j++ {
+				c := newTestClient()
+				r, status := manager.join(roomID, c, expert)
+				room = r
+				switch status {
+				case joinAdmitted:
+					joined++
+					clients = append(clients, c)
+				case joinFull:
+					if r.isPeer(c) || len(roomMembers(t, roomID)) != joined {
+						t.Errorf("rejected connection kept in the room")
+					}
+				default:
+					t.Fatalf("join status = %q", status)
+				}
+			}
+			if joined != tt.wantJoined {
+				t.Fatalf("joined = %d, want %d", joined, tt.wantJoined)
+			}
+			if got := room.size(); got != joined {
+				t.Errorf("size = %d, want %d", got, joined)
+			}
+
+			for j, c := range clients {
+				remaining, wasPeer := manager.leave(room, c)
+				if !wasPeer {
+					t.Errorf("leave %d: wasPeer = false", j)
+				}
+				if want := len(clients) - j - 1; remaining != want {
+					t.Errorf("leave %d: remaining = %d, want %d", j, remaining, want)
+				}
+			}
+			if manager.get(roomID) != nil {
+				t.Error("empty room was not dropped")
+			}
+			if members := roomMembers(t, roomID); len(members) != 0 {
+				t.Errorf("members after leave = %v", members)
+			}
+		})
+	}
+}
+
+func TestLobby(t *testing.T) {
+	resetHub(t)
+	const roomID = "lobby"
+
+	expertConn := newTestClient()
+	room, status := manager.join(roomID, expertConn, &participant{Role: RoleExpert})
+	if status != joinAdmitted {
+		t.Fatalf("expert: status = %q", status)
+	}
+
+	client := &participant{Role: RoleClient}
+	waiting := newTestClient()
+	if _, status := manager.join(roomID, waiting, client); status != joinWaiting {
+		t.Fatalf("client: status = %q, want waiting", status)
+	}
+
+	// A lobby connection gets no signaling and never counts as a peer
+	room.broadcast(expertConn, newMessage(MsgChat, nil))
+	expectNoFrame(t, waiting)
+	if remaining, wasPeer := manager.leave(room, waiting); wasPeer || remaining != 1 {
+		t.Errorf("lobby leave = (%d, %v), want (1, false)", remaining, wasPeer)
+	}
+
+	// The transcript is replayed on admission, never to the lobby
+	waiting = newTestClient()
+	replayed := 0
+	waiting.admitted = func() { replayed++ }
+	manager.join(roomID, waiting, client)
+	if replayed != 0 {
+		t.Error("lobby client was admitted on join")
+	}
+	waitingRoom.enter(roomID, roomBooking{BookingID: 1})
+	if _, ok := waitingRoom.decide(roomID, true); !ok {
+		t.Fatal("decide failed")
+	}
+	manager.resolveLobby(roomID, true)
+	expectFrame(t, waiting, MsgAdmitted)
+	expectFrame(t, expertConn, MsgPeerJoined)
+	if !room.isPeer(waiting) {
+		t.Error("admitted client is not a peer")
+	}
+	if replayed != 1 {
+		t.Errorf("admitted hook ran %d times, want 1", replayed)
+	}
+
+	// Decided once: a second decision is refused
+	if _, ok := waitingRoom.decide(roomID, false); ok {
+		t.Error("admitted client was decided again")
+	}
+
+	// Reconnecting after admission skips the lobby, up to capacity
+	manager.leave(room, waiting)
+	if _, status := manager.join(roomID, newTestClient(), client); status != joinAdmitted {
+		t.Errorf("rejoin: status = %q, want joined", status)
+	}
+	if _, status := manager.join(roomID, newTestClient(), client); status != joinFull {
+		t.Errorf("third peer: status = %q, want full", status)
+	}
+}
+
+func TestLobbyDenied(t *testing.T) {
+	resetHub(t)
+	const roomID = "denied"
+
+	waiting := newTestClient()
+	waiting.admitted = func() { t.Error("denied client was admitted") }
+	room, _ := manager.join(roomID, waiting, &participant{Role: RoleClient})
+	waitingRoom.enter(roomID, roomBooking{BookingID: 1})
+	waitingRoom.decide(roomID, false)
+	manager.resolveLobby(roomID, false)
+
+	expectFrame(t, waiting, MsgDenied)
+	select {
+	case <-waiting.done:
+	default:
+		t.Error("denied client was not closed")
+	}
+	if _, wasPeer := manager.leave(room, waiting); wasPeer {
+		t.Error("denied client was a peer")
+	}
+	if manager.get(roomID) != nil {
+		t.Error("empty room was not dropped")
+	}
+}
+
+func TestLobbyConcurrentDecisions(t *testing.T) {
+	resetHub(t)
+	const (
+		roomID  = "crossing"
+		workers = 8
+	)
+
+	// Every instance sees the client knock at once: only one announces it,
+	// and only one of the crossing decisions counts
+	var announced, decided int32
+	var admits int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, isNew := waitingRoom.enter(roomID, roomBooking{BookingID: 1}); isNew {
+				atomic.AddInt32(&announced, 1)
+			}
+			if a, ok := waitingRoom.decide(roomID, i%2 == 0); ok {
+				atomic.AddInt32(&decided, 1)
+				if a.Status == admissionAdmitted {
+					atomic.AddInt32(&admits, 1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if announced != 1 || decided != 1 {
+		t.Fatalf("announced %d, decided %d; want 1, 1", announced, decided)
+	}
+	want := admissionDenied
+	if admits == 1 {
+		want = admissionAdmitted
+	}
+	if got := waitingRoom.status(roomID); got != want {
+		t.Errorf("status = %q, want the recorded decision %q", got, want)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	resetHub(t)
+	const roomID = "broadcast"
+	a, b := newTestClient(), newTestClient()
+	room, _ := manager.join(roomID, a, &participant{Role: RoleExpert})
+	manager.join(roomID, b, &participant{Role: RoleClient})
+	waitingRoom.enter(roomID, roomBooking{BookingID: 1})
+	waitingRoom.decide(roomID, true)
+	manager.resolveLobby(roomID, true)
+	expectFrame(t, b, MsgAdmitted)
+	expectFrame(t, a, MsgPeerJoined)
+
+	// Both sides send at once; each gets every frame of the other and none
+	// of its own
+	const frames = sendBufferSize / 2
+	var wg sync.WaitGroup
+	for _, from := range []*wsClient{a, b} {
+		wg.Add(1)
+		go func(from *wsClient) {
+			defer wg.Done()
+			for i := 0; i < frames; i++ {
+				room.broadcast(from, newMessage(MsgChat, nil))
+			}
+		}(from)
+	}
+	wg.Wait()
+	for _, c := range []*wsClient{a, b} {
+		if got := len(c.send); got != frames {
+			t.Errorf("queued = %d, want %d", got, frames)
+		}
+	}
+
+	// Role-addressed frames only reach that role
+	for len(a.send) > 0 {
+		<-a.send
+	}
+	for len(b.send) > 0 {
+		<-b.send
+	}
+	room.broadcastRole(RoleClient, nil, newMessage(MsgChat, nil))
+	expectFrame(t, b, MsgChat)
+	expectNoFrame(t, a)
+}
+
+func TestClosedPeer(t *testing.T) {
+	resetHub(t)
+	const roomID = "closed"
+	a, b := newTestClient(), newTestClient()
+	expert := &participant{Role: RoleExpert}
+	room, _ := manager.join(roomID, a, expert)
+	manager.join(roomID, b, expert)
+
+	// A closed connection takes no more frames, but stays a peer until its
+	// handler leaves
+	b.close()
+	b.close() // Safe to repeat
+	if b.enqueue(newMessage(MsgChat, nil)) {
+		t.Error("enqueue succeeded on a closed connection")
+	}
+	payload, _ := json.Marshal(newMessage(MsgChat, nil))
+	if sent := room.deliverLocal(a, payload, ""); sent != 0 {
+		t.Errorf("delivered to %d closed peers", sent)
+	}
+
+	remaining, wasPeer := manager.leave(room, b)
+	if !wasPeer || remaining != 1 {
+		t.Errorf("leave = (%d, %v), want (1, true)", remaining, wasPeer)
+	}
+	if wasPeer && remaining > 0 {
+		room.broadcast(b, newMessage(MsgPeerLeft, nil))
+	}
+	expectFrame(t, a, MsgPeerLeft)
+}
+
+func TestConcurrentJoinLeave(t *testing.T) {
+	resetHub(t)
+	const (
+		roomID  = "stress"
+		workers = 16
+		rounds  = 50
+	)
+	expert := &participant{Role: RoleExpert}
+
+	var inRoom, maxInRoom int32
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				// Nobody reads these: size the buffer for every frame the
+				// others can send (a chat and a peer-left each)
+				c := newBufferedTestClient(2 * workers * rounds)
+				room, status := manager.join(roomID, c, expert)
+				if status == joinAdmitted {
+					n := atomic.AddInt32(&inRoom, 1)
+					for {
+						max := atomic.LoadInt32(&maxInRoom)
+						if n <= max || atomic.CompareAndSwapInt32(&maxInRoom, max, n) {
+							break
+						}
+					}
+					room.broadcast(c, newMessage(MsgChat, nil))
+					atomic.AddInt32(&inRoom, -1)
+				}
+				if remaining, wasPeer := manager.leave(room, c); wasPeer && remaining > 0 {
+					room.broadcast(c, newMessage(MsgPeerLeft, nil))
+				}
+				c.close()
+			}
+		}()
+	}
+
+	// The heartbeat runs alongside joins and leaves
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				manager.refreshMembers()
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+
+	if maxInRoom > roomCapacity {
+		t.Errorf("%d peers in the room at once, capacity %d", maxInRoom, roomCapacity)
+	}
+	if manager.get(roomID) != nil {
+		t.Error("empty room was not dropped")
+	}
+	// A refresh racing the last leave must not resurrect a member
+	time.Sleep(10 * time.Millisecond)
+	if members := roomMembers(t, roomID); len(members) != 0 {
+		t.Errorf("members after everyone left = %v", members)
+	}
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"bytes"
+	"counseling-webrtc/database"
+	"counseling-webrtc/invoice"
+	"counseling-webrtc/models"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// INVOICES
+// =============================================
+//
+// Every paid booking gets one invoice, numbered INV/<year>/<seq> without
+// gaps within a year. The invoice row copies what was sold (client,
+// psychologist and license number, session, amount) when the payment
+// arrives, so later edits never change an issued document. PDFs are
+// rendered on request from that row; only the clinic letterhead and a
+// refund correction line are current.
+
+// clinic is printed as the issuer of every invoice
+var clinic = invoice.Clinic{Name: "SafeSpace Counseling"}
+
+// SetClinic sets the clinic details printed on invoices. Call it once at
+// startup.
+func SetClinic(c invoice.Clinic) {
+	clinic = c
+}
+
+// nextInvoiceNumber reserves the next number of the year issuedAt falls
+// in. The sequence row stays locked until tx ends, so numbers are handed
+// out in commit order without gaps.
+func nextInvoiceNumber(tx *sql.Tx, issuedAt time.Time) (string, error) {
+	year := issuedAt.In(sessionLocation).Year()
+	if _, err := tx.Exec("INSERT INTO invoice_sequences (year, last_number) VALUES (?, 1) ON DUPLICATE KEY UPDATE last_number = last_number + 1", year); err != nil {
+		return "", err
+	}
+	var n int
+	if err := tx.QueryRow("SELECT last_number FROM invoice_sequences WHERE year = ?", year).Scan(&n); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("INV/%d/%06d", year, n), nil
+}
+
+// issueInvoice records the invoice of a booking paid by paymentID. A
+// booking that already has one keeps it.
+func issueInvoice(tx *sql.Tx, bookingID, paymentID int) error {
+	var exists int
+	err := tx.QueryRow("SELECT id FROM invoices WHERE booking_id = ?", bookingID).Scan(&exists)
+	if err == nil {
+		return nil
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	inv := models.Invoice{BookingID: bookingID, PaymentID: paymentID}
+	var contact, license sql.NullString
+	err = tx.QueryRow(`
+		SELECT b.client_name, b.client_contact, IFNULL(p.name, ''), p.license_number, IFNULL(st.name, ?),
+			b.schedule_time, b.duration_minutes, b.modality,
+			pay.amount, pay.provider, pay.order_id, IFNULL(pay.paid_at, pay.updated_at)
+		FROM bookings b
+		JOIN payments pay ON pay.id = ? AND pay.booking_id = b.id
+		LEFT JOIN psychologists p ON b.psychologist_id = p.id
+		LEFT JOIN session_types st ON b.session_type_id = st.id
+		WHERE b.id = ?
+	`, defaultSessionType.Name, paymentID, bookingID).Scan(&inv.ClientName, &contact, &inv.PsychologistName, &license, &inv.Description,
+		&inv.ScheduleTime, &inv.DurationMinutes, &inv.Modality,
+		&inv.Amount, &inv.PaymentProvider, &inv.OrderID, &inv.PaidAt)
+	if err != nil {
+		return err
+	}
+	inv.ClientContact = contact.String
+	inv.LicenseNumber = license.String
+
+	inv.IssuedAt = time.Now().UTC().Truncate(time.Second)
+	if inv.InvoiceNumber, err = nextInvoiceNumber(tx, inv.IssuedAt); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO invoices (invoice_number, booking_id, payment_id, amount, client_name, client_contact, psychologist_name, license_number,
+			description, schedule_time, duration_minutes, modality, payment_provider, order_id, paid_at, issued_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, inv.InvoiceNumber, inv.BookingID, inv.PaymentID, inv.Amount, inv.ClientName, nullString(inv.ClientContact), inv.PsychologistName, nullString(inv.LicenseNumber),
+		inv.Description, inv.ScheduleTime, inv.DurationMinutes, inv.Modality, inv.PaymentProvider, inv.OrderID, inv.PaidAt, inv.IssuedAt)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INVOICE] Issued %s for booking %d", inv.InvoiceNumber, bookingID)
+	return nil
+}
+
+// nullString stores "" as NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// ensureInvoice issues the invoice of a booking paid before invoices
+// existed (or whose issuing failed) and returns its id
+func ensureInvoice(bookingID int) (int, error) {
+	var id int
+	err := database.DB.QueryRow("SELECT id FROM invoices WHERE booking_id = ?", bookingID).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var paymentID int
+	err = tx.QueryRow("SELECT id FROM payments WHERE booking_id = ? AND status = 'paid' ORDER BY paid_at, id LIMIT 1", bookingID).Scan(&paymentID)
+	if err != nil {
+		return 0, err
+	}
+	if err := issueInvoice(tx, bookingID, paymentID); err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRow("SELECT id FROM invoices WHERE booking_id = ?", bookingID).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+const invoiceColumns = `id, invoice_number, booking_id, payment_id, amount, client_name, IFNULL(client_contact, ''), psychologist_name, IFNULL(license_number, ''),
+	description, schedule_time, duration_minutes, modality, payment_provider, order_id, paid_at, issued_at`
+
+func scanInvoice(row interface{ Scan(...interface{}) error }) (models.Invoice, error) {
+	var inv models.Invoice
+	err := row.Scan(&inv.ID, &inv.InvoiceNumber, &inv.BookingID, &inv.PaymentID, &inv.Amount, &inv.ClientName, &inv.ClientContact, &inv.PsychologistName, &inv.LicenseNumber,
+		&inv.Description, &inv.ScheduleTime, &inv.DurationMinutes, &inv.Modality, &inv.PaymentProvider, &inv.OrderID, &inv.PaidAt, &inv.IssuedAt)
+	return inv, err
+}
+
+// invoiceDocument turns an invoice row into the printed document. refunded
+// is what has been returned to the client since.
+func invoiceDocument(inv models.Invoice, refunded int) *invoice.Invoice {
+	detail := fmt.Sprintf("Sesi %s, %d menit", formatSessionTime(inv.ScheduleTime), inv.DurationMinutes)
+	if label, ok := modalityLabels[inv.Modality]; ok {
+		detail += ", " + label
+	}
+	return &invoice.Invoice{
+		Number:           inv.InvoiceNumber,
+		IssuedAt:         inv.IssuedAt.In(sessionLocation),
+		PaidAt:           inv.PaidAt.In(sessionLocation),
+		Clinic:           clinic,
+		ClientName:       inv.ClientName,
+		ClientContact:    inv.ClientContact,
+		PsychologistName: inv.PsychologistName,
+		LicenseNumber:    inv.LicenseNumber,
+		Items: []invoice.Item{{
+			Description: inv.Description + " - " + inv.PsychologistName,
+			Detail:      detail,
+			Quantity:    1,
+			UnitPrice:   inv.Amount,
+		}},
+		PaymentMethod: inv.PaymentProvider,
+		PaymentRef:    inv.OrderID,
+		Refunded:      refunded,
+	}
+}
+
+// writeInvoicePDF renders invoice id as a PDF download
+func writeInvoicePDF(c *gin.Context, id int) {
+	inv, err := scanInvoice(database.DB.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = ?`, id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
+	}
+
+	var refunded int
+	database.DB.QueryRow("SELECT IFNULL(SUM(amount), 0) FROM refunds WHERE booking_id = ? AND payment_id = ? AND status = 'succeeded'",
+		inv.BookingID, inv.PaymentID).Scan(&refunded)
+
+	var buf bytes.Buffer
+	if err := invoice.Render(&buf, invoiceDocument(inv, refunded)); err != nil {
+		log.Printf("[INVOICE] Rendering %s failed: %v", inv.InvoiceNumber, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render invoice"})
+		return
+	}
+
+	filename := strings.ReplaceAll(inv.InvoiceNumber, "/", "-") + ".pdf"
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Cache-Control", "no-cache, private")
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+// DownloadBookingInvoice serves the invoice of a paid booking to its client
+func DownloadBookingInvoice(c *gin.Context) {
+	b, ok := getPaymentBooking(c.Param("id"), c.Query("email"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
+	if b.PaymentStatus != paymentPaid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking ini belum dibayar"})
+		return
+	}
+
+	id, err := ensureInvoice(b.ID)
+	if err != nil {
+		log.Printf("[INVOICE] Issuing invoice for booking %d failed: %v", b.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue invoice"})
+		return
+	}
+	writeInvoicePDF(c, id)
+}
+
+// ListInvoices lists issued invoices, newest first. Optional from/to
+// (YYYY-MM-DD, WIB) filter on the issue date.
+func ListInvoices(c *gin.Context) {
+	query := `SELECT ` + invoiceColumns + ` FROM invoices WHERE 1=1`
+	var args []interface{}
+	for _, f := range []struct{ param, cond string }{
+		{"from", " AND issued_at >= ?"},
+		{"to", " AND issued_at < ?"},
+	} {
+		s := c.Query(f.param)
+		if s == "" {
+			continue
+		}
+		day, err := time.ParseInLocation("2006-01-02", s, sessionLocation)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": f.param + " must be YYYY-MM-DD"})
+			return
+		}
+		if f.param == "to" {
+			day = day.AddDate(0, 0, 1)
+		}
+		query += f.cond
+		args = append(args, day.UTC())
+	}
+
+	rows, err := database.DB.Query(query+" ORDER BY id DESC LIMIT 500", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	invoices := []models.Invoice{}
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		invoices = append(invoices, inv)
+	}
+
+	c.JSON(http.StatusOK, invoices)
+}
+
+// DownloadInvoice serves any invoice as a PDF (admin)
+func DownloadInvoice(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	writeInvoicePDF(c, id)
+}
+
+// GetLicenseNumber returns the license number (SIPP) printed on a
+// psychologist's invoices
+func GetLicenseNumber(c *gin.Context) {
+	var license sql.NullString
+	err := database.DB.QueryRow("SELECT license_number FROM psychologists WHERE email = ?", c.Query("email")).Scan(&license)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"license_number": license.String})
+}
+
+// UpdateLicenseNumber sets the license number printed on invoices issued
+// from now on
+func UpdateLicenseNumber(c *gin.Context) {
+	var input struct {
+		Email         string `json:"email" binding:"required"`
+		LicenseNumber string `json:"license_number"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	license := strings.TrimSpace(input.LicenseNumber)
+	if len(license) > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "license_number is too long"})
+		return
+	}
+
+	psychoID, ok := psychologistIDByEmail(input.Email)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+		return
+	}
+	if _, err := database.DB.Exec("UPDATE psychologists SET license_number = ? WHERE id = ?", nullString(license), psychoID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update license number"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"license_number": license})
+}
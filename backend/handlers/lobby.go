@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"counseling-webrtc/pubsub"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// WAITING ROOM (Expert-controlled admission)
+// =============================================
+
+// Admission states of the client of a room
+const (
+	admissionNone     = ""
+	admissionWaiting  = "waiting"
+	admissionAdmitted = "admitted"
+	admissionDenied   = "denied"
+)
+
+// Entries not touched for this long are forgotten
+const admissionTTL = 24 * time.Hour
+
+// A denied client may knock again after this
+const admissionDeniedCooldown = 5 * time.Minute
+
+// admission tracks whether the client of a room may enter the session
+type admission struct {
+	BookingID         int       `json:"booking_id"`
+	ClientName        string    `json:"client_name"`
+	ClientEmail       string    `json:"client_email"`
+	PsychologistEmail string    `json:"psychologist_email"`
+	RoomID            string    `json:"room_id"`
+	Status            string    `json:"status"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// WaitingRoom keeps the admission state per room in the broker, so it is
+// shared by all instances and outlives signaling connections: a client that
+// reloads the page keeps their place (or their admission). Changes are
+// compare-and-set on the broker, so two instances can't both announce the
+// same client or record crossing decisions.
+type WaitingRoom struct{}
+
+var waitingRoom WaitingRoom
+
+// Attempts of a change that keeps losing to other instances
+const admissionUpdateAttempts = 5
+
+func admissionKey(roomID string) string        { return "lobby:" + roomID }
+func lobbyIndexKey(psychologist string) string { return "lobby:waiting:" + psychologist }
+
+// load reads the admission for roomID and its encoded form, for a later
+// compare-and-set
+func (w *WaitingRoom) load(roomID string) (*admission, []byte) {
+	ctx, cancel := brokerCtx()
+	defer cancel()
+
+	data, err := broker.Get(ctx, admissionKey(roomID))
+	if err != nil {
+		if err != pubsub.ErrNotFound {
+			log.Printf("[LOBBY] Failed to load room %s: %v", roomID, err)
+		}
+		return nil, nil
+	}
+	var a admission
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, nil
+	}
+	return &a, data
+}
+
+// update applies change to the admission of roomID (nil when there is
+// none) and stores the result unless another write got there first, in
+// which case it starts over with the new value. change returns false to
+// leave the admission as it is. update reports whether it stored one.
+func (w *WaitingRoom) update(roomID string, change func(a *admission) (*admission, bool)) (admission, bool) {
+	for i := 0; i < admissionUpdateAttempts; i++ {
+		cur, old := w.load(roomID)
+		next, ok := change(cur)
+		if !ok {
+			if cur != nil {
+				return *cur, false
+			}
+			return admission{}, false
+		}
+
+		stored, err := w.store(next, old)
+		if err != nil {
+			log.Printf("[LOBBY] Failed to store room %s: %v", roomID, err)
+			return admission{}, false
+		}
+		if stored {
+			return *next, true
+		}
+	}
+	log.Printf("[LOBBY] Gave up updating room %s after %d conflicting writes", roomID, admissionUpdateAttempts)
+	return admission{}, false
+}
+
+// store writes a if the broker still holds old, and then keeps the
+// per-psychologist index of waiting rooms in sync. A stale index entry
+// is harmless: waitingFor checks each room's status.
+func (w *WaitingRoom) store(a *admission, old []byte) (bool, error) {
+	ctx, cancel := brokerCtx()
+	defer cancel()
+
+	a.UpdatedAt = time.Now()
+	data, _ := json.Marshal(a)
+	ttl := admissionTTL
+	if a.Status == admissionDenied {
+		ttl = admissionDeniedCooldown
+	}
+	if ok, err := broker.CompareAndSet(ctx, admissionKey(a.RoomID), old, data, ttl); !ok || err != nil {
+		return false, err
+	}
+
+	if a.Status == admissionWaiting {
+		broker.SetAdd(ctx, lobbyIndexKey(a.PsychologistEmail), a.RoomID, admissionTTL)
+	} else {
+		broker.SetRemove(ctx, lobbyIndexKey(a.PsychologistEmail), a.RoomID)
+	}
+	return true, nil
+}
+
+// status returns the admission state of the client of roomID
+func (w *WaitingRoom) status(roomID string) string {
+	if a, _ := w.load(roomID); a != nil {
+		return a.Status
+	}
+	return admissionNone
+}
+
+// get returns a copy of the admission for roomID
+func (w *WaitingRoom) get(roomID string) (admission, bool) {
+	a, _ := w.load(roomID)
+	if a == nil {
+		return admission{}, false
+	}
+	return *a, true
+}
+
+// enter puts the client of rb in the lobby unless a decision was already
+// made. It returns the resulting admission and whether the client is newly
+// waiting (so the psychologist should be notified).
+func (w *WaitingRoom) enter(roomID string, rb roomBooking) (admission, bool) {
+	return w.update(roomID, func(a *admission) (*admission, bool) {
+		if a != nil && a.Status != admissionNone {
+			return a, false
+		}
+		return &admission{
+			BookingID:         rb.BookingID,
+			ClientName:        rb.ClientName,
+			ClientEmail:       rb.ClientContact,
+			PsychologistEmail: rb.PsychologistEmail,
+			RoomID:            roomID,
+			Status:            admissionWaiting,
+		}, true
+	})
+}
+
+// decide records the psychologist's decision for the client waiting in
+// roomID. A client already admitted or denied cannot be decided again.
+func (w *WaitingRoom) decide(roomID string, admit bool) (admission, bool) {
+	a, ok := w.update(roomID, func(a *admission) (*admission, bool) {
+		if a == nil || a.Status != admissionWaiting {
+			return a, false
+		}
+		next := *a
+		next.Status = admissionDenied
+		if admit {
+			next.Status = admissionAdmitted
+		}
+		return &next, true
+	})
+	if !ok {
+		return admission{}, false
+	}
+	return a, true
+}
+
+// waitingFor lists the clients currently waiting for psychologistEmail
+func (w *WaitingRoom) waitingFor(psychologistEmail string) []admission {
+	ctx, cancel := brokerCtx()
+	defer cancel()
+
+	roomIDs, err := broker.SetMembers(ctx, lobbyIndexKey(psychologistEmail))
+	if err != nil {
+		log.Printf("[LOBBY] Failed to list waiting rooms: %v", err)
+		return []admission{}
+	}
+
+	list := []admission{}
+	for _, roomID := range roomIDs {
+		if a, ok := w.get(roomID); ok && a.Status == admissionWaiting {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+// enterWaitingRoom places the client of roomID in the lobby and tells the
+// psychologist, both inside the room and over /api/notify.
+func enterWaitingRoom(roomID string, rb roomBooking) admission {
+	a, isNew := waitingRoom.enter(roomID, rb)
+	if !isNew {
+		return a
+	}
+
+	log.Printf("[LOBBY] Room %s: %s is waiting", roomID, rb.ClientName)
+	notifyClientWaiting(a)
+	return a
+}
+
+// notifyClientWaiting tells the psychologist that a client waits in the lobby
+func notifyClientWaiting(a admission) {
+	sendToRoom(a.RoomID, RoleExpert, newMessage(MsgClientWaiting, ClientWaitingData{
+		BookingID:  a.BookingID,
+		ClientName: a.ClientName,
+	}))
+	SendNotification(a.PsychologistEmail, gin.H{
+		"type":        "client_waiting",
+		"room_id":     a.RoomID,
+		"booking_id":  a.BookingID,
+		"client_name": a.ClientName,
+		"message":     fmt.Sprintf("%s sedang menunggu di ruang tunggu", a.ClientName),
+	})
+}
+
+// decideAdmission applies the psychologist's decision for roomID to the
+// lobby and tells the client.
+func decideAdmission(roomID string, admit bool) (admission, bool) {
+	a, ok := waitingRoom.decide(roomID, admit)
+	if !ok {
+		return a, false
+	}
+
+	manager.resolveLobby(roomID, admit)
+
+	message := "Psikolog mempersilakan Anda masuk ke sesi."
+	if !admit {
+		message = "Psikolog belum dapat menerima Anda saat ini. Anda dapat mencoba masuk lagi dalam beberapa menit."
+	}
+	SendNotification(a.ClientEmail, gin.H{
+		"type":    "admission",
+		"status":  a.Status,
+		"room_id": roomID,
+		"message": message,
+	})
+
+	log.Printf("[LOBBY] Room %s: client %s", roomID, a.Status)
+	return a, true
+}
+
+// EnterWaitingRoom lets a client knock on the room of their booking
+func EnterWaitingRoom(c *gin.Context) {
+	roomID := c.Param("roomId")
+	var input struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rb, ok := lookupRoomBooking(c, roomID)
+	if !ok {
+		return
+	}
+	if rb.ClientContact != input.Email {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Anda bukan klien dari sesi ini"})
+		return
+	}
+	if !rb.open() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Sesi ini tidak aktif karena booking belum disetujui, sudah selesai, atau dibatalkan"})
+		return
+	}
+
+	a := enterWaitingRoom(roomID, rb)
+	c.JSON(http.StatusOK, gin.H{"status": a.Status, "booking_id": a.BookingID})
+}
+
+// GetWaitingRoomStatus returns the admission state of the client of a room
+func GetWaitingRoomStatus(c *gin.Context) {
+	roomID := c.Param("roomId")
+	email := c.Query("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+
+	a, ok := waitingRoom.get(roomID)
+	if !ok || a.ClientEmail != email {
+		c.JSON(http.StatusOK, gin.H{"status": "not_waiting"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": a.Status, "booking_id": a.BookingID})
+}
+
+// GetWaitingClients lists clients waiting for the psychologist
+func GetWaitingClients(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+
+	var list []gin.H
+	for _, a := range waitingRoom.waitingFor(email) {
+		list = append(list, gin.H{
+			"room_id":     a.RoomID,
+			"booking_id":  a.BookingID,
+			"client_name": a.ClientName,
+			"since":       a.UpdatedAt,
+		})
+	}
+	if list == nil {
+		list = []gin.H{}
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// DecideAdmission admits or denies the client waiting in a room
+func DecideAdmission(c *gin.Context) {
+	roomID := c.Param("roomId")
+	var input struct {
+		Email  string `json:"email" binding:"required"`
+		Action string `json:"action" binding:"required"` // admit, deny
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Action != "admit" && input.Action != "deny" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be admit or deny"})
+		return
+	}
+
+	rb, ok := lookupRoomBooking(c, roomID)
+	if !ok {
+		return
+	}
+	if rb.PsychologistEmail != input.Email {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Anda bukan psikolog dari sesi ini"})
+		return
+	}
+
+	a, ok := decideAdmission(roomID, input.Action == "admit")
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tidak ada klien di ruang tunggu"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": a.Status})
+}
+
+// lookupRoomBooking resolves roomID to its booking, writing the error
+// response itself on failure
+func lookupRoomBooking(c *gin.Context, roomID string) (roomBooking, bool) {
+	rb, err := getRoomBooking(roomID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return rb, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return rb, false
+	}
+	return rb, true
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/notify"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// WHATSAPP / SMS OPT-IN
+// =============================================
+//
+// Messages are only sent to phone numbers that opted in for the channel.
+// Numbers are stored in E.164 so "0812..." and "+62812..." are the same
+// client.
+
+// OptInStore is the database-backed notify.OptInStore
+type OptInStore struct{}
+
+func (OptInStore) OptedIn(phone, channel string) (bool, error) {
+	var optedIn bool
+	err := database.DB.QueryRow("SELECT opted_in FROM messaging_opt_ins WHERE phone = ? AND channel = ?", phone, channel).Scan(&optedIn)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return optedIn, err
+}
+
+// setOptIn records the consent of phone (already normalized) for channel
+func setOptIn(phone, channel string, optedIn bool, source string) error {
+	_, err := database.DB.Exec(`
+		INSERT INTO messaging_opt_ins (phone, channel, opted_in, source, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE opted_in = VALUES(opted_in), source = VALUES(source), updated_at = VALUES(updated_at)
+	`, phone, channel, optedIn, source, time.Now().UTC().Truncate(time.Second))
+	return err
+}
+
+// recordBookingOptIn stores the WhatsApp opt-in given on the booking form.
+// Contacts that are not phone numbers are ignored.
+func recordBookingOptIn(contact string) {
+	phone, err := notify.NormalizePhone(contact)
+	if err != nil {
+		return
+	}
+	if err := setOptIn(phone, notify.ChannelWhatsApp, true, "booking"); err != nil {
+		log.Printf("[NOTIFY] Failed to record opt-in for %s: %v", phone, err)
+	}
+}
+
+func isMessagingChannel(channel string) bool {
+	return channel == notify.ChannelWhatsApp || channel == notify.ChannelSMS
+}
+
+// GetMessagingOptIn returns the WhatsApp/SMS consent of a contact
+func GetMessagingOptIn(c *gin.Context) {
+	phone, err := notify.NormalizePhone(c.Query("contact"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nomor telepon tidak valid"})
+		return
+	}
+
+	optIns := gin.H{}
+	for _, channel := range []string{notify.ChannelWhatsApp, notify.ChannelSMS} {
+		ok, err := OptInStore{}.OptedIn(phone, channel)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		optIns[channel] = ok
+	}
+
+	c.JSON(http.StatusOK, gin.H{"phone": phone, "opt_in": optIns})
+}
+
+// UpdateMessagingOptIn opts a contact in or out of WhatsApp/SMS messages
+func UpdateMessagingOptIn(c *gin.Context) {
+	var input struct {
+		Contact string `json:"contact" binding:"required"`
+		Channel string `json:"channel" binding:"required"`
+		OptIn   bool   `json:"opt_in"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !isMessagingChannel(input.Channel) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Channel must be whatsapp or sms"})
+		return
+	}
+
+	phone, err := notify.NormalizePhone(input.Contact)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nomor telepon tidak valid"})
+		return
+	}
+
+	if err := setOptIn(phone, input.Channel, input.OptIn, "api"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"phone": phone, "channel": input.Channel, "opt_in": input.OptIn})
+}
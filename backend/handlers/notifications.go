@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// NOTIFICATION INBOX
+// =============================================
+//
+// Every notification is stored before it is pushed, so users who are not
+// connected get it replayed when they reconnect to /api/notify and can
+// list it afterwards.
+
+// Page size of the inbox listing
+const maxInboxItems = 100
+
+// storeNotification saves a notification for email and returns the frame
+// to push (the original message plus its notification_id).
+func storeNotification(email string, message interface{}) (int64, []byte, error) {
+	raw, err := json.Marshal(message)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Notifications are JSON objects with at least "type" and "message"
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return 0, raw, err
+	}
+	notifType, _ := fields["type"].(string)
+	text, _ := fields["message"].(string)
+
+	res, err := database.DB.Exec(`
+		INSERT INTO notifications (recipient_email, type, message, payload, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, email, notifType, text, string(raw), time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		return 0, raw, err
+	}
+	id, _ := res.LastInsertId()
+
+	fields["notification_id"] = id
+	frame, err := json.Marshal(fields)
+	if err != nil {
+		return id, raw, err
+	}
+	return id, frame, nil
+}
+
+// markNotificationDelivered records that notification id was written to a
+// live connection
+func markNotificationDelivered(id int64) {
+	if id == 0 {
+		return
+	}
+	_, err := database.DB.Exec("UPDATE notifications SET delivered_at = ? WHERE id = ? AND delivered_at IS NULL", time.Now().UTC().Truncate(time.Second), id)
+	if err != nil {
+		log.Printf("[NOTIFY] Failed to mark notification %d delivered: %v", id, err)
+	}
+}
+
+// markFrameDelivered marks the notification carried by a written frame
+// (its notification_id) as delivered
+func markFrameDelivered(payload []byte) {
+	var frame struct {
+		ID int64 `json:"notification_id"`
+	}
+	if json.Unmarshal(payload, &frame) == nil {
+		markNotificationDelivered(frame.ID)
+	}
+}
+
+// replayUndelivered pushes the notifications email missed while offline to
+// client: the newest maxInboxItems, which fit in the connection's buffer.
+// Older ones stay in the inbox listing. Delivery is at-least-once: a
+// notification sent concurrently with the reconnect may arrive twice
+// (clients dedupe on notification_id).
+func replayUndelivered(email string, client notifyConn) {
+	replayNotifications(email, client, fmt.Sprintf(`
+		SELECT id, payload FROM (
+			SELECT id, payload FROM notifications
+			WHERE recipient_email = ? AND delivered_at IS NULL
+			ORDER BY id DESC LIMIT %d
+		) recent
+		ORDER BY id ASC
+	`, maxInboxItems), email)
+}
+
+// replaySince pushes every notification of email after lastID, delivered
+// or not. Used by SSE clients resuming with Last-Event-ID, which may have
+// missed frames another tab already acknowledged.
+func replaySince(email string, lastID int64, client notifyConn) {
+	replayNotifications(email, client, fmt.Sprintf(`
+		SELECT id, payload FROM notifications
+		WHERE recipient_email = ? AND id > ?
+		ORDER BY id ASC LIMIT %d
+	`, maxInboxItems), email, lastID)
+}
+
+func replayNotifications(email string, client notifyConn, query string, args ...interface{}) {
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		log.Printf("[NOTIFY] Failed to load notifications to replay for %s: %v", email, err)
+		return
+	}
+
+	// Marked delivered by the connection once written, not here
+	replayed := 0
+	for rows.Next() {
+		var id int64
+		var payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+			continue
+		}
+		fields["notification_id"] = id
+		fields["replayed"] = true
+		if client.enqueue(fields) {
+			replayed++
+		}
+	}
+	rows.Close()
+
+	if replayed > 0 {
+		log.Printf("[NOTIFY] Replayed %d notifications to '%s'", replayed, email)
+	}
+}
+
+// GetNotifications lists the inbox of a user (newest first)
+func GetNotifications(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+
+	query := `
+		SELECT id, recipient_email, type, IFNULL(message, ''), payload, created_at, delivered_at, read_at
+		FROM notifications
+		WHERE recipient_email = ?`
+	if c.Query("unread") == "true" {
+		query += " AND read_at IS NULL"
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", maxInboxItems)
+
+	rows, err := database.DB.Query(query, email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		var n models.Notification
+		var payload string
+		var deliveredAt, readAt sql.NullTime
+		if err := rows.Scan(&n.ID, &n.Email, &n.Type, &n.Message, &payload, &n.CreatedAt, &deliveredAt, &readAt); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		n.Payload = json.RawMessage(payload)
+		if deliveredAt.Valid {
+			n.DeliveredAt = &deliveredAt.Time
+		}
+		if readAt.Valid {
+			n.IsRead = true
+			n.ReadAt = &readAt.Time
+		}
+		notifications = append(notifications, n)
+	}
+
+	var unread int
+	database.DB.QueryRow("SELECT COUNT(*) FROM notifications WHERE recipient_email = ? AND read_at IS NULL", email).Scan(&unread)
+
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications, "unread": unread})
+}
+
+// MarkNotificationRead marks a single notification as read
+func MarkNotificationRead(c *gin.Context) {
+	id := c.Param("id")
+	var input struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := database.DB.Exec(`
+		UPDATE notifications SET read_at = ?
+		WHERE id = ? AND recipient_email = ? AND read_at IS NULL
+	`, time.Now().UTC().Truncate(time.Second), id, input.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		err := database.DB.QueryRow("SELECT id FROM notifications WHERE id = ? AND recipient_email = ?", id, input.Email).Scan(&exists)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+// MarkAllNotificationsRead marks the whole inbox of a user as read
+func MarkAllNotificationsRead(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := database.DB.Exec(`
+		UPDATE notifications SET read_at = ?
+		WHERE recipient_email = ? AND read_at IS NULL
+	`, time.Now().UTC().Truncate(time.Second), input.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+
+	updated, _ := res.RowsAffected()
+	c.JSON(http.StatusOK, gin.H{"message": "Notifications marked as read", "updated": updated})
+}
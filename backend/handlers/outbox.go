@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// =============================================
+// TRANSACTIONAL OUTBOX
+// =============================================
+//
+// Booking handlers don't fire side effects inline. They write outbox rows
+// in the same transaction as the booking change, and the relay below
+// performs them afterwards: a crash between commit and delivery only
+// delays the side effect. Delivery is at-least-once; a row is marked done
+// only after its side effect succeeded, so a crash mid-way can repeat it
+// (webhooks are deduplicated by event id, notifications may show twice).
+
+// Kinds of outbox rows
+const (
+	outboxNotify    = "notify"    // payload: outboxNotification
+	outboxWebhook   = "webhook"   // payload: outboxWebhookEvent
+	outboxReminders = "reminders" // re-sync session reminders, no payload
+	outboxRefund    = "refund"    // payload: outboxRefundRequest
+)
+
+const (
+	// Fallback poll; new rows are normally picked up right after commit
+	outboxPollInterval = 2 * time.Second
+	// A claimed row is retried by anyone after this (relay crashed mid-way)
+	outboxLease = 2 * time.Minute
+	// Attempts before a row is marked failed
+	outboxMaxAttempts = 10
+	outboxBatchSize   = 50
+)
+
+type outboxNotification struct {
+	Email   string                 `json:"email"`
+	Message map[string]interface{} `json:"message"`
+	Channel string                 `json:"channel,omitempty"` // Empty on rows queued before channels got rows of their own
+}
+
+type outboxWebhookEvent struct {
+	Event string `json:"event"`
+}
+
+// outboxKick wakes the relay after a commit
+var outboxKick = make(chan struct{}, 1)
+
+func kickOutbox() {
+	select {
+	case outboxKick <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueOutbox writes a side effect of a booking change inside tx
+func enqueueOutbox(tx *sql.Tx, bookingID interface{}, kind string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err = tx.Exec(`
+		INSERT INTO outbox (booking_id, kind, payload, status, attempts, next_attempt_at, created_at)
+		VALUES (?, ?, ?, 'pending', 0, ?, ?)
+	`, bookingID, kind, string(data), now, now)
+	return err
+}
+
+// enqueueNotification queues SendNotification(email, message), one row
+// per channel so a retry only resends over the channel that failed
+func enqueueNotification(tx *sql.Tx, bookingID interface{}, email string, message map[string]interface{}) error {
+	if email == "" {
+		return nil
+	}
+	for _, channel := range notifier.Channels() {
+		if err := enqueueOutbox(tx, bookingID, outboxNotify, outboxNotification{Email: email, Message: message, Channel: channel}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueWebhook queues a booking lifecycle webhook event
+func enqueueWebhook(tx *sql.Tx, bookingID interface{}, event string) error {
+	return enqueueOutbox(tx, bookingID, outboxWebhook, outboxWebhookEvent{Event: event})
+}
+
+// enqueueReminderSync queues scheduleReminders for the booking
+func enqueueReminderSync(tx *sql.Tx, bookingID interface{}) error {
+	return enqueueOutbox(tx, bookingID, outboxReminders, nil)
+}
+
+// StartOutboxRelay starts the relay loop. Call it once at startup; rows
+// left over from before a restart are picked up on the first pass.
+func StartOutboxRelay() {
+	go func() {
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+		for {
+			// A full batch means more rows may be waiting
+			for relayOutbox() == outboxBatchSize {
+			}
+			select {
+			case <-ticker.C:
+			case <-outboxKick:
+			}
+		}
+	}()
+}
+
+type outboxRow struct {
+	ID            int64
+	BookingID     int64
+	Kind          string
+	Payload       string
+	Attempts      int
+	NextAttemptAt time.Time
+}
+
+// relayOutbox claims and performs due rows in order. It returns how many
+// rows it looked at.
+func relayOutbox() int {
+	now := time.Now().UTC().Truncate(time.Second)
+	rows, err := database.DB.Query(fmt.Sprintf(`
+		SELECT id, booking_id, kind, payload, attempts, next_attempt_at
+		FROM outbox
+		WHERE status = 'pending' AND next_attempt_at <= ?
+		ORDER BY id ASC
+		LIMIT %d
+	`, outboxBatchSize), now)
+	if err != nil {
+		log.Printf("[OUTBOX] Failed to load pending rows: %v", err)
+		return 0
+	}
+
+	var due []outboxRow
+	for rows.Next() {
+		var r outboxRow
+		if err := rows.Scan(&r.ID, &r.BookingID, &r.Kind, &r.Payload, &r.Attempts, &r.NextAttemptAt); err != nil {
+			continue
+		}
+		due = append(due, r)
+	}
+	rows.Close()
+
+	for _, r := range due {
+		// Claim: only one instance gets past this for a given attempt
+		res, err := database.DB.Exec(`
+			UPDATE outbox SET next_attempt_at = ?
+			WHERE id = ? AND status = 'pending' AND next_attempt_at = ?
+		`, now.Add(outboxLease), r.ID, r.NextAttemptAt)
+		if err != nil {
+			continue
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			continue
+		}
+
+		if err := performOutbox(r); err != nil {
+			failOutbox(r, err)
+			continue
+		}
+		database.DB.Exec("UPDATE outbox SET status = 'done', attempts = ?, processed_at = ?, last_error = NULL WHERE id = ?",
+			r.Attempts+1, time.Now().UTC().Truncate(time.Second), r.ID)
+	}
+	return len(due)
+}
+
+// performOutbox runs the side effect of one row
+func performOutbox(r outboxRow) error {
+	switch r.Kind {
+	case outboxNotify:
+		var n outboxNotification
+		if err := json.Unmarshal([]byte(r.Payload), &n); err != nil {
+			return err
+		}
+		if n.Channel == "" {
+			return deliverNotification(n.Email, n.Message)
+		}
+		return deliverNotificationTo(n.Channel, n.Email, n.Message)
+	case outboxWebhook:
+		var ev outboxWebhookEvent
+		if err := json.Unmarshal([]byte(r.Payload), &ev); err != nil {
+			return err
+		}
+		// Stable per outbox row, so a retried row doesn't queue the event twice
+		eventID := uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("outbox:%d", r.ID))).String()
+		return emitWebhookEvent(ev.Event, r.BookingID, eventID)
+	case outboxReminders:
+		return scheduleReminders(r.BookingID)
+	case outboxRefund:
+		var req outboxRefundRequest
+		if err := json.Unmarshal([]byte(r.Payload), &req); err != nil {
+			return err
+		}
+		return processRefund(req.RefundID)
+	}
+	return fmt.Errorf("unknown outbox kind %q", r.Kind)
+}
+
+// failOutbox schedules a retry (same backoff as webhooks) or gives up
+func failOutbox(r outboxRow, cause error) {
+	attempt := r.Attempts + 1
+	status := "pending"
+	var next interface{} = time.Now().UTC().Add(webhookBackoff(attempt)).Truncate(time.Second)
+	if attempt >= outboxMaxAttempts {
+		status, next = "failed", nil
+	}
+
+	database.DB.Exec("UPDATE outbox SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?",
+		status, attempt, next, cause.Error(), r.ID)
+	log.Printf("[OUTBOX] %s for booking %d failed (attempt %d, %s): %v", r.Kind, r.BookingID, attempt, status, cause)
+	if status == "failed" && r.Kind == outboxRefund {
+		failRefund(r.Payload, cause)
+	}
+}
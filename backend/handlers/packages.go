@@ -0,0 +1,518 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/models"
+	"counseling-webrtc/payments"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// SESSION PACKAGES & CREDITS
+// =============================================
+//
+// Psychologists sell packages of N sessions of one of their session types.
+// A paid purchase is a balance of credits valid for validity_days. Booking
+// that session type reserves a credit instead of asking for payment; the
+// credit is used when the psychologist approves the booking and comes
+// back when the booking is rejected, or cancelled with enough notice for a
+// full refund (see quoteCancellation). Late cancellations forfeit it.
+
+// bookings.credit_status values
+const (
+	creditNone      = "none"
+	creditReserved  = "reserved"  // Pending booking holds a credit
+	creditUsed      = "used"      // Approved
+	creditReturned  = "returned"  // Cancelled with enough notice
+	creditForfeited = "forfeited" // Cancelled too late
+	creditReleased  = "released"  // Rejected or cancelled before approval
+)
+
+// Limits for psychologist-defined packages
+const (
+	minPackageSessions = 2
+	maxPackageSessions = 50
+	minValidityDays    = 7
+	maxValidityDays    = 730
+)
+
+const packageColumns = `sp.id, sp.psychologist_id, sp.session_type_id, st.name, sp.name, sp.session_count, sp.price, sp.validity_days, sp.is_active`
+
+func scanPackage(row interface{ Scan(...interface{}) error }) (models.SessionPackage, error) {
+	var p models.SessionPackage
+	err := row.Scan(&p.ID, &p.PsychologistID, &p.SessionTypeID, &p.SessionTypeName, &p.Name, &p.SessionCount, &p.Price, &p.ValidityDays, &p.IsActive)
+	return p, err
+}
+
+// getPackages lists a psychologist's packages, cheapest first. activeOnly
+// leaves out retired packages and packages of retired session types.
+func getPackages(psychologistID int, activeOnly bool) []models.SessionPackage {
+	query := `SELECT ` + packageColumns + `
+		FROM session_packages sp JOIN session_types st ON st.id = sp.session_type_id
+		WHERE sp.psychologist_id = ?`
+	if activeOnly {
+		query += " AND sp.is_active = TRUE AND st.is_active = TRUE"
+	}
+	rows, err := database.DB.Query(query+" ORDER BY sp.price, sp.id", psychologistID)
+	if err != nil {
+		return []models.SessionPackage{}
+	}
+	defer rows.Close()
+
+	packages := []models.SessionPackage{}
+	for rows.Next() {
+		p, err := scanPackage(rows)
+		if err != nil {
+			continue
+		}
+		packages = append(packages, p)
+	}
+	return packages
+}
+
+func validatePackage(p models.SessionPackage) error {
+	switch {
+	case strings.TrimSpace(p.Name) == "":
+		return errors.New("name is required")
+	case p.SessionCount < minPackageSessions || p.SessionCount > maxPackageSessions:
+		return fmt.Errorf("session_count must be between %d and %d", minPackageSessions, maxPackageSessions)
+	case p.Price <= 0:
+		return errors.New("price must be positive")
+	case p.ValidityDays < minValidityDays || p.ValidityDays > maxValidityDays:
+		return fmt.Errorf("validity_days must be between %d and %d", minValidityDays, maxValidityDays)
+	}
+	return nil
+}
+
+// GetPsychologistPackages lists the packages clients can buy from a
+// psychologist
+func GetPsychologistPackages(c *gin.Context) {
+	psychoID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid psychologist id"})
+		return
+	}
+	c.JSON(http.StatusOK, getPackages(psychoID, true))
+}
+
+// =============================================
+// EXPERT: MANAGE PACKAGES
+// =============================================
+
+// GetExpertPackages lists all packages of a psychologist, retired ones included
+func GetExpertPackages(c *gin.Context) {
+	psychoID, ok := psychologistIDByEmail(c.Query("email"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+		return
+	}
+	c.JSON(http.StatusOK, getPackages(psychoID, false))
+}
+
+type packageInput struct {
+	Email         string  `json:"email" binding:"required"`
+	SessionTypeID *int    `json:"session_type_id"`
+	Name          *string `json:"name"`
+	SessionCount  *int    `json:"session_count"`
+	Price         *int    `json:"price"`
+	ValidityDays  *int    `json:"validity_days"`
+	IsActive      *bool   `json:"is_active"`
+}
+
+// apply copies the fields that were sent onto p
+func (in packageInput) apply(p *models.SessionPackage) {
+	if in.SessionTypeID != nil {
+		p.SessionTypeID = *in.SessionTypeID
+	}
+	if in.Name != nil {
+		p.Name = strings.TrimSpace(*in.Name)
+	}
+	if in.SessionCount != nil {
+		p.SessionCount = *in.SessionCount
+	}
+	if in.Price != nil {
+		p.Price = *in.Price
+	}
+	if in.ValidityDays != nil {
+		p.ValidityDays = *in.ValidityDays
+	}
+	if in.IsActive != nil {
+		p.IsActive = *in.IsActive
+	}
+}
+
+// checkPackageSessionType fills in the session type name, which must be one
+// of the psychologist's own types
+func checkPackageSessionType(p *models.SessionPackage) error {
+	err := database.DB.QueryRow("SELECT name FROM session_types WHERE id = ? AND psychologist_id = ?", p.SessionTypeID, p.PsychologistID).
+		Scan(&p.SessionTypeName)
+	if err == sql.ErrNoRows {
+		return errSessionTypeNotFound
+	}
+	return err
+}
+
+// CreatePackage adds a package for one of the psychologist's session types
+func CreatePackage(c *gin.Context) {
+	var input packageInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	psychoID, ok := psychologistIDByEmail(input.Email)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+		return
+	}
+
+	p := models.SessionPackage{PsychologistID: psychoID, IsActive: true}
+	input.apply(&p)
+	if err := validatePackage(p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkPackageSessionType(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Jenis sesi tidak valid: " + err.Error()})
+		return
+	}
+
+	res, err := database.DB.Exec(`
+		INSERT INTO session_packages (psychologist_id, session_type_id, name, session_count, price, validity_days, is_active, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, psychoID, p.SessionTypeID, p.Name, p.SessionCount, p.Price, p.ValidityDays, p.IsActive, time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create package"})
+		return
+	}
+	id, _ := res.LastInsertId()
+	p.ID = int(id)
+
+	c.JSON(http.StatusOK, p)
+}
+
+// UpdatePackage changes the fields sent. Purchases already made keep their
+// sessions and validity.
+func UpdatePackage(c *gin.Context) {
+	var input packageInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	p, err := scanPackage(database.DB.QueryRow(`SELECT `+packageColumns+`
+		FROM session_packages sp
+		JOIN session_types st ON st.id = sp.session_type_id
+		JOIN psychologists ps ON ps.id = sp.psychologist_id
+		WHERE sp.id = ? AND ps.email = ?`, c.Param("id"), input.Email))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+		return
+	}
+
+	input.apply(&p)
+	if err := validatePackage(p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkPackageSessionType(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Jenis sesi tidak valid: " + err.Error()})
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		UPDATE session_packages SET session_type_id = ?, name = ?, session_count = ?, price = ?, validity_days = ?, is_active = ?
+		WHERE id = ?
+	`, p.SessionTypeID, p.Name, p.SessionCount, p.Price, p.ValidityDays, p.IsActive, p.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update package"})
+		return
+	}
+
+	c.JSON(http.StatusOK, p)
+}
+
+// DeletePackage retires a package; credits already bought stay usable
+func DeletePackage(c *gin.Context) {
+	res, err := database.DB.Exec(`
+		UPDATE session_packages sp JOIN psychologists p ON p.id = sp.psychologist_id
+		SET sp.is_active = FALSE
+		WHERE sp.id = ? AND p.email = ?
+	`, c.Param("id"), c.Query("email"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete package"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		database.DB.QueryRow(`
+			SELECT COUNT(*) FROM session_packages sp JOIN psychologists p ON p.id = sp.psychologist_id
+			WHERE sp.id = ? AND p.email = ?
+		`, c.Param("id"), c.Query("email")).Scan(&exists)
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Package retired"})
+}
+
+// =============================================
+// PURCHASES
+// =============================================
+
+// PurchasePackage records an unpaid purchase and opens its checkout. The
+// credits are granted when the provider reports the payment.
+func PurchasePackage(c *gin.Context) {
+	var input struct {
+		Email     string `json:"email" binding:"required"` // Client contact the credits belong to
+		Name      string `json:"name"`
+		ReturnURL string `json:"return_url"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.ReturnURL != "" && !validWebhookURL(input.ReturnURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "return_url must be an absolute http(s) URL"})
+		return
+	}
+	if paymentProvider == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Pembayaran sedang tidak tersedia"})
+		return
+	}
+
+	p, err := scanPackage(database.DB.QueryRow(`SELECT `+packageColumns+`
+		FROM session_packages sp JOIN session_types st ON st.id = sp.session_type_id
+		WHERE sp.id = ? AND sp.is_active = TRUE AND st.is_active = TRUE`, c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Paket tidak ditemukan"})
+		return
+	}
+	var psychoName string
+	database.DB.QueryRow("SELECT name FROM psychologists WHERE id = ?", p.PsychologistID).Scan(&psychoName)
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		name = input.Email
+	}
+	res, err := database.DB.Exec(`
+		INSERT INTO package_purchases (package_id, session_type_id, client_name, client_contact, sessions_total, price, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, 'unpaid', ?)
+	`, p.ID, p.SessionTypeID, name, input.Email, p.SessionCount, p.Price, time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create purchase"})
+		return
+	}
+	purchaseID, _ := res.LastInsertId()
+
+	req := payments.ChargeRequest{
+		OrderID:      newOrderID("PK", int(purchaseID)),
+		Amount:       p.Price,
+		Description:  fmt.Sprintf("%s - %s", p.Name, psychoName),
+		CustomerName: name,
+		ReturnURL:    input.ReturnURL,
+		Expiry:       paymentExpiry,
+	}
+	if strings.Contains(input.Email, "@") {
+		req.CustomerEmail = input.Email
+	}
+
+	if payment, ok := openCheckout(c, req, 0, int(purchaseID)); ok {
+		c.JSON(http.StatusOK, payment)
+	}
+}
+
+// applyPurchasePayment grants the credits of a purchase that was just paid
+// and commits tx. A second payment for the same purchase is refunded.
+func applyPurchasePayment(tx *sql.Tx, orderID string, paymentID, purchaseID, amount int) error {
+	var status, clientContact, packageName string
+	var sessions, validityDays int
+	err := tx.QueryRow(`
+		SELECT pp.status, pp.client_contact, pp.sessions_total, sp.name, sp.validity_days
+		FROM package_purchases pp JOIN session_packages sp ON sp.id = pp.package_id
+		WHERE pp.id = ? FOR UPDATE
+	`, purchaseID).Scan(&status, &clientContact, &sessions, &packageName, &validityDays)
+	if err != nil {
+		return err
+	}
+
+	if status == paymentPaid {
+		if err := queueRefund(tx, nil, paymentID, amount, 0, refundReasonDuplicate); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		kickOutbox()
+		log.Printf("[PACKAGE] %s paid for purchase %d which is already paid, refunding", orderID, purchaseID)
+		return nil
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	expiresAt := now.AddDate(0, 0, validityDays)
+	_, err = tx.Exec("UPDATE package_purchases SET status = 'paid', paid_at = ?, expires_at = ? WHERE id = ?", now, expiresAt, purchaseID)
+	if err != nil {
+		return err
+	}
+
+	expiresLabel := expiresAt.In(sessionLocation).Format("02/01/2006")
+	err = enqueueNotification(tx, 0, clientContact, gin.H{
+		"type":         "package_purchased",
+		"message":      fmt.Sprintf("Paket %s aktif: %d kredit sesi, berlaku hingga %s", packageName, sessions, expiresLabel),
+		"purchase_id":  purchaseID,
+		"package_name": packageName,
+		"sessions":     sessions,
+		"expires_at":   expiresLabel,
+		"amount":       amount,
+		"amount_label": formatRupiah(amount),
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+	kickOutbox()
+	log.Printf("[PACKAGE] Purchase %d paid: %d credits until %s", purchaseID, sessions, expiresLabel)
+	return nil
+}
+
+// GetClientCredits lists the client's paid packages and what is left of
+// them, newest first
+func GetClientCredits(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT pp.id, pp.package_id, sp.name, pp.session_type_id, IFNULL(st.name, ''), IFNULL(ps.name, ''),
+			pp.sessions_total, pp.sessions_used, pp.sessions_reserved, pp.price, pp.status, pp.paid_at, pp.expires_at
+		FROM package_purchases pp
+		JOIN session_packages sp ON sp.id = pp.package_id
+		LEFT JOIN session_types st ON st.id = pp.session_type_id
+		LEFT JOIN psychologists ps ON ps.id = sp.psychologist_id
+		WHERE pp.client_contact = ? AND pp.status = 'paid'
+		ORDER BY pp.paid_at DESC
+	`, email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	credits := []models.PackagePurchase{}
+	for rows.Next() {
+		var p models.PackagePurchase
+		var paidAt, expiresAt sql.NullTime
+		if err := rows.Scan(&p.ID, &p.PackageID, &p.PackageName, &p.SessionTypeID, &p.SessionTypeName, &p.PsychologistName,
+			&p.SessionsTotal, &p.SessionsUsed, &p.SessionsReserved, &p.Price, &p.Status, &paidAt, &expiresAt); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		if paidAt.Valid {
+			p.PaidAt = &paidAt.Time
+		}
+		if expiresAt.Valid {
+			p.ExpiresAt = &expiresAt.Time
+			p.Expired = !now.Before(expiresAt.Time)
+		}
+		if !p.Expired {
+			p.Remaining = p.SessionsTotal - p.SessionsUsed - p.SessionsReserved
+		}
+		credits = append(credits, p)
+	}
+
+	c.JSON(http.StatusOK, credits)
+}
+
+// =============================================
+// CREDIT LIFECYCLE
+// =============================================
+
+// reserveCredit holds one credit of the client for a booking of
+// sessionTypeID at start, taking from the purchase that expires first. It
+// returns 0 when the client has no usable credit.
+func reserveCredit(tx *sql.Tx, clientContact string, sessionTypeID int, start time.Time) (int, error) {
+	rows, err := tx.Query(`
+		SELECT id FROM package_purchases
+		WHERE client_contact = ? AND session_type_id = ? AND status = 'paid' AND expires_at > ?
+			AND sessions_used + sessions_reserved < sessions_total
+		ORDER BY expires_at, id
+		FOR UPDATE
+	`, clientContact, sessionTypeID, start.UTC())
+	if err != nil {
+		return 0, err
+	}
+	var candidates []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			candidates = append(candidates, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range candidates {
+		res, err := tx.Exec(`
+			UPDATE package_purchases SET sessions_reserved = sessions_reserved + 1
+			WHERE id = ? AND sessions_used + sessions_reserved < sessions_total
+		`, id)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return id, nil
+		}
+	}
+	return 0, nil
+}
+
+// consumeBookingCredit turns the credit a booking holds into a used one
+// (on approval). Bookings without a credit are left alone.
+func consumeBookingCredit(tx *sql.Tx, bookingID interface{}) error {
+	_, err := tx.Exec(`
+		UPDATE bookings b JOIN package_purchases pp ON pp.id = b.credit_purchase_id
+		SET pp.sessions_reserved = pp.sessions_reserved - 1, pp.sessions_used = pp.sessions_used + 1, b.credit_status = ?
+		WHERE b.id = ? AND b.credit_status = ?
+	`, creditUsed, bookingID, creditReserved)
+	return err
+}
+
+// releaseBookingCredit settles the credit of a booking that was rejected or
+// cancelled. A reserved credit always goes back; a used one only when
+// giveBack is set, and is forfeited otherwise.
+func releaseBookingCredit(tx *sql.Tx, bookingID interface{}, giveBack bool) error {
+	_, err := tx.Exec(`
+		UPDATE bookings b JOIN package_purchases pp ON pp.id = b.credit_purchase_id
+		SET pp.sessions_reserved = pp.sessions_reserved - 1, b.credit_status = ?
+		WHERE b.id = ? AND b.credit_status = ?
+	`, creditReleased, bookingID, creditReserved)
+	if err != nil {
+		return err
+	}
+
+	if giveBack {
+		_, err = tx.Exec(`
+			UPDATE bookings b JOIN package_purchases pp ON pp.id = b.credit_purchase_id
+			SET pp.sessions_used = pp.sessions_used - 1, b.credit_status = ?
+			WHERE b.id = ? AND b.credit_status = ?
+		`, creditReturned, bookingID, creditUsed)
+	} else {
+		_, err = tx.Exec("UPDATE bookings SET credit_status = ? WHERE id = ? AND credit_status = ?", creditForfeited, bookingID, creditUsed)
+	}
+	return err
+}
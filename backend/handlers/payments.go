@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"context"
+	"counseling-webrtc/database"
+	"counseling-webrtc/models"
+	"counseling-webrtc/payments"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// PAYMENTS
+// =============================================
+//
+// A booking of a priced session type starts out with payment_status
+// 'unpaid'. The client pays through a payment intent (one payments row per
+// checkout attempt, each with its own order_id at the provider); the
+// provider's webhook marks the intent and the booking paid. Psychologists
+// can only approve priced bookings once they are paid. Package purchases
+// (see packages.go) are paid the same way.
+
+// bookings.payment_status values
+const (
+	paymentNotRequired = "not_required"
+	paymentUnpaid      = "unpaid"
+	paymentPaid        = "paid"
+	paymentCredit      = "credit"    // Paid with a package credit
+	paymentSponsored   = "sponsored" // Covered by the client's employer/EAP sponsor
+	paymentRefunded    = "refunded"  // A refund was queued; the booking can't be approved or completed
+)
+
+const (
+	// How long a checkout stays open at the provider
+	paymentExpiry = 30 * time.Minute
+	// A pending intent is reused only if it stays open at least this long
+	paymentReuseMargin = 5 * time.Minute
+	paymentTimeout     = 20 * time.Second
+)
+
+var paymentProvider payments.Provider
+
+// SetPaymentProvider sets the provider used for checkouts and webhooks.
+// Call it once at startup.
+func SetPaymentProvider(p payments.Provider) {
+	paymentProvider = p
+}
+
+// initialPaymentStatus is the payment_status of a new booking
+func initialPaymentStatus(price int) string {
+	if price > 0 {
+		return paymentUnpaid
+	}
+	return paymentNotRequired
+}
+
+// formatRupiah formats an amount for humans ("Rp 150.000")
+func formatRupiah(amount int) string {
+	s := strconv.Itoa(amount)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "." + s[i:]
+	}
+	return "Rp " + s
+}
+
+// newOrderID returns a provider order id for a checkout of a booking
+// (prefix "SS") or package purchase ("PK")
+func newOrderID(prefix string, id int) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", prefix, id, hex.EncodeToString(b))
+}
+
+const paymentColumns = `id, IFNULL(booking_id, 0), IFNULL(purchase_id, 0), order_id, provider, amount, status, payment_url, expires_at, paid_at, created_at`
+
+func scanPayment(row interface{ Scan(...interface{}) error }) (models.Payment, error) {
+	var p models.Payment
+	var paidAt sql.NullTime
+	err := row.Scan(&p.ID, &p.BookingID, &p.PurchaseID, &p.OrderID, &p.Provider, &p.Amount, &p.Status, &p.PaymentURL, &p.ExpiresAt, &paidAt, &p.CreatedAt)
+	if paidAt.Valid {
+		p.PaidAt = &paidAt.Time
+	}
+	return p, err
+}
+
+// paymentBooking is what the client-facing payment endpoints need
+type paymentBooking struct {
+	ID            int
+	ClientName    string
+	ClientContact string
+	Status        string
+	PaymentStatus string
+	Price         int
+	ScheduleTime  time.Time
+	Description   string
+}
+
+// getPaymentBooking loads booking id if email is its client
+func getPaymentBooking(id, email string) (paymentBooking, bool) {
+	return loadPaymentBooking(database.DB, id, email)
+}
+
+// getPaymentBookingTx is getPaymentBooking inside tx
+func getPaymentBookingTx(tx *sql.Tx, id, email string) (paymentBooking, bool) {
+	return loadPaymentBooking(tx, id, email)
+}
+
+func loadPaymentBooking(db interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, id, email string) (paymentBooking, bool) {
+	var b paymentBooking
+	var contact sql.NullString
+	err := db.QueryRow(`
+		SELECT b.id, b.client_name, b.client_contact, b.status, b.payment_status, b.price, b.schedule_time,
+			CONCAT(IFNULL(st.name, 'Sesi Konseling'), ' - ', IFNULL(p.name, ''))
+		FROM bookings b
+		LEFT JOIN psychologists p ON b.psychologist_id = p.id
+		LEFT JOIN session_types st ON b.session_type_id = st.id
+		WHERE b.id = ?
+	`, id).Scan(&b.ID, &b.ClientName, &contact, &b.Status, &b.PaymentStatus, &b.Price, &b.ScheduleTime, &b.Description)
+	b.ClientContact = contact.String
+	if err != nil || email == "" || !strings.EqualFold(email, b.ClientContact) {
+		return b, false
+	}
+	return b, true
+}
+
+// expireStalePayments marks the booking's pending intents whose checkout
+// has closed as expired (providers don't always report expiry)
+func expireStalePayments(bookingID int) {
+	now := time.Now().UTC().Truncate(time.Second)
+	database.DB.Exec("UPDATE payments SET status = 'expired', updated_at = ? WHERE booking_id = ? AND status = 'pending' AND expires_at <= ?",
+		now, bookingID, now)
+}
+
+// CreateBookingPayment starts (or resumes) the checkout of a priced
+// booking and returns the provider's payment page
+func CreateBookingPayment(c *gin.Context) {
+	var input struct {
+		Email     string `json:"email" binding:"required"`
+		ReturnURL string `json:"return_url"` // Page the checkout sends the client back to
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.ReturnURL != "" && !validWebhookURL(input.ReturnURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "return_url must be an absolute http(s) URL"})
+		return
+	}
+	if paymentProvider == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Pembayaran sedang tidak tersedia"})
+		return
+	}
+
+	b, ok := getPaymentBooking(c.Param("id"), input.Email)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
+	switch {
+	case b.PaymentStatus == paymentNotRequired:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sesi ini tidak berbayar"})
+		return
+	case b.PaymentStatus == paymentPaid:
+		c.JSON(http.StatusConflict, gin.H{"error": "Booking ini sudah dibayar"})
+		return
+	case b.PaymentStatus == paymentCredit:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sesi ini dibayar dengan kredit paket"})
+		return
+	case b.PaymentStatus == paymentSponsored:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sesi ini ditanggung sponsor"})
+		return
+	case b.Status != "pending":
+		c.JSON(http.StatusConflict, gin.H{"error": "Booking ini tidak dapat dibayar lagi"})
+		return
+	}
+
+	// Resume an open checkout instead of creating a second one
+	expireStalePayments(b.ID)
+	existing, err := scanPayment(database.DB.QueryRow(`SELECT `+paymentColumns+` FROM payments
+		WHERE booking_id = ? AND status = 'pending' AND provider = ? AND expires_at > ?
+		ORDER BY id DESC LIMIT 1`, b.ID, paymentProvider.Name(), time.Now().UTC().Add(paymentReuseMargin)))
+	if err == nil {
+		c.JSON(http.StatusOK, existing)
+		return
+	}
+
+	req := payments.ChargeRequest{
+		OrderID:      newOrderID("SS", b.ID),
+		Amount:       b.Price,
+		Description:  b.Description,
+		CustomerName: b.ClientName,
+		ReturnURL:    input.ReturnURL,
+		Expiry:       paymentExpiry,
+	}
+	if strings.Contains(b.ClientContact, "@") {
+		req.CustomerEmail = b.ClientContact
+	}
+
+	if p, ok := openCheckout(c, req, b.ID, 0); ok {
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// openCheckout creates a checkout at the provider and records it as a
+// pending payment of bookingID or purchaseID (the other one 0). On failure
+// it has already answered the request.
+func openCheckout(c *gin.Context, req payments.ChargeRequest, bookingID, purchaseID int) (models.Payment, bool) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), paymentTimeout)
+	defer cancel()
+	charge, err := paymentProvider.CreateCharge(ctx, req)
+	if err != nil {
+		log.Printf("[PAYMENT] Checkout %s failed: %v", req.OrderID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Gagal membuat pembayaran, silakan coba lagi"})
+		return models.Payment{}, false
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	res, err := database.DB.Exec(`
+		INSERT INTO payments (booking_id, purchase_id, order_id, provider, provider_ref, amount, status, payment_url, expires_at, created_at, updated_at)
+		VALUES (NULLIF(?, 0), NULLIF(?, 0), ?, ?, ?, ?, 'pending', ?, ?, ?, ?)
+	`, bookingID, purchaseID, req.OrderID, paymentProvider.Name(), charge.ProviderRef, req.Amount, charge.PaymentURL, charge.ExpiresAt.UTC().Truncate(time.Second), now, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment"})
+		return models.Payment{}, false
+	}
+	id, _ := res.LastInsertId()
+
+	return models.Payment{
+		ID:         int(id),
+		BookingID:  bookingID,
+		PurchaseID: purchaseID,
+		OrderID:    req.OrderID,
+		Provider:   paymentProvider.Name(),
+		Amount:     req.Amount,
+		Status:     payments.StatusPending,
+		PaymentURL: charge.PaymentURL,
+		ExpiresAt:  charge.ExpiresAt.UTC().Truncate(time.Second),
+		CreatedAt:  now,
+	}, true
+}
+
+// GetBookingPayment returns the payment status of a booking and its
+// payment attempts, newest first
+func GetBookingPayment(c *gin.Context) {
+	b, ok := getPaymentBooking(c.Param("id"), c.Query("email"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
+	expireStalePayments(b.ID)
+
+	rows, err := database.DB.Query(`SELECT `+paymentColumns+` FROM payments WHERE booking_id = ? ORDER BY id DESC`, b.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	attempts := []models.Payment{}
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		attempts = append(attempts, p)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"booking_id":     b.ID,
+		"payment_status": b.PaymentStatus,
+		"amount":         b.Price,
+		"payments":       attempts,
+	})
+}
+
+var errPaymentNotFound = errors.New("payment not found")
+
+// HandlePaymentWebhook receives payment notifications at
+// /api/payments/webhook/:provider. Providers retry until they get a 2xx,
+// so notifications that change nothing are acknowledged too.
+func HandlePaymentWebhook(c *gin.Context) {
+	if paymentProvider == nil || c.Param("provider") != paymentProvider.Name() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment provider"})
+		return
+	}
+
+	event, err := paymentProvider.ParseWebhook(c.Request)
+	if err == payments.ErrInvalidSignature {
+		log.Printf("[PAYMENT] Rejected %s notification with an invalid signature from %s", paymentProvider.Name(), c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := applyPaymentEvent(event); err == errPaymentNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	} else if err != nil {
+		log.Printf("[PAYMENT] Notification for %s failed: %v", event.OrderID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process notification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "OK"})
+}
+
+// applyPaymentEvent records a verified notification. A paid intent is
+// final; a payment of a different amount than was charged marks the intent
+// failed and is refunded, like payments the booking doesn't need (anymore).
+func applyPaymentEvent(e *payments.Event) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var paymentID, bookingID, purchaseID, amount int
+	var status string
+	err = tx.QueryRow("SELECT id, IFNULL(booking_id, 0), IFNULL(purchase_id, 0), amount, status FROM payments WHERE order_id = ? FOR UPDATE", e.OrderID).
+		Scan(&paymentID, &bookingID, &purchaseID, &amount, &status)
+	if err == sql.ErrNoRows {
+		return errPaymentNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if status == payments.StatusPaid || e.Status == payments.StatusPending || e.Status == status {
+		return nil
+	}
+	if e.Status == payments.StatusPaid && e.Amount != amount {
+		return rejectPaymentAmount(tx, e, paymentID, bookingID, amount)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var paidAt interface{}
+	if e.Status == payments.StatusPaid {
+		paidAt = now
+	}
+	_, err = tx.Exec("UPDATE payments SET status = ?, provider_ref = IFNULL(NULLIF(?, ''), provider_ref), paid_at = ?, updated_at = ? WHERE id = ?",
+		e.Status, e.ProviderRef, paidAt, now, paymentID)
+	if err != nil {
+		return err
+	}
+	log.Printf("[PAYMENT] %s (booking %d, purchase %d): %s -> %s", e.OrderID, bookingID, purchaseID, status, e.Status)
+
+	if e.Status != payments.StatusPaid {
+		return tx.Commit()
+	}
+	if purchaseID != 0 {
+		return applyPurchasePayment(tx, e.OrderID, paymentID, purchaseID, amount)
+	}
+
+	var clientName, clientContact, psychoEmail, bookingStatus, paymentStatus string
+	var scheduleTime time.Time
+	err = tx.QueryRow(`
+		SELECT b.client_name, IFNULL(b.client_contact, ''), IFNULL(p.email, ''), b.schedule_time, b.status, b.payment_status
+		FROM bookings b LEFT JOIN psychologists p ON b.psychologist_id = p.id
+		WHERE b.id = ? FOR UPDATE
+	`, bookingID).Scan(&clientName, &clientContact, &psychoEmail, &scheduleTime, &bookingStatus, &paymentStatus)
+	if err != nil {
+		return err
+	}
+
+	// Paid through a second checkout, or after the booking was closed:
+	// the money goes straight back
+	if paymentStatus == paymentPaid || bookingStatus != "pending" {
+		if err := queueRefund(tx, bookingID, paymentID, amount, 0, refundReasonDuplicate); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		kickOutbox()
+		log.Printf("[PAYMENT] %s paid for booking %d which is %s/%s, refunding", e.OrderID, bookingID, bookingStatus, paymentStatus)
+		return nil
+	}
+
+	// Booking is paid: tell the client, the psychologist and external systems
+	if _, err := tx.Exec("UPDATE bookings SET payment_status = ? WHERE id = ?", paymentPaid, bookingID); err != nil {
+		return err
+	}
+	if err := issueInvoice(tx, bookingID, paymentID); err != nil {
+		return err
+	}
+
+	err = enqueueNotification(tx, bookingID, clientContact, gin.H{
+		"type":          "payment_received",
+		"message":       fmt.Sprintf("Pembayaran %s untuk booking Anda telah diterima", formatRupiah(amount)),
+		"booking_id":    bookingID,
+		"amount":        amount,
+		"amount_label":  formatRupiah(amount),
+		"schedule_time": formatSessionTime(scheduleTime),
+	})
+	if err == nil {
+		err = enqueueNotification(tx, bookingID, psychoEmail, gin.H{
+			"type":        "booking_paid",
+			"message":     fmt.Sprintf("Booking dari %s telah dibayar dan siap disetujui", clientName),
+			"booking_id":  bookingID,
+			"client_name": clientName,
+		})
+	}
+	if err == nil {
+		err = enqueueWebhook(tx, bookingID, EventBookingPaid)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+	kickOutbox()
+	return nil
+}
+
+// rejectPaymentAmount handles a paid notification whose amount differs
+// from the intent: the intent is marked failed so the booking stays
+// unpaid, and whatever was received goes back. It is acknowledged like any
+// other notification; the refund shows up in /api/admin/refunds.
+func rejectPaymentAmount(tx *sql.Tx, e *payments.Event, paymentID, bookingID, amount int) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err := tx.Exec("UPDATE payments SET status = ?, provider_ref = IFNULL(NULLIF(?, ''), provider_ref), updated_at = ? WHERE id = ?",
+		payments.StatusFailed, e.ProviderRef, now, paymentID)
+	if err != nil {
+		return err
+	}
+	if e.Amount > 0 {
+		var booking interface{}
+		if bookingID != 0 {
+			booking = bookingID
+		}
+		if err := queueRefund(tx, booking, paymentID, e.Amount, 0, refundReasonMismatch); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	kickOutbox()
+	log.Printf("[PAYMENT] %s paid %s, expected %s: marked failed, refunding", e.OrderID, formatRupiah(e.Amount), formatRupiah(amount))
+	return nil
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/notify"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// NOTIFICATION CHANNELS & PREFERENCES
+// =============================================
+
+// notifier routes every notification. The in-app channel is always
+// registered; main adds email and the other channels from configuration.
+var notifier = notify.NewDispatcher(preferenceStore{}, inAppChannel{})
+
+// RegisterNotificationChannel adds a delivery channel (email, ...). Call it
+// at startup, before serving requests.
+func RegisterNotificationChannel(ch notify.Channel) {
+	notifier.Register(ch)
+}
+
+// preferenceStore reads notification_preferences
+type preferenceStore struct{}
+
+func (preferenceStore) Get(email string) (notify.Preferences, error) {
+	prefs := notify.Preferences{Language: notify.DefaultLanguage, Disabled: map[string]bool{}}
+
+	var disabled string
+	err := database.DB.QueryRow("SELECT language, disabled_channels FROM notification_preferences WHERE email = ?", email).Scan(&prefs.Language, &disabled)
+	if err == sql.ErrNoRows {
+		return prefs, nil
+	} else if err != nil {
+		return prefs, err
+	}
+
+	for _, ch := range strings.Split(disabled, ",") {
+		if ch != "" {
+			prefs.Disabled[ch] = true
+		}
+	}
+	return prefs, nil
+}
+
+// preferencesResponse lists every registered channel with its state
+func preferencesResponse(email string, prefs notify.Preferences) gin.H {
+	channels := gin.H{}
+	for _, name := range notifier.Channels() {
+		channels[name] = prefs.Enabled(name)
+	}
+	return gin.H{"email": email, "language": prefs.Language, "channels": channels}
+}
+
+// GetNotificationPreferences returns the channel/language settings of a user
+func GetNotificationPreferences(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+
+	prefs, err := preferenceStore{}.Get(email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, preferencesResponse(email, prefs))
+}
+
+// UpdateNotificationPreferences stores the channel/language settings of a
+// user. Channels missing from the request keep their current state.
+func UpdateNotificationPreferences(c *gin.Context) {
+	var input struct {
+		Email    string          `json:"email" binding:"required"`
+		Language string          `json:"language"`
+		Channels map[string]bool `json:"channels"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prefs, err := preferenceStore{}.Get(input.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	switch input.Language {
+	case "":
+	case notify.LangIndonesian, notify.LangEnglish:
+		prefs.Language = input.Language
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bahasa tidak didukung (id atau en)"})
+		return
+	}
+
+	known := map[string]bool{}
+	for _, name := range notifier.Channels() {
+		known[name] = true
+	}
+	for name, enabled := range input.Channels {
+		if !known[name] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown channel: " + name})
+			return
+		}
+		if name == notify.ChannelInApp && !enabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Notifikasi in-app tidak dapat dinonaktifkan"})
+			return
+		}
+		prefs.Disabled[name] = !enabled
+	}
+
+	var disabled []string
+	for name, off := range prefs.Disabled {
+		if off {
+			disabled = append(disabled, name)
+		}
+	}
+	sort.Strings(disabled)
+
+	_, err = database.DB.Exec(`
+		INSERT INTO notification_preferences (email, language, disabled_channels)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE language = VALUES(language), disabled_channels = VALUES(disabled_channels)
+	`, input.Email, prefs.Language, strings.Join(disabled, ","))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, preferencesResponse(input.Email, prefs))
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"bytes"
+	"counseling-webrtc/models"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// =============================================
+// SIGNALING PROTOCOL
+// =============================================
+//
+// Every frame is {"type": "...", "data": {...}}. A client must send "join"
+// with the protocol version it speaks before anything else; the server
+// answers with "joined" (negotiated version) or an "error" frame.
+
+const (
+	// ProtocolVersion is the newest signaling protocol spoken by the server
+	ProtocolVersion = 1
+	// minProtocolVersion is the oldest version still accepted at join
+	minProtocolVersion = 1
+)
+
+// Client -> server message types
+const (
+	MsgJoin      = "join"
+	MsgOffer     = "offer"
+	MsgAnswer    = "answer"
+	MsgCandidate = "candidate"
+	MsgChat      = "chat"
+	MsgControl   = "control"
+	MsgAdmit     = "admit" // expert only
+	MsgDeny      = "deny"  // expert only
+)
+
+// Server -> client message types
+const (
+	MsgJoined        = "joined"
+	MsgPeerJoined    = "peer-joined"
+	MsgPeerLeft      = "peer-left"
+	MsgFull          = "full"
+	MsgError         = "error"
+	MsgChatHistory   = "chat-history"
+	MsgWaiting       = "waiting"        // client was placed in the lobby
+	MsgAdmitted      = "admitted"       // client was let into the room
+	MsgDenied        = "denied"         // client was turned away
+	MsgClientWaiting = "client-waiting" // sent to the expert
+)
+
+// Error codes sent back in error frames
+const (
+	ErrCodeMalformed          = "malformed"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeInvalidPayload     = "invalid_payload"
+	ErrCodeTooLarge           = "too_large"
+	ErrCodeNotJoined          = "not_joined"
+	ErrCodeAlreadyJoined      = "already_joined"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeChatUnavailable    = "chat_unavailable"
+	ErrCodeNotParticipant     = "not_participant"
+	ErrCodeNotAdmitted        = "not_admitted"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeSessionEnded       = "session_ended"
+	ErrCodeSessionClosed      = "session_closed" // booking not approved (pending, rejected, cancelled, completed)
+)
+
+// Payload size limits
+const (
+	maxSDPSize       = 32 * 1024
+	maxCandidateSize = 1024
+	maxChatLength    = 2000 // characters
+)
+
+// Allowed control actions relayed between peers
+var controlActions = map[string]bool{
+	"mute":               true,
+	"unmute":             true,
+	"camera-on":          true,
+	"camera-off":         true,
+	"screen-share-start": true,
+	"screen-share-stop":  true,
+	"end":                true,
+}
+
+// JoinData is sent by the client to enter a room. Role and email must
+// identify the client or psychologist of the booking that owns the room.
+type JoinData struct {
+	Version int    `json:"version"`
+	Role    string `json:"role"` // client, expert
+	Email   string `json:"email"`
+}
+
+// JoinedData confirms the join and the negotiated protocol version
+type JoinedData struct {
+	Version int `json:"version"`
+	Peers   int `json:"peers"` // Participants in the room including the caller
+}
+
+// AdmittedData tells a client it left the lobby
+type AdmittedData struct {
+	Peers int `json:"peers"`
+}
+
+// ClientWaitingData tells the psychologist a client is in the lobby
+type ClientWaitingData struct {
+	BookingID  int    `json:"booking_id"`
+	ClientName string `json:"client_name"`
+}
+
+// SessionDescription carries an SDP offer or answer
+type SessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// ICECandidate mirrors RTCIceCandidateInit
+type ICECandidate struct {
+	Candidate        string  `json:"candidate"`
+	SDPMid           *string `json:"sdpMid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
+	UsernameFragment *string `json:"usernameFragment,omitempty"`
+}
+
+// ChatData is an in-session text message sent by a client. The server
+// answers (and relays) with the stored models.ChatMessage.
+type ChatData struct {
+	Text string `json:"text"`
+}
+
+// ChatHistoryData replays the stored transcript to a participant on join
+type ChatHistoryData struct {
+	Messages []models.ChatMessage `json:"messages"`
+}
+
+// ControlData is a media/session control event (mute, end, ...)
+type ControlData struct {
+	Action string `json:"action"`
+}
+
+// ErrorData is sent back to the client for rejected frames
+type ErrorData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// protocolError is a recoverable error: the frame is rejected with an
+// error frame but the connection stays open.
+type protocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *protocolError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newProtocolError(code, format string, args ...interface{}) *protocolError {
+	return &protocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// errorFrame builds the error message sent to the client
+func errorFrame(err *protocolError) Message {
+	data, _ := json.Marshal(ErrorData{Code: err.Code, Message: err.Message})
+	return Message{Type: MsgError, Data: data}
+}
+
+// newMessage encodes a typed payload into a Message
+func newMessage(msgType string, payload interface{}) Message {
+	if payload == nil {
+		return Message{Type: msgType}
+	}
+	data, _ := json.Marshal(payload)
+	return Message{Type: msgType, Data: data}
+}
+
+// readSignal reads the next frame from conn and validates it. A
+// *protocolError means the frame was rejected but the connection is still
+// usable; any other error is fatal for the connection.
+func readSignal(conn *websocket.Conn) (Message, interface{}, error) {
+	msgType, raw, err := conn.ReadMessage()
+	if err != nil {
+		return Message{}, nil, err
+	}
+	if msgType != websocket.TextMessage {
+		return Message{}, nil, newProtocolError(ErrCodeMalformed, "only text frames are accepted")
+	}
+
+	var msg Message
+	if err := decodeStrict(raw, &msg); err != nil {
+		return Message{}, nil, newProtocolError(ErrCodeMalformed, "invalid frame: %v", err)
+	}
+
+	payload, perr := validateSignal(msg)
+	if perr != nil {
+		return msg, nil, perr
+	}
+	return msg, payload, nil
+}
+
+// validateSignal checks msg against the schema for its type and returns
+// the decoded payload.
+func validateSignal(msg Message) (interface{}, *protocolError) {
+	switch msg.Type {
+	case MsgJoin:
+		var d JoinData
+		if err := decodeStrict(msg.Data, &d); err != nil {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "join: %v", err)
+		}
+		if d.Role != RoleClient && d.Role != RoleExpert {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "join: unknown role %q", d.Role)
+		}
+		if d.Email == "" {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "join: email is required")
+		}
+		return d, nil
+
+	case MsgOffer, MsgAnswer:
+		var d SessionDescription
+		if err := decodeStrict(msg.Data, &d); err != nil {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "%s: %v", msg.Type, err)
+		}
+		if d.Type != msg.Type {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "%s: description type is %q", msg.Type, d.Type)
+		}
+		if d.SDP == "" {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "%s: sdp is required", msg.Type)
+		}
+		if len(d.SDP) > maxSDPSize {
+			return nil, newProtocolError(ErrCodeTooLarge, "%s: sdp exceeds %d bytes", msg.Type, maxSDPSize)
+		}
+		return d, nil
+
+	case MsgCandidate:
+		var d ICECandidate
+		if err := decodeStrict(msg.Data, &d); err != nil {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "candidate: %v", err)
+		}
+		// An empty candidate string signals end-of-candidates and is valid
+		if len(d.Candidate) > maxCandidateSize {
+			return nil, newProtocolError(ErrCodeTooLarge, "candidate exceeds %d bytes", maxCandidateSize)
+		}
+		return d, nil
+
+	case MsgChat:
+		var d ChatData
+		if err := decodeStrict(msg.Data, &d); err != nil {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "chat: %v", err)
+		}
+		d.Text = strings.TrimSpace(d.Text)
+		if d.Text == "" {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "chat: text is required")
+		}
+		if !utf8.ValidString(d.Text) {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "chat: text is not valid UTF-8")
+		}
+		if utf8.RuneCountInString(d.Text) > maxChatLength {
+			return nil, newProtocolError(ErrCodeTooLarge, "chat: text exceeds %d characters", maxChatLength)
+		}
+		return d, nil
+
+	case MsgControl:
+		var d ControlData
+		if err := decodeStrict(msg.Data, &d); err != nil {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "control: %v", err)
+		}
+		if !controlActions[d.Action] {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "control: unknown action %q", d.Action)
+		}
+		return d, nil
+
+	case MsgAdmit, MsgDeny:
+		// No payload; an empty object is tolerated
+		if len(msg.Data) > 0 {
+			var d struct{}
+			if err := decodeStrict(msg.Data, &d); err != nil {
+				return nil, newProtocolError(ErrCodeInvalidPayload, "%s: %v", msg.Type, err)
+			}
+		}
+		return nil, nil
+
+	case "":
+		return nil, newProtocolError(ErrCodeMalformed, "type is required")
+	}
+
+	return nil, newProtocolError(ErrCodeUnknownType, "unknown message type %q", msg.Type)
+}
+
+// negotiateVersion picks the protocol version for a join request
+func negotiateVersion(requested int) (int, *protocolError) {
+	if requested < minProtocolVersion {
+		return 0, newProtocolError(ErrCodeUnsupportedVersion, "version %d is not supported (min %d, max %d)", requested, minProtocolVersion, ProtocolVersion)
+	}
+	if requested > ProtocolVersion {
+		// Newer clients fall back to what we speak
+		return ProtocolVersion, nil
+	}
+	return requested, nil
+}
+
+// decodeStrict unmarshals data rejecting unknown fields and trailing data
+func decodeStrict(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("data is required")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected trailing data")
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/notify"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// WEB PUSH
+// =============================================
+//
+// Browsers subscribe with the server's VAPID public key and register the
+// resulting PushSubscription here; the web_push channel then reaches them
+// even when no dashboard tab is open.
+
+// vapidKeys is set by EnableWebPush
+var vapidKeys notify.VAPIDKeys
+
+// EnableWebPush loads the VAPID keys and registers the web_push channel.
+// subscriber is the contact sent to push services (mailto:...).
+func EnableWebPush(subscriber string) error {
+	keys, err := loadVAPIDKeys()
+	if err != nil {
+		return err
+	}
+	vapidKeys = keys
+	RegisterNotificationChannel(notify.NewWebPushChannel(keys, subscriber, pushStore{}))
+	return nil
+}
+
+// loadVAPIDKeys takes the keys from VAPID_PUBLIC_KEY/VAPID_PRIVATE_KEY, or
+// else from the database, generating them on first start. Keeping them in
+// the database means every instance signs with the same key and existing
+// subscriptions survive restarts.
+func loadVAPIDKeys() (notify.VAPIDKeys, error) {
+	keys := notify.VAPIDKeys{Public: os.Getenv("VAPID_PUBLIC_KEY"), Private: os.Getenv("VAPID_PRIVATE_KEY")}
+	if keys.Public != "" && keys.Private != "" {
+		return keys, nil
+	}
+
+	generated, err := notify.GenerateVAPIDKeys()
+	if err != nil {
+		return keys, err
+	}
+	// Only the first instance to start wins; everyone reads the stored pair
+	_, err = database.DB.Exec(`
+		INSERT IGNORE INTO vapid_keys (id, public_key, private_key, created_at)
+		VALUES (1, ?, ?, ?)
+	`, generated.Public, generated.Private, time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		return keys, err
+	}
+
+	err = database.DB.QueryRow("SELECT public_key, private_key FROM vapid_keys WHERE id = 1").Scan(&keys.Public, &keys.Private)
+	return keys, err
+}
+
+func endpointHash(endpoint string) string {
+	sum := sha256.Sum256([]byte(endpoint))
+	return hex.EncodeToString(sum[:])
+}
+
+// pushStore is the database-backed notify.PushSubscriptionStore
+type pushStore struct{}
+
+func (pushStore) Subscriptions(email string) ([]notify.PushSubscription, error) {
+	rows, err := database.DB.Query("SELECT endpoint, p256dh, auth FROM push_subscriptions WHERE email = ?", email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []notify.PushSubscription
+	for rows.Next() {
+		var s notify.PushSubscription
+		if err := rows.Scan(&s.Endpoint, &s.Keys.P256dh, &s.Keys.Auth); err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+func (pushStore) Remove(endpoint string) error {
+	_, err := database.DB.Exec("DELETE FROM push_subscriptions WHERE endpoint_hash = ?", endpointHash(endpoint))
+	if err == nil {
+		log.Printf("[PUSH] Removed expired subscription %.60s...", endpoint)
+	}
+	return err
+}
+
+// GetVAPIDPublicKey returns the applicationServerKey for pushManager.subscribe
+func GetVAPIDPublicKey(c *gin.Context) {
+	if vapidKeys.Public == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Web Push is not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"public_key": vapidKeys.Public})
+}
+
+// SubscribePush registers a browser push subscription for a user. The same
+// endpoint re-registered by another user moves to that user.
+func SubscribePush(c *gin.Context) {
+	var input struct {
+		Email        string                  `json:"email" binding:"required"`
+		Subscription notify.PushSubscription `json:"subscription"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sub := input.Subscription
+	if sub.Endpoint == "" || sub.Keys.P256dh == "" || sub.Keys.Auth == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscription endpoint and keys are required"})
+		return
+	}
+
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	_, err := database.DB.Exec(`
+		INSERT INTO push_subscriptions (email, endpoint_hash, endpoint, p256dh, auth, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE email = VALUES(email), p256dh = VALUES(p256dh), auth = VALUES(auth), user_agent = VALUES(user_agent)
+	`, input.Email, endpointHash(sub.Endpoint), sub.Endpoint, sub.Keys.P256dh, sub.Keys.Auth, userAgent, time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Subscribed"})
+}
+
+// UnsubscribePush removes a browser push subscription
+func UnsubscribePush(c *gin.Context) {
+	var input struct {
+		Endpoint string `json:"endpoint" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := database.DB.Exec("DELETE FROM push_subscriptions WHERE endpoint_hash = ?", endpointHash(input.Endpoint)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed"})
+}
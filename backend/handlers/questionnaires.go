@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/models"
+	"counseling-webrtc/questionnaire"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// INTAKE QUESTIONNAIRES
+// =============================================
+//
+// Clients may answer standardized questionnaires (PHQ-9, GAD-7, ...) when
+// booking. Answers are scored once, at creation, and the score and band are
+// stored with the definition version so later edits to a definition don't
+// change what the psychologist saw.
+
+// questionnaireAnswers is one questionnaire as submitted with a booking
+type questionnaireAnswers struct {
+	Code    string `json:"code"`
+	Answers []int  `json:"answers"` // One value per item, in item order
+}
+
+// GetQuestionnaires lists the questionnaires clients can answer
+func GetQuestionnaires(c *gin.Context) {
+	c.JSON(http.StatusOK, questionnaire.All())
+}
+
+// scoreQuestionnaires validates and scores submitted answers
+func scoreQuestionnaires(submitted []questionnaireAnswers) ([]questionnaire.Result, error) {
+	var results []questionnaire.Result
+	seen := map[string]bool{}
+	for _, s := range submitted {
+		def, ok := questionnaire.Get(s.Code)
+		if !ok {
+			return nil, fmt.Errorf("unknown questionnaire %q", s.Code)
+		}
+		if seen[s.Code] {
+			return nil, fmt.Errorf("%s submitted twice", s.Code)
+		}
+		seen[s.Code] = true
+
+		r, err := def.Score(s.Answers)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// saveQuestionnaires stores scored answers with a booking
+func saveQuestionnaires(tx *sql.Tx, bookingID int64, results []questionnaire.Result) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, r := range results {
+		answers, _ := json.Marshal(r.Answers)
+		_, err := tx.Exec(`
+			INSERT INTO booking_questionnaires (booking_id, code, version, answers, score, severity, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, bookingID, r.Code, r.Version, string(answers), r.Score, r.Band.Severity, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadQuestionnaires returns results by booking ID for the bookings
+// matching cond (on bookings b and psychologists p)
+func loadQuestionnaires(cond string, args ...interface{}) (map[int][]models.QuestionnaireResult, error) {
+	rows, err := database.DB.Query(`
+		SELECT q.booking_id, q.code, q.answers, q.score, q.severity, q.created_at
+		FROM booking_questionnaires q
+		JOIN bookings b ON q.booking_id = b.id
+		JOIN psychologists p ON b.psychologist_id = p.id
+		WHERE `+cond+`
+		ORDER BY q.booking_id, q.code
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byBooking := map[int][]models.QuestionnaireResult{}
+	for rows.Next() {
+		var bookingID int
+		var answers string
+		var r models.QuestionnaireResult
+		if err := rows.Scan(&bookingID, &r.Code, &answers, &r.Score, &r.Severity, &r.CreatedAt); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		json.Unmarshal([]byte(answers), &r.Answers)
+
+		// Names and labels come from the current definition; the stored
+		// severity is kept even if the bands have changed since
+		r.Name, r.SeverityLabel = r.Code, r.Severity
+		if def, ok := questionnaire.Get(r.Code); ok {
+			r.Name, r.MaxScore = def.Name, def.MaxScore()
+			if band, ok := def.BandNamed(r.Severity); ok {
+				r.SeverityLabel = band.Label
+			}
+		}
+		byBooking[bookingID] = append(byBooking[bookingID], r)
+	}
+	return byBooking, rows.Err()
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"context"
+	"counseling-webrtc/database"
+	"counseling-webrtc/models"
+	"counseling-webrtc/payments"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// =============================================
+// REFUNDS & CANCELLATIONS
+// =============================================
+//
+// Money goes back through the payment provider: a refunds row is written
+// in the same transaction as the booking change and the outbox relay asks
+// the provider for it (retried like any outbox row; the refund_key makes
+// retries safe). Rejected bookings are refunded in full. A client who
+// cancels an approved booking gets back the share refundPolicy gives for
+// the notice; the rest is the cancellation fee. Pending bookings are not
+// confirmed yet, so cancelling them is always free.
+
+// bookings.refund_status values
+const (
+	refundNone     = "none"
+	refundPending  = "pending"
+	refundRefunded = "refunded"
+	refundFailed   = "failed"
+)
+
+// refunds.reason values
+const (
+	refundReasonRejected  = "rejected"
+	refundReasonCancelled = "cancelled"
+	refundReasonDuplicate = "duplicate" // Paid twice, or after the booking was closed
+	refundReasonMismatch  = "mismatch"  // Paid a different amount than was charged
+)
+
+// Reasons as sent to the provider
+var refundReasonText = map[string]string{
+	refundReasonRejected:  "Booking ditolak psikolog",
+	refundReasonCancelled: "Booking dibatalkan klien",
+	refundReasonDuplicate: "Pembayaran ganda",
+	refundReasonMismatch:  "Jumlah pembayaran tidak sesuai",
+}
+
+// refundsBooking reports whether a refund reason refunds the booking
+// itself, rather than a stray payment the booking never needed
+func refundsBooking(reason string) bool {
+	return reason != refundReasonDuplicate && reason != refundReasonMismatch
+}
+
+var refundPolicy = payments.DefaultRefundPolicy
+
+// SetRefundPolicy replaces the cancellation policy. Call it once at startup.
+func SetRefundPolicy(p payments.RefundPolicy) {
+	refundPolicy = p
+}
+
+type outboxRefundRequest struct {
+	RefundID int64 `json:"refund_id"`
+}
+
+// queueRefund records a refund of a paid intent inside tx and queues it
+// for the relay. bookingID is nil for package purchases.
+func queueRefund(tx *sql.Tx, bookingID interface{}, paymentID, amount, fee int, reason string) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	res, err := tx.Exec(`
+		INSERT INTO refunds (booking_id, payment_id, amount, fee, reason, status, refund_key, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, 'pending', ?, ?, ?)
+	`, bookingID, paymentID, amount, fee, reason, uuid.New().String(), now, now)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	if bookingID == nil {
+		bookingID = 0
+	}
+	return enqueueOutbox(tx, bookingID, outboxRefund, outboxRefundRequest{RefundID: id})
+}
+
+// queueBookingRefund refunds percent of the booking's payment inside tx
+// and records refund and fee on the booking, marking it refunded if any
+// money goes back. Bookings that were never paid are left alone.
+func queueBookingRefund(tx *sql.Tx, bookingID interface{}, percent int, reason string) (amount, fee int, err error) {
+	var paymentID, paid int
+	err = tx.QueryRow(`
+		SELECT p.id, p.amount FROM payments p
+		WHERE p.booking_id = ? AND p.status = 'paid' AND NOT EXISTS (SELECT 1 FROM refunds r WHERE r.payment_id = p.id)
+		ORDER BY p.id ASC LIMIT 1
+	`, bookingID).Scan(&paymentID, &paid)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	amount = paid * percent / 100
+	fee = paid - amount
+	if amount == 0 {
+		_, err = tx.Exec("UPDATE bookings SET refund_status = ?, refund_amount = 0, cancellation_fee = ? WHERE id = ?", refundNone, fee, bookingID)
+		return 0, fee, err
+	}
+	if err = queueRefund(tx, bookingID, paymentID, amount, fee, reason); err != nil {
+		return 0, 0, err
+	}
+	_, err = tx.Exec("UPDATE bookings SET payment_status = ?, refund_status = ?, refund_amount = ?, cancellation_fee = ? WHERE id = ?",
+		paymentRefunded, refundPending, amount, fee, bookingID)
+	return amount, fee, err
+}
+
+// processRefund asks the provider for a queued refund (outbox)
+func processRefund(refundID int64) error {
+	var bookingID, purchaseID, amount int
+	var reason, status, refundKey, orderID, provider string
+	err := database.DB.QueryRow(`
+		SELECT IFNULL(r.booking_id, 0), IFNULL(p.purchase_id, 0), r.amount, r.reason, r.status, r.refund_key, p.order_id, p.provider
+		FROM refunds r JOIN payments p ON p.id = r.payment_id
+		WHERE r.id = ?
+	`, refundID).Scan(&bookingID, &purchaseID, &amount, &reason, &status, &refundKey, &orderID, &provider)
+	if err != nil {
+		return fmt.Errorf("load refund %d: %w", refundID, err)
+	}
+	if status != refundPending {
+		return nil
+	}
+	if paymentProvider == nil || paymentProvider.Name() != provider {
+		return fmt.Errorf("payment provider %q is not configured", provider)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), paymentTimeout)
+	defer cancel()
+	result, err := paymentProvider.Refund(ctx, payments.RefundRequest{
+		OrderID:   orderID,
+		RefundKey: refundKey,
+		Amount:    amount,
+		Reason:    refundReasonText[reason],
+	})
+	now := time.Now().UTC().Truncate(time.Second)
+	if err != nil {
+		database.DB.Exec("UPDATE refunds SET last_error = ?, updated_at = ? WHERE id = ?", err.Error(), now, refundID)
+		return err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE refunds SET status = 'succeeded', provider_ref = ?, last_error = NULL, updated_at = ? WHERE id = ?",
+		result.ProviderRef, now, refundID)
+	if err == nil && purchaseID != 0 {
+		// Package purchases have no booking to update or report
+		var clientContact string
+		tx.QueryRow("SELECT client_contact FROM package_purchases WHERE id = ?", purchaseID).Scan(&clientContact)
+		err = enqueueNotification(tx, 0, clientContact, gin.H{
+			"type":         "refund_processed",
+			"message":      fmt.Sprintf("Dana %s untuk pembelian paket telah dikembalikan", formatRupiah(amount)),
+			"purchase_id":  purchaseID,
+			"amount":       amount,
+			"amount_label": formatRupiah(amount),
+		})
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return err
+		}
+		kickOutbox()
+		log.Printf("[REFUND] Refund %d for package purchase %d (%s, %s) succeeded", refundID, purchaseID, formatRupiah(amount), reason)
+		return nil
+	}
+	if err == nil && bookingID != 0 && refundsBooking(reason) {
+		_, err = tx.Exec("UPDATE bookings SET refund_status = ? WHERE id = ?", refundRefunded, bookingID)
+	}
+
+	var clientContact string
+	tx.QueryRow("SELECT IFNULL(client_contact, '') FROM bookings WHERE id = ?", bookingID).Scan(&clientContact)
+	if err == nil {
+		err = enqueueNotification(tx, bookingID, clientContact, gin.H{
+			"type":         "refund_processed",
+			"message":      fmt.Sprintf("Dana %s untuk booking #%d telah dikembalikan", formatRupiah(amount), bookingID),
+			"booking_id":   bookingID,
+			"amount":       amount,
+			"amount_label": formatRupiah(amount),
+		})
+	}
+	if err == nil {
+		err = enqueueWebhook(tx, bookingID, EventBookingRefunded)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+	kickOutbox()
+
+	log.Printf("[REFUND] Refund %d for booking %d (%s, %s) succeeded", refundID, bookingID, formatRupiah(amount), reason)
+	return nil
+}
+
+// failRefund marks a refund the relay gave up on as failed, so an admin
+// can look at it and retry
+func failRefund(payload string, cause error) {
+	var req outboxRefundRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	database.DB.Exec("UPDATE refunds SET status = 'failed', last_error = ?, updated_at = ? WHERE id = ? AND status = 'pending'",
+		cause.Error(), now, req.RefundID)
+	database.DB.Exec(`
+		UPDATE bookings b JOIN refunds r ON r.booking_id = b.id
+		SET b.refund_status = ?
+		WHERE r.id = ? AND r.reason NOT IN (?, ?)
+	`, refundFailed, req.RefundID, refundReasonDuplicate, refundReasonMismatch)
+}
+
+// ListRefunds lists refunds, optionally filtered by ?status=
+func ListRefunds(c *gin.Context) {
+	query := `SELECT id, IFNULL(booking_id, 0), payment_id, amount, fee, reason, status, IFNULL(last_error, ''), created_at, updated_at FROM refunds`
+	var args []interface{}
+	if status := c.Query("status"); status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	rows, err := database.DB.Query(query+" ORDER BY id DESC LIMIT 200", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	refunds := []models.Refund{}
+	for rows.Next() {
+		var r models.Refund
+		if err := rows.Scan(&r.ID, &r.BookingID, &r.PaymentID, &r.Amount, &r.Fee, &r.Reason, &r.Status, &r.LastError, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		refunds = append(refunds, r)
+	}
+
+	c.JSON(http.StatusOK, refunds)
+}
+
+// RetryRefund queues a failed refund again. Refunds of package purchases
+// have no booking.
+func RetryRefund(c *gin.Context) {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	var bookingID int
+	var reason string
+	if err := database.DB.QueryRow("SELECT IFNULL(booking_id, 0), reason FROM refunds WHERE id = ? AND status = 'failed'", id).Scan(&bookingID, &reason); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed refund not found"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Retry failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE refunds SET status = 'pending', updated_at = ? WHERE id = ? AND status = 'failed'", time.Now().UTC().Truncate(time.Second), id)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n != 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Refund is already being retried"})
+			return
+		}
+	}
+	if err == nil && bookingID != 0 && refundsBooking(reason) {
+		_, err = tx.Exec("UPDATE bookings SET refund_status = ? WHERE id = ?", refundPending, bookingID)
+	}
+	if err == nil {
+		err = enqueueOutbox(tx, bookingID, outboxRefund, outboxRefundRequest{RefundID: id})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Retry failed"})
+		return
+	}
+	kickOutbox()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Refund queued for retry"})
+}
+
+// cancellationQuote is what cancelling a booking now costs the client
+type cancellationQuote struct {
+	Paid          int    `json:"paid"`
+	RefundPercent int    `json:"refund_percent"`
+	RefundAmount  int    `json:"refund_amount"`
+	Fee           int    `json:"cancellation_fee"`
+	Notice        string `json:"notice"`            // Time left until the session, e.g. "26h0m0s"
+	Credit        string `json:"credit,omitempty"`  // Paid with a package credit: returned or forfeited
+	Sponsor       string `json:"sponsor,omitempty"` // Sponsored: released (back to the quota) or forfeited
+}
+
+// quoteCancellation applies refundPolicy to b at now. ok is false when b
+// can't be cancelled any more.
+func quoteCancellation(b paymentBooking, now time.Time) (q cancellationQuote, ok bool) {
+	start := sessionTime(b.ScheduleTime)
+	if (b.Status != "pending" && b.Status != "approved") || !now.Before(start) {
+		return q, false
+	}
+
+	notice := start.Sub(now).Truncate(time.Minute)
+	q.Notice = notice.String()
+	q.RefundPercent = 100
+	if b.Status == "approved" {
+		q.RefundPercent = refundPolicy.Percent(notice)
+	}
+	if b.PaymentStatus == paymentPaid {
+		q.Paid = b.Price
+	}
+	q.RefundAmount = q.Paid * q.RefundPercent / 100
+	q.Fee = q.Paid - q.RefundAmount
+	if b.PaymentStatus == paymentCredit {
+		// Same rule as money: the credit comes back when a full refund would
+		q.Credit = creditForfeited
+		if q.RefundPercent == 100 {
+			q.Credit = creditReturned
+		}
+	}
+	if b.PaymentStatus == paymentSponsored {
+		q.Sponsor = sponsorForfeited
+		if q.RefundPercent == 100 {
+			q.Sponsor = sponsorReleased
+		}
+	}
+	return q, true
+}
+
+// GetCancellationQuote tells the client what cancelling would cost,
+// before they confirm
+func GetCancellationQuote(c *gin.Context) {
+	b, ok := getPaymentBooking(c.Param("id"), c.Query("email"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
+	q, ok := quoteCancellation(b, time.Now())
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Booking ini tidak dapat dibatalkan lagi"})
+		return
+	}
+	c.JSON(http.StatusOK, q)
+}
+
+// CancelBooking cancels a booking on behalf of its client and refunds
+// what the cancellation policy allows
+func CancelBooking(c *gin.Context) {
+	var input struct {
+		Email  string `json:"email" binding:"required"`
+		Reason string `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := c.Param("id")
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel booking"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the booking so an approval can't slip in between quote and update
+	var locked string
+	if err := tx.QueryRow("SELECT status FROM bookings WHERE id = ? FOR UPDATE", id).Scan(&locked); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
+	b, ok := getPaymentBookingTx(tx, id, input.Email)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
+	q, ok := quoteCancellation(b, time.Now())
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Booking ini tidak dapat dibatalkan lagi"})
+		return
+	}
+
+	_, err = tx.Exec("UPDATE bookings SET status = 'cancelled', cancellation_reason = NULLIF(?, ''), cancelled_at = ? WHERE id = ?",
+		input.Reason, time.Now().UTC().Truncate(time.Second), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel booking"})
+		return
+	}
+
+	// Refund, cancel calendar entry and reminders, tell the psychologist and external systems
+	_, _, err = queueBookingRefund(tx, id, q.RefundPercent, refundReasonCancelled)
+	if err == nil {
+		err = releaseBookingCredit(tx, id, q.RefundPercent == 100)
+	}
+	if err == nil {
+		err = releaseSponsorship(tx, id, q.RefundPercent == 100)
+	}
+	if err == nil {
+		err = bumpCalendarSequence(tx, id, false)
+	}
+	if err == nil {
+		err = enqueueReminderSync(tx, id)
+	}
+	if err == nil {
+		err = enqueueWebhook(tx, id, EventBookingCancelled)
+	}
+	var psychoEmail string
+	tx.QueryRow("SELECT IFNULL(p.email, '') FROM bookings b LEFT JOIN psychologists p ON b.psychologist_id = p.id WHERE b.id = ?", id).Scan(&psychoEmail)
+	if err == nil {
+		err = enqueueNotification(tx, id, psychoEmail, gin.H{
+			"type":        "booking_cancelled",
+			"message":     fmt.Sprintf("%s membatalkan booking %s", b.ClientName, formatSessionTime(b.ScheduleTime)),
+			"booking_id":  b.ID,
+			"client_name": b.ClientName,
+			"reason":      input.Reason,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel booking"})
+		return
+	}
+	kickOutbox()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Booking cancelled", "refund_amount": q.RefundAmount, "cancellation_fee": q.Fee, "credit": q.Credit, "sponsor": q.Sponsor})
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// SESSION REMINDERS
+// =============================================
+//
+// Reminders are rows in session_reminders, created when a booking is
+// approved. A background loop sends the due ones to the client and the
+// psychologist. Each row is claimed (pending -> sent) before sending, so a
+// restart or a second instance never sends the same reminder twice.
+// Reminders of a booking that is no longer approved, or whose schedule_time
+// changed, are cancelled.
+
+// How often due reminders are checked
+const reminderPollInterval = 30 * time.Second
+
+// reminderOffsets are the reminders sent before every session
+var reminderOffsets = []struct {
+	Kind   string
+	Before time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"15m", 15 * time.Minute},
+}
+
+// schedule_time is stored as WIB wall clock time (what the booking page
+// sends), while the driver hands it back tagged as UTC.
+var sessionLocation = loadSessionLocation()
+
+func loadSessionLocation() *time.Location {
+	if loc, err := time.LoadLocation("Asia/Jakarta"); err == nil {
+		return loc
+	}
+	return time.FixedZone("WIB", 7*60*60)
+}
+
+// sessionTime converts a schedule_time read from the database to the
+// actual instant of the session
+func sessionTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, sessionLocation)
+}
+
+// formatSessionTime formats a schedule_time for humans ("02/01/2006 15:04 WIB")
+func formatSessionTime(t time.Time) string {
+	return t.Format("02/01/2006 15:04") + " WIB"
+}
+
+// scheduleReminders brings the reminders of a booking in line with its
+// current status and schedule_time. Call it after every change to either
+// (booking handlers do so through the outbox).
+func scheduleReminders(bookingID interface{}) error {
+	var status string
+	var scheduleTime time.Time
+	err := database.DB.QueryRow("SELECT status, schedule_time FROM bookings WHERE id = ?", bookingID).Scan(&status, &scheduleTime)
+	if err != nil {
+		return fmt.Errorf("load booking %v: %w", bookingID, err)
+	}
+
+	// Drop reminders for an old schedule_time (rescheduled) or a booking that is no longer approved
+	_, err = database.DB.Exec(`
+		UPDATE session_reminders SET status = 'cancelled'
+		WHERE booking_id = ? AND status = 'pending' AND (schedule_time <> ? OR ? <> 'approved')
+	`, bookingID, scheduleTime, status)
+	if err != nil {
+		return fmt.Errorf("cancel reminders of booking %v: %w", bookingID, err)
+	}
+	if status != "approved" {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	start := sessionTime(scheduleTime)
+	if !start.After(now) {
+		return nil
+	}
+	for _, off := range reminderOffsets {
+		remindAt := start.Add(-off.Before).UTC()
+		if remindAt.Before(now) {
+			// Approved late (e.g. 2h before): send the reminder right away only if it is the last one
+			if off.Before > reminderOffsets[len(reminderOffsets)-1].Before {
+				continue
+			}
+			remindAt = now
+		}
+
+		_, err := database.DB.Exec(`
+			INSERT IGNORE INTO session_reminders (booking_id, kind, schedule_time, remind_at, status)
+			VALUES (?, ?, ?, ?, 'pending')
+		`, bookingID, off.Kind, scheduleTime, remindAt.Truncate(time.Second))
+		if err != nil {
+			return fmt.Errorf("schedule %s reminder for booking %v: %w", off.Kind, bookingID, err)
+		}
+	}
+	return nil
+}
+
+// StartReminders schedules reminders for approved upcoming bookings that
+// have none yet and starts the delivery loop. Call it once at startup.
+func StartReminders() {
+	rows, err := database.DB.Query(`
+		SELECT b.id FROM bookings b
+		WHERE b.status = 'approved' AND b.schedule_time > ?
+		AND NOT EXISTS (SELECT 1 FROM session_reminders r WHERE r.booking_id = b.id AND r.schedule_time = b.schedule_time)
+	`, time.Now().In(sessionLocation).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("[REMINDER] Failed to backfill reminders: %v", err)
+	} else {
+		var ids []int
+		for rows.Next() {
+			var id int
+			if rows.Scan(&id) == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		for _, id := range ids {
+			if err := scheduleReminders(id); err != nil {
+				log.Printf("[REMINDER] Backfill failed: %v", err)
+			}
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(reminderPollInterval)
+		defer ticker.Stop()
+		for {
+			sendDueReminders()
+			<-ticker.C
+		}
+	}()
+}
+
+// dueReminder is a pending reminder joined with its booking
+type dueReminder struct {
+	ID                int64
+	BookingID         int
+	Kind              string
+	ScheduleTime      time.Time
+	Current           time.Time // bookings.schedule_time now
+	Status            string
+	RoomID            string
+	ClientName        string
+	ClientContact     string
+	PsychologistName  string
+	PsychologistEmail string
+}
+
+// sendDueReminders sends every reminder whose time has come
+func sendDueReminders() {
+	rows, err := database.DB.Query(`
+		SELECT r.id, r.booking_id, r.kind, r.schedule_time, b.schedule_time, b.status, IFNULL(b.room_id, ''),
+			b.client_name, IFNULL(b.client_contact, ''), IFNULL(p.name, ''), IFNULL(p.email, '')
+		FROM session_reminders r
+		JOIN bookings b ON b.id = r.booking_id
+		LEFT JOIN psychologists p ON p.id = b.psychologist_id
+		WHERE r.status = 'pending' AND r.remind_at <= ?
+		ORDER BY r.remind_at ASC
+	`, time.Now().UTC())
+	if err != nil {
+		log.Printf("[REMINDER] Failed to load due reminders: %v", err)
+		return
+	}
+
+	var due []dueReminder
+	for rows.Next() {
+		var r dueReminder
+		if err := rows.Scan(&r.ID, &r.BookingID, &r.Kind, &r.ScheduleTime, &r.Current, &r.Status, &r.RoomID,
+			&r.ClientName, &r.ClientContact, &r.PsychologistName, &r.PsychologistEmail); err != nil {
+			continue
+		}
+		due = append(due, r)
+	}
+	rows.Close()
+
+	for _, r := range due {
+		switch {
+		case r.Status != "approved" || !r.ScheduleTime.Equal(r.Current):
+			finishReminder(r.ID, "cancelled")
+		case !sessionTime(r.ScheduleTime).After(time.Now()):
+			// The server was down until after the session started
+			finishReminder(r.ID, "skipped")
+		case finishReminder(r.ID, "sent"):
+			sendReminder(r)
+		}
+	}
+}
+
+// finishReminder moves a pending reminder to status. It returns false if
+// another instance got there first.
+func finishReminder(id int64, status string) bool {
+	res, err := database.DB.Exec(`
+		UPDATE session_reminders SET status = ?, processed_at = ?
+		WHERE id = ? AND status = 'pending'
+	`, status, time.Now().UTC().Truncate(time.Second), id)
+	if err != nil {
+		log.Printf("[REMINDER] Failed to update reminder %d: %v", id, err)
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
+
+// sendReminder notifies both participants of a session
+func sendReminder(r dueReminder) {
+	when := formatSessionTime(r.ScheduleTime)
+	base := func() gin.H {
+		return gin.H{
+			"type":              "session_reminder",
+			"kind":              r.Kind,
+			"booking_id":        r.BookingID,
+			"room_id":           r.RoomID,
+			"schedule_time":     when,
+			"client_name":       r.ClientName,
+			"psychologist_name": r.PsychologistName,
+		}
+	}
+
+	if r.ClientContact != "" {
+		msg := base()
+		msg["role"] = RoleClient
+		msg["message"] = fmt.Sprintf("Pengingat: sesi konseling Anda dengan %s dimulai %s", r.PsychologistName, when)
+		SendNotification(r.ClientContact, msg)
+	}
+	if r.PsychologistEmail != "" {
+		msg := base()
+		msg["role"] = RoleExpert
+		msg["message"] = fmt.Sprintf("Pengingat: sesi dengan %s dimulai %s", r.ClientName, when)
+		SendNotification(r.PsychologistEmail, msg)
+	}
+
+	log.Printf("[REMINDER] Sent %s reminder for booking %d", r.Kind, r.BookingID)
+}
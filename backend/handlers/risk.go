@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/models"
+	"counseling-webrtc/risk"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// CRISIS & RISK SCREENING
+// =============================================
+//
+// CreateBooking screens the complaint and intake questionnaires (see
+// package risk). A high-risk booking is flagged, the psychologist and the
+// risk alert contacts are notified at once, and the client gets crisis
+// hotlines in the booking response.
+
+// riskAlertEmails are alerted of every high-risk booking, besides its
+// psychologist
+var riskAlertEmails []string
+
+// SetRiskAlertEmails sets the admins/clinical leads alerted of high-risk
+// bookings. Call it at startup, before serving requests.
+func SetRiskAlertEmails(emails []string) {
+	riskAlertEmails = emails
+}
+
+// riskReasonsValue is the risk_reasons column for an assessment
+func riskReasonsValue(a risk.Assessment) interface{} {
+	if !a.High() {
+		return nil
+	}
+	reasons, _ := json.Marshal(a.Reasons)
+	return string(reasons)
+}
+
+// alertHighRisk queues the alerts for a flagged booking
+func alertHighRisk(tx *sql.Tx, bookingID int64, psychologistEmail, clientName, scheduleTime string, a risk.Assessment) error {
+	message := gin.H{
+		"type":          "high_risk_booking",
+		"message":       fmt.Sprintf("Booking berisiko tinggi dari %s perlu segera ditinjau", clientName),
+		"booking_id":    bookingID,
+		"client_name":   clientName,
+		"schedule_time": scheduleTime,
+		"reasons":       a.Reasons,
+	}
+	for _, email := range riskAlertRecipients(psychologistEmail) {
+		if err := enqueueNotification(tx, bookingID, email, message); err != nil {
+			return err
+		}
+	}
+	return enqueueWebhook(tx, bookingID, EventBookingRiskFlagged)
+}
+
+// alertHighRiskRequest alerts the same people of a flagged client whose
+// booking could not be made, so someone still reaches out. There is no
+// booking to look the client up from, so the alert carries their contact.
+func alertHighRiskRequest(psychologistID int, clientName, clientContact, scheduleTime string, a risk.Assessment) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("[RISK] Failed to alert high-risk request from %s: %v", clientName, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var psychoEmail string
+	tx.QueryRow("SELECT email FROM psychologists WHERE id = ?", psychologistID).Scan(&psychoEmail)
+	message := gin.H{
+		"type":           "high_risk_request",
+		"message":        fmt.Sprintf("%s (berisiko tinggi) gagal membuat booking dan perlu segera dihubungi", clientName),
+		"client_name":    clientName,
+		"client_contact": clientContact,
+		"schedule_time":  scheduleTime,
+		"reasons":        a.Reasons,
+	}
+	for _, email := range riskAlertRecipients(psychoEmail) {
+		if err = enqueueNotification(tx, 0, email, message); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("[RISK] Failed to alert high-risk request from %s: %v", clientName, err)
+		return
+	}
+	kickOutbox()
+}
+
+// riskAlertRecipients is the psychologist plus the risk alert contacts,
+// without duplicates
+func riskAlertRecipients(psychologistEmail string) []string {
+	var emails []string
+	seen := map[string]bool{}
+	for _, email := range append([]string{psychologistEmail}, riskAlertEmails...) {
+		if email == "" || seen[email] {
+			continue
+		}
+		seen[email] = true
+		emails = append(emails, email)
+	}
+	return emails
+}
+
+// crisisSupport is shown to a client whose booking was flagged
+func crisisSupport() gin.H {
+	return gin.H{
+		"message": "Kami memperhatikan Anda mungkin sedang melalui masa yang sangat berat, dan Anda tidak sendiri. " +
+			"Psikolog Anda sudah diberi tahu. Jika Anda dalam bahaya atau berpikir untuk menyakiti diri sendiri, " +
+			"jangan menunggu jadwal sesi: segera hubungi layanan berikut atau orang terdekat yang Anda percayai.",
+		"hotlines": risk.Hotlines(),
+	}
+}
+
+// ListHighRiskBookings lists flagged bookings for admins, newest first.
+// ?status= filters by booking status (e.g. pending).
+func ListHighRiskBookings(c *gin.Context) {
+	query := `
+		SELECT b.id, b.client_name, IFNULL(b.client_contact, ''), IFNULL(b.complaint, ''), b.psychologist_id, p.name,
+			DATE_FORMAT(b.schedule_time, '%Y-%m-%dT%H:%i:%s'), b.status, b.risk_level, IFNULL(b.risk_reasons, ''),
+			IFNULL(DATE_FORMAT(b.created_at, '%Y-%m-%dT%H:%i:%s'), '')
+		FROM bookings b
+		JOIN psychologists p ON b.psychologist_id = p.id
+		WHERE b.risk_level = ?`
+	args := []interface{}{risk.LevelHigh}
+	if status := c.Query("status"); status != "" {
+		query += " AND b.status = ?"
+		args = append(args, status)
+	}
+	rows, err := database.DB.Query(query+" ORDER BY b.id DESC LIMIT 200", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	bookings := []models.Booking{}
+	for rows.Next() {
+		var b models.Booking
+		var reasons string
+		if err := rows.Scan(&b.ID, &b.ClientName, &b.ClientContact, &b.Complaint, &b.PsychologistID, &b.PsychologistName,
+			&b.ScheduleTime, &b.Status, &b.RiskLevel, &reasons, &b.CreatedAt); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		json.Unmarshal([]byte(reasons), &b.RiskReasons)
+		bookings = append(bookings, b)
+	}
+
+	c.JSON(http.StatusOK, bookings)
+}
@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/ical"
+	"counseling-webrtc/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// SESSION TYPES
+// =============================================
+//
+// Psychologists define the sessions they offer (duration, price,
+// modality); every session is one-to-one (see roomCapacity). A booking
+// copies the values of its type, so editing or retiring a type never
+// changes sessions already booked. Psychologists without types offer
+// defaultSessionType.
+
+// Session modalities
+const (
+	modalityVideo = "video"
+	modalityAudio = "audio"
+	modalityChat  = "chat"
+)
+
+// modalityLabels are shown to users (calendar entries, notifications)
+var modalityLabels = map[string]string{
+	modalityVideo: "video call",
+	modalityAudio: "panggilan suara",
+	modalityChat:  "chat",
+}
+
+// Limits for psychologist-defined types
+const (
+	minSessionMinutes = 15
+	maxSessionMinutes = 240
+)
+
+// defaultSessionType is the one-hour, one-to-one video session every
+// booking used to be
+var defaultSessionType = models.SessionType{
+	Name:            "Sesi Konseling",
+	DurationMinutes: 60,
+	Modality:        modalityVideo,
+	IsActive:        true,
+}
+
+var errSessionTypeNotFound = errors.New("session type not found")
+
+// getSessionTypes lists a psychologist's types, cheapest first
+func getSessionTypes(psychologistID int, activeOnly bool) []models.SessionType {
+	query := `
+		SELECT id, psychologist_id, name, duration_minutes, price, modality, is_active
+		FROM session_types WHERE psychologist_id = ?`
+	if activeOnly {
+		query += " AND is_active = TRUE"
+	}
+	rows, err := database.DB.Query(query+" ORDER BY price, duration_minutes, id", psychologistID)
+	if err != nil {
+		return []models.SessionType{}
+	}
+	defer rows.Close()
+
+	types := []models.SessionType{}
+	for rows.Next() {
+		var t models.SessionType
+		if err := rows.Scan(&t.ID, &t.PsychologistID, &t.Name, &t.DurationMinutes, &t.Price, &t.Modality, &t.IsActive); err != nil {
+			continue
+		}
+		types = append(types, t)
+	}
+	return types
+}
+
+// offeredSessionTypes is what clients can choose from
+func offeredSessionTypes(psychologistID int) []models.SessionType {
+	types := getSessionTypes(psychologistID, true)
+	if len(types) == 0 {
+		t := defaultSessionType
+		t.PsychologistID = psychologistID
+		types = append(types, t)
+	}
+	return types
+}
+
+// resolveSessionType returns the active type id of the psychologist, or
+// the default type for id 0 when the psychologist has none
+func resolveSessionType(psychologistID, id int) (models.SessionType, error) {
+	if id == 0 {
+		types := offeredSessionTypes(psychologistID)
+		if len(types) == 1 {
+			return types[0], nil
+		}
+		return models.SessionType{}, errors.New("session_type_id is required")
+	}
+
+	var t models.SessionType
+	err := database.DB.QueryRow(`
+		SELECT id, psychologist_id, name, duration_minutes, price, modality, is_active
+		FROM session_types WHERE id = ? AND psychologist_id = ? AND is_active = TRUE
+	`, id, psychologistID).Scan(&t.ID, &t.PsychologistID, &t.Name, &t.DurationMinutes, &t.Price, &t.Modality, &t.IsActive)
+	if err == sql.ErrNoRows {
+		return t, errSessionTypeNotFound
+	}
+	return t, err
+}
+
+func validateSessionType(t models.SessionType) error {
+	switch {
+	case strings.TrimSpace(t.Name) == "":
+		return errors.New("name is required")
+	case t.DurationMinutes < minSessionMinutes || t.DurationMinutes > maxSessionMinutes || t.DurationMinutes%minSessionMinutes != 0:
+		return fmt.Errorf("duration_minutes must be a multiple of %d between %d and %d", minSessionMinutes, minSessionMinutes, maxSessionMinutes)
+	case t.Price < 0:
+		return errors.New("price must not be negative")
+	case t.Modality != modalityVideo && t.Modality != modalityAudio && t.Modality != modalityChat:
+		return errors.New("modality must be 'video', 'audio' or 'chat'")
+	}
+	return nil
+}
+
+// =============================================
+// SLOTS
+// =============================================
+
+// Spacing of the start times offered by GetAvailableSlots
+const slotStep = 30 * time.Minute
+
+// parseScheduleTime reads a schedule_time as sent by the booking page
+// ("2006-01-02T15:04:05", optionally with a trailing Z) as WIB wall clock
+func parseScheduleTime(s string) (time.Time, error) {
+	s = strings.TrimSuffix(strings.Replace(s, " ", "T", 1), "Z")
+	if len(s) == len("2006-01-02T15:04") {
+		s += ":00"
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05", s, sessionLocation)
+}
+
+// busyIntervals returns the pending/approved bookings and imported busy
+// times of a psychologist overlapping [from, to)
+func busyIntervals(psychologistID int, from, to time.Time) ([]ical.Period, error) {
+	fromStr, toStr := from.In(sessionLocation).Format(wallClockFormat), to.In(sessionLocation).Format(wallClockFormat)
+	var busy []ical.Period
+
+	rows, err := database.DB.Query(`
+		SELECT schedule_time, duration_minutes FROM bookings
+		WHERE psychologist_id = ? AND status IN ('pending', 'approved')
+			AND schedule_time < ? AND DATE_ADD(schedule_time, INTERVAL duration_minutes MINUTE) > ?
+	`, psychologistID, toStr, fromStr)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var start time.Time
+		var minutes int
+		if rows.Scan(&start, &minutes) == nil {
+			busy = append(busy, ical.Period{Start: sessionTime(start), End: sessionTime(start).Add(time.Duration(minutes) * time.Minute)})
+		}
+	}
+	rows.Close()
+
+	rows, err = database.DB.Query(`
+		SELECT start_time, end_time FROM external_busy_times
+		WHERE psychologist_id = ? AND start_time < ? AND end_time > ?
+	`, psychologistID, toStr, fromStr)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var start, end time.Time
+		if rows.Scan(&start, &end) == nil {
+			busy = append(busy, ical.Period{Start: sessionTime(start), End: sessionTime(end)})
+		}
+	}
+	return busy, rows.Err()
+}
+
+func overlapsAny(busy []ical.Period, start, end time.Time) bool {
+	for _, p := range busy {
+		if p.Start.Before(end) && p.End.After(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// fitsSchedule reports whether a session lies within one of the practice
+// hours. Psychologists without practice hours take any time, as before.
+func fitsSchedule(schedules []models.Schedule, start time.Time, minutes int) bool {
+	if len(schedules) == 0 {
+		return true
+	}
+	from := start.Hour()*60 + start.Minute()
+	for _, s := range schedules {
+		if !s.IsActive || s.DayOfWeek != int(start.Weekday()) {
+			continue
+		}
+		if from >= clockMinutes(s.StartTime) && from+minutes <= clockMinutes(s.EndTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// clockMinutes converts "HH:MM[:SS]" to minutes since midnight
+func clockMinutes(s string) int {
+	var h, m int
+	fmt.Sscanf(s, "%d:%d", &h, &m)
+	return h*60 + m
+}
+
+// slotAvailable checks one session against practice hours, bookings and
+// imported busy times
+func slotAvailable(psychologistID int, schedules []models.Schedule, start time.Time, minutes int) (bool, error) {
+	if !fitsSchedule(schedules, start, minutes) {
+		return false, nil
+	}
+	end := start.Add(time.Duration(minutes) * time.Minute)
+	busy, err := busyIntervals(psychologistID, start, end)
+	if err != nil {
+		return false, err
+	}
+	return !overlapsAny(busy, start, end), nil
+}
+
+// GetAvailableSlots lists the free start times ("HH:MM", WIB) of a
+// psychologist on a date for a session type
+func GetAvailableSlots(c *gin.Context) {
+	psychoID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid psychologist id"})
+		return
+	}
+	day, err := time.ParseInLocation("2006-01-02", c.Query("date"), sessionLocation)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+	typeID, _ := strconv.Atoi(c.Query("session_type_id"))
+	st, err := resolveSessionType(psychoID, typeID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	busy, err := busyIntervals(psychoID, day, day.AddDate(0, 0, 1).Add(maxSessionMinutes*time.Minute))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	schedules := getPsychologistSchedules(psychoID)
+	length := time.Duration(st.DurationMinutes) * time.Minute
+	now := time.Now()
+	slots := []string{}
+	for t := day; t.Before(day.AddDate(0, 0, 1)); t = t.Add(slotStep) {
+		if !t.After(now) || !fitsSchedule(schedules, t, st.DurationMinutes) || overlapsAny(busy, t, t.Add(length)) {
+			continue
+		}
+		slots = append(slots, t.Format("15:04"))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"session_type": st, "slots": slots})
+}
+
+// =============================================
+// EXPERT: MANAGE SESSION TYPES
+// =============================================
+
+// GetExpertSessionTypes lists all types of a psychologist, retired ones included
+func GetExpertSessionTypes(c *gin.Context) {
+	psychoID, ok := psychologistIDByEmail(c.Query("email"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+		return
+	}
+	c.JSON(http.StatusOK, getSessionTypes(psychoID, false))
+}
+
+type sessionTypeInput struct {
+	Email           string  `json:"email" binding:"required"`
+	Name            *string `json:"name"`
+	DurationMinutes *int    `json:"duration_minutes"`
+	Price           *int    `json:"price"`
+	Modality        *string `json:"modality"`
+	IsActive        *bool   `json:"is_active"`
+}
+
+// apply copies the fields that were sent onto t
+func (in sessionTypeInput) apply(t *models.SessionType) {
+	if in.Name != nil {
+		t.Name = strings.TrimSpace(*in.Name)
+	}
+	if in.DurationMinutes != nil {
+		t.DurationMinutes = *in.DurationMinutes
+	}
+	if in.Price != nil {
+		t.Price = *in.Price
+	}
+	if in.Modality != nil {
+		t.Modality = *in.Modality
+	}
+	if in.IsActive != nil {
+		t.IsActive = *in.IsActive
+	}
+}
+
+// CreateSessionType adds a type; unspecified fields take the defaults
+// of a one-hour video session
+func CreateSessionType(c *gin.Context) {
+	var input sessionTypeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	psychoID, ok := psychologistIDByEmail(input.Email)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Psychologist not found"})
+		return
+	}
+
+	t := defaultSessionType
+	t.Name = ""
+	t.PsychologistID = psychoID
+	input.apply(&t)
+	if err := validateSessionType(t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := database.DB.Exec(`
+		INSERT INTO session_types (psychologist_id, name, duration_minutes, price, modality, is_active, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, psychoID, t.Name, t.DurationMinutes, t.Price, t.Modality, t.IsActive, time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session type"})
+		return
+	}
+	id, _ := res.LastInsertId()
+	t.ID = int(id)
+
+	c.JSON(http.StatusOK, t)
+}
+
+// UpdateSessionType changes the fields sent. Existing bookings keep the
+// values they were booked with.
+func UpdateSessionType(c *gin.Context) {
+	var input sessionTypeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var t models.SessionType
+	err := database.DB.QueryRow(`
+		SELECT s.id, s.psychologist_id, s.name, s.duration_minutes, s.price, s.modality, s.is_active
+		FROM session_types s JOIN psychologists p ON p.id = s.psychologist_id
+		WHERE s.id = ? AND p.email = ?
+	`, c.Param("id"), input.Email).Scan(&t.ID, &t.PsychologistID, &t.Name, &t.DurationMinutes, &t.Price, &t.Modality, &t.IsActive)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session type not found"})
+		return
+	}
+
+	input.apply(&t)
+	if err := validateSessionType(t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		UPDATE session_types SET name = ?, duration_minutes = ?, price = ?, modality = ?, is_active = ?
+		WHERE id = ?
+	`, t.Name, t.DurationMinutes, t.Price, t.Modality, t.IsActive, t.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update session type"})
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+// DeleteSessionType retires a type; it is kept for the bookings made with it
+func DeleteSessionType(c *gin.Context) {
+	res, err := database.DB.Exec(`
+		UPDATE session_types s JOIN psychologists p ON p.id = s.psychologist_id
+		SET s.is_active = FALSE
+		WHERE s.id = ? AND p.email = ?
+	`, c.Param("id"), c.Query("email"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete session type"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		database.DB.QueryRow(`
+			SELECT COUNT(*) FROM session_types s JOIN psychologists p ON p.id = s.psychologist_id
+			WHERE s.id = ? AND p.email = ?
+		`, c.Param("id"), c.Query("email")).Scan(&exists)
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session type not found"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session type retired"})
+}
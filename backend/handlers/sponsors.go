@@ -0,0 +1,800 @@
+package handlers
+
+import (
+	"counseling-webrtc/database"
+	"counseling-webrtc/models"
+	"counseling-webrtc/notify"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// SPONSORS (CORPORATE / EAP)
+// =============================================
+//
+// A sponsor covers priced sessions of its employees, up to
+// sessions_per_employee per client contact over the contract. Employees
+// are recognised by the sponsor's voucher code, entered when booking, or
+// by an email address in the sponsor's domain. Contacts aren't verified
+// otherwise, so a domain match only counts with a one-time code mailed to
+// that address (see SendSponsorEmailCode). A domain set next to a voucher
+// code also restricts the code to emails of that domain.
+//
+// A sponsored booking reserves one session of the quota, used on approval
+// like a package credit. Rejections and cancellations with enough notice
+// give it back; late cancellations are billed to the sponsor (forfeited).
+// Sponsors only ever see aggregates (see buildSponsorReport).
+
+// bookings.sponsor_status values
+const (
+	sponsorNone      = "none"
+	sponsorReserved  = "reserved"  // Pending booking holds a session of the quota
+	sponsorUsed      = "used"      // Approved
+	sponsorReleased  = "released"  // Rejected or cancelled with enough notice
+	sponsorForfeited = "forfeited" // Cancelled too late; still counts against the quota
+)
+
+const (
+	maxSessionsPerEmployee = 100
+	// Reports leave out every count, and categories, below this size
+	minReportGroup = 5
+
+	// Email verification codes
+	sponsorEmailCodeTTL      = 15 * time.Minute
+	sponsorEmailCodeResend   = time.Minute // A new code is mailed at most this often
+	sponsorEmailCodeAttempts = 5           // Wrong guesses before a code is void
+)
+
+var (
+	errSponsorNotFound = errors.New("code not found or not active")
+	errSponsorDomain   = errors.New("code is only valid with the sponsor's email domain")
+	errSponsorExpired  = errors.New("sponsorship has ended")
+	errSponsorQuota    = errors.New("sponsored sessions used up")
+	errSponsorEmail    = errors.New("email verification code is wrong or expired")
+)
+
+var (
+	voucherPattern = regexp.MustCompile(`^[A-Z0-9-]{4,40}$`)
+	domainPattern  = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)+$`)
+)
+
+const sponsorColumns = `id, name, IFNULL(voucher_code, ''), IFNULL(email_domain, ''), sessions_per_employee,
+	IFNULL(DATE_FORMAT(valid_until, '%Y-%m-%d'), ''), is_active, created_at`
+
+func scanSponsor(row interface{ Scan(...interface{}) error }) (models.Sponsor, error) {
+	var s models.Sponsor
+	err := row.Scan(&s.ID, &s.Name, &s.VoucherCode, &s.EmailDomain, &s.SessionsPerEmployee, &s.ValidUntil, &s.IsActive, &s.CreatedAt)
+	return s, err
+}
+
+func normalizeVoucher(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// emailDomain is the lower-cased domain of an email contact, "" for phones
+func emailDomain(contact string) string {
+	at := strings.LastIndex(contact, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(contact[at+1:]))
+}
+
+func validateSponsor(s models.Sponsor) error {
+	switch {
+	case strings.TrimSpace(s.Name) == "":
+		return errors.New("name is required")
+	case s.VoucherCode == "" && s.EmailDomain == "":
+		return errors.New("voucher_code or email_domain is required")
+	case s.VoucherCode != "" && !voucherPattern.MatchString(s.VoucherCode):
+		return errors.New("voucher_code must be 4-40 letters, digits or dashes")
+	case s.EmailDomain != "" && !domainPattern.MatchString(s.EmailDomain):
+		return errors.New("email_domain must be a domain such as example.co.id")
+	case s.SessionsPerEmployee < 1 || s.SessionsPerEmployee > maxSessionsPerEmployee:
+		return fmt.Errorf("sessions_per_employee must be between 1 and %d", maxSessionsPerEmployee)
+	}
+	if s.ValidUntil != "" {
+		if _, err := time.Parse("2006-01-02", s.ValidUntil); err != nil {
+			return errors.New("valid_until must be YYYY-MM-DD")
+		}
+	}
+	return nil
+}
+
+// findSponsor returns the active sponsor of code covering a session of
+// clientContact at start. The sponsor row is locked when db is a
+// transaction, so quota checks for it run one at a time.
+func findSponsor(db interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, clientContact, code string, start time.Time) (models.Sponsor, error) {
+	if code == "" {
+		return models.Sponsor{}, errSponsorNotFound
+	}
+	s, err := activeSponsor(db, "voucher_code", normalizeVoucher(code))
+	if err != nil {
+		return s, err
+	}
+	if s.EmailDomain != "" && s.EmailDomain != emailDomain(clientContact) {
+		return s, errSponsorDomain
+	}
+	return s, sponsorCovers(s, start)
+}
+
+// findDomainSponsor returns the active sponsor of clientContact's email
+// domain covering a session at start. Callers check the address first
+// (see checkSponsorEmailCode).
+func findDomainSponsor(db interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, clientContact string, start time.Time) (models.Sponsor, error) {
+	domain := emailDomain(clientContact)
+	if domain == "" {
+		return models.Sponsor{}, errSponsorNotFound
+	}
+	s, err := activeSponsor(db, "email_domain", domain)
+	if err != nil {
+		return s, err
+	}
+	return s, sponsorCovers(s, start)
+}
+
+// activeSponsor loads the active sponsor whose column (voucher_code or
+// email_domain) is value, locking it inside a transaction
+func activeSponsor(db interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, column, value string) (models.Sponsor, error) {
+	s, err := scanSponsor(db.QueryRow(`SELECT `+sponsorColumns+` FROM sponsors WHERE `+column+` = ? AND is_active = TRUE FOR UPDATE`, value))
+	if err == sql.ErrNoRows {
+		return s, errSponsorNotFound
+	}
+	return s, err
+}
+
+// sponsorCovers checks the contract of s still runs on the day of start
+func sponsorCovers(s models.Sponsor, start time.Time) error {
+	if s.ValidUntil != "" && start.In(sessionLocation).Format("2006-01-02") > s.ValidUntil {
+		return errSponsorExpired
+	}
+	return nil
+}
+
+// sponsoredSessions counts the sessions of a sponsor's quota clientContact
+// holds or has used
+func sponsoredSessions(db interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, sponsorID int, clientContact string) (int, error) {
+	var n int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM bookings
+		WHERE sponsor_id = ? AND client_contact = ? AND sponsor_status IN (?, ?, ?)
+	`, sponsorID, clientContact, sponsorReserved, sponsorUsed, sponsorForfeited).Scan(&n)
+	return n, err
+}
+
+// reserveSponsorship finds the sponsor of the voucher code, or else of the
+// verified email, entered for a new booking and checks the employee's
+// quota. The email code is used up with the booking. It returns 0 without
+// either code.
+func reserveSponsorship(tx *sql.Tx, clientContact, code, emailCode string, start time.Time) (int, error) {
+	var s models.Sponsor
+	var err error
+	switch {
+	case code != "":
+		s, err = findSponsor(tx, clientContact, code, start)
+	case emailCode != "":
+		if err = checkSponsorEmailCode(tx, clientContact, emailCode); err == nil {
+			s, err = findDomainSponsor(tx, clientContact, start)
+		}
+	default:
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	used, err := sponsoredSessions(tx, s.ID, clientContact)
+	if err == nil && used >= s.SessionsPerEmployee {
+		err = errSponsorQuota
+	}
+	if err != nil {
+		return 0, err
+	}
+	return s.ID, nil
+}
+
+// isSponsorRefusal tells the reasons a sponsor doesn't cover a session
+// apart from database errors
+func isSponsorRefusal(err error) bool {
+	return err == errSponsorNotFound || err == errSponsorDomain || err == errSponsorExpired || err == errSponsorQuota || err == errSponsorEmail
+}
+
+// consumeSponsorship marks the sponsored session of a booking as used (on
+// approval). Bookings without a sponsor are left alone.
+func consumeSponsorship(tx *sql.Tx, bookingID interface{}) error {
+	_, err := tx.Exec("UPDATE bookings SET sponsor_status = ? WHERE id = ? AND sponsor_status = ?", sponsorUsed, bookingID, sponsorReserved)
+	return err
+}
+
+// releaseSponsorship settles the sponsored session of a booking that was
+// rejected or cancelled. giveBack returns a used session to the quota;
+// otherwise it stays billed to the sponsor.
+func releaseSponsorship(tx *sql.Tx, bookingID interface{}, giveBack bool) error {
+	used := sponsorForfeited
+	if giveBack {
+		used = sponsorReleased
+	}
+	_, err := tx.Exec(`
+		UPDATE bookings SET sponsor_status = CASE sponsor_status WHEN ? THEN ? ELSE ? END
+		WHERE id = ? AND sponsor_status IN (?, ?)
+	`, sponsorReserved, sponsorReleased, used, bookingID, sponsorReserved, sponsorUsed)
+	return err
+}
+
+// GetSponsorship tells a client whether a voucher code, or the email code
+// mailed to them, would cover their next session, before booking. The
+// email code is checked, not used up. Anyone can ask about any email, so
+// the answer is only yes or no: no sponsor name, usage or quota.
+func GetSponsorship(c *gin.Context) {
+	email, code, emailCode := strings.TrimSpace(c.Query("email")), strings.TrimSpace(c.Query("code")), strings.TrimSpace(c.Query("email_code"))
+	if email == "" || (code == "" && emailCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email and code or email_code are required"})
+		return
+	}
+
+	var s models.Sponsor
+	var err error
+	if code != "" {
+		s, err = findSponsor(database.DB, email, code, time.Now())
+	} else if err = checkSponsorEmailCode(nil, email, emailCode); err == nil {
+		s, err = findDomainSponsor(database.DB, email, time.Now())
+	}
+	if err == nil {
+		var used int
+		used, err = sponsoredSessions(database.DB, s.ID, email)
+		if err == nil && used >= s.SessionsPerEmployee {
+			err = errSponsorQuota
+		}
+	}
+	if err != nil && !isSponsorRefusal(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"eligible": err == nil})
+}
+
+// =============================================
+// SPONSORED EMAIL VERIFICATION
+// =============================================
+//
+// An employee without a voucher code asks for a one-time code, mailed to
+// their address if its domain belongs to a sponsor, and enters it when
+// booking. One code per address; only its hash is stored.
+
+// SendSponsorEmailCode mails a verification code to an address of a
+// sponsored domain. The answer is the same whether or not the domain is
+// sponsored, so it can't be used to find out which companies are.
+func SendSponsorEmailCode(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+	if !strings.Contains(email, "@") || emailDomain(email) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email must be an email address"})
+		return
+	}
+	sent := gin.H{"message": "Jika domain email Anda terdaftar sebagai sponsor, kode verifikasi telah dikirim"}
+
+	if _, err := findDomainSponsor(database.DB, email, time.Now()); err != nil {
+		if !isSponsorRefusal(err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, sent)
+		return
+	}
+
+	code, err := newSponsorEmailCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Replaces the previous code, unless that one was only just sent
+	now := time.Now().UTC().Truncate(time.Second)
+	res, err := tx.Exec(`
+		INSERT INTO sponsor_email_codes (email, code_hash, attempts, expires_at, created_at) VALUES (?, ?, 0, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			code_hash = IF(created_at > ?, code_hash, VALUES(code_hash)),
+			attempts = IF(created_at > ?, attempts, 0),
+			expires_at = IF(created_at > ?, expires_at, VALUES(expires_at)),
+			created_at = IF(created_at > ?, created_at, VALUES(created_at))
+	`, email, endpointHash(code), now.Add(sponsorEmailCodeTTL), now,
+		now.Add(-sponsorEmailCodeResend), now.Add(-sponsorEmailCodeResend), now.Add(-sponsorEmailCodeResend), now.Add(-sponsorEmailCodeResend))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusOK, sent)
+		return
+	}
+
+	// Email only: the code proves the client reads this mailbox
+	err = enqueueOutbox(tx, 0, outboxNotify, outboxNotification{Email: email, Channel: notify.ChannelEmail, Message: gin.H{
+		"type":            "sponsor_email_code",
+		"message":         "Kode verifikasi sponsor Anda: " + code,
+		"code":            code,
+		"expires_minutes": int(sponsorEmailCodeTTL / time.Minute),
+	}})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+	kickOutbox()
+	log.Printf("[SPONSOR] Verification code sent to an address of %s", emailDomain(email))
+
+	c.JSON(http.StatusOK, sent)
+}
+
+// newSponsorEmailCode is a random six-digit code
+func newSponsorEmailCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// checkSponsorEmailCode checks the code mailed to email. Inside a booking
+// transaction the code is used up; with a nil tx it is only checked. Wrong
+// guesses are counted outside tx, so a failed booking still counts them.
+func checkSponsorEmailCode(tx *sql.Tx, email, code string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	var hash string
+	var attempts int
+	var expiresAt time.Time
+	err := database.DB.QueryRow("SELECT code_hash, attempts, expires_at FROM sponsor_email_codes WHERE email = ?", email).
+		Scan(&hash, &attempts, &expiresAt)
+	if err == sql.ErrNoRows {
+		return errSponsorEmail
+	}
+	if err != nil {
+		return err
+	}
+	if attempts >= sponsorEmailCodeAttempts || time.Now().After(expiresAt) {
+		return errSponsorEmail
+	}
+	if subtle.ConstantTimeCompare([]byte(endpointHash(strings.TrimSpace(code))), []byte(hash)) != 1 {
+		database.DB.Exec("UPDATE sponsor_email_codes SET attempts = attempts + 1 WHERE email = ?", email)
+		return errSponsorEmail
+	}
+	if tx == nil {
+		return nil
+	}
+
+	// Two bookings racing for one code: only one deletes it
+	res, err := tx.Exec("DELETE FROM sponsor_email_codes WHERE email = ? AND code_hash = ?", email, hash)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return errSponsorEmail
+	}
+	return nil
+}
+
+// =============================================
+// ADMIN: MANAGE SPONSORS
+// =============================================
+
+// newSponsorToken is a secret for GET /api/sponsor/report. Only its hash
+// is stored.
+func newSponsorToken() (string, error) {
+	token, err := newCalendarToken()
+	return "spr_" + token, err
+}
+
+// ListSponsors lists all sponsors (report tokens are not returned)
+func ListSponsors(c *gin.Context) {
+	rows, err := database.DB.Query(`SELECT ` + sponsorColumns + ` FROM sponsors ORDER BY name, id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	sponsors := []models.Sponsor{}
+	for rows.Next() {
+		s, err := scanSponsor(rows)
+		if err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		sponsors = append(sponsors, s)
+	}
+
+	c.JSON(http.StatusOK, sponsors)
+}
+
+type sponsorInput struct {
+	Name                *string `json:"name"`
+	VoucherCode         *string `json:"voucher_code"`
+	EmailDomain         *string `json:"email_domain"`
+	SessionsPerEmployee *int    `json:"sessions_per_employee"`
+	ValidUntil          *string `json:"valid_until"` // "" clears
+	IsActive            *bool   `json:"is_active"`
+}
+
+// apply copies the fields that were sent onto s
+func (in sponsorInput) apply(s *models.Sponsor) {
+	if in.Name != nil {
+		s.Name = strings.TrimSpace(*in.Name)
+	}
+	if in.VoucherCode != nil {
+		s.VoucherCode = normalizeVoucher(*in.VoucherCode)
+	}
+	if in.EmailDomain != nil {
+		s.EmailDomain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(*in.EmailDomain)), "@")
+	}
+	if in.SessionsPerEmployee != nil {
+		s.SessionsPerEmployee = *in.SessionsPerEmployee
+	}
+	if in.ValidUntil != nil {
+		s.ValidUntil = strings.TrimSpace(*in.ValidUntil)
+	}
+	if in.IsActive != nil {
+		s.IsActive = *in.IsActive
+	}
+}
+
+// sponsorConflict reports whether another sponsor already uses the code
+// or domain of s
+func sponsorConflict(s models.Sponsor) bool {
+	var n int
+	database.DB.QueryRow(`
+		SELECT COUNT(*) FROM sponsors
+		WHERE id <> ? AND ((voucher_code IS NOT NULL AND voucher_code = ?) OR (email_domain IS NOT NULL AND email_domain = ?))
+	`, s.ID, s.VoucherCode, s.EmailDomain).Scan(&n)
+	return n > 0
+}
+
+// CreateSponsor adds a sponsor. The report token is generated here and
+// only returned once.
+func CreateSponsor(c *gin.Context) {
+	var input sponsorInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s := models.Sponsor{IsActive: true}
+	input.apply(&s)
+	if err := validateSponsor(s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if sponsorConflict(s) {
+		c.JSON(http.StatusConflict, gin.H{"error": "voucher_code or email_domain is already used by another sponsor"})
+		return
+	}
+	token, err := newSponsorToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sponsor"})
+		return
+	}
+
+	s.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	res, err := database.DB.Exec(`
+		INSERT INTO sponsors (name, voucher_code, email_domain, sessions_per_employee, valid_until, is_active, report_token_hash, created_at)
+		VALUES (?, NULLIF(?, ''), NULLIF(?, ''), ?, NULLIF(?, ''), ?, ?, ?)
+	`, s.Name, s.VoucherCode, s.EmailDomain, s.SessionsPerEmployee, s.ValidUntil, s.IsActive, endpointHash(token), s.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sponsor: " + err.Error()})
+		return
+	}
+	id, _ := res.LastInsertId()
+	s.ID = int(id)
+	s.ReportToken = token
+
+	c.JSON(http.StatusOK, s)
+}
+
+// UpdateSponsor changes the fields sent. A lower quota doesn't touch
+// bookings already made.
+func UpdateSponsor(c *gin.Context) {
+	var input sponsorInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s, err := scanSponsor(database.DB.QueryRow(`SELECT `+sponsorColumns+` FROM sponsors WHERE id = ?`, c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sponsor not found"})
+		return
+	}
+	input.apply(&s)
+	if err := validateSponsor(s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if sponsorConflict(s) {
+		c.JSON(http.StatusConflict, gin.H{"error": "voucher_code or email_domain is already used by another sponsor"})
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		UPDATE sponsors SET name = ?, voucher_code = NULLIF(?, ''), email_domain = NULLIF(?, ''),
+			sessions_per_employee = ?, valid_until = NULLIF(?, ''), is_active = ?
+		WHERE id = ?
+	`, s.Name, s.VoucherCode, s.EmailDomain, s.SessionsPerEmployee, s.ValidUntil, s.IsActive, s.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update sponsor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, s)
+}
+
+// RotateSponsorReportToken replaces (revokes) a sponsor's report token
+func RotateSponsorReportToken(c *gin.Context) {
+	token, err := newSponsorToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	res, err := database.DB.Exec("UPDATE sponsors SET report_token_hash = ? WHERE id = ?", endpointHash(token), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sponsor not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report_token": token})
+}
+
+// =============================================
+// SPONSOR REPORTS
+// =============================================
+//
+// Reports count billable sessions (approved, or cancelled too late) by
+// session month and category, over whole months only. They never list
+// bookings, names or contacts, and every count below minReportGroup is
+// left out so small groups can't be singled out: a month with fewer
+// employees shows nothing, and a total hides what any of its months hide,
+// so it can't be subtracted back out.
+
+type sponsorUsage struct {
+	Sessions          *int `json:"sessions"` // Counts are null below minReportGroup
+	Completed         *int `json:"completed"`
+	LateCancellations *int `json:"late_cancellations"`
+	Amount            *int `json:"amount"` // Rupiah billed to the sponsor; null with sessions
+	Employees         *int `json:"employees"`
+}
+
+type sponsorMonth struct {
+	Month string `json:"month"` // YYYY-MM
+	sponsorUsage
+}
+
+type sponsorCategory struct {
+	Category string `json:"category"`
+	Sessions int    `json:"sessions"`
+}
+
+type sponsorReport struct {
+	Sponsor             string            `json:"sponsor"`
+	SessionsPerEmployee int               `json:"sessions_per_employee"`
+	From                string            `json:"from,omitempty"` // YYYY-MM
+	To                  string            `json:"to,omitempty"`
+	Pending             *int              `json:"pending"` // Sessions booked, not yet approved
+	Totals              sponsorUsage      `json:"totals"`
+	Months              []sponsorMonth    `json:"months"`
+	Categories          []sponsorCategory `json:"categories"`
+	OtherCategories     *int              `json:"other_categories"` // Sessions in categories too small to show
+	MinGroupSize        int               `json:"min_group_size"`
+}
+
+// suppressed hides counts below minReportGroup
+func suppressed(n int) *int {
+	if n < minReportGroup {
+		return nil
+	}
+	return &n
+}
+
+// scanSponsorUsage reads the usage columns of a report query. A group of
+// fewer than minReportGroup employees shows nothing at all.
+func scanSponsorUsage(row interface{ Scan(...interface{}) error }, dest ...interface{}) (sponsorUsage, error) {
+	var sessions, completed, late, amount, employees int
+	if err := row.Scan(append(dest, &sessions, &completed, &late, &amount, &employees)...); err != nil {
+		return sponsorUsage{}, err
+	}
+	if employees < minReportGroup {
+		return sponsorUsage{}, nil
+	}
+	u := sponsorUsage{
+		Sessions:          suppressed(sessions),
+		Completed:         suppressed(completed),
+		LateCancellations: suppressed(late),
+		Employees:         &employees,
+	}
+	if u.Sessions != nil {
+		u.Amount = &amount
+	}
+	return u, nil
+}
+
+// hideWith hides the counts of u that m hides
+func (u *sponsorUsage) hideWith(m sponsorUsage) {
+	for _, f := range []struct{ total, month **int }{
+		{&u.Sessions, &m.Sessions},
+		{&u.Completed, &m.Completed},
+		{&u.LateCancellations, &m.LateCancellations},
+		{&u.Amount, &m.Amount},
+		{&u.Employees, &m.Employees},
+	} {
+		if *f.month == nil {
+			*f.total = nil
+		}
+	}
+}
+
+// buildSponsorReport aggregates a sponsor's sessions scheduled in the
+// months from to to (inclusive YYYY-MM, WIB; empty for open ends)
+func buildSponsorReport(s models.Sponsor, from, to string) (sponsorReport, error) {
+	r := sponsorReport{
+		Sponsor:             s.Name,
+		SessionsPerEmployee: s.SessionsPerEmployee,
+		From:                from,
+		To:                  to,
+		Months:              []sponsorMonth{},
+		Categories:          []sponsorCategory{},
+		MinGroupSize:        minReportGroup,
+	}
+
+	// schedule_time is wall-clock WIB
+	where := " WHERE b.sponsor_id = ?"
+	args := []interface{}{s.ID}
+	if from != "" {
+		month, _ := time.Parse("2006-01", from)
+		where += " AND b.schedule_time >= ?"
+		args = append(args, month.Format(wallClockFormat))
+	}
+	if to != "" {
+		month, _ := time.Parse("2006-01", to)
+		where += " AND b.schedule_time < ?"
+		args = append(args, month.AddDate(0, 1, 0).Format(wallClockFormat))
+	}
+	billable := where + " AND b.sponsor_status IN ('" + sponsorUsed + "', '" + sponsorForfeited + "')"
+
+	var pending int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM bookings b`+where+" AND b.sponsor_status = ?", append(args, sponsorReserved)...).
+		Scan(&pending); err != nil {
+		return r, err
+	}
+	r.Pending = suppressed(pending)
+
+	const usage = `COUNT(*), IFNULL(SUM(b.status = 'completed'), 0), IFNULL(SUM(b.sponsor_status = '` + sponsorForfeited + `'), 0),
+		IFNULL(SUM(b.price), 0), COUNT(DISTINCT b.client_contact)`
+	var err error
+	if r.Totals, err = scanSponsorUsage(database.DB.QueryRow(`SELECT `+usage+` FROM bookings b`+billable, args...)); err != nil {
+		return r, err
+	}
+
+	rows, err := database.DB.Query(`SELECT DATE_FORMAT(b.schedule_time, '%Y-%m') AS month, `+usage+` FROM bookings b`+billable+
+		" GROUP BY month ORDER BY month", args...)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m sponsorMonth
+		if m.sponsorUsage, err = scanSponsorUsage(rows, &m.Month); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		r.Totals.hideWith(m.sponsorUsage)
+		r.Months = append(r.Months, m)
+	}
+
+	catRows, err := database.DB.Query(`SELECT cat.name, COUNT(*), COUNT(DISTINCT b.client_contact)
+		FROM bookings b JOIN categories cat ON cat.id = b.category_id`+billable+
+		" GROUP BY cat.id, cat.name ORDER BY COUNT(*) DESC, cat.name", args...)
+	if err != nil {
+		return r, err
+	}
+	defer catRows.Close()
+	other := 0
+	for catRows.Next() {
+		var cat sponsorCategory
+		var employees int
+		if err := catRows.Scan(&cat.Category, &cat.Sessions, &employees); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		if employees < minReportGroup {
+			other += cat.Sessions
+			continue
+		}
+		r.Categories = append(r.Categories, cat)
+	}
+	r.OtherCategories = suppressed(other)
+	if other > 0 && r.OtherCategories == nil {
+		// Total sessions minus the categories shown would give it away
+		r.Totals.Sessions, r.Totals.Amount = nil, nil
+	}
+
+	return r, nil
+}
+
+// writeSponsorReport answers with the report of s for ?from=&to=
+// (YYYY-MM)
+func writeSponsorReport(c *gin.Context, s models.Sponsor) {
+	from, to := c.Query("from"), c.Query("to")
+	for _, m := range []string{from, to} {
+		if _, err := time.Parse("2006-01", m); m != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be whole months (YYYY-MM)"})
+			return
+		}
+	}
+
+	r, err := buildSponsorReport(s, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// GetSponsorReportAdmin is the report of any sponsor, for admins
+func GetSponsorReportAdmin(c *gin.Context) {
+	s, err := scanSponsor(database.DB.QueryRow(`SELECT `+sponsorColumns+` FROM sponsors WHERE id = ?`, c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sponsor not found"})
+		return
+	}
+	writeSponsorReport(c, s)
+}
+
+// GetSponsorReport is the report of the sponsor whose token is sent as
+// X-Sponsor-Token
+func GetSponsorReport(c *gin.Context) {
+	token := c.GetHeader("X-Sponsor-Token")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing sponsor token"})
+		return
+	}
+	s, err := scanSponsor(database.DB.QueryRow(`SELECT `+sponsorColumns+` FROM sponsors WHERE report_token_hash = ?`, endpointHash(token)))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid sponsor token"})
+		return
+	}
+	writeSponsorReport(c, s)
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// SERVER-SENT EVENTS FALLBACK
+// =============================================
+//
+// GET /api/notify/stream carries the same notification frames as the
+// /api/notify WebSocket for networks that block upgrades. Every event has
+// the inbox id as its SSE id, so EventSource resumes from the inbox with
+// Last-Event-ID after a reconnect.
+
+// Comment line sent when idle so proxies don't close the stream
+const ssePingPeriod = 25 * time.Second
+
+// Reconnect delay suggested to EventSource (milliseconds)
+const sseRetry = 3000
+
+// sseClient is a notifyConn writing to an event stream. Like wsClient it
+// buffers frames and drops the connection when the buffer is full.
+type sseClient struct {
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSSEClient() *sseClient {
+	// Room for a full inbox replay on top of live traffic
+	return &sseClient{
+		send: make(chan []byte, sendBufferSize+maxInboxItems),
+		done: make(chan struct{}),
+	}
+}
+
+func (c *sseClient) enqueue(v interface{}) bool {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[SSE] Failed to marshal message: %v", err)
+		return false
+	}
+	return c.enqueueRaw(payload)
+}
+
+func (c *sseClient) enqueueRaw(payload []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	case c.send <- payload:
+		return true
+	default:
+		log.Printf("[SSE] Send buffer full, dropping connection")
+		c.close()
+		return false
+	}
+}
+
+func (c *sseClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// writeSSEEvent writes one frame as a "message" event, using its
+// notification_id (if any) as the event id
+func writeSSEEvent(w http.ResponseWriter, payload []byte) error {
+	var frame struct {
+		ID int64 `json:"notification_id"`
+	}
+	json.Unmarshal(payload, &frame)
+
+	if frame.ID > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", frame.ID); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", payload)
+	return err
+}
+
+// NotificationStreamHandler is the SSE equivalent of NotificationHandler
+func NotificationStreamHandler(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+
+	// EventSource sends Last-Event-ID on reconnect; allow a query param for
+	// the first connection of a page that remembers where it was
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	var lastID int64
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || id < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+		lastID = id
+	}
+
+	w := c.Writer
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: don't buffer the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	if err := rc.Flush(); err != nil {
+		log.Println("[SSE] Streaming not supported:", err)
+		return
+	}
+
+	client := newSSEClient()
+	notifManager.add(email, client)
+	defer func() {
+		notifManager.remove(email, client)
+		client.close()
+	}()
+
+	if lastEventID != "" {
+		replaySince(email, lastID, client)
+	} else {
+		replayUndelivered(email, client)
+	}
+
+	ticker := time.NewTicker(ssePingPeriod)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		var written []byte
+		select {
+		case payload := <-client.send:
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			err = writeSSEEvent(w, payload)
+			written = payload
+		case <-ticker.C:
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case <-client.done:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			log.Printf("[SSE] Write error for '%s': %v", email, err)
+			return
+		}
+		if written != nil {
+			markFrameDelivered(written)
+		}
+	}
+}
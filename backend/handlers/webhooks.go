@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"bytes"
+	"counseling-webrtc/database"
+	"counseling-webrtc/models"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================
+// OUTBOUND WEBHOOKS
+// =============================================
+//
+// Booking lifecycle events are queued as one webhook_deliveries row per
+// matching subscription and POSTed by a background worker. Every request
+// carries X-Webhook-Signature: sha256=HMAC(secret, "<timestamp>.<body>").
+// Failed deliveries are retried with exponential backoff and can be
+// replayed by an admin.
+
+// Booking lifecycle events
+const (
+	EventBookingCreated      = "booking.created"
+	EventBookingApproved     = "booking.approved"
+	EventBookingRejected     = "booking.rejected"
+	EventBookingCompleted    = "booking.completed"
+	EventBookingNotesUpdated = "booking.notes_updated"
+	EventBookingPaid         = "booking.paid"
+	EventBookingCancelled    = "booking.cancelled"
+	EventBookingRefunded     = "booking.refunded"
+	EventBookingRiskFlagged  = "booking.risk_flagged" // Crisis screening flagged the booking at creation
+)
+
+var webhookEvents = map[string]bool{
+	EventBookingCreated:      true,
+	EventBookingApproved:     true,
+	EventBookingRejected:     true,
+	EventBookingCompleted:    true,
+	EventBookingNotesUpdated: true,
+	EventBookingPaid:         true,
+	EventBookingCancelled:    true,
+	EventBookingRefunded:     true,
+	EventBookingRiskFlagged:  true,
+}
+
+const (
+	// How often due deliveries are checked
+	webhookPollInterval = 5 * time.Second
+	// A claimed delivery is retried by anyone after this (worker crashed mid-request)
+	webhookLease   = 5 * time.Minute
+	webhookTimeout = 10 * time.Second
+	// Attempts before a delivery is marked failed
+	webhookMaxAttempts = 8
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+	// Deliveries claimed per poll
+	webhookBatchSize = 20
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// webhookBackoff returns the delay before retry number attempt (1-based):
+// 30s, 1m, 2m, 4m, ... capped at 6h, with up to 20% jitter
+func webhookBackoff(attempt int) time.Duration {
+	d := webhookBaseBackoff << uint(attempt-1)
+	if d <= 0 || d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+	return d + time.Duration(mathrand.Int63n(int64(d)/5+1))
+}
+
+// signWebhook returns the X-Webhook-Signature value for body
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookPayload is the JSON body POSTed to subscribers
+type webhookPayload struct {
+	ID        string         `json:"id"` // Same for every attempt/replay of an event; dedupe on it
+	Event     string         `json:"event"`
+	CreatedAt time.Time      `json:"created_at"`
+	Data      models.Booking `json:"data"`
+}
+
+// bookingSnapshot loads the booking as sent in webhook payloads
+func bookingSnapshot(bookingID interface{}) (models.Booking, error) {
+	var b models.Booking
+	var complaint, notes, roomID, reason sql.NullString
+	err := database.DB.QueryRow(`
+		SELECT b.id, b.client_name, IFNULL(b.client_contact, ''), b.category_id, IFNULL(cat.name, ''), b.complaint,
+			b.psychologist_id, IFNULL(p.name, ''), DATE_FORMAT(b.schedule_time, '%Y-%m-%dT%H:%i:%s'), b.status,
+			b.room_id, b.session_notes, b.rejection_reason, IFNULL(DATE_FORMAT(b.created_at, '%Y-%m-%dT%H:%i:%s'), ''),
+			b.duration_minutes, b.price, b.modality, b.payment_status, b.risk_level
+		FROM bookings b
+		LEFT JOIN psychologists p ON b.psychologist_id = p.id
+		LEFT JOIN categories cat ON b.category_id = cat.id
+		WHERE b.id = ?
+	`, bookingID).Scan(&b.ID, &b.ClientName, &b.ClientContact, &b.CategoryID, &b.CategoryName, &complaint,
+		&b.PsychologistID, &b.PsychologistName, &b.ScheduleTime, &b.Status,
+		&roomID, &notes, &reason, &b.CreatedAt,
+		&b.DurationMinutes, &b.Price, &b.Modality, &b.PaymentStatus, &b.RiskLevel)
+	b.Complaint = complaint.String
+	b.RoomID = roomID.String
+	b.SessionNotes = notes.String
+	b.RejectionReason = reason.String
+	return b, err
+}
+
+// emitWebhookEvent queues event for every active subscription listening
+// to it. eventID identifies the event to receivers; emitting the same
+// eventID twice (outbox retry) queues it only once per subscription.
+func emitWebhookEvent(event string, bookingID interface{}, eventID string) error {
+	booking, err := bookingSnapshot(bookingID)
+	if err != nil {
+		return fmt.Errorf("load booking %v: %w", bookingID, err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	payload, err := json.Marshal(webhookPayload{ID: eventID, Event: event, CreatedAt: now, Data: booking})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT IGNORE INTO webhook_deliveries (subscription_id, event_id, event, payload, status, attempts, next_attempt_at, created_at)
+		SELECT id, ?, ?, ?, 'pending', 0, ?, ?
+		FROM webhook_subscriptions
+		WHERE is_active = TRUE AND (events = '*' OR FIND_IN_SET(?, events) > 0)
+	`, eventID, event, string(payload), now, now, event)
+	if err != nil {
+		return fmt.Errorf("queue %s for booking %v: %w", event, bookingID, err)
+	}
+	return nil
+}
+
+// StartWebhookWorker starts the delivery loop. Call it once at startup.
+func StartWebhookWorker() {
+	go func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+		for {
+			deliverDueWebhooks()
+			<-ticker.C
+		}
+	}()
+}
+
+// dueDelivery is a claimed delivery with its subscription
+type dueDelivery struct {
+	ID       int64
+	EventID  string
+	Event    string
+	Payload  string
+	Attempts int
+	URL      string
+	Secret   string
+}
+
+// deliverDueWebhooks claims and sends the deliveries whose time has come.
+// Claiming pushes next_attempt_at past the lease, so other instances (and
+// the next poll) skip rows that are in flight.
+func deliverDueWebhooks() {
+	now := time.Now().UTC().Truncate(time.Second)
+	rows, err := database.DB.Query(fmt.Sprintf(`
+		SELECT d.id, d.event_id, d.event, d.payload, d.attempts, d.next_attempt_at, s.url, s.secret
+		FROM webhook_deliveries d
+		JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at ASC
+		LIMIT %d
+	`, webhookBatchSize), now)
+	if err != nil {
+		log.Printf("[WEBHOOK] Failed to load due deliveries: %v", err)
+		return
+	}
+
+	type candidate struct {
+		dueDelivery
+		NextAttemptAt time.Time
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var d candidate
+		if err := rows.Scan(&d.ID, &d.EventID, &d.Event, &d.Payload, &d.Attempts, &d.NextAttemptAt, &d.URL, &d.Secret); err != nil {
+			continue
+		}
+		candidates = append(candidates, d)
+	}
+	rows.Close()
+
+	for _, d := range candidates {
+		res, err := database.DB.Exec(`
+			UPDATE webhook_deliveries SET next_attempt_at = ?
+			WHERE id = ? AND status = 'pending' AND next_attempt_at = ?
+		`, now.Add(webhookLease), d.ID, d.NextAttemptAt)
+		if err != nil {
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			sendWebhook(d.dueDelivery)
+		}
+	}
+}
+
+// sendWebhook POSTs one delivery and records the outcome
+func sendWebhook(d dueDelivery) {
+	attempt := d.Attempts + 1
+	statusCode, err := postWebhook(d)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	if err == nil {
+		database.DB.Exec(`
+			UPDATE webhook_deliveries
+			SET status = 'delivered', attempts = ?, last_status_code = ?, last_error = NULL, delivered_at = ?, next_attempt_at = NULL
+			WHERE id = ?
+		`, attempt, statusCode, now, d.ID)
+		log.Printf("[WEBHOOK] Delivered %s (%s) to %s", d.Event, d.EventID, d.URL)
+		return
+	}
+
+	status := "pending"
+	var next interface{} = now.Add(webhookBackoff(attempt))
+	if attempt >= webhookMaxAttempts {
+		status, next = "failed", nil
+	}
+	database.DB.Exec(`
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, last_status_code = ?, last_error = ?, next_attempt_at = ?
+		WHERE id = ?
+	`, status, attempt, statusCode, err.Error(), next, d.ID)
+	log.Printf("[WEBHOOK] Attempt %d of %s to %s failed: %v", attempt, d.Event, d.URL, err)
+}
+
+// postWebhook sends the request. Any 2xx response counts as delivered.
+func postWebhook(d dueDelivery) (int, error) {
+	body := []byte(d.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SafeSpace-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Id", d.EventID)
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", signWebhook(d.Secret, timestamp, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// ===== ADMIN API =====
+
+// parseWebhookEvents validates a list of event names ("*" = all)
+func parseWebhookEvents(events []string) (string, error) {
+	if len(events) == 0 {
+		return "*", nil
+	}
+	for _, e := range events {
+		if e == "*" {
+			return "*", nil
+		}
+		if !webhookEvents[e] {
+			return "", fmt.Errorf("Unknown event: %s", e)
+		}
+	}
+	return strings.Join(events, ","), nil
+}
+
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// ListWebhooks lists all webhook subscriptions (secrets are not returned)
+func ListWebhooks(c *gin.Context) {
+	rows, err := database.DB.Query("SELECT id, url, events, IFNULL(description, ''), is_active, created_at FROM webhook_subscriptions ORDER BY id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	subs := []models.WebhookSubscription{}
+	for rows.Next() {
+		var s models.WebhookSubscription
+		var events string
+		if err := rows.Scan(&s.ID, &s.URL, &events, &s.Description, &s.IsActive, &s.CreatedAt); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		s.Events = strings.Split(events, ",")
+		subs = append(subs, s)
+	}
+
+	c.JSON(http.StatusOK, subs)
+}
+
+// CreateWebhook adds a subscription. The signing secret is generated unless
+// given and only returned here.
+func CreateWebhook(c *gin.Context) {
+	var input struct {
+		URL         string   `json:"url" binding:"required"`
+		Events      []string `json:"events"`
+		Secret      string   `json:"secret"`
+		Description string   `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validWebhookURL(input.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http(s) URL"})
+		return
+	}
+	events, err := parseWebhookEvents(input.Events)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Secret == "" {
+		input.Secret = newWebhookSecret()
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	res, err := database.DB.Exec(`
+		INSERT INTO webhook_subscriptions (url, secret, events, description, is_active, created_at)
+		VALUES (?, ?, ?, ?, TRUE, ?)
+	`, input.URL, input.Secret, events, input.Description, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook: " + err.Error()})
+		return
+	}
+	id, _ := res.LastInsertId()
+
+	c.JSON(http.StatusOK, models.WebhookSubscription{
+		ID:          int(id),
+		URL:         input.URL,
+		Secret:      input.Secret,
+		Events:      strings.Split(events, ","),
+		Description: input.Description,
+		IsActive:    true,
+		CreatedAt:   now,
+	})
+}
+
+// UpdateWebhook changes the URL, events, description or active flag of a
+// subscription
+func UpdateWebhook(c *gin.Context) {
+	id := c.Param("id")
+	var input struct {
+		URL         *string  `json:"url"`
+		Events      []string `json:"events"`
+		Description *string  `json:"description"`
+		IsActive    *bool    `json:"is_active"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	if input.URL != nil {
+		if !validWebhookURL(*input.URL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http(s) URL"})
+			return
+		}
+		sets, args = append(sets, "url = ?"), append(args, *input.URL)
+	}
+	if input.Events != nil {
+		events, err := parseWebhookEvents(input.Events)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sets, args = append(sets, "events = ?"), append(args, events)
+	}
+	if input.Description != nil {
+		sets, args = append(sets, "description = ?"), append(args, *input.Description)
+	}
+	if input.IsActive != nil {
+		sets, args = append(sets, "is_active = ?"), append(args, *input.IsActive)
+	}
+	if len(sets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	res, err := database.DB.Exec("UPDATE webhook_subscriptions SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(args, id)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		if database.DB.QueryRow("SELECT id FROM webhook_subscriptions WHERE id = ?", id).Scan(&exists) != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook updated"})
+}
+
+// DeleteWebhook removes a subscription and its delivery log
+func DeleteWebhook(c *gin.Context) {
+	res, err := database.DB.Exec("DELETE FROM webhook_subscriptions WHERE id = ?", c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Delete failed"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// GetWebhookDeliveries returns the delivery log of a subscription (newest
+// first, optionally filtered with ?status=)
+func GetWebhookDeliveries(c *gin.Context) {
+	query := `
+		SELECT id, subscription_id, event_id, event, payload, status, attempts, next_attempt_at,
+			IFNULL(last_status_code, 0), IFNULL(last_error, ''), created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE subscription_id = ?`
+	args := []interface{}{c.Param("id")}
+	if status := c.Query("status"); status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT 100"
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var payload string
+		var next, delivered sql.NullTime
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.Event, &payload, &d.Status, &d.Attempts, &next,
+			&d.LastStatusCode, &d.LastError, &d.CreatedAt, &delivered); err != nil {
+			fmt.Println("Scan error:", err)
+			continue
+		}
+		d.Payload = json.RawMessage(payload)
+		if next.Valid {
+			d.NextAttemptAt = &next.Time
+		}
+		if delivered.Valid {
+			d.DeliveredAt = &delivered.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// ReplayWebhookDelivery queues a delivery (failed or already delivered)
+// to be sent again right away with the same event id
+func ReplayWebhookDelivery(c *gin.Context) {
+	res, err := database.DB.Exec(`
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = ?, last_error = NULL
+		WHERE id = ?
+	`, time.Now().UTC().Truncate(time.Second), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Replay failed"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Delivery queued for replay"})
+}
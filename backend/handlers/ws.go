@@ -1,38 +1,71 @@
 package handlers
 
 import (
+	"counseling-webrtc/notify"
 	"encoding/json"
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
+// Message is a single signaling frame (see protocol.go for the schema)
 type Message struct {
 	Type string          `json:"type"`
-	Data json.RawMessage `json:"data"`
+	Data json.RawMessage `json:"data,omitempty"`
 }
 
-// RoomManager handles the state of chat rooms (Signaling)
-type RoomManager struct {
-	rooms map[string]map[*websocket.Conn]bool
-	mutex sync.Mutex
+// notifyConn is a live notification stream: a WebSocket (wsClient) or an
+// SSE response (sseClient). Both queue frames without blocking.
+type notifyConn interface {
+	enqueue(v interface{}) bool
+	enqueueRaw(payload []byte) bool
 }
 
-var manager = RoomManager{
-	rooms: make(map[string]map[*websocket.Conn]bool),
-}
-
-// NotificationManager handles user-specific notifications
+// NotificationManager handles user-specific notifications. A user may be
+// connected from several devices/tabs at once; every connection gets its
+// own writer goroutine so the lock is never held during writes.
 type NotificationManager struct {
-	clients map[string]*websocket.Conn // Map email -> connection
+	clients map[string]map[notifyConn]bool // Map email -> connections
 	mutex   sync.Mutex
 }
 
 var notifManager = NotificationManager{
-	clients: make(map[string]*websocket.Conn),
+	clients: make(map[string]map[notifyConn]bool),
+}
+
+// add registers a connection for email
+func (m *NotificationManager) add(email string, c notifyConn) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.clients[email] == nil {
+		m.clients[email] = make(map[notifyConn]bool)
+	}
+	m.clients[email][c] = true
+}
+
+// remove unregisters a single connection of email
+func (m *NotificationManager) remove(email string, c notifyConn) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.clients[email], c)
+	if len(m.clients[email]) == 0 {
+		delete(m.clients, email)
+	}
+}
+
+// connections returns a snapshot of the connections of email
+func (m *NotificationManager) connections(email string) []notifyConn {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	conns := make([]notifyConn, 0, len(m.clients[email]))
+	for c := range m.clients[email] {
+		conns = append(conns, c)
+	}
+	return conns
 }
 
 var upgrader = websocket.Upgrader{
@@ -41,31 +74,84 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// SendNotification sends a message to a specific user by email
+// SendNotification stores a notification in the user's inbox and pushes it
+// to whichever instance they are connected to, then hands it to the other
+// channels (email, ...) the user has enabled. Users that are offline get
+// the in-app copy replayed on their next connection.
 func SendNotification(email string, message interface{}) {
-	notifManager.mutex.Lock()
-	defer notifManager.mutex.Unlock()
+	deliverNotification(email, message)
+}
 
-	// Debug: List all connected clients
-	log.Printf("[NOTIFY] Looking for client: '%s'. Connected clients: %d", email, len(notifManager.clients))
-	for connectedEmail := range notifManager.clients {
-		log.Printf("[NOTIFY]   - Connected: '%s'", connectedEmail)
+// deliverNotification is SendNotification reporting whether the in-app
+// copy could be stored
+func deliverNotification(email string, message interface{}) error {
+	n, err := newNotification(email, message)
+	if err != nil {
+		return err
 	}
+	return notifier.Dispatch(n)
+}
 
-	client, ok := notifManager.clients[email]
-	if !ok {
-		log.Printf("[NOTIFY] Client '%s' NOT FOUND in connected clients!", email)
-		return // User not connected
+// deliverNotificationTo sends a notification over one channel only and
+// reports its error (used by the outbox relay to retry that channel)
+func deliverNotificationTo(channel, email string, message interface{}) error {
+	n, err := newNotification(email, message)
+	if err != nil {
+		return err
 	}
+	return notifier.DispatchTo(channel, n)
+}
 
-	log.Printf("[NOTIFY] Sending message to client: '%s'", email)
-	if err := client.WriteJSON(message); err != nil {
-		log.Printf("[NOTIFY] Failed to send notification to %s: %v", email, err)
-		client.Close()
-		delete(notifManager.clients, email)
-	} else {
-		log.Printf("[NOTIFY] Successfully sent notification to '%s'", email)
+func newNotification(email string, message interface{}) (notify.Notification, error) {
+	raw, err := json.Marshal(message)
+	if err != nil {
+		return notify.Notification{}, err
 	}
+
+	// Notifications are JSON objects with at least "type" and "message"
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		log.Printf("[NOTIFY] Invalid notification for %s: %v", email, err)
+		return notify.Notification{}, err
+	}
+	event, _ := data["type"].(string)
+	return notify.Notification{Recipient: email, Event: event, Data: data}, nil
+}
+
+// inAppChannel delivers notifications to the inbox and live /api/notify
+// connections. It cannot be disabled.
+type inAppChannel struct{}
+
+func (inAppChannel) Name() string { return notify.ChannelInApp }
+func (inAppChannel) Inline() bool { return true }
+
+func (inAppChannel) Send(n notify.Notification) error {
+	// Not pushed unless stored: the outbox retries the in-app row, and the
+	// connections mark it delivered by its id once written
+	id, payload, err := storeNotification(n.Recipient, n.Data)
+	if err != nil {
+		log.Printf("[NOTIFY] Failed to store notification for %s: %v", n.Recipient, err)
+		return err
+	}
+	sendLocalNotification(n.Recipient, payload)
+	publishNotification(id, n.Recipient, payload)
+	return nil
+}
+
+// sendLocalNotification delivers an encoded notification to every
+// connection of the user on this instance. It returns how many connections
+// it was queued for.
+func sendLocalNotification(email string, payload []byte) int {
+	sent := 0
+	for _, c := range notifManager.connections(email) {
+		if c.enqueueRaw(payload) {
+			sent++
+		}
+	}
+	if sent > 0 {
+		log.Printf("[NOTIFY] Sent notification to '%s' (%d connections)", email, sent)
+	}
+	return sent
 }
 
 // NotificationHandler manages persistent connections for updates
@@ -82,26 +168,20 @@ func NotificationHandler(c *gin.Context) {
 		return
 	}
 
-	notifManager.mutex.Lock()
-	// Close existing connection if any (one active tab policy or overwrite)
-	if existing, ok := notifManager.clients[email]; ok {
-		existing.Close()
-	}
-	notifManager.clients[email] = conn
-	notifManager.mutex.Unlock()
+	client := newNotifyWSClient(conn)
+	notifManager.add(email, client)
+
+	// Push everything that arrived while the user was offline
+	replayUndelivered(email, client)
 
-	// Keep connection alive
 	defer func() {
-		notifManager.mutex.Lock()
-		if notifManager.clients[email] == conn {
-			delete(notifManager.clients, email)
-		}
-		notifManager.mutex.Unlock()
-		conn.Close()
+		notifManager.remove(email, client)
+		client.close()
 	}()
 
 	for {
-		// Read messages - handle ping/pong to keep connection alive
+		// Read messages - server pings keep the connection alive, but the
+		// dashboard also sends an application-level ping
 		_, message, err := conn.ReadMessage()
 		if err != nil {
 			break
@@ -114,12 +194,13 @@ func NotificationHandler(c *gin.Context) {
 		if err := json.Unmarshal(message, &msg); err == nil {
 			if msg.Type == "ping" {
 				// Respond with pong
-				conn.WriteJSON(map[string]string{"type": "pong"})
+				client.enqueue(map[string]string{"type": "pong"})
 			}
 		}
 	}
 }
 
+// WebSocketHandler serves the signaling protocol for a room
 func WebSocketHandler(c *gin.Context) {
 	roomID := c.Query("room")
 	if roomID == "" {
@@ -133,68 +214,165 @@ func WebSocketHandler(c *gin.Context) {
 		return
 	}
 
-	manager.mutex.Lock()
-	if _, ok := manager.rooms[roomID]; !ok {
-		manager.rooms[roomID] = make(map[*websocket.Conn]bool)
+	client := newWSClient(conn)
+	defer client.close()
+
+	// The first valid frame must be a join carrying the protocol version
+	conn.SetReadDeadline(time.Now().Add(joinWait))
+	join, version, ok := awaitJoin(conn, client)
+	if !ok {
+		return
 	}
+	conn.SetReadDeadline(time.Now().Add(pongWait))
 
-	clients := manager.rooms[roomID]
-	if len(clients) >= 2 {
-		manager.mutex.Unlock()
-		conn.WriteJSON(Message{Type: "full"})
-		conn.Close()
+	// Only the client and psychologist of the booking may enter
+	self, rb, err := resolveParticipant(roomID, join.Role, join.Email)
+	if err != nil {
+		log.Printf("Room %s: rejected join: %v", roomID, err)
+		client.enqueue(errorFrame(newProtocolError(ErrCodeNotParticipant, "not a participant of this session")))
 		return
 	}
 
-	manager.rooms[roomID][conn] = true
+	if !rb.open() {
+		log.Printf("Room %s: rejected join, booking is %s", roomID, rb.Status)
+		client.enqueue(errorFrame(newProtocolError(ErrCodeSessionClosed, "this session is %s", rb.Status)))
+		return
+	}
 
-	// Notify others that a peer has joined if there's already someone else
-	if len(manager.rooms[roomID]) > 1 {
-		log.Printf("Room %s: Peer joined, notifying existing clients", roomID)
-		for client := range manager.rooms[roomID] {
-			if client != conn {
-				client.WriteJSON(Message{Type: "peer-joined"})
-			}
+	// The room closes at the end of the booked session
+	if time.Now().After(rb.validUntil()) {
+		client.enqueue(errorFrame(newProtocolError(ErrCodeSessionEnded, "this session has ended")))
+		return
+	}
+
+	// Clients knock first; the psychologist decides who enters
+	if self.Role == RoleClient {
+		if a := enterWaitingRoom(roomID, rb); a.Status == admissionDenied {
+			client.enqueue(newMessage(MsgDenied, nil))
+			return
 		}
+		// The transcript is only for peers, not for the lobby
+		client.admitted = func() { replayChatHistory(client, self) }
 	}
-	manager.mutex.Unlock()
 
+	room, status := manager.join(roomID, client, self)
+	if status == joinFull {
+		// Never a peer, so nobody is told we left
+		client.enqueue(newMessage(MsgFull, nil))
+		return
+	}
 	defer func() {
-		manager.mutex.Lock()
-		if _, ok := manager.rooms[roomID]; ok {
-			delete(manager.rooms[roomID], conn)
+		if remaining, wasPeer := manager.leave(room, client); wasPeer && remaining > 0 {
 			// Notify remaining clients of disconnect
-			for client := range manager.rooms[roomID] {
-				client.WriteJSON(Message{Type: "peer-left"})
-			}
-			if len(manager.rooms[roomID]) == 0 {
-				delete(manager.rooms, roomID)
-			}
+			room.broadcast(client, newMessage(MsgPeerLeft, nil))
 		}
-		manager.mutex.Unlock()
-		conn.Close()
 	}()
 
+	switch status {
+	case joinWaiting:
+		log.Printf("Room %s: Client waiting in lobby (protocol v%d)", roomID, version)
+		client.enqueue(newMessage(MsgWaiting, JoinedData{Version: version, Peers: room.size()}))
+	default:
+		client.enqueue(newMessage(MsgJoined, JoinedData{Version: version, Peers: room.size()}))
+
+		// Notify others that a peer has joined if there's already someone else
+		log.Printf("Room %s: Peer joined (protocol v%d)", roomID, version)
+		room.broadcast(client, newMessage(MsgPeerJoined, nil))
+		replayChatHistory(client, self)
+	}
+
+	// A psychologist arriving after the client started waiting
+	if self.Role == RoleExpert {
+		if a, ok := waitingRoom.get(roomID); ok && a.Status == admissionWaiting {
+			client.enqueue(newMessage(MsgClientWaiting, ClientWaitingData{BookingID: a.BookingID, ClientName: a.ClientName}))
+		}
+	}
+
 	for {
-		var msg Message
-		if err := conn.ReadJSON(&msg); err != nil {
-			log.Printf("Error reading json: %v", err)
+		msg, payload, err := readSignal(conn)
+		if perr, ok := err.(*protocolError); ok {
+			client.enqueue(errorFrame(perr))
+			continue
+		}
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Error reading signal: %v", err)
+			}
 			break
 		}
 
-		// Relay message to other peer in the room
-		manager.mutex.Lock()
-		if peers, ok := manager.rooms[roomID]; ok {
-			for peer := range peers {
-				if peer != conn {
-					if err := peer.WriteJSON(msg); err != nil {
-						log.Printf("Error writing json: %v", err)
-						peer.Close()
-						delete(peers, peer)
-					}
-				}
+		switch msg.Type {
+		case MsgJoin:
+			client.enqueue(errorFrame(newProtocolError(ErrCodeAlreadyJoined, "already joined room")))
+			continue
+
+		case MsgAdmit, MsgDeny:
+			if self.Role != RoleExpert {
+				client.enqueue(errorFrame(newProtocolError(ErrCodeForbidden, "only the psychologist can %s", msg.Type)))
+			} else if _, ok := decideAdmission(roomID, msg.Type == MsgAdmit); !ok {
+				client.enqueue(errorFrame(newProtocolError(ErrCodeNotAdmitted, "no client is waiting")))
 			}
+			continue
+		}
+
+		// Nothing is relayed from the lobby
+		if !room.isPeer(client) {
+			client.enqueue(errorFrame(newProtocolError(ErrCodeNotAdmitted, "waiting for the psychologist to admit you")))
+			continue
+		}
+
+		if msg.Type == MsgChat {
+			relayChat(room, client, self, payload.(ChatData))
+			continue
+		}
+
+		// Relay the normalized message to the other peer in the room
+		room.broadcast(client, newMessage(msg.Type, payload))
+	}
+}
+
+// awaitJoin reads frames until a valid join arrives, answering anything
+// else with an error frame. It returns the join request and the negotiated
+// protocol version.
+func awaitJoin(conn *websocket.Conn, client *wsClient) (JoinData, int, bool) {
+	for {
+		msg, payload, err := readSignal(conn)
+		if perr, ok := err.(*protocolError); ok {
+			client.enqueue(errorFrame(perr))
+			continue
+		}
+		if err != nil {
+			return JoinData{}, 0, false
+		}
+
+		if msg.Type != MsgJoin {
+			client.enqueue(errorFrame(newProtocolError(ErrCodeNotJoined, "send join before %q", msg.Type)))
+			continue
+		}
+
+		join := payload.(JoinData)
+		version, perr := negotiateVersion(join.Version)
+		if perr != nil {
+			client.enqueue(errorFrame(perr))
+			return JoinData{}, 0, false
 		}
-		manager.mutex.Unlock()
+		return join, version, true
+	}
+}
+
+// relayChat stores a chat message and delivers it to the other participant.
+// The sender gets the stored message back as confirmation.
+func relayChat(room *Room, client *wsClient, self *participant, data ChatData) {
+	stored, err := saveChatMessage(self, data.Text)
+	if err != nil {
+		log.Printf("[CHAT] Failed to store message for booking %d: %v", self.BookingID, err)
+		client.enqueue(errorFrame(newProtocolError(ErrCodeChatUnavailable, "message could not be saved")))
+		return
+	}
+
+	if room.size() > 1 {
+		markChatDelivered(&stored)
 	}
+	room.broadcast(client, newMessage(MsgChat, stored))
+	client.enqueue(newMessage(MsgChat, stored))
 }
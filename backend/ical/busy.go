@@ -0,0 +1,190 @@
+package ical
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Period is a busy block [Start, End)
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Upper bound on generated candidates per recurring event
+const maxOccurrences = 5000
+
+// rrule is the part of RRULE that Occurrences understands
+type rrule struct {
+	Freq     string
+	Interval int
+	Count    int
+	Until    time.Time
+	ByDay    []time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseRRule reads FREQ (DAILY, WEEKLY, MONTHLY, YEARLY), INTERVAL, COUNT,
+// UNTIL and, for WEEKLY, BYDAY. Any other rule part makes it return false,
+// and the event is then treated as a single occurrence.
+func parseRRule(s string, loc *time.Location) (rrule, bool) {
+	r := rrule{Interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			r.Freq = strings.ToUpper(v)
+		case "INTERVAL":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return r, false
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return r, false
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseTime(property{Value: v}, loc)
+			if err != nil {
+				return r, false
+			}
+			r.Until = t
+		case "BYDAY":
+			for _, d := range strings.Split(v, ",") {
+				wd, ok := weekdays[strings.ToUpper(d)]
+				if !ok {
+					return r, false // ordinal days (1MO, -1FR) are not supported
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "WKST", "":
+		default:
+			return r, false
+		}
+	}
+	switch r.Freq {
+	case "DAILY", "MONTHLY", "YEARLY":
+		return r, len(r.ByDay) == 0
+	case "WEEKLY":
+		return r, true
+	}
+	return r, false
+}
+
+// Occurrences returns the start times of e that begin before to and end
+// after from
+func (e Event) Occurrences(from, to time.Time) []time.Time {
+	length := e.End.Sub(e.Start)
+	overlaps := func(t time.Time) bool { return t.Before(to) && t.Add(length).After(from) }
+
+	r, ok := parseRRule(e.RRule, e.Start.Location())
+	if e.RRule == "" || !ok {
+		if overlaps(e.Start) {
+			return []time.Time{e.Start}
+		}
+		return nil
+	}
+
+	excluded := map[int64]bool{}
+	for _, t := range e.ExDates {
+		excluded[t.Unix()] = true
+	}
+
+	var out []time.Time
+	n := 0
+	emit := func(t time.Time) bool {
+		if t.Before(e.Start) {
+			return true
+		}
+		if (!r.Until.IsZero() && t.After(r.Until)) || !t.Before(to) {
+			return false
+		}
+		n++
+		if r.Count > 0 && n > r.Count {
+			return false
+		}
+		if !excluded[t.Unix()] && overlaps(t) {
+			out = append(out, t)
+		}
+		return true
+	}
+
+	// Candidates are built from DTSTART's wall clock so DST shifts keep
+	// the local time of day
+	s := e.Start
+	for i := 0; i < maxOccurrences; i++ {
+		var t time.Time
+		switch r.Freq {
+		case "DAILY":
+			t = s.AddDate(0, 0, i*r.Interval)
+		case "MONTHLY":
+			t = s.AddDate(0, i*r.Interval, 0)
+			if t.Day() != s.Day() {
+				continue // no such day in this month
+			}
+		case "YEARLY":
+			t = s.AddDate(i*r.Interval, 0, 0)
+			if t.Day() != s.Day() {
+				continue
+			}
+		case "WEEKLY":
+			days := r.ByDay
+			if len(days) == 0 {
+				days = []time.Weekday{s.Weekday()}
+			}
+			sorted := append([]time.Weekday(nil), days...)
+			sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+			// Sunday of the week i*Interval weeks after DTSTART's
+			weekStart := s.AddDate(0, 0, i*r.Interval*7-int(s.Weekday()))
+			for _, wd := range sorted {
+				if !emit(weekStart.AddDate(0, 0, int(wd))) {
+					return out
+				}
+			}
+			continue
+		}
+		if !emit(t) {
+			return out
+		}
+	}
+	return out
+}
+
+// BusyPeriods returns the time blocked by events within [from, to),
+// expanding recurrences and applying overrides of single occurrences.
+// Cancelled and transparent (free) events don't block time.
+func BusyPeriods(events []Event, from, to time.Time) []Period {
+	// Occurrences moved or cancelled by a RECURRENCE-ID override
+	overridden := map[string]bool{}
+	for _, e := range events {
+		if !e.RecurrenceID.IsZero() {
+			overridden[e.UID+"/"+strconv.FormatInt(e.RecurrenceID.Unix(), 10)] = true
+		}
+	}
+
+	var out []Period
+	for _, e := range events {
+		if e.Status == StatusCancelled || e.Transparent || !e.End.After(e.Start) {
+			continue
+		}
+		length := e.End.Sub(e.Start)
+		for _, t := range e.Occurrences(from, to) {
+			if e.RecurrenceID.IsZero() && overridden[e.UID+"/"+strconv.FormatInt(t.Unix(), 10)] {
+				continue
+			}
+			out = append(out, Period{Start: t, End: t.Add(length)})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
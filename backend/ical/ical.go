@@ -0,0 +1,161 @@
+// Package ical reads and writes the subset of iCalendar (RFC 5545) used
+// for booking exports, calendar feeds and external busy-time imports.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ProdID identifies this application in exported calendars
+const ProdID = "-//SafeSpace Counseling//Booking Calendar//ID"
+
+// Event status values
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusTentative = "TENTATIVE"
+	StatusCancelled = "CANCELLED"
+)
+
+// Event is a VEVENT. UID must stay the same for the lifetime of the
+// booking and Sequence must grow with every change, so calendar clients
+// update (or cancel) the existing entry instead of adding a new one.
+type Event struct {
+	UID         string
+	Sequence    int
+	Stamp       time.Time // DTSTAMP, when this version was generated
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Status      string
+
+	// Only filled by Parse
+	AllDay       bool        // DTSTART;VALUE=DATE
+	Transparent  bool        // TRANSP:TRANSPARENT, doesn't block time
+	RRule        string      // Raw RRULE value, see Occurrences
+	ExDates      []time.Time // EXDATE, occurrences left out
+	RecurrenceID time.Time   // Set on an override of one occurrence
+
+	duration time.Duration // DURATION, applied once DTSTART is known
+}
+
+// Calendar is a VCALENDAR
+type Calendar struct {
+	Name    string // X-WR-CALNAME, shown by subscribing clients
+	Method  string // PUBLISH for downloads/feeds
+	Refresh time.Duration
+	Events  []Event
+}
+
+// WriteTo encodes the calendar with CRLF line endings and folded lines
+func (c *Calendar) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	line := func(name, value string) { writeLine(&buf, name+":"+value) }
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", ProdID)
+	line("CALSCALE", "GREGORIAN")
+	if c.Method != "" {
+		line("METHOD", c.Method)
+	}
+	if c.Name != "" {
+		line("X-WR-CALNAME", Escape(c.Name))
+	}
+	if c.Refresh > 0 {
+		line("REFRESH-INTERVAL;VALUE=DURATION", formatDuration(c.Refresh))
+		line("X-PUBLISHED-TTL", formatDuration(c.Refresh))
+	}
+
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.UID)
+		line("SEQUENCE", fmt.Sprint(e.Sequence))
+		line("DTSTAMP", FormatTime(e.Stamp))
+		line("DTSTART", FormatTime(e.Start))
+		line("DTEND", FormatTime(e.End))
+		if e.RRule != "" {
+			line("RRULE", e.RRule)
+		}
+		line("SUMMARY", Escape(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", Escape(e.Description))
+		}
+		if e.Location != "" {
+			line("LOCATION", Escape(e.Location))
+		}
+		if e.URL != "" {
+			line("URL;VALUE=URI", e.URL)
+		}
+		if e.Status != "" {
+			line("STATUS", e.Status)
+		}
+		if e.Transparent {
+			line("TRANSP", "TRANSPARENT")
+		}
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// Bytes returns the encoded calendar
+func (c *Calendar) Bytes() []byte {
+	var buf bytes.Buffer
+	c.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// FormatTime formats t as a UTC DATE-TIME (20060102T150405Z)
+func FormatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Escape escapes a TEXT value
+func Escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+	return r.Replace(s)
+}
+
+func formatDuration(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("PT%dH", d/time.Hour)
+	}
+	return fmt.Sprintf("PT%dM", d/time.Minute)
+}
+
+// writeLine writes a content line folded at 75 octets, never splitting a
+// UTF-8 sequence
+func writeLine(buf *bytes.Buffer, s string) {
+	const limit = 75
+	first := true
+	for len(s) > 0 {
+		max := limit
+		if !first {
+			max = limit - 1 // continuation lines start with a space
+		}
+		cut := len(s)
+		if cut > max {
+			cut = max
+			for cut > 0 && !utf8.RuneStart(s[cut]) {
+				cut--
+			}
+		}
+		if !first {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(s[:cut])
+		buf.WriteString("\r\n")
+		s = s[cut:]
+		first = false
+	}
+}
@@ -0,0 +1,248 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotCalendar is returned by Parse when the input has no VCALENDAR
+var ErrNotCalendar = errors.New("ical: not an iCalendar document")
+
+// property is one unfolded content line
+type property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Parse reads the VEVENTs of an iCalendar document. Times without a
+// zone (floating) or with an unknown TZID are read in loc.
+func Parse(r io.Reader, loc *time.Location) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var cur *Event
+	depth := 0 // nesting inside the current VEVENT (VALARM etc.)
+	seenCalendar := false
+
+	for _, line := range lines {
+		p, ok := parseProperty(line)
+		if !ok {
+			continue
+		}
+		switch {
+		case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VCALENDAR"):
+			seenCalendar = true
+		case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VEVENT") && cur == nil:
+			cur = &Event{}
+			depth = 0
+		case p.Name == "BEGIN" && cur != nil:
+			depth++
+		case p.Name == "END" && cur != nil && depth > 0:
+			depth--
+		case p.Name == "END" && strings.EqualFold(p.Value, "VEVENT") && cur != nil:
+			if !cur.Start.IsZero() {
+				if cur.End.IsZero() && cur.duration != 0 {
+					cur.End = cur.Start.Add(cur.duration)
+				}
+				if cur.End.IsZero() && cur.AllDay {
+					cur.End = cur.Start.AddDate(0, 0, 1)
+				}
+				events = append(events, *cur)
+			}
+			cur = nil
+		case cur != nil && depth == 0:
+			if err := cur.setProperty(p, loc); err != nil {
+				return nil, fmt.Errorf("ical: event %q: %w", cur.UID, err)
+			}
+		}
+	}
+
+	if !seenCalendar {
+		return nil, ErrNotCalendar
+	}
+	return events, nil
+}
+
+func (e *Event) setProperty(p property, loc *time.Location) error {
+	var err error
+	switch p.Name {
+	case "UID":
+		e.UID = p.Value
+	case "SEQUENCE":
+		e.Sequence, _ = strconv.Atoi(p.Value)
+	case "SUMMARY":
+		e.Summary = Unescape(p.Value)
+	case "DESCRIPTION":
+		e.Description = Unescape(p.Value)
+	case "LOCATION":
+		e.Location = Unescape(p.Value)
+	case "STATUS":
+		e.Status = strings.ToUpper(p.Value)
+	case "TRANSP":
+		e.Transparent = strings.EqualFold(p.Value, "TRANSPARENT")
+	case "RRULE":
+		e.RRule = p.Value
+	case "DTSTAMP":
+		e.Stamp, err = parseTime(p, loc)
+	case "DTSTART":
+		e.AllDay = p.Params["VALUE"] == "DATE" || len(p.Value) == 8
+		e.Start, err = parseTime(p, loc)
+	case "DTEND":
+		e.End, err = parseTime(p, loc)
+	case "DURATION":
+		e.duration, err = ParseDuration(p.Value)
+	case "RECURRENCE-ID":
+		e.RecurrenceID, err = parseTime(p, loc)
+	case "EXDATE":
+		for _, v := range strings.Split(p.Value, ",") {
+			t, err := parseTime(property{Params: p.Params, Value: v}, loc)
+			if err != nil {
+				return err
+			}
+			e.ExDates = append(e.ExDates, t)
+		}
+	}
+	return err
+}
+
+// parseTime reads a DATE or DATE-TIME value, honouring TZID
+func parseTime(p property, loc *time.Location) (time.Time, error) {
+	v := strings.TrimSpace(p.Value)
+	if tzid := p.Params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(strings.Trim(tzid, "/")); err == nil {
+			loc = l
+		}
+	}
+	switch {
+	case len(v) == 8:
+		return time.ParseInLocation("20060102", v, loc)
+	case strings.HasSuffix(v, "Z"):
+		return time.Parse("20060102T150405Z", v)
+	default:
+		return time.ParseInLocation("20060102T150405", v, loc)
+	}
+}
+
+// ParseDuration reads a DURATION value such as PT1H30M, P1D or -PT15M
+func ParseDuration(s string) (time.Duration, error) {
+	orig := s
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+	s = strings.TrimPrefix(s, "+")
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+	s = s[1:]
+
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			num += string(r)
+		case r == 'T':
+			inTime = true
+		default:
+			n, err := strconv.Atoi(num)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", orig)
+			}
+			unit := map[string]time.Duration{
+				"W": 7 * 24 * time.Hour, "D": 24 * time.Hour,
+				"TH": time.Hour, "TM": time.Minute, "TS": time.Second,
+			}
+			key := string(r)
+			if inTime {
+				key = "T" + key
+			}
+			u, ok := unit[key]
+			if !ok {
+				return 0, fmt.Errorf("invalid duration %q", orig)
+			}
+			d += time.Duration(n) * u
+			num = ""
+		}
+	}
+	if num != "" {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+	return sign * d, nil
+}
+
+// Unescape reverses Escape
+func Unescape(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
+
+// unfold splits the input into logical content lines
+func unfold(r io.Reader) ([]string, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, sc.Err()
+}
+
+// parseProperty splits NAME;PARAM=VALUE;...:VALUE, allowing ':' and ';'
+// inside quoted parameter values
+func parseProperty(line string) (property, bool) {
+	inQuote := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuote = !inQuote
+		} else if r == ':' && !inQuote {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property{}, false
+	}
+
+	p := property{Value: line[colon+1:], Params: map[string]string{}}
+	head := line[:colon]
+	parts := []string{}
+	start := 0
+	inQuote = false
+	for i, r := range head {
+		if r == '"' {
+			inQuote = !inQuote
+		} else if r == ';' && !inQuote {
+			parts = append(parts, head[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, head[start:])
+
+	p.Name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		if k, v, ok := strings.Cut(param, "="); ok {
+			p.Params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return p, true
+}
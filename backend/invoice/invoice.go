@@ -0,0 +1,226 @@
+// Package invoice renders the platform's PDF documents: invoices/receipts
+// for paid bookings and earnings statements for psychologists. Renderers
+// only see the document they are given; handlers fill it from records
+// taken when the booking was paid or completed, so reprints match.
+package invoice
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Clinic is the issuer printed in the letterhead
+type Clinic struct {
+	Name    string
+	Address string
+	Phone   string
+	Email   string
+	TaxID   string // NPWP, optional
+}
+
+// Item is one invoice line
+type Item struct {
+	Description string
+	Detail      string // Second line, e.g. session time and duration
+	Quantity    int
+	UnitPrice   int // Rupiah
+}
+
+// Invoice is the content of one document. Times are printed in their own
+// location, so pass them in the clinic's time zone.
+type Invoice struct {
+	Number   string
+	IssuedAt time.Time
+	PaidAt   time.Time
+
+	Clinic Clinic
+
+	ClientName    string
+	ClientContact string
+
+	PsychologistName string
+	LicenseNumber    string // SIPP; printed as "-" when not set
+
+	Items []Item
+
+	PaymentMethod string // Provider name
+	PaymentRef    string // Order id at the provider
+	Refunded      int    // Rupiah returned to the client, if any
+}
+
+// Total is the sum of all lines
+func (inv *Invoice) Total() int {
+	total := 0
+	for _, it := range inv.Items {
+		total += it.Quantity * it.UnitPrice
+	}
+	return total
+}
+
+// rupiah formats an amount for humans ("Rp 150.000")
+func rupiah(amount int) string {
+	s := strconv.Itoa(amount)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "." + s[i:]
+	}
+	return "Rp " + s
+}
+
+// Layout, in mm on A4
+const (
+	margin     = 18.0
+	lineHeight = 6.0
+)
+
+// newDocument starts an A4 document with one page. tr converts UTF-8 text
+// for the core fonts, which are cp1252; names with accents would otherwise
+// come out garbled.
+func newDocument(title string, clinic Clinic, created time.Time) (*fpdf.Fpdf, func(string) string) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(true, margin)
+	pdf.SetTitle(title, true)
+	pdf.SetAuthor(clinic.Name, true)
+	pdf.SetCreator("SafeSpace Counseling", true)
+	pdf.SetCreationDate(created)
+	pdf.SetModificationDate(created)
+	pdf.SetCatalogSort(true)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+	return pdf, tr
+}
+
+// letterhead prints the clinic's details with the document title on the
+// right, followed by a rule
+func letterhead(pdf *fpdf.Fpdf, tr func(string) string, clinic Clinic, title string) {
+	pageW, _ := pdf.GetPageSize()
+	width := pageW - 2*margin
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(width/2, 8, tr(clinic.Name), "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(width/2, 8, title, "", 1, "R", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(90, 90, 90)
+	for _, line := range []string{clinic.Address, clinic.Phone, clinic.Email} {
+		if line != "" {
+			pdf.MultiCell(width/2, 4.5, tr(line), "", "L", false)
+		}
+	}
+	if clinic.TaxID != "" {
+		pdf.CellFormat(width/2, 4.5, tr("NPWP: "+clinic.TaxID), "", 1, "L", false, 0, "")
+	}
+	pdf.SetTextColor(0, 0, 0)
+	pdf.Ln(3)
+	pdf.SetDrawColor(200, 200, 200)
+	pdf.Line(margin, pdf.GetY(), pageW-margin, pdf.GetY())
+	pdf.Ln(4)
+}
+
+// Render writes inv as a PDF to w
+func Render(w io.Writer, inv *Invoice) error {
+	pdf, tr := newDocument("Invoice "+inv.Number, inv.Clinic, inv.IssuedAt)
+	pageW, _ := pdf.GetPageSize()
+	width := pageW - 2*margin
+
+	letterhead(pdf, tr, inv.Clinic, "INVOICE / KWITANSI")
+
+	// Document details and parties
+	field := func(label, value string) {
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.SetTextColor(90, 90, 90)
+		pdf.CellFormat(38, 5, tr(label), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.MultiCell(width-38, 5, tr(value), "", "L", false)
+	}
+	field("Nomor", inv.Number)
+	field("Tanggal terbit", inv.IssuedAt.Format("02/01/2006"))
+	if !inv.PaidAt.IsZero() {
+		field("Tanggal bayar", inv.PaidAt.Format("02/01/2006 15:04 MST"))
+	}
+	pdf.Ln(3)
+	field("Klien", inv.ClientName)
+	if inv.ClientContact != "" {
+		field("Kontak", inv.ClientContact)
+	}
+	field("Psikolog", inv.PsychologistName)
+	license := inv.LicenseNumber
+	if license == "" {
+		license = "-"
+	}
+	field("No. SIPP", license)
+	pdf.Ln(5)
+
+	// Lines
+	cols := []float64{width - 70, 15, 27.5, 27.5}
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(240, 240, 240)
+	for i, h := range []string{"Deskripsi", "Jml", "Harga", "Subtotal"} {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		pdf.CellFormat(cols[i], 7, h, "B", 0, align, true, 0, "")
+	}
+	pdf.Ln(-1)
+	for _, it := range inv.Items {
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(cols[0], lineHeight, tr(it.Description), "", 0, "L", false, 0, "")
+		pdf.CellFormat(cols[1], lineHeight, strconv.Itoa(it.Quantity), "", 0, "R", false, 0, "")
+		pdf.CellFormat(cols[2], lineHeight, rupiah(it.UnitPrice), "", 0, "R", false, 0, "")
+		pdf.CellFormat(cols[3], lineHeight, rupiah(it.Quantity*it.UnitPrice), "", 1, "R", false, 0, "")
+		if it.Detail != "" {
+			pdf.SetFont("Helvetica", "", 8.5)
+			pdf.SetTextColor(90, 90, 90)
+			pdf.MultiCell(cols[0], 4.5, tr(it.Detail), "", "L", false)
+			pdf.SetTextColor(0, 0, 0)
+		}
+		pdf.Ln(1)
+	}
+	pdf.Line(margin, pdf.GetY(), pageW-margin, pdf.GetY())
+	pdf.Ln(2)
+
+	// Totals
+	total := func(label, value string, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetFont("Helvetica", style, 10)
+		pdf.CellFormat(width-55, lineHeight, tr(label), "", 0, "R", false, 0, "")
+		pdf.CellFormat(55, lineHeight, value, "", 1, "R", false, 0, "")
+	}
+	total("Total", rupiah(inv.Total()), true)
+	if !inv.PaidAt.IsZero() {
+		total("Dibayar", rupiah(inv.Total()), false)
+	}
+	if inv.Refunded > 0 {
+		total("Dikembalikan (refund)", "-"+rupiah(inv.Refunded), false)
+		total("Total bersih", rupiah(inv.Total()-inv.Refunded), true)
+	}
+	pdf.Ln(6)
+
+	// Payment status
+	if !inv.PaidAt.IsZero() {
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.SetTextColor(16, 128, 80)
+		pdf.CellFormat(width, 7, "LUNAS", "", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	}
+	pdf.SetFont("Helvetica", "", 9)
+	if inv.PaymentMethod != "" {
+		pdf.MultiCell(width, 4.5, tr(fmt.Sprintf("Dibayar melalui %s (ref. %s).", inv.PaymentMethod, inv.PaymentRef)), "", "L", false)
+	}
+	pdf.Ln(8)
+	pdf.SetTextColor(110, 110, 110)
+	pdf.SetFont("Helvetica", "I", 8.5)
+	pdf.MultiCell(width, 4.5, tr("Dokumen ini diterbitkan secara elektronik dan sah tanpa tanda tangan. "+
+		"Dapat digunakan sebagai bukti pembayaran untuk klaim asuransi atau penggantian biaya."), "", "L", false)
+
+	return pdf.Output(w)
+}
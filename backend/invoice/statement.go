@@ -0,0 +1,192 @@
+package invoice
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statement is a psychologist's earnings and payouts over a period, with
+// what the platform owes them before and after it
+type Statement struct {
+	Clinic   Clinic
+	IssuedAt time.Time
+
+	PsychologistName string
+	LicenseNumber    string
+
+	From, To time.Time // Inclusive dates
+
+	CommissionNote string // e.g. "20% dari bruto"
+
+	Lines   []StatementLine
+	Payouts []Payout
+
+	OpeningBalance int // Owed before From
+}
+
+// StatementLine is the earning from one completed session
+type StatementLine struct {
+	Date        time.Time
+	Reference   string // Booking number
+	Description string
+	Source      string // How the session was paid
+	Gross       int
+	Commission  int
+	Net         int
+}
+
+// Payout is money transferred to the psychologist
+type Payout struct {
+	Date      time.Time
+	Reference string
+	Amount    int
+}
+
+// Totals sums the lines
+func (s *Statement) Totals() (gross, commission, net int) {
+	for _, l := range s.Lines {
+		gross += l.Gross
+		commission += l.Commission
+		net += l.Net
+	}
+	return gross, commission, net
+}
+
+// PaidOut sums the payouts
+func (s *Statement) PaidOut() int {
+	total := 0
+	for _, p := range s.Payouts {
+		total += p.Amount
+	}
+	return total
+}
+
+// ClosingBalance is what is owed at the end of the period
+func (s *Statement) ClosingBalance() int {
+	_, _, net := s.Totals()
+	return s.OpeningBalance + net - s.PaidOut()
+}
+
+// RenderStatement writes s as a PDF to w
+func RenderStatement(w io.Writer, s *Statement) error {
+	period := s.From.Format("02/01/2006") + " - " + s.To.Format("02/01/2006")
+	pdf, tr := newDocument("Laporan Pendapatan "+period, s.Clinic, s.IssuedAt)
+	pageW, _ := pdf.GetPageSize()
+	width := pageW - 2*margin
+
+	letterhead(pdf, tr, s.Clinic, "LAPORAN PENDAPATAN")
+
+	field := func(label, value string) {
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.SetTextColor(90, 90, 90)
+		pdf.CellFormat(38, 5, tr(label), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.MultiCell(width-38, 5, tr(value), "", "L", false)
+	}
+	field("Psikolog", s.PsychologistName)
+	license := s.LicenseNumber
+	if license == "" {
+		license = "-"
+	}
+	field("No. SIPP", license)
+	field("Periode", period)
+	field("Tanggal terbit", s.IssuedAt.Format("02/01/2006"))
+	if s.CommissionNote != "" {
+		field("Komisi", s.CommissionNote)
+	}
+	pdf.Ln(5)
+
+	// Sessions
+	cols := []float64{22, 16, width - 22 - 16 - 24*3, 24, 24, 24}
+	header := func(titles []string, widths []float64) {
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(240, 240, 240)
+		for i, h := range titles {
+			align := "R"
+			if i < len(titles)-3 {
+				align = "L"
+			}
+			pdf.CellFormat(widths[i], 7, h, "B", 0, align, true, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	header([]string{"Tanggal", "No.", "Sesi", "Bruto", "Komisi", "Neto"}, cols)
+	pdf.SetFont("Helvetica", "", 9)
+	if len(s.Lines) == 0 {
+		pdf.SetTextColor(110, 110, 110)
+		pdf.CellFormat(width, lineHeight, "Tidak ada sesi selesai pada periode ini.", "", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	}
+	for _, l := range s.Lines {
+		desc := l.Description
+		if l.Source != "" {
+			desc += " (" + l.Source + ")"
+		}
+		pdf.CellFormat(cols[0], lineHeight, l.Date.Format("02/01/2006"), "", 0, "L", false, 0, "")
+		pdf.CellFormat(cols[1], lineHeight, tr(l.Reference), "", 0, "L", false, 0, "")
+		// Cells don't wrap; cut long session names before they reach the amounts
+		desc = tr(desc)
+		for len(desc) > 3 && pdf.GetStringWidth(desc) > cols[2]-2 {
+			desc = strings.TrimRight(desc[:len(desc)-4], " ") + "..."
+		}
+		pdf.CellFormat(cols[2], lineHeight, desc, "", 0, "L", false, 0, "")
+		pdf.CellFormat(cols[3], lineHeight, rupiah(l.Gross), "", 0, "R", false, 0, "")
+		pdf.CellFormat(cols[4], lineHeight, rupiah(l.Commission), "", 0, "R", false, 0, "")
+		pdf.CellFormat(cols[5], lineHeight, rupiah(l.Net), "", 1, "R", false, 0, "")
+	}
+	gross, commission, net := s.Totals()
+	pdf.Line(margin, pdf.GetY(), pageW-margin, pdf.GetY())
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.CellFormat(cols[0]+cols[1]+cols[2], lineHeight, tr(strconv.Itoa(len(s.Lines))+" sesi"), "", 0, "L", false, 0, "")
+	pdf.CellFormat(cols[3], lineHeight, rupiah(gross), "", 0, "R", false, 0, "")
+	pdf.CellFormat(cols[4], lineHeight, rupiah(commission), "", 0, "R", false, 0, "")
+	pdf.CellFormat(cols[5], lineHeight, rupiah(net), "", 1, "R", false, 0, "")
+	pdf.Ln(6)
+
+	// Payouts
+	if len(s.Payouts) > 0 {
+		pcols := []float64{22, width - 22 - 24, 24}
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(240, 240, 240)
+		pdf.CellFormat(pcols[0], 7, "Tanggal", "B", 0, "L", true, 0, "")
+		pdf.CellFormat(pcols[1], 7, "Pembayaran ke psikolog", "B", 0, "L", true, 0, "")
+		pdf.CellFormat(pcols[2], 7, "Jumlah", "B", 1, "R", true, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+		for _, p := range s.Payouts {
+			pdf.CellFormat(pcols[0], lineHeight, p.Date.Format("02/01/2006"), "", 0, "L", false, 0, "")
+			pdf.CellFormat(pcols[1], lineHeight, tr(p.Reference), "", 0, "L", false, 0, "")
+			pdf.CellFormat(pcols[2], lineHeight, rupiah(p.Amount), "", 1, "R", false, 0, "")
+		}
+		pdf.Ln(6)
+	}
+
+	// Balance
+	total := func(label string, amount int, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		value := rupiah(amount)
+		if amount < 0 {
+			value = "-" + rupiah(-amount)
+		}
+		pdf.SetFont("Helvetica", style, 10)
+		pdf.CellFormat(width-55, lineHeight, tr(label), "", 0, "R", false, 0, "")
+		pdf.CellFormat(55, lineHeight, value, "", 1, "R", false, 0, "")
+	}
+	total("Saldo awal", s.OpeningBalance, false)
+	total("Pendapatan neto periode ini", net, false)
+	total("Dibayarkan", -s.PaidOut(), false)
+	total("Saldo terutang", s.ClosingBalance(), true)
+
+	pdf.Ln(8)
+	pdf.SetTextColor(110, 110, 110)
+	pdf.SetFont("Helvetica", "I", 8.5)
+	pdf.MultiCell(width, 4.5, tr("Pendapatan dihitung dari sesi yang selesai dan telah dibayar (termasuk kredit paket dan sponsor), "+
+		"dikurangi komisi platform yang berlaku saat sesi selesai. Dokumen ini diterbitkan secara elektronik."), "", "L", false)
+
+	return pdf.Output(w)
+}
@@ -2,23 +2,126 @@ package main
 
 import (
 	"counseling-webrtc/database"
+	"counseling-webrtc/handlers"
+	"counseling-webrtc/invoice"
+	"counseling-webrtc/notify"
+	"counseling-webrtc/payments"
+	"counseling-webrtc/pubsub"
+	"counseling-webrtc/questionnaire"
+	"counseling-webrtc/risk"
 	"counseling-webrtc/routes"
 
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
 	database.ConnectDB()
+
+	// Shared state for signaling/notifications. Set PUBSUB_URL=redis://host:6379/0
+	// when running more than one backend instance.
+	broker, err := pubsub.New(os.Getenv("PUBSUB_URL"))
+	if err != nil {
+		log.Fatal("Failed to connect pub/sub broker:", err)
+	}
+	if err := handlers.SetBroker(broker); err != nil {
+		log.Fatal("Failed to subscribe to notifications:", err)
+	}
+
+	// Email notifications. Without SMTP_HOST mail is written as .eml files
+	// to MAIL_DEV_DIR (default mail-outbox/) for local development.
+	handlers.RegisterNotificationChannel(notify.NewEmailChannel(newMailer(), getEnv("MAIL_FROM", "SafeSpace Counseling <no-reply@safespace.local>")))
+
+	// WhatsApp/SMS through an HTTP gateway (see tools/fakegateway for local testing)
+	if url := os.Getenv("MESSAGING_GATEWAY_URL"); url != "" {
+		gateway := notify.NewHTTPGateway(url, os.Getenv("MESSAGING_GATEWAY_TOKEN"))
+		handlers.RegisterNotificationChannel(notify.NewMessagingChannel(notify.ChannelWhatsApp, gateway, handlers.OptInStore{}, notify.ParseTemplateIDs(os.Getenv("WHATSAPP_TEMPLATES"))))
+		handlers.RegisterNotificationChannel(notify.NewMessagingChannel(notify.ChannelSMS, gateway, handlers.OptInStore{}, notify.ParseTemplateIDs(os.Getenv("SMS_TEMPLATES"))))
+	}
+
+	// Web Push. VAPID keys come from VAPID_PUBLIC_KEY/VAPID_PRIVATE_KEY or are
+	// generated once and kept in the database.
+	if err := handlers.EnableWebPush(getEnv("VAPID_SUBJECT", "mailto:admin@safespace.local")); err != nil {
+		log.Println("Web Push disabled:", err)
+	}
+
+	provider, err := newPaymentProvider()
+	if err != nil {
+		log.Fatal("Invalid payment configuration:", err)
+	}
+	handlers.SetPaymentProvider(provider)
+
+	// Cancellation policy as notice:refund%, e.g. REFUND_POLICY=24h:100,6h:50 (the default)
+	if s := os.Getenv("REFUND_POLICY"); s != "" {
+		policy, err := payments.ParseRefundPolicy(s)
+		if err != nil {
+			log.Fatal("Invalid REFUND_POLICY:", err)
+		}
+		handlers.SetRefundPolicy(policy)
+	}
+
+	// Issuer details printed on invoices/receipts
+	handlers.SetClinic(invoice.Clinic{
+		Name:    getEnv("CLINIC_NAME", "SafeSpace Counseling"),
+		Address: os.Getenv("CLINIC_ADDRESS"),
+		Phone:   os.Getenv("CLINIC_PHONE"),
+		Email:   os.Getenv("CLINIC_EMAIL"),
+		TaxID:   os.Getenv("CLINIC_NPWP"),
+	})
+
+	// Platform commission on completed sessions, in percent
+	if s := os.Getenv("PLATFORM_COMMISSION_PERCENT"); s != "" {
+		percent, err := strconv.Atoi(s)
+		if err != nil || percent < 0 || percent > 100 {
+			log.Fatal("Invalid PLATFORM_COMMISSION_PERCENT: ", s)
+		}
+		handlers.SetCommissionPercent(percent)
+	}
+
+	// Intake questionnaires beyond the built-in PHQ-9 and GAD-7
+	if dir := os.Getenv("QUESTIONNAIRES_DIR"); dir != "" {
+		if err := questionnaire.LoadDir(dir); err != nil {
+			log.Fatal("Invalid questionnaire in QUESTIONNAIRES_DIR: ", err)
+		}
+	}
+
+	// Crisis screening: RISK_RULES_FILE replaces the built-in keywords and
+	// hotlines; RISK_ALERT_EMAILS (comma-separated) are alerted of every
+	// high-risk booking along with its psychologist
+	if path := os.Getenv("RISK_RULES_FILE"); path != "" {
+		if err := risk.LoadFile(path); err != nil {
+			log.Fatal("Invalid RISK_RULES_FILE: ", err)
+		}
+	}
+	if s := os.Getenv("RISK_ALERT_EMAILS"); s != "" {
+		var emails []string
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				emails = append(emails, e)
+			}
+		}
+		handlers.SetRiskAlertEmails(emails)
+	}
+
+	handlers.StartReminders()
+	handlers.StartWebhookWorker()
+	handlers.StartOutboxRelay()
+	handlers.StartCalendarSync()
+	handlers.StartRoomHeartbeat()
+
 	r := gin.Default()
 
 	// CORS Middleware
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, X-Admin-Token, X-Sponsor-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -48,3 +151,51 @@ func main() {
 	fmt.Println("Starting Server on :8080 (HTTP)...")
 	r.Run(":8080")
 }
+
+func newMailer() notify.Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		dir := getEnv("MAIL_DEV_DIR", "mail-outbox")
+		fmt.Printf("SMTP_HOST not set, writing emails to %s/\n", dir)
+		return &notify.FileMailer{Dir: dir}
+	}
+	return &notify.SMTPMailer{
+		Host:     host,
+		Port:     getEnv("SMTP_PORT", "587"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	}
+}
+
+// newPaymentProvider returns the provider named by PAYMENT_PROVIDER:
+// midtrans, or fake (see tools/fakepay) for local development. There is no
+// default, so a deployment never takes fake payments by accident.
+func newPaymentProvider() (payments.Provider, error) {
+	switch name := os.Getenv("PAYMENT_PROVIDER"); name {
+	case "midtrans":
+		key := os.Getenv("MIDTRANS_SERVER_KEY")
+		if key == "" {
+			return nil, errors.New("MIDTRANS_SERVER_KEY is required")
+		}
+		return payments.NewMidtrans(key, os.Getenv("MIDTRANS_PRODUCTION") == "true"), nil
+	case "fake":
+		secret := os.Getenv("FAKEPAY_SECRET")
+		if secret == "" {
+			return nil, errors.New("FAKEPAY_SECRET is required (the -secret of tools/fakepay)")
+		}
+		checkout := getEnv("FAKEPAY_URL", "http://localhost:9092")
+		fmt.Printf("Using fake payments at %s\n", checkout)
+		return &payments.Fake{CheckoutURL: checkout, Secret: secret}, nil
+	case "":
+		return nil, errors.New("PAYMENT_PROVIDER is required (midtrans or fake)")
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q", name)
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Category represents a specialty/complaint category
 type Category struct {
@@ -12,16 +15,17 @@ type Category struct {
 
 // Psychologist represents an expert/counselor
 type Psychologist struct {
-	ID          int        `json:"id"`
-	Name        string     `json:"name"`
-	Email       string     `json:"email,omitempty"`
-	Specialties string     `json:"specialties"` // Backward compatible string format
-	Categories  []Category `json:"categories"`  // New: array of categories
-	Schedules   []Schedule `json:"schedules"`   // New: availability
-	Bio         string     `json:"bio"`
-	IsAvailable bool       `json:"is_available"`
-	IsBooked    bool       `json:"is_booked"` // New: Check for specific slot conflict
-	CreatedAt   time.Time  `json:"created_at,omitempty"`
+	ID           int           `json:"id"`
+	Name         string        `json:"name"`
+	Email        string        `json:"email,omitempty"`
+	Specialties  string        `json:"specialties"` // Backward compatible string format
+	Categories   []Category    `json:"categories"`  // New: array of categories
+	Schedules    []Schedule    `json:"schedules"`   // New: availability
+	SessionTypes []SessionType `json:"session_types"`
+	Bio          string        `json:"bio"`
+	IsAvailable  bool          `json:"is_available"`
+	IsBooked     bool          `json:"is_booked"` // New: Check for specific slot conflict
+	CreatedAt    time.Time     `json:"created_at,omitempty"`
 }
 
 // Schedule represents a psychologist's availability
@@ -34,6 +38,18 @@ type Schedule struct {
 	IsActive       bool   `json:"is_active"`
 }
 
+// SessionType is a kind of session a psychologist offers
+type SessionType struct {
+	ID              int    `json:"id"`
+	PsychologistID  int    `json:"psychologist_id"`
+	Name            string `json:"name"`
+	DurationMinutes int    `json:"duration_minutes"`
+	Price           int    `json:"price"`    // Rupiah
+	Modality        string `json:"modality"` // video, audio, chat
+	IsActive        bool   `json:"is_active"`
+	Available       *bool  `json:"available,omitempty"` // For the slot asked in GetPsychologists
+}
+
 // Client represents a user seeking counseling
 type Client struct {
 	ID           int       `json:"id"`
@@ -52,13 +68,228 @@ type Booking struct {
 	Complaint       string `json:"complaint"`               // Additional details
 	PsychologistID  int    `json:"psychologist_id"`
 	ScheduleTime    string `json:"schedule_time"` // Original input string
-	Status          string `json:"status"`        // pending, approved, rejected, completed
+	Status          string `json:"status"`        // pending, approved, rejected, completed, cancelled
 	RoomID          string `json:"room_id"`
 	SessionNotes    string `json:"session_notes"`              // Expert notes
 	RejectionReason string `json:"rejection_reason,omitempty"` // Reason for rejection
+	SessionTypeID   int    `json:"session_type_id,omitempty"`
+	SessionTypeName string `json:"session_type_name,omitempty"` // Joined field
+	DurationMinutes int    `json:"duration_minutes"`
+	Price           int    `json:"price"`                   // Rupiah
+	Modality        string `json:"modality"`                // video, audio, chat
+	PaymentStatus   string `json:"payment_status"`          // not_required, unpaid, paid, credit, sponsored
+	RefundStatus    string `json:"refund_status,omitempty"` // none, pending, refunded, failed
+	RefundAmount    int    `json:"refund_amount,omitempty"`
+	CancellationFee int    `json:"cancellation_fee,omitempty"`
+	CreditStatus    string `json:"credit_status,omitempty"`  // none, reserved, used, returned, forfeited, released
+	SponsorStatus   string `json:"sponsor_status,omitempty"` // none, reserved, used, released, forfeited
 	ChatHistory     string `json:"chat_history,omitempty"`
 	CreatedAt       string `json:"created_at"`
 
+	// Crisis screening at booking
+	RiskLevel   string   `json:"risk_level,omitempty"` // none, high
+	RiskReasons []string `json:"risk_reasons,omitempty"`
+
 	// Joins
-	PsychologistName string `json:"psychologist_name,omitempty"`
+	PsychologistName string                `json:"psychologist_name,omitempty"`
+	SponsorName      string                `json:"sponsor_name,omitempty"`
+	Questionnaires   []QuestionnaireResult `json:"questionnaires,omitempty"`
+}
+
+// QuestionnaireResult is an intake questionnaire scored at booking
+type QuestionnaireResult struct {
+	Code          string    `json:"code"` // phq9, gad7
+	Name          string    `json:"name"` // PHQ-9
+	Score         int       `json:"score"`
+	MaxScore      int       `json:"max_score"`
+	Severity      string    `json:"severity"` // minimal, mild, moderate, moderately_severe, severe
+	SeverityLabel string    `json:"severity_label"`
+	Answers       []int     `json:"answers"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ChatMessage is an in-session chat message persisted per booking
+type ChatMessage struct {
+	ID          int64      `json:"id"`
+	BookingID   int        `json:"booking_id"`
+	SenderRole  string     `json:"sender_role"` // client, expert
+	Text        string     `json:"text"`
+	Status      string     `json:"status"` // sent, delivered
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// Notification is a persisted notification in a user's inbox
+type Notification struct {
+	ID          int64           `json:"id"`
+	Email       string          `json:"email"`
+	Type        string          `json:"type"`
+	Message     string          `json:"message"`
+	Payload     json.RawMessage `json:"payload"` // The frame as sent over /api/notify
+	IsRead      bool            `json:"is_read"`
+	CreatedAt   time.Time       `json:"created_at"`
+	DeliveredAt *time.Time      `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time      `json:"read_at,omitempty"`
+}
+
+// WebhookSubscription is an external endpoint receiving booking events
+type WebhookSubscription struct {
+	ID          int       `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"` // Only returned on creation
+	Events      []string  `json:"events"`           // booking.created, ... or "*"
+	Description string    `json:"description"`
+	IsActive    bool      `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent (or being retried) to a subscription
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int             `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"` // pending, delivered, failed
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// CalendarSource is an external calendar whose busy times block bookings
+type CalendarSource struct {
+	ID             int        `json:"id"`
+	PsychologistID int        `json:"psychologist_id"`
+	Kind           string     `json:"kind"` // ics, caldav
+	URL            string     `json:"url"`
+	Username       string     `json:"username,omitempty"`
+	BusyCount      int        `json:"busy_count"` // Imported busy blocks
+	LastSyncedAt   *time.Time `json:"last_synced_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Payment is one checkout attempt for a priced booking or package
+type Payment struct {
+	ID         int        `json:"id"`
+	BookingID  int        `json:"booking_id,omitempty"`
+	PurchaseID int        `json:"purchase_id,omitempty"` // Package purchases are paid like bookings
+	OrderID    string     `json:"order_id"`
+	Provider   string     `json:"provider"`
+	Amount     int        `json:"amount"` // Rupiah
+	Status     string     `json:"status"` // pending, paid, failed, expired
+	PaymentURL string     `json:"payment_url"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	PaidAt     *time.Time `json:"paid_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Refund returns (part of) a payment to the client
+type Refund struct {
+	ID        int       `json:"id"`
+	BookingID int       `json:"booking_id"`
+	PaymentID int       `json:"payment_id"`
+	Amount    int       `json:"amount"` // Rupiah refunded
+	Fee       int       `json:"fee"`    // Rupiah kept
+	Reason    string    `json:"reason"` // rejected, cancelled, duplicate
+	Status    string    `json:"status"` // pending, succeeded, failed
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Invoice is the receipt of a paid booking. Everything printed on it is
+// copied when it is issued.
+type Invoice struct {
+	ID               int       `json:"id"`
+	InvoiceNumber    string    `json:"invoice_number"` // INV/2026/000123
+	BookingID        int       `json:"booking_id"`
+	PaymentID        int       `json:"payment_id"`
+	Amount           int       `json:"amount"` // Rupiah
+	ClientName       string    `json:"client_name"`
+	ClientContact    string    `json:"client_contact,omitempty"`
+	PsychologistName string    `json:"psychologist_name"`
+	LicenseNumber    string    `json:"license_number,omitempty"`
+	Description      string    `json:"description"`
+	ScheduleTime     time.Time `json:"schedule_time"`
+	DurationMinutes  int       `json:"duration_minutes"`
+	Modality         string    `json:"modality"`
+	PaymentProvider  string    `json:"payment_provider"`
+	OrderID          string    `json:"order_id"`
+	PaidAt           time.Time `json:"paid_at"`
+	IssuedAt         time.Time `json:"issued_at"`
+}
+
+// SessionPackage is a prepaid bundle of sessions of one session type
+type SessionPackage struct {
+	ID              int    `json:"id"`
+	PsychologistID  int    `json:"psychologist_id"`
+	SessionTypeID   int    `json:"session_type_id"`
+	SessionTypeName string `json:"session_type_name,omitempty"` // Joined field
+	Name            string `json:"name"`
+	SessionCount    int    `json:"session_count"`
+	Price           int    `json:"price"` // Rupiah for the whole package
+	ValidityDays    int    `json:"validity_days"`
+	IsActive        bool   `json:"is_active"`
+}
+
+// PackagePurchase is a client's balance of credits from one package
+type PackagePurchase struct {
+	ID               int        `json:"id"`
+	PackageID        int        `json:"package_id"`
+	PackageName      string     `json:"package_name"` // Joined field
+	SessionTypeID    int        `json:"session_type_id"`
+	SessionTypeName  string     `json:"session_type_name,omitempty"` // Joined field
+	PsychologistName string     `json:"psychologist_name,omitempty"` // Joined field
+	SessionsTotal    int        `json:"sessions_total"`
+	SessionsUsed     int        `json:"sessions_used"`
+	SessionsReserved int        `json:"sessions_reserved"` // Held by pending bookings
+	Remaining        int        `json:"remaining"`         // Still bookable
+	Price            int        `json:"price"`
+	Status           string     `json:"status"` // unpaid, paid
+	PaidAt           *time.Time `json:"paid_at,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	Expired          bool       `json:"expired"`
+}
+
+// Sponsor is an employer or EAP provider covering sessions for its
+// employees. Employees are recognised by voucher code or email domain.
+type Sponsor struct {
+	ID                  int       `json:"id"`
+	Name                string    `json:"name"`
+	VoucherCode         string    `json:"voucher_code,omitempty"`
+	EmailDomain         string    `json:"email_domain,omitempty"`
+	SessionsPerEmployee int       `json:"sessions_per_employee"`
+	ValidUntil          string    `json:"valid_until,omitempty"` // YYYY-MM-DD, last session date covered
+	IsActive            bool      `json:"is_active"`
+	ReportToken         string    `json:"report_token,omitempty"` // Only returned when (re)generated
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// Earning is what a psychologist earned from one completed session
+type Earning struct {
+	ID                int    `json:"id"`
+	BookingID         int    `json:"booking_id"`
+	SessionTime       string `json:"session_time"` // Wall clock WIB
+	Description       string `json:"description"`
+	Source            string `json:"source"` // paid, credit, sponsored
+	Gross             int    `json:"gross"`  // Rupiah
+	CommissionPercent int    `json:"commission_percent"`
+	Commission        int    `json:"commission"`
+	Net               int    `json:"net"`
+}
+
+// Payout is a transfer of earnings to a psychologist
+type Payout struct {
+	ID               int       `json:"id"`
+	PsychologistID   int       `json:"psychologist_id"`
+	PsychologistName string    `json:"psychologist_name,omitempty"` // Joined field
+	Amount           int       `json:"amount"`                      // Rupiah
+	Reference        string    `json:"reference"`
+	Note             string    `json:"note,omitempty"`
+	PaidAt           time.Time `json:"paid_at"`
+	CreatedAt        time.Time `json:"created_at"`
 }
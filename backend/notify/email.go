@@ -0,0 +1,184 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Email is a rendered message ready to be handed to a Mailer
+type Email struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Bytes encodes the email as an RFC 5322 message (multipart/alternative
+// when there is an HTML body)
+func (e *Email) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", e.From)
+	header("To", e.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(e.From))
+	header("MIME-Version", "1.0")
+
+	if e.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, e.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", e.Text},
+		{"text/html; charset=utf-8", e.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if at := bytes.LastIndexByte([]byte(addr.Address), '@'); at >= 0 {
+			domain = addr.Address[at+1:]
+		}
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// Mailer hands an email to a transport
+type Mailer interface {
+	Send(e *Email) error
+}
+
+// SMTPMailer sends email through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string // Optional; PLAIN auth is used when set
+	Password string
+}
+
+func (m *SMTPMailer) Send(e *Email) error {
+	msg, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(e.From)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, from.Address, []string{e.To}, msg)
+}
+
+// FileMailer writes every email as an .eml file into Dir. Used in
+// development so mail can be checked without a mail server.
+type FileMailer struct {
+	Dir string
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func (m *FileMailer) Send(e *Email) error {
+	msg, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000"), unsafeFileChars.ReplaceAllString(e.To, "_"))
+	return os.WriteFile(filepath.Join(m.Dir, name), msg, 0644)
+}
+
+// EmailChannel renders notifications with the event templates and sends
+// them through a Mailer
+type EmailChannel struct {
+	Mailer Mailer
+	From   string
+}
+
+// NewEmailChannel returns an email channel sending as from
+func NewEmailChannel(mailer Mailer, from string) *EmailChannel {
+	return &EmailChannel{Mailer: mailer, From: from}
+}
+
+func (c *EmailChannel) Name() string { return ChannelEmail }
+
+func (c *EmailChannel) Send(n Notification) error {
+	// Recipients are identified by free-text contact; only real addresses get mail
+	addr, err := mail.ParseAddress(n.Recipient)
+	if err != nil {
+		return nil
+	}
+
+	r, err := Render(n.Event, n.Language, n.Data)
+	if err == ErrNoTemplate {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return c.Mailer.Send(&Email{
+		From:    c.From,
+		To:      addr.Address,
+		Subject: r.Subject,
+		Text:    r.Text,
+		HTML:    r.HTML,
+	})
+}
@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Messaging channel names
+const (
+	ChannelWhatsApp = "whatsapp"
+	ChannelSMS      = "sms"
+)
+
+// TextMessage is a WhatsApp/SMS message handed to a gateway. Providers that
+// only allow pre-approved templates (WhatsApp Business) use TemplateID and
+// Params; the others send Text.
+type TextMessage struct {
+	Channel    string            `json:"channel"` // whatsapp, sms
+	To         string            `json:"to"`      // E.164
+	TemplateID string            `json:"template_id,omitempty"`
+	Language   string            `json:"language,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	Text       string            `json:"text"`
+}
+
+// MessageGateway delivers text messages through a provider
+type MessageGateway interface {
+	SendMessage(m TextMessage) error
+}
+
+// HTTPGateway posts messages as JSON to a provider endpoint (or an adapter
+// in front of one). See tools/fakegateway for a local fake.
+type HTTPGateway struct {
+	URL    string
+	Token  string // Sent as "Authorization: Bearer <token>" when set
+	Client *http.Client
+}
+
+// NewHTTPGateway returns a gateway posting to url
+func NewHTTPGateway(url, token string) *HTTPGateway {
+	return &HTTPGateway{URL: url, Token: token, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (g *HTTPGateway) SendMessage(m TextMessage) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, g.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("gateway returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// OptInStore tells whether a phone number agreed to receive messages on a
+// channel
+type OptInStore interface {
+	OptedIn(phone, channel string) (bool, error)
+}
+
+// MessagingChannel sends notifications as WhatsApp or SMS messages to
+// recipients whose contact is a phone number and who opted in
+type MessagingChannel struct {
+	name      string
+	Gateway   MessageGateway
+	OptIns    OptInStore
+	Templates map[string]string // event -> provider template ID
+}
+
+// NewMessagingChannel returns a channel named name (ChannelWhatsApp or
+// ChannelSMS)
+func NewMessagingChannel(name string, gateway MessageGateway, optIns OptInStore, templates map[string]string) *MessagingChannel {
+	return &MessagingChannel{name: name, Gateway: gateway, OptIns: optIns, Templates: templates}
+}
+
+func (c *MessagingChannel) Name() string { return c.name }
+
+func (c *MessagingChannel) Send(n Notification) error {
+	phone, err := NormalizePhone(n.Recipient)
+	if err != nil {
+		return nil
+	}
+
+	if c.OptIns != nil {
+		ok, err := c.OptIns.OptedIn(phone, c.name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+
+	// SMS gets the short message; WhatsApp the full rendered template
+	text := n.Message()
+	if c.name != ChannelSMS {
+		if r, err := Render(n.Event, n.Language, n.Data); err == nil {
+			text = r.Subject + "\n\n" + r.Text
+		}
+	}
+	if text == "" {
+		return nil
+	}
+
+	params := make(map[string]string, len(n.Data))
+	for k, v := range n.Data {
+		if v != nil {
+			params[k] = fmt.Sprint(v)
+		}
+	}
+
+	return c.Gateway.SendMessage(TextMessage{
+		Channel:    c.name,
+		To:         phone,
+		TemplateID: c.Templates[n.Event],
+		Language:   n.Language,
+		Params:     params,
+		Text:       text,
+	})
+}
+
+// ParseTemplateIDs parses "event=template,event=template" (as used in the
+// environment) into a map
+func ParseTemplateIDs(s string) map[string]string {
+	ids := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		event, id, ok := strings.Cut(pair, "=")
+		event, id = strings.TrimSpace(event), strings.TrimSpace(id)
+		if ok && event != "" && id != "" {
+			ids[event] = id
+		}
+	}
+	return ids
+}
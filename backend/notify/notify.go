@@ -0,0 +1,191 @@
+// Package notify delivers booking events to users over pluggable channels
+// (in-app, email, ...). The Dispatcher fans a Notification out to every
+// channel the recipient has enabled.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Supported template languages
+const (
+	LangIndonesian = "id"
+	LangEnglish    = "en"
+)
+
+// Built-in channel names
+const (
+	ChannelInApp = "in_app"
+	ChannelEmail = "email"
+)
+
+// DefaultLanguage is used when a user has no preference
+const DefaultLanguage = LangIndonesian
+
+// Notification is a single event for a single recipient
+type Notification struct {
+	Recipient string                 // Email / contact the user is known by
+	Event     string                 // new_booking, booking_updated, booking_rejected, ...
+	Language  string                 // Filled in by the dispatcher from preferences
+	Data      map[string]interface{} // Event fields; "type" and "message" are always set
+}
+
+// Message returns the short human readable text of the notification
+func (n Notification) Message() string {
+	msg, _ := n.Data["message"].(string)
+	return msg
+}
+
+// Channel delivers notifications over one medium
+type Channel interface {
+	// Name identifies the channel in preferences ("in_app", "email", ...)
+	Name() string
+	// Send delivers n. Channels that cannot serve n (e.g. no template for
+	// the event, recipient has no address for this medium) return nil.
+	Send(n Notification) error
+}
+
+// InlineChannel is implemented by channels that are cheap and must keep
+// the order of notifications (in-app delivery). Dispatch sends to them
+// synchronously instead of on a goroutine.
+type InlineChannel interface {
+	Channel
+	Inline() bool
+}
+
+// Preferences are the per-user notification settings
+type Preferences struct {
+	Language string
+	Disabled map[string]bool // channel name -> disabled
+}
+
+// Enabled reports whether channel is enabled
+func (p Preferences) Enabled(channel string) bool {
+	return !p.Disabled[channel]
+}
+
+// PreferenceStore loads the preferences of a recipient
+type PreferenceStore interface {
+	Get(recipient string) (Preferences, error)
+}
+
+// Dispatcher routes notifications to the enabled channels
+type Dispatcher struct {
+	prefs    PreferenceStore
+	channels []Channel
+	mutex    sync.RWMutex
+}
+
+// NewDispatcher returns a dispatcher for channels. prefs may be nil, in
+// which case every channel is enabled and the default language is used.
+func NewDispatcher(prefs PreferenceStore, channels ...Channel) *Dispatcher {
+	return &Dispatcher{prefs: prefs, channels: channels}
+}
+
+// Register adds a channel
+func (d *Dispatcher) Register(c Channel) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.channels = append(d.channels, c)
+}
+
+// Channels returns the names of the registered channels
+func (d *Dispatcher) Channels() []string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	names := make([]string, 0, len(d.channels))
+	for _, c := range d.channels {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+// preferences loads the recipient's preferences, falling back to defaults
+func (d *Dispatcher) preferences(recipient string) Preferences {
+	prefs := Preferences{Language: DefaultLanguage}
+	if d.prefs == nil {
+		return prefs
+	}
+
+	p, err := d.prefs.Get(recipient)
+	if err != nil {
+		log.Printf("[NOTIFY] Failed to load preferences for %s: %v", recipient, err)
+		return prefs
+	}
+	if p.Language == "" {
+		p.Language = DefaultLanguage
+	}
+	return p
+}
+
+// Dispatch delivers n to every channel the recipient has enabled. Inline
+// channels are sent before Dispatch returns and their errors are returned;
+// every other channel runs on its own goroutine (errors are logged) so a
+// slow mail server never delays the in-app notification. When an inline
+// channel fails the others are not started, so a caller retrying n doesn't
+// send them twice.
+func (d *Dispatcher) Dispatch(n Notification) error {
+	prefs := d.preferences(n.Recipient)
+	n.Language = prefs.Language
+
+	d.mutex.RLock()
+	channels := append([]Channel(nil), d.channels...)
+	d.mutex.RUnlock()
+
+	var errs []error
+	var later []Channel
+	for _, c := range channels {
+		if !prefs.Enabled(c.Name()) {
+			continue
+		}
+		if ic, ok := c.(InlineChannel); ok && ic.Inline() {
+			if err := send(c, n); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", c.Name(), err))
+			}
+			continue
+		}
+		later = append(later, c)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	for _, c := range later {
+		go send(c, n)
+	}
+	return nil
+}
+
+// DispatchTo delivers n over the channel named channel only, synchronously,
+// and returns its error. It does nothing when the channel isn't registered
+// or the recipient disabled it.
+func (d *Dispatcher) DispatchTo(channel string, n Notification) error {
+	prefs := d.preferences(n.Recipient)
+	n.Language = prefs.Language
+	if !prefs.Enabled(channel) {
+		return nil
+	}
+
+	d.mutex.RLock()
+	var target Channel
+	for _, c := range d.channels {
+		if c.Name() == channel {
+			target = c
+		}
+	}
+	d.mutex.RUnlock()
+	if target == nil {
+		return nil
+	}
+	return send(target, n)
+}
+
+func send(c Channel, n Notification) error {
+	err := c.Send(n)
+	if err != nil {
+		log.Printf("[NOTIFY] %s: failed to send %s to %s: %v", c.Name(), n.Event, n.Recipient, err)
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testChannel records what it was asked to send
+type testChannel struct {
+	name   string
+	inline bool
+	err    error
+
+	mutex sync.Mutex
+	sent  int
+}
+
+func (c *testChannel) Name() string { return c.name }
+func (c *testChannel) Inline() bool { return c.inline }
+
+func (c *testChannel) Send(n Notification) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sent++
+	return c.err
+}
+
+func (c *testChannel) count() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.sent
+}
+
+type testPrefs map[string]Preferences
+
+func (p testPrefs) Get(recipient string) (Preferences, error) { return p[recipient], nil }
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		inlineErr error
+		disabled  bool // Recipient turned email off
+		wantErr   bool
+		wantEmail int
+	}{
+		{name: "all channels", wantEmail: 1},
+		{name: "inline failure holds the others back", inlineErr: errors.New("db down"), wantErr: true},
+		{name: "disabled channel", disabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inApp := &testChannel{name: ChannelInApp, inline: true, err: tt.inlineErr}
+			email := &testChannel{name: ChannelEmail}
+			prefs := testPrefs{"klien@example.com": {Disabled: map[string]bool{ChannelEmail: tt.disabled}}}
+			d := NewDispatcher(prefs, inApp, email)
+
+			err := d.Dispatch(Notification{Recipient: "klien@example.com", Event: "new_booking"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if inApp.count() != 1 {
+				t.Errorf("in-app sent %d times, want 1", inApp.count())
+			}
+			// Other channels run on goroutines
+			deadline := time.Now().Add(time.Second)
+			for email.count() < tt.wantEmail && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			time.Sleep(20 * time.Millisecond)
+			if email.count() != tt.wantEmail {
+				t.Errorf("email sent %d times, want %d", email.count(), tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestDispatchTo(t *testing.T) {
+	failing := errors.New("smtp down")
+	tests := []struct {
+		name     string
+		channel  string
+		err      error
+		disabled bool
+		wantErr  error
+		wantSent int
+	}{
+		{name: "sent", channel: ChannelEmail, wantSent: 1},
+		{name: "error returned", channel: ChannelEmail, err: failing, wantErr: failing, wantSent: 1},
+		{name: "disabled", channel: ChannelEmail, disabled: true},
+		{name: "unknown channel", channel: "fax"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inApp := &testChannel{name: ChannelInApp, inline: true}
+			email := &testChannel{name: ChannelEmail, err: tt.err}
+			prefs := testPrefs{"klien@example.com": {Disabled: map[string]bool{ChannelEmail: tt.disabled}}}
+			d := NewDispatcher(prefs, inApp, email)
+
+			err := d.DispatchTo(tt.channel, Notification{Recipient: "klien@example.com", Event: "new_booking"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if email.count() != tt.wantSent || inApp.count() != 0 {
+				t.Errorf("sent email %d, in-app %d; want %d, 0", email.count(), inApp.count(), tt.wantSent)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"errors"
+	"strings"
+)
+
+// DefaultCountryCode is assumed for local numbers (0812..., 812...)
+const DefaultCountryCode = "62"
+
+// ErrInvalidPhone is returned for contacts that are not phone numbers
+var ErrInvalidPhone = errors.New("notify: not a phone number")
+
+// NormalizePhone converts a free-text phone number to E.164
+// ("0812-3456-789" -> "+628123456789"). Local numbers are assumed to be
+// Indonesian.
+func NormalizePhone(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, "@") {
+		return "", ErrInvalidPhone
+	}
+
+	international := strings.HasPrefix(s, "+") || strings.HasPrefix(s, "00")
+	var digits strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+
+	d := digits.String()
+	switch {
+	case strings.HasPrefix(s, "00"):
+		d = d[2:]
+	case international:
+	case strings.HasPrefix(d, DefaultCountryCode):
+	case strings.HasPrefix(d, "0"):
+		d = DefaultCountryCode + d[1:]
+	default:
+		d = DefaultCountryCode + d
+	}
+
+	// E.164: country code + subscriber number, at most 15 digits
+	if len(d) < 8 || len(d) > 15 || d[0] == '0' {
+		return "", ErrInvalidPhone
+	}
+	return "+" + d, nil
+}
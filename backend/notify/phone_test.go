@@ -0,0 +1,41 @@
+package notify
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "0812-3456-789", want: "+628123456789"},
+		{in: "+62 812 3456 789", want: "+628123456789"},
+		{in: "0062 812 3456 789", want: "+628123456789"},
+		{in: "812 3456 789", want: "+628123456789"},
+		{in: "628123456789", want: "+628123456789"},
+		{in: " (0812) 3456.789 ", want: "+628123456789"},
+		{in: "+1 415 555 0100", want: "+14155550100"},
+		{in: "klien@example.com", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "0812", wantErr: true},                   // Too short
+		{in: "+62 812 3456 7890 1234", wantErr: true}, // More than 15 digits
+		{in: "0812-34AB-789", wantErr: true},
+		{in: "+62+8123456789", wantErr: true},
+		{in: "+0812 3456 789", wantErr: true}, // No country code starts with 0
+		{in: "00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := NormalizePhone(tt.in)
+			if tt.wantErr {
+				if err != ErrInvalidPhone {
+					t.Errorf("NormalizePhone(%q) = %q, %v; want ErrInvalidPhone", tt.in, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizePhone(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
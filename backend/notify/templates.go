@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates live in templates/<lang>/<event>.txt and .html. The .txt file
+// defines "subject" and the plain-text body; the .html file the HTML body.
+//
+//go:embed templates
+var templateFS embed.FS
+
+// ErrNoTemplate is returned when an event has no template in any language
+var ErrNoTemplate = errors.New("notify: no template for event")
+
+// Rendered is a notification rendered for a text medium
+type Rendered struct {
+	Subject string
+	Text    string
+	HTML    string // Empty if the event has no HTML template
+}
+
+// Render renders event in lang (falling back to the default language)
+func Render(event, lang string, data map[string]interface{}) (Rendered, error) {
+	for _, l := range []string{lang, DefaultLanguage, LangEnglish} {
+		r, err := render(event, l, data)
+		if err == ErrNoTemplate {
+			continue
+		}
+		return r, err
+	}
+	return Rendered{}, ErrNoTemplate
+}
+
+func render(event, lang string, data map[string]interface{}) (Rendered, error) {
+	var r Rendered
+	base := "templates/" + lang + "/" + event
+
+	textSrc, err := templateFS.ReadFile(base + ".txt")
+	if err != nil {
+		return r, ErrNoTemplate
+	}
+	text, err := texttemplate.New(event).Option("missingkey=zero").Parse(string(textSrc))
+	if err != nil {
+		return r, err
+	}
+
+	var buf bytes.Buffer
+	if t := text.Lookup("subject"); t != nil {
+		if err := t.Execute(&buf, data); err != nil {
+			return r, err
+		}
+		r.Subject = strings.TrimSpace(buf.String())
+		buf.Reset()
+	}
+	if err := text.Execute(&buf, data); err != nil {
+		return r, err
+	}
+	r.Text = strings.TrimSpace(buf.String()) + "\n"
+
+	if htmlSrc, err := templateFS.ReadFile(base + ".html"); err == nil {
+		html, err := htmltemplate.New(event).Option("missingkey=zero").Parse(string(htmlSrc))
+		if err != nil {
+			return r, err
+		}
+		buf.Reset()
+		if err := html.Execute(&buf, data); err != nil {
+			return r, err
+		}
+		r.HTML = buf.String()
+	}
+
+	return r, nil
+}
@@ -0,0 +1,113 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/SherClockHolmes/webpush-go"
+)
+
+// ChannelWebPush is the name of the Web Push channel
+const ChannelWebPush = "web_push"
+
+// Push messages are dropped by the push service if the browser stays
+// offline longer than this (seconds)
+const webPushTTL = 24 * 60 * 60
+
+// VAPIDKeys identify this server to browser push services (base64url, as
+// generated by GenerateVAPIDKeys)
+type VAPIDKeys struct {
+	Public  string
+	Private string
+}
+
+// GenerateVAPIDKeys creates a new P-256 key pair
+func GenerateVAPIDKeys() (VAPIDKeys, error) {
+	private, public, err := webpush.GenerateVAPIDKeys()
+	return VAPIDKeys{Public: public, Private: private}, err
+}
+
+// PushSubscription is a browser PushSubscription (as returned by
+// PushSubscription.toJSON())
+type PushSubscription = webpush.Subscription
+
+// PushSubscriptionStore holds the push subscriptions of every user
+type PushSubscriptionStore interface {
+	Subscriptions(recipient string) ([]PushSubscription, error)
+	// Remove forgets a subscription the push service reported as gone
+	Remove(endpoint string) error
+}
+
+// PushPayload is the JSON the service worker receives
+type PushPayload struct {
+	Title string                 `json:"title"`
+	Body  string                 `json:"body"`
+	Event string                 `json:"event"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+// WebPushChannel sends notifications to every browser a user subscribed
+type WebPushChannel struct {
+	Keys       VAPIDKeys
+	Subscriber string // Contact (mailto: or https:) sent to push services
+	Store      PushSubscriptionStore
+	Client     *http.Client // Optional
+}
+
+// NewWebPushChannel returns a Web Push channel signing with keys
+func NewWebPushChannel(keys VAPIDKeys, subscriber string, store PushSubscriptionStore) *WebPushChannel {
+	return &WebPushChannel{Keys: keys, Subscriber: subscriber, Store: store}
+}
+
+func (c *WebPushChannel) Name() string { return ChannelWebPush }
+
+func (c *WebPushChannel) Send(n Notification) error {
+	subs, err := c.Store.Subscriptions(n.Recipient)
+	if err != nil || len(subs) == 0 {
+		return err
+	}
+
+	payload := PushPayload{Title: n.Event, Body: n.Message(), Event: n.Event, Data: n.Data}
+	if r, err := Render(n.Event, n.Language, n.Data); err == nil {
+		payload.Title = r.Subject
+	}
+	message, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	options := &webpush.Options{
+		Subscriber:      c.Subscriber,
+		VAPIDPublicKey:  c.Keys.Public,
+		VAPIDPrivateKey: c.Keys.Private,
+		TTL:             webPushTTL,
+		Urgency:         webpush.UrgencyHigh,
+	}
+	if c.Client != nil {
+		options.HTTPClient = c.Client
+	}
+
+	var lastErr error
+	for i := range subs {
+		resp, err := webpush.SendNotification(message, &subs[i], options)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+			// Unsubscribed or expired in the browser
+			if err := c.Store.Remove(subs[i].Endpoint); err != nil {
+				lastErr = err
+			}
+		case resp.StatusCode < 200 || resp.StatusCode > 299:
+			lastErr = fmt.Errorf("push service returned %s: %s", resp.Status, body)
+		}
+	}
+	return lastErr
+}
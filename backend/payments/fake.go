@@ -0,0 +1,84 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// FakeSignatureHeader carries hex HMAC-SHA256(secret, body) on fake
+// notifications
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// FakeNotification is the body of a fake payment notification
+type FakeNotification struct {
+	OrderID string `json:"order_id"`
+	Status  string `json:"status"` // paid, failed, expired
+	Amount  int    `json:"amount"`
+}
+
+// Fake is a local provider: charges are checkout links to tools/fakepay,
+// which lets you pay or fail them and posts a signed notification back.
+// No money moves and nothing leaves the machine.
+type Fake struct {
+	CheckoutURL string // Base URL of tools/fakepay
+	Secret      string // Shared with tools/fakepay
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) CreateCharge(ctx context.Context, req ChargeRequest) (*Charge, error) {
+	q := url.Values{}
+	q.Set("order_id", req.OrderID)
+	q.Set("amount", strconv.Itoa(req.Amount))
+	q.Set("description", req.Description)
+	if req.ReturnURL != "" {
+		q.Set("return_url", req.ReturnURL)
+	}
+	return &Charge{
+		ProviderRef: "fake-" + req.OrderID,
+		PaymentURL:  f.CheckoutURL + "/pay?" + q.Encode(),
+		ExpiresAt:   time.Now().Add(req.Expiry),
+	}, nil
+}
+
+// Refund always succeeds; there is no money to return
+func (f *Fake) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	return &RefundResult{ProviderRef: "fake-refund-" + req.RefundKey}, nil
+}
+
+// Sign returns the FakeSignatureHeader value for body
+func (f *Fake) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(f.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (f *Fake) ParseWebhook(r *http.Request) (*Event, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		return nil, err
+	}
+	if f.Secret == "" || !hmac.Equal([]byte(f.Sign(body)), []byte(r.Header.Get(FakeSignatureHeader))) {
+		return nil, ErrInvalidSignature
+	}
+
+	var n FakeNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, fmt.Errorf("fake: %w", err)
+	}
+	switch n.Status {
+	case StatusPaid, StatusFailed, StatusExpired:
+	default:
+		return nil, fmt.Errorf("fake: unknown status %q", n.Status)
+	}
+	return &Event{OrderID: n.OrderID, ProviderRef: "fake-" + n.OrderID, Status: n.Status, Amount: n.Amount}, nil
+}
@@ -0,0 +1,45 @@
+package payments
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFakeParseWebhookSignature(t *testing.T) {
+	signer := &Fake{Secret: "test-secret"}
+	body := `{"order_id":"booking-1","status":"paid","amount":150000}`
+	valid := signer.Sign([]byte(body))
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      string
+		signature string
+		wantErr   error
+	}{
+		{"valid", "test-secret", body, valid, nil},
+		{"missing signature", "test-secret", body, "", ErrInvalidSignature},
+		{"wrong signature", "test-secret", body, strings.Repeat("0", 64), ErrInvalidSignature},
+		{"tampered body", "test-secret", strings.Replace(body, "150000", "1", 1), valid, ErrInvalidSignature},
+		{"other secret", "other-secret", body, valid, ErrInvalidSignature},
+		{"no secret configured", "", body, (&Fake{}).Sign([]byte(body)), ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/payments/webhook/fake", strings.NewReader(tt.body))
+			if tt.signature != "" {
+				req.Header.Set(FakeSignatureHeader, tt.signature)
+			}
+
+			ev, err := (&Fake{Secret: tt.secret}).ParseWebhook(req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (ev.OrderID != "booking-1" || ev.Status != StatusPaid || ev.Amount != 150000) {
+				t.Errorf("event = %+v", ev)
+			}
+		})
+	}
+}
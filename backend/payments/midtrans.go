@@ -0,0 +1,199 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Midtrans Snap and Core API endpoints
+const (
+	midtransSandboxURL       = "https://app.sandbox.midtrans.com/snap/v1/transactions"
+	midtransProductionURL    = "https://app.midtrans.com/snap/v1/transactions"
+	midtransSandboxAPIURL    = "https://api.sandbox.midtrans.com/v2"
+	midtransProductionAPIURL = "https://api.midtrans.com/v2"
+)
+
+// Midtrans creates Snap checkouts and reads Midtrans HTTP notifications
+// (set the notification URL in the dashboard to /api/payments/webhook/midtrans)
+type Midtrans struct {
+	ServerKey  string
+	Production bool
+	Client     *http.Client
+}
+
+// NewMidtrans returns a Midtrans provider using serverKey
+func NewMidtrans(serverKey string, production bool) *Midtrans {
+	return &Midtrans{ServerKey: serverKey, Production: production, Client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (m *Midtrans) Name() string { return "midtrans" }
+
+func (m *Midtrans) CreateCharge(ctx context.Context, req ChargeRequest) (*Charge, error) {
+	minutes := int(req.Expiry / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	name := req.Description
+	if len(name) > 50 { // Midtrans rejects longer item names
+		name = name[:50]
+	}
+
+	body := map[string]interface{}{
+		"transaction_details": map[string]interface{}{"order_id": req.OrderID, "gross_amount": req.Amount},
+		"item_details":        []map[string]interface{}{{"id": req.OrderID, "price": req.Amount, "quantity": 1, "name": name}},
+		"customer_details":    map[string]interface{}{"first_name": req.CustomerName, "email": req.CustomerEmail},
+		"expiry":              map[string]interface{}{"unit": "minutes", "duration": minutes},
+	}
+	if req.ReturnURL != "" {
+		body["callbacks"] = map[string]string{"finish": req.ReturnURL}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := midtransSandboxURL
+	if m.Production {
+		endpoint = midtransProductionURL
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.SetBasicAuth(m.ServerKey, "")
+
+	client := m.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	expiresAt := time.Now().Add(time.Duration(minutes) * time.Minute)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("midtrans returned %s: %s", resp.Status, bytes.TrimSpace(raw))
+	}
+
+	var snap struct {
+		Token       string `json:"token"`
+		RedirectURL string `json:"redirect_url"`
+	}
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		return nil, fmt.Errorf("midtrans: %w", err)
+	}
+	if snap.RedirectURL == "" {
+		return nil, fmt.Errorf("midtrans: no redirect_url in response")
+	}
+	return &Charge{ProviderRef: snap.Token, PaymentURL: snap.RedirectURL, ExpiresAt: expiresAt}, nil
+}
+
+// midtransNotification is the body of a Midtrans HTTP notification
+type midtransNotification struct {
+	OrderID           string `json:"order_id"`
+	TransactionID     string `json:"transaction_id"`
+	TransactionStatus string `json:"transaction_status"`
+	FraudStatus       string `json:"fraud_status"`
+	StatusCode        string `json:"status_code"`
+	GrossAmount       string `json:"gross_amount"` // e.g. "150000.00"
+	SignatureKey      string `json:"signature_key"`
+}
+
+// ParseWebhook checks signature_key = SHA512(order_id + status_code +
+// gross_amount + server key) and maps transaction_status
+func (m *Midtrans) ParseWebhook(r *http.Request) (*Event, error) {
+	var n midtransNotification
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxWebhookBody)).Decode(&n); err != nil {
+		return nil, fmt.Errorf("midtrans: %w", err)
+	}
+
+	sum := sha512.Sum512([]byte(n.OrderID + n.StatusCode + n.GrossAmount + m.ServerKey))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(n.SignatureKey))) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	amount, err := strconv.ParseFloat(n.GrossAmount, 64)
+	if err != nil {
+		return nil, fmt.Errorf("midtrans: invalid gross_amount %q", n.GrossAmount)
+	}
+
+	e := &Event{OrderID: n.OrderID, ProviderRef: n.TransactionID, Amount: int(amount), Status: StatusPending}
+	switch n.TransactionStatus {
+	case "settlement":
+		e.Status = StatusPaid
+	case "capture": // Card payments; "challenge" stays pending until reviewed
+		if n.FraudStatus == "accept" {
+			e.Status = StatusPaid
+		}
+	case "deny", "cancel", "failure":
+		e.Status = StatusFailed
+	case "expire":
+		e.Status = StatusExpired
+	}
+	return e, nil
+}
+
+// Refund calls the Core API refund endpoint. Midtrans answers HTTP 200 for
+// refused refunds too; the outcome is in status_code.
+func (m *Midtrans) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"refund_key": req.RefundKey,
+		"amount":     req.Amount,
+		"reason":     req.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	base := midtransSandboxAPIURL
+	if m.Production {
+		base = midtransProductionAPIURL
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/"+url.PathEscape(req.OrderID)+"/refund", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.SetBasicAuth(m.ServerKey, "")
+
+	client := m.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookBody))
+	var result struct {
+		StatusCode    string `json:"status_code"`
+		StatusMessage string `json:"status_message"`
+		TransactionID string `json:"transaction_id"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("midtrans returned %s: %s", resp.Status, bytes.TrimSpace(raw))
+	}
+	if result.StatusCode != "200" {
+		return nil, fmt.Errorf("midtrans refused refund (%s): %s", result.StatusCode, result.StatusMessage)
+	}
+	return &RefundResult{ProviderRef: result.TransactionID}, nil
+}
@@ -0,0 +1,66 @@
+// Package payments creates charges and refunds at a payment provider and
+// reads the provider's payment notifications. Handlers only see the Provider
+// interface; Midtrans is the production implementation and Fake stands in
+// for it locally (see tools/fakepay).
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Payment statuses, as stored in payments.status
+const (
+	StatusPending = "pending"
+	StatusPaid    = "paid"
+	StatusFailed  = "failed"
+	StatusExpired = "expired"
+)
+
+// ErrInvalidSignature is returned by ParseWebhook for notifications that
+// were not sent by the provider
+var ErrInvalidSignature = errors.New("payments: invalid webhook signature")
+
+// ChargeRequest asks the provider for a checkout of Amount rupiah
+type ChargeRequest struct {
+	OrderID       string // Unique per attempt; the provider reports back with it
+	Amount        int
+	Description   string
+	CustomerName  string
+	CustomerEmail string // Optional
+	ReturnURL     string // Where the checkout sends the client afterwards (optional)
+	Expiry        time.Duration
+}
+
+// Charge is a checkout created at the provider
+type Charge struct {
+	ProviderRef string
+	PaymentURL  string
+	ExpiresAt   time.Time
+}
+
+// Event is a verified payment notification
+type Event struct {
+	OrderID     string
+	ProviderRef string
+	Status      string // One of the Status* constants
+	Amount      int
+}
+
+// Provider is a payment provider
+type Provider interface {
+	Name() string
+	CreateCharge(ctx context.Context, req ChargeRequest) (*Charge, error)
+	// ParseWebhook verifies and decodes a payment notification. Statuses
+	// the provider reports that don't change anything here (e.g. a
+	// challenge still under review) come back as StatusPending.
+	ParseWebhook(r *http.Request) (*Event, error)
+	// Refund returns (part of) a paid order. It returns an error unless
+	// the provider accepted the refund.
+	Refund(ctx context.Context, req RefundRequest) (*RefundResult, error)
+}
+
+// maxWebhookBody limits notification bodies read by providers
+const maxWebhookBody = 64 * 1024
@@ -0,0 +1,76 @@
+package payments
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RefundRequest asks the provider to return Amount rupiah of a paid order
+type RefundRequest struct {
+	OrderID   string
+	RefundKey string // Unique per refund; retrying with the same key refunds once
+	Amount    int
+	Reason    string
+}
+
+// RefundResult is a refund accepted by the provider
+type RefundResult struct {
+	ProviderRef string
+}
+
+// RefundTier refunds Percent of the price when at least Notice is given
+// before the session
+type RefundTier struct {
+	Notice  time.Duration
+	Percent int
+}
+
+// RefundPolicy is a list of tiers, most notice first. Less notice than
+// the last tier refunds nothing.
+type RefundPolicy []RefundTier
+
+// DefaultRefundPolicy: full refund from 24 hours before the session, half
+// from 6 hours, nothing after that
+var DefaultRefundPolicy = RefundPolicy{
+	{Notice: 24 * time.Hour, Percent: 100},
+	{Notice: 6 * time.Hour, Percent: 50},
+}
+
+// Percent returns the share of the price refunded for notice
+func (p RefundPolicy) Percent(notice time.Duration) int {
+	for _, t := range p {
+		if notice >= t.Notice {
+			return t.Percent
+		}
+	}
+	return 0
+}
+
+// ParseRefundPolicy parses "24h:100,6h:50" (as used in the environment)
+func ParseRefundPolicy(s string) (RefundPolicy, error) {
+	var p RefundPolicy
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		notice, percent, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("refund policy: %q is not notice:percent", part)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(notice))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("refund policy: invalid notice %q", notice)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(percent))
+		if err != nil || n < 0 || n > 100 {
+			return nil, fmt.Errorf("refund policy: invalid percent %q", percent)
+		}
+		p = append(p, RefundTier{Notice: d, Percent: n})
+	}
+	sort.Slice(p, func(i, j int) bool { return p[i].Notice > p[j].Notice })
+	return p, nil
+}
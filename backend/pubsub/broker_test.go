@@ -0,0 +1,203 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// The same suite runs against every broker. The Redis run needs a local
+// server, e.g. PUBSUB_TEST_REDIS_URL=redis://localhost:6379/15 go test ./pubsub
+func TestMemoryBroker(t *testing.T) {
+	testBroker(t, NewMemoryBroker())
+}
+
+func TestRedisBroker(t *testing.T) {
+	url := os.Getenv("PUBSUB_TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("PUBSUB_TEST_REDIS_URL not set")
+	}
+	b, err := NewRedisBroker(url)
+	if err != nil {
+		t.Fatalf("connect %s: %v", url, err)
+	}
+	defer b.Close()
+	testBroker(t, b)
+}
+
+func testBroker(t *testing.T, b Broker) {
+	// Unique keys so reruns against a shared server don't see old state
+	prefix := fmt.Sprintf("test:%d:", time.Now().UnixNano())
+	ctx := context.Background()
+
+	t.Run("PublishSubscribe", func(t *testing.T) {
+		topic := prefix + "topic"
+		got := make(chan string, 16)
+		sub, err := b.Subscribe(topic, func(payload []byte) { got <- string(payload) })
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+
+		// Redis confirms subscriptions asynchronously: publish until one
+		// arrives
+		deadline := time.After(5 * time.Second)
+	wait:
+		for {
+			if err := b.Publish(ctx, topic, []byte("hello")); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+			select {
+			case payload := <-got:
+				if payload != "hello" {
+					t.Fatalf("payload = %q, want hello", payload)
+				}
+				break wait
+			case <-time.After(50 * time.Millisecond):
+			case <-deadline:
+				t.Fatal("no message received")
+			}
+		}
+
+		sub.Close()
+		time.Sleep(100 * time.Millisecond)
+		for len(got) > 0 {
+			<-got
+		}
+		b.Publish(ctx, topic, []byte("after close"))
+		select {
+		case payload := <-got:
+			t.Fatalf("received %q after Close", payload)
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+
+	t.Run("Sets", func(t *testing.T) {
+		key := prefix + "set"
+		tests := []struct {
+			op     string
+			member string
+			want   int64
+		}{
+			{"add", "a", 1},
+			{"add", "b", 2},
+			{"add", "a", 2}, // Refresh, not a new member
+			{"remove", "a", 1},
+			{"remove", "missing", 1},
+			{"remove", "b", 0},
+		}
+		for _, tt := range tests {
+			var got int64
+			var err error
+			if tt.op == "add" {
+				got, err = b.SetAdd(ctx, key, tt.member, time.Minute)
+			} else {
+				got, err = b.SetRemove(ctx, key, tt.member)
+			}
+			if err != nil {
+				t.Fatalf("%s %s: %v", tt.op, tt.member, err)
+			}
+			if got != tt.want {
+				t.Errorf("%s %s: size = %d, want %d", tt.op, tt.member, got, tt.want)
+			}
+		}
+
+		b.SetAdd(ctx, key, "y", time.Minute)
+		b.SetAdd(ctx, key, "x", time.Minute)
+		members, err := b.SetMembers(ctx, key)
+		if err != nil {
+			t.Fatalf("SetMembers: %v", err)
+		}
+		sort.Strings(members)
+		if fmt.Sprint(members) != "[x y]" {
+			t.Errorf("SetMembers = %v, want [x y]", members)
+		}
+	})
+
+	t.Run("SetMemberExpiry", func(t *testing.T) {
+		key := prefix + "expiring"
+		b.SetAdd(ctx, key, "stale", 200*time.Millisecond)
+		b.SetAdd(ctx, key, "live", 200*time.Millisecond)
+		time.Sleep(120 * time.Millisecond)
+		b.SetAdd(ctx, key, "live", 200*time.Millisecond) // Heartbeat
+		time.Sleep(120 * time.Millisecond)
+
+		members, err := b.SetMembers(ctx, key)
+		if err != nil {
+			t.Fatalf("SetMembers: %v", err)
+		}
+		if fmt.Sprint(members) != "[live]" {
+			t.Errorf("SetMembers = %v, want [live]", members)
+		}
+		if n, _ := b.SetAdd(ctx, key, "new", time.Minute); n != 2 {
+			t.Errorf("size after expiry = %d, want 2", n)
+		}
+	})
+
+	t.Run("Values", func(t *testing.T) {
+		key := prefix + "value"
+		if _, err := b.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get missing: err = %v, want ErrNotFound", err)
+		}
+		if err := b.Set(ctx, key, []byte("v1"), time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if v, err := b.Get(ctx, key); err != nil || string(v) != "v1" {
+			t.Fatalf("Get = %q, %v; want v1", v, err)
+		}
+		if err := b.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := b.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get deleted: err = %v, want ErrNotFound", err)
+		}
+
+		b.Set(ctx, key, []byte("short"), 100*time.Millisecond)
+		time.Sleep(200 * time.Millisecond)
+		if _, err := b.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get expired: err = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("CompareAndSet", func(t *testing.T) {
+		key := prefix + "cas"
+		tests := []struct {
+			name       string
+			old, value string
+			missing    bool // Expect no value (old = nil)
+			want       bool
+			wantValue  string
+		}{
+			{name: "create", missing: true, value: "v1", want: true, wantValue: "v1"},
+			{name: "create again", missing: true, value: "v2", want: false, wantValue: "v1"},
+			{name: "stale old value", old: "v0", value: "v2", want: false, wantValue: "v1"},
+			{name: "current old value", old: "v1", value: "v2", want: true, wantValue: "v2"},
+		}
+		for _, tt := range tests {
+			var old []byte
+			if !tt.missing {
+				old = []byte(tt.old)
+			}
+			ok, err := b.CompareAndSet(ctx, key, old, []byte(tt.value), time.Minute)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if ok != tt.want {
+				t.Errorf("%s: stored = %v, want %v", tt.name, ok, tt.want)
+			}
+			if v, _ := b.Get(ctx, key); string(v) != tt.wantValue {
+				t.Errorf("%s: value = %q, want %q", tt.name, v, tt.wantValue)
+			}
+		}
+
+		// An expired value counts as missing
+		b.Set(ctx, key, []byte("short"), 100*time.Millisecond)
+		time.Sleep(200 * time.Millisecond)
+		if ok, err := b.CompareAndSet(ctx, key, nil, []byte("fresh"), time.Minute); err != nil || !ok {
+			t.Errorf("create after expiry = %v, %v", ok, err)
+		}
+	})
+}
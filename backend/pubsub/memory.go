@@ -0,0 +1,193 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryBroker keeps everything in process. It is the default for a single
+// backend instance.
+type MemoryBroker struct {
+	mutex    sync.Mutex
+	handlers map[string]map[*memorySubscription]Handler
+	sets     map[string]*memorySet
+	values   map[string]memoryValue
+}
+
+type memorySet struct {
+	members map[string]time.Time // Member -> expiry
+}
+
+type memoryValue struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+type memorySubscription struct {
+	broker *MemoryBroker
+	topic  string
+}
+
+// NewMemoryBroker returns an empty in-process broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		handlers: make(map[string]map[*memorySubscription]Handler),
+		sets:     make(map[string]*memorySet),
+		values:   make(map[string]memoryValue),
+	}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	// Copy handlers so they run without the broker lock held
+	b.mutex.Lock()
+	handlers := make([]Handler, 0, len(b.handlers[topic]))
+	for _, h := range b.handlers[topic] {
+		handlers = append(handlers, h)
+	}
+	b.mutex.Unlock()
+
+	for _, h := range handlers {
+		h(payload)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(topic string, handler Handler) (Subscription, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	sub := &memorySubscription{broker: b, topic: topic}
+	if b.handlers[topic] == nil {
+		b.handlers[topic] = make(map[*memorySubscription]Handler)
+	}
+	b.handlers[topic][sub] = handler
+	return sub, nil
+}
+
+func (s *memorySubscription) Close() error {
+	b := s.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.handlers[s.topic], s)
+	if len(b.handlers[s.topic]) == 0 {
+		delete(b.handlers, s.topic)
+	}
+	return nil
+}
+
+// set returns the set at key without its expired members, or nil if none
+// are left. Caller must hold the lock.
+func (b *MemoryBroker) set(key string) *memorySet {
+	s, ok := b.sets[key]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	for m, expiresAt := range s.members {
+		if now.After(expiresAt) {
+			delete(s.members, m)
+		}
+	}
+	if len(s.members) == 0 {
+		delete(b.sets, key)
+		return nil
+	}
+	return s
+}
+
+func (b *MemoryBroker) SetAdd(ctx context.Context, key, member string, ttl time.Duration) (int64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := b.set(key)
+	if s == nil {
+		s = &memorySet{members: make(map[string]time.Time)}
+		b.sets[key] = s
+	}
+	s.members[member] = time.Now().Add(ttl)
+	return int64(len(s.members)), nil
+}
+
+func (b *MemoryBroker) SetRemove(ctx context.Context, key, member string) (int64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := b.set(key)
+	if s == nil {
+		return 0, nil
+	}
+	delete(s.members, member)
+	if len(s.members) == 0 {
+		delete(b.sets, key)
+	}
+	return int64(len(s.members)), nil
+}
+
+func (b *MemoryBroker) SetMembers(ctx context.Context, key string) ([]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := b.set(key)
+	if s == nil {
+		return []string{}, nil
+	}
+	members := make([]string, 0, len(s.members))
+	for m := range s.members {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func (b *MemoryBroker) Get(ctx context.Context, key string) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	v, ok := b.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if time.Now().After(v.expiresAt) {
+		delete(b.values, key)
+		return nil, ErrNotFound
+	}
+	return v.data, nil
+}
+
+func (b *MemoryBroker) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.values[key] = memoryValue{data: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (b *MemoryBroker) CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	v, ok := b.values[key]
+	if ok && time.Now().After(v.expiresAt) {
+		ok = false
+	}
+	if ok != (old != nil) || (ok && !bytes.Equal(v.data, old)) {
+		return false, nil
+	}
+	b.values[key] = memoryValue{data: value, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (b *MemoryBroker) Delete(ctx context.Context, key string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.values, key)
+	delete(b.sets, key)
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
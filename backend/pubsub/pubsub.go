@@ -0,0 +1,67 @@
+// Package pubsub abstracts the state shared between backend instances:
+// message fan-out (publish/subscribe), set membership (who is in which
+// room) and small keyed values with expiry. A single instance uses the
+// in-memory broker; several instances behind a load balancer share a Redis
+// broker.
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get for missing or expired keys
+var ErrNotFound = errors.New("pubsub: key not found")
+
+// Handler receives every payload published on a subscribed topic
+type Handler func(payload []byte)
+
+// Subscription is an active subscription; Close stops delivery
+type Subscription interface {
+	Close() error
+}
+
+// Broker is the shared backend used by the signaling hub and notifications
+type Broker interface {
+	// Publish sends payload to every subscriber of topic on any instance
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe calls handler for each payload published on topic
+	Subscribe(topic string, handler Handler) (Subscription, error)
+
+	// SetAdd adds member to the set at key, or refreshes it, and returns
+	// the set size. The member expires after ttl unless added again, so
+	// members of an instance that died without cleaning up disappear on
+	// their own. Members of one key should share the same ttl.
+	SetAdd(ctx context.Context, key, member string, ttl time.Duration) (int64, error)
+	// SetRemove removes member and returns the remaining set size
+	SetRemove(ctx context.Context, key, member string) (int64, error)
+	// SetMembers lists the members of the set at key
+	SetMembers(ctx context.Context, key string) ([]string, error)
+
+	// Get returns the value at key or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value at key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// CompareAndSet stores value at key for ttl only if the key still holds
+	// old (nil: the key must be missing or expired), atomically across
+	// instances. It reports whether value was stored.
+	CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error)
+	// Delete removes key
+	Delete(ctx context.Context, key string) error
+
+	Close() error
+}
+
+// New returns the broker for url: "" or "memory://" for the in-memory
+// broker, "redis://..." for Redis.
+func New(url string) (Broker, error) {
+	switch {
+	case url == "" || url == "memory://":
+		return NewMemoryBroker(), nil
+	case strings.HasPrefix(url, "redis://") || strings.HasPrefix(url, "rediss://"):
+		return NewRedisBroker(url)
+	}
+	return nil, errors.New("pubsub: unsupported broker url " + url)
+}
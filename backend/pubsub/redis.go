@@ -0,0 +1,196 @@
+package pubsub
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBroker shares state between backend instances through Redis.
+// All subscriptions of an instance share one Redis pub/sub connection.
+type RedisBroker struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+
+	mutex    sync.Mutex
+	handlers map[string]map[*redisSubscription]Handler
+	done     chan struct{}
+}
+
+type redisSubscription struct {
+	broker *RedisBroker
+	topic  string
+}
+
+// NewRedisBroker connects to the Redis server at url (redis://host:port/db)
+func NewRedisBroker(url string) (*RedisBroker, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	b := &RedisBroker{
+		client:   client,
+		pubsub:   client.Subscribe(context.Background()),
+		handlers: make(map[string]map[*redisSubscription]Handler),
+		done:     make(chan struct{}),
+	}
+	go b.receive()
+	return b, nil
+}
+
+// receive dispatches incoming pub/sub messages to local handlers
+func (b *RedisBroker) receive() {
+	ch := b.pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			b.mutex.Lock()
+			handlers := make([]Handler, 0, len(b.handlers[msg.Channel]))
+			for _, h := range b.handlers[msg.Channel] {
+				handlers = append(handlers, h)
+			}
+			b.mutex.Unlock()
+
+			for _, h := range handlers {
+				h([]byte(msg.Payload))
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	return b.client.Publish(ctx, topic, payload).Err()
+}
+
+func (b *RedisBroker) Subscribe(topic string, handler Handler) (Subscription, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.handlers[topic] == nil {
+		if err := b.pubsub.Subscribe(context.Background(), topic); err != nil {
+			return nil, err
+		}
+		b.handlers[topic] = make(map[*redisSubscription]Handler)
+	}
+
+	sub := &redisSubscription{broker: b, topic: topic}
+	b.handlers[topic][sub] = handler
+	return sub, nil
+}
+
+func (s *redisSubscription) Close() error {
+	b := s.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.handlers[s.topic], s)
+	if len(b.handlers[s.topic]) == 0 {
+		delete(b.handlers, s.topic)
+		return b.pubsub.Unsubscribe(context.Background(), s.topic)
+	}
+	return nil
+}
+
+// Sets are sorted sets scored by each member's expiry (unix milliseconds);
+// expired members are pruned on every write and skipped on reads.
+
+func unixMilli(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+func (b *RedisBroker) SetAdd(ctx context.Context, key, member string, ttl time.Duration) (int64, error) {
+	now := time.Now()
+	var card *redis.IntCmd
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", unixMilli(now))
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(ttl).UnixMilli()), Member: member})
+		pipe.PExpire(ctx, key, ttl) // The newest member expires last
+		card = pipe.ZCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return card.Val(), nil
+}
+
+func (b *RedisBroker) SetRemove(ctx context.Context, key, member string) (int64, error) {
+	var card *redis.IntCmd
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, key, member)
+		pipe.ZRemRangeByScore(ctx, key, "-inf", unixMilli(time.Now()))
+		card = pipe.ZCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return card.Val(), nil
+}
+
+func (b *RedisBroker) SetMembers(ctx context.Context, key string) ([]string, error) {
+	return b.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: "(" + unixMilli(time.Now()), Max: "+inf"}).Result()
+}
+
+func (b *RedisBroker) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := b.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (b *RedisBroker) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return b.client.Set(ctx, key, value, ttl).Err()
+}
+
+// compareAndSet is CompareAndSet in one server-side step. ARGV: whether
+// an old value is expected, the old value, the new value, ttl in ms.
+var compareAndSet = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if ARGV[1] == '1' then
+	if cur ~= ARGV[2] then return 0 end
+elseif cur then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+return 1`)
+
+func (b *RedisBroker) CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	expected := "0"
+	if old != nil {
+		expected = "1"
+	}
+	n, err := compareAndSet.Run(ctx, b.client, []string{key}, expected, old, value, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (b *RedisBroker) Delete(ctx context.Context, key string) error {
+	return b.client.Del(ctx, key).Err()
+}
+
+func (b *RedisBroker) Close() error {
+	close(b.done)
+	if err := b.pubsub.Close(); err != nil {
+		log.Println("[PUBSUB] Failed to close subscription:", err)
+	}
+	return b.client.Close()
+}
@@ -0,0 +1,230 @@
+// Package questionnaire holds standardized intake questionnaires (PHQ-9,
+// GAD-7, ...) and scores a client's answers into a severity band.
+//
+// Definitions are JSON files: the built-in ones are embedded from
+// definitions/, and more can be loaded from a directory at startup.
+package questionnaire
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+//go:embed definitions/*.json
+var builtinFS embed.FS
+
+// Option is one answer choice; every item shares the same options
+type Option struct {
+	Value   int    `json:"value"`
+	Label   string `json:"label"`
+	LabelEN string `json:"label_en"`
+}
+
+// Item is one question
+type Item struct {
+	ID     string `json:"id"`
+	Text   string `json:"text"`
+	TextEN string `json:"text_en"`
+
+	// RiskMin flags a crisis risk when the answer is at least this value
+	// (e.g. PHQ-9 item 9, thoughts of self-harm). Zero never flags.
+	RiskMin int `json:"risk_min,omitempty"`
+}
+
+// Band maps a total score range (inclusive) to a severity
+type Band struct {
+	Min      int    `json:"min"`
+	Max      int    `json:"max"`
+	Severity string `json:"severity"` // e.g. minimal, mild, moderate, severe
+	Label    string `json:"label"`
+	LabelEN  string `json:"label_en"`
+}
+
+// Definition is a questionnaire and how it is scored: the sum of the
+// answers, looked up in Bands
+type Definition struct {
+	Code           string   `json:"code"`    // e.g. phq9
+	Version        int      `json:"version"` // Bumped when items or bands change
+	Name           string   `json:"name"`    // e.g. PHQ-9
+	Title          string   `json:"title"`
+	TitleEN        string   `json:"title_en"`
+	Instructions   string   `json:"instructions"`
+	InstructionsEN string   `json:"instructions_en"`
+	Options        []Option `json:"options"`
+	Items          []Item   `json:"items"`
+	Bands          []Band   `json:"bands"`
+}
+
+// Result is a scored set of answers
+type Result struct {
+	Code     string
+	Version  int
+	Answers  []int // One per item, in item order
+	Score    int
+	MaxScore int
+	Band     Band
+
+	RiskItems []int // Indexes of items whose answer reached their RiskMin
+}
+
+// OptionLabel returns the label of the answer value
+func (d *Definition) OptionLabel(value int) string {
+	for _, o := range d.Options {
+		if o.Value == value {
+			return o.Label
+		}
+	}
+	return strconv.Itoa(value)
+}
+
+// MaxScore is the highest possible total
+func (d *Definition) MaxScore() int {
+	highest := 0
+	for _, o := range d.Options {
+		if o.Value > highest {
+			highest = o.Value
+		}
+	}
+	return highest * len(d.Items)
+}
+
+// BandFor returns the band containing score
+func (d *Definition) BandFor(score int) (Band, bool) {
+	for _, b := range d.Bands {
+		if score >= b.Min && score <= b.Max {
+			return b, true
+		}
+	}
+	return Band{}, false
+}
+
+// BandNamed returns the band with the given severity
+func (d *Definition) BandNamed(severity string) (Band, bool) {
+	for _, b := range d.Bands {
+		if b.Severity == severity {
+			return b, true
+		}
+	}
+	return Band{}, false
+}
+
+// Score checks that answers has one allowed value per item and scores it
+func (d *Definition) Score(answers []int) (Result, error) {
+	if len(answers) != len(d.Items) {
+		return Result{}, fmt.Errorf("%s: expected %d answers, got %d", d.Code, len(d.Items), len(answers))
+	}
+	allowed := make(map[int]bool, len(d.Options))
+	for _, o := range d.Options {
+		allowed[o.Value] = true
+	}
+	score := 0
+	var risky []int
+	for i, a := range answers {
+		if !allowed[a] {
+			return Result{}, fmt.Errorf("%s: invalid answer %d to item %d", d.Code, a, i+1)
+		}
+		score += a
+		if min := d.Items[i].RiskMin; min > 0 && a >= min {
+			risky = append(risky, i)
+		}
+	}
+	band, _ := d.BandFor(score) // validate guarantees a band
+	return Result{
+		Code:      d.Code,
+		Version:   d.Version,
+		Answers:   append([]int(nil), answers...),
+		Score:     score,
+		MaxScore:  d.MaxScore(),
+		Band:      band,
+		RiskItems: risky,
+	}, nil
+}
+
+// validate checks that the definition can score every possible total
+func (d *Definition) validate() error {
+	if d.Code == "" {
+		return errors.New("missing code")
+	}
+	if len(d.Items) == 0 || len(d.Options) == 0 {
+		return fmt.Errorf("%s: needs items and options", d.Code)
+	}
+	for _, o := range d.Options {
+		if o.Value < 0 {
+			return fmt.Errorf("%s: negative option value %d", d.Code, o.Value)
+		}
+	}
+	for score := 0; score <= d.MaxScore(); score++ {
+		if _, ok := d.BandFor(score); !ok {
+			return fmt.Errorf("%s: no band for score %d", d.Code, score)
+		}
+	}
+	return nil
+}
+
+var registry = map[string]*Definition{}
+
+func init() {
+	files, _ := builtinFS.ReadDir("definitions")
+	for _, f := range files {
+		src, err := builtinFS.ReadFile("definitions/" + f.Name())
+		if err == nil {
+			err = register(src)
+		}
+		if err != nil {
+			panic("questionnaire: built-in " + f.Name() + ": " + err.Error())
+		}
+	}
+}
+
+func register(src []byte) error {
+	var d Definition
+	if err := json.Unmarshal(src, &d); err != nil {
+		return err
+	}
+	if err := d.validate(); err != nil {
+		return err
+	}
+	registry[d.Code] = &d
+	return nil
+}
+
+// LoadDir registers every *.json definition in dir, replacing built-ins
+// with the same code. Call it at startup, before serving requests.
+func LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err == nil {
+			err = register(src)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+// Get returns the definition with the given code
+func Get(code string) (*Definition, bool) {
+	d, ok := registry[code]
+	return d, ok
+}
+
+// All returns every definition, ordered by code
+func All() []*Definition {
+	defs := make([]*Definition, 0, len(registry))
+	for _, d := range registry {
+		defs = append(defs, d)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Code < defs[j].Code })
+	return defs
+}
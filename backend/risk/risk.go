@@ -0,0 +1,126 @@
+// Package risk screens what a client writes and answers when booking for
+// signs of a crisis (suicidal thoughts, self-harm).
+//
+// The screen errs on the side of flagging: a keyword in a negated sentence
+// still flags, because every flagged booking is reviewed by a person and a
+// missed crisis costs far more than a false alarm.
+package risk
+
+import (
+	"counseling-webrtc/questionnaire"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Default keywords (Indonesian and English) and crisis hotlines
+//
+//go:embed rules.json
+var defaultRules []byte
+
+// Risk levels stored on bookings
+const (
+	LevelNone = "none"
+	LevelHigh = "high"
+)
+
+// Hotline is a crisis service shown to flagged clients
+type Hotline struct {
+	Name        string `json:"name"`
+	Phone       string `json:"phone"`
+	Description string `json:"description,omitempty"`
+}
+
+// Rules are the phrases that flag free text and the hotlines to show
+type Rules struct {
+	Keywords []string  `json:"keywords"`
+	Hotlines []Hotline `json:"hotlines"`
+}
+
+// Assessment is the outcome of a screen
+type Assessment struct {
+	Level   string
+	Reasons []string // Why it was flagged, for the psychologist
+}
+
+// High reports whether the booking needs urgent attention
+func (a Assessment) High() bool {
+	return a.Level == LevelHigh
+}
+
+var rules Rules
+
+func init() {
+	if err := setRules(defaultRules); err != nil {
+		panic("risk: built-in rules: " + err.Error())
+	}
+}
+
+func setRules(src []byte) error {
+	var r Rules
+	if err := json.Unmarshal(src, &r); err != nil {
+		return err
+	}
+	if len(r.Keywords) == 0 || len(r.Hotlines) == 0 {
+		return errors.New("rules need keywords and hotlines")
+	}
+	for i, k := range r.Keywords {
+		r.Keywords[i] = normalize(k)
+	}
+	rules = r
+	return nil
+}
+
+// LoadFile replaces the built-in rules with a JSON file of the same shape
+// as rules.json. Call it at startup, before serving requests.
+func LoadFile(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return setRules(src)
+}
+
+// Hotlines returns the crisis services to show a flagged client
+func Hotlines() []Hotline {
+	return rules.Hotlines
+}
+
+// Screen checks free text (the complaint) and scored questionnaires
+func Screen(text string, intake []questionnaire.Result) Assessment {
+	a := Assessment{Level: LevelNone}
+
+	padded := " " + normalize(text) + " "
+	for _, k := range rules.Keywords {
+		if k != "" && strings.Contains(padded, " "+k+" ") {
+			a.Reasons = append(a.Reasons, fmt.Sprintf("Keluhan menyebut %q", k))
+		}
+	}
+
+	for _, r := range intake {
+		def, ok := questionnaire.Get(r.Code)
+		if !ok {
+			continue
+		}
+		for _, i := range r.RiskItems {
+			a.Reasons = append(a.Reasons, fmt.Sprintf("%s butir %d (%s): %s", def.Name, i+1, def.Items[i].Text, def.OptionLabel(r.Answers[i])))
+		}
+	}
+
+	if len(a.Reasons) > 0 {
+		a.Level = LevelHigh
+	}
+	return a
+}
+
+// normalize lowercases s and turns everything but letters and digits into
+// single spaces, so phrases match across punctuation and line breaks
+func normalize(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
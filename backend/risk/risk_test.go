@@ -0,0 +1,89 @@
+package risk
+
+import (
+	"counseling-webrtc/questionnaire"
+	"strings"
+	"testing"
+)
+
+// phq9 scores PHQ-9 answers with the built-in definition
+func phq9(t *testing.T, answers ...int) questionnaire.Result {
+	t.Helper()
+	def, ok := questionnaire.Get("phq9")
+	if !ok {
+		t.Fatal("phq9 is not registered")
+	}
+	r, err := def.Score(answers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestScreen(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		intake      func(t *testing.T) []questionnaire.Result
+		wantReasons []string // Each reason contains this, in order; none means not flagged
+	}{
+		{name: "no match", text: "Saya sulit tidur dan cemas soal pekerjaan"},
+		{name: "indonesian", text: "Akhir-akhir ini saya ingin mati", wantReasons: []string{`"ingin mati"`}},
+		{name: "english", text: "Some days I just want to die", wantReasons: []string{`"want to die"`}},
+		{name: "case and punctuation", text: "Saya sering berpikir BUNUH-DIRI!!!", wantReasons: []string{`"bunuh diri"`}},
+		{name: "phrase across a line break", text: "Rasanya ingin\nmengakhiri\r\nhidup saja", wantReasons: []string{`"mengakhiri hidup"`}},
+		{name: "longer phrase only", text: "ingin mengakhiri hidupku", wantReasons: []string{`"mengakhiri hidupku"`}},
+		{name: "several phrases", text: "I feel suicidal and hurt myself", wantReasons: []string{`"suicidal"`, `"hurt myself"`}},
+		{name: "negated still flags", text: "Saya tidak pernah melukai diri", wantReasons: []string{`"melukai diri"`}},
+		{name: "part of a word", text: "We studied suicidality at work"},
+		{name: "empty", text: ""},
+		{
+			name: "phq9 item 9",
+			intake: func(t *testing.T) []questionnaire.Result {
+				return []questionnaire.Result{phq9(t, 0, 0, 0, 0, 0, 0, 0, 0, 1)}
+			},
+			wantReasons: []string{"PHQ-9 butir 9"},
+		},
+		{
+			name: "phq9 high without item 9",
+			intake: func(t *testing.T) []questionnaire.Result {
+				return []questionnaire.Result{phq9(t, 3, 3, 3, 3, 3, 3, 3, 3, 0)}
+			},
+		},
+		{
+			name: "text and questionnaire",
+			text: "kill myself",
+			intake: func(t *testing.T) []questionnaire.Result {
+				return []questionnaire.Result{phq9(t, 1, 1, 1, 1, 1, 1, 1, 1, 3)}
+			},
+			wantReasons: []string{`"kill myself"`, "PHQ-9 butir 9"},
+		},
+		{
+			name: "unknown questionnaire",
+			intake: func(t *testing.T) []questionnaire.Result {
+				return []questionnaire.Result{{Code: "unknown", RiskItems: []int{0}}}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var intake []questionnaire.Result
+			if tt.intake != nil {
+				intake = tt.intake(t)
+			}
+			a := Screen(tt.text, intake)
+
+			if a.High() != (len(tt.wantReasons) > 0) {
+				t.Errorf("level = %q, reasons %q", a.Level, a.Reasons)
+			}
+			if len(a.Reasons) != len(tt.wantReasons) {
+				t.Fatalf("reasons = %q, want %q", a.Reasons, tt.wantReasons)
+			}
+			for i, want := range tt.wantReasons {
+				if !strings.Contains(a.Reasons[i], want) {
+					t.Errorf("reason %d = %q, want it to contain %q", i, a.Reasons[i], want)
+				}
+			}
+		})
+	}
+}
@@ -11,10 +11,26 @@ func RegisterRoutes(r *gin.Engine) {
 	{
 		public.GET("/categories", handlers.GetCategories)
 		public.GET("/psychologists", handlers.GetPsychologists)
+		public.GET("/psychologists/:id/slots", handlers.GetAvailableSlots)
+		public.GET("/psychologists/:id/packages", handlers.GetPsychologistPackages)
+		public.POST("/packages/:id/purchase", handlers.PurchasePackage) // Returns the checkout
+		public.GET("/credits", handlers.GetClientCredits)
+		public.GET("/sponsorship", handlers.GetSponsorship)                   // ?email=&code= or &email_code=; yes/no only
+		public.POST("/sponsorship/email-code", handlers.SendSponsorEmailCode) // Mails a code to a sponsored email domain
+		public.GET("/questionnaires", handlers.GetQuestionnaires)
 		public.POST("/booking", handlers.CreateBooking)
 		public.GET("/my-bookings", handlers.GetClientBookings)
 		public.POST("/login", handlers.ClientLogin)
 		public.GET("/room-status/:roomId", handlers.CheckRoomStatus) // New: Check if room is still valid
+		public.GET("/bookings/:id/chat", handlers.GetClientChatHistory)
+		public.GET("/bookings/:id/calendar.ics", handlers.DownloadBookingICS)
+		public.POST("/bookings/:id/payment", handlers.CreateBookingPayment) // Start or resume checkout
+		public.GET("/bookings/:id/payment", handlers.GetBookingPayment)
+		public.GET("/bookings/:id/cancel", handlers.GetCancellationQuote) // Refund and fee if cancelled now
+		public.GET("/bookings/:id/invoice.pdf", handlers.DownloadBookingInvoice)
+		public.POST("/bookings/:id/cancel", handlers.CancelBooking)
+		public.POST("/waiting-room/:roomId", handlers.EnterWaitingRoom)
+		public.GET("/waiting-room/:roomId", handlers.GetWaitingRoomStatus)
 	}
 
 	expert := r.Group("/api/expert")
@@ -24,6 +40,49 @@ func RegisterRoutes(r *gin.Engine) {
 		expert.DELETE("/bookings/:id/reject", handlers.RejectBooking)  // Reject & delete booking
 		expert.PUT("/bookings/:id/notes", handlers.UpdateSessionNotes) // New Notes Endpoint
 		expert.POST("/schedule", handlers.UpdatePsychologistSchedule)  // New Endpoint
+		expert.GET("/bookings/:id/chat", handlers.GetExpertChatHistory)
+		expert.GET("/waiting-room", handlers.GetWaitingClients)
+		expert.PUT("/waiting-room/:roomId", handlers.DecideAdmission) // Admit or deny the waiting client
+		expert.GET("/calendar-sources", handlers.GetCalendarSources)
+		expert.POST("/calendar-sources", handlers.AddCalendarSource)
+		expert.POST("/calendar-sources/:id/sync", handlers.SyncCalendarSource)
+		expert.DELETE("/calendar-sources/:id", handlers.DeleteCalendarSource)
+		expert.GET("/session-types", handlers.GetExpertSessionTypes)
+		expert.POST("/session-types", handlers.CreateSessionType)
+		expert.PUT("/session-types/:id", handlers.UpdateSessionType)
+		expert.DELETE("/session-types/:id", handlers.DeleteSessionType) // Retires the type
+		expert.GET("/packages", handlers.GetExpertPackages)
+		expert.POST("/packages", handlers.CreatePackage)
+		expert.PUT("/packages/:id", handlers.UpdatePackage)
+		expert.DELETE("/packages/:id", handlers.DeletePackage) // Retires the package
+		expert.GET("/license", handlers.GetLicenseNumber)
+		expert.PUT("/license", handlers.UpdateLicenseNumber) // SIPP printed on invoices
+		expert.GET("/earnings", handlers.GetExpertEarnings)  // ?from=&to=, default this month
+		expert.GET("/earnings/statement.csv", handlers.DownloadEarningsCSV)
+		expert.GET("/earnings/statement.pdf", handlers.DownloadEarningsPDF)
+	}
+
+	admin := r.Group("/api/admin", handlers.AdminAuth())
+	{
+		admin.GET("/webhooks", handlers.ListWebhooks)
+		admin.POST("/webhooks", handlers.CreateWebhook)
+		admin.PUT("/webhooks/:id", handlers.UpdateWebhook)
+		admin.DELETE("/webhooks/:id", handlers.DeleteWebhook)
+		admin.GET("/webhooks/:id/deliveries", handlers.GetWebhookDeliveries)
+		admin.POST("/webhook-deliveries/:id/replay", handlers.ReplayWebhookDelivery)
+		admin.GET("/refunds", handlers.ListRefunds)
+		admin.POST("/refunds/:id/retry", handlers.RetryRefund)
+		admin.GET("/invoices", handlers.ListInvoices)
+		admin.GET("/invoices/:id/pdf", handlers.DownloadInvoice)
+		admin.GET("/sponsors", handlers.ListSponsors)
+		admin.POST("/sponsors", handlers.CreateSponsor)
+		admin.PUT("/sponsors/:id", handlers.UpdateSponsor)
+		admin.POST("/sponsors/:id/report-token", handlers.RotateSponsorReportToken)
+		admin.GET("/sponsors/:id/report", handlers.GetSponsorReportAdmin)
+		admin.GET("/earnings", handlers.ListEarnings)
+		admin.GET("/payouts", handlers.ListPayouts)
+		admin.POST("/payouts", handlers.CreatePayout)
+		admin.GET("/high-risk-bookings", handlers.ListHighRiskBookings) // ?status=, flagged by crisis screening
 	}
 
 	api := r.Group("/api")
@@ -32,6 +91,22 @@ func RegisterRoutes(r *gin.Engine) {
 		// api.GET("/waiting-room", handlers.WaitingRoomStatus) // Legacy
 		// api.GET("/signal", handlers.Signaling) // Legacy
 		api.GET("/ws", handlers.WebSocketHandler)
-		api.GET("/notify", handlers.NotificationHandler) // New Notification WS
+		api.GET("/notify", handlers.NotificationHandler)              // New Notification WS
+		api.GET("/notify/stream", handlers.NotificationStreamHandler) // SSE fallback for /notify
+		api.GET("/notifications", handlers.GetNotifications)
+		api.PUT("/notifications/read-all", handlers.MarkAllNotificationsRead)
+		api.PUT("/notifications/:id/read", handlers.MarkNotificationRead)
+		api.GET("/notification-preferences", handlers.GetNotificationPreferences)
+		api.PUT("/notification-preferences", handlers.UpdateNotificationPreferences)
+		api.GET("/messaging/opt-in", handlers.GetMessagingOptIn)
+		api.PUT("/messaging/opt-in", handlers.UpdateMessagingOptIn)
+		api.GET("/push/vapid-public-key", handlers.GetVAPIDPublicKey)
+		api.POST("/push/subscriptions", handlers.SubscribePush)
+		api.DELETE("/push/subscriptions", handlers.UnsubscribePush)
+		api.POST("/calendar/feed", handlers.CreateCalendarFeed)
+		api.DELETE("/calendar/feed", handlers.DeleteCalendarFeed)
+		api.GET("/calendar/feed/:token", handlers.GetCalendarFeed) // <token>.ics
+		api.POST("/payments/webhook/:provider", handlers.HandlePaymentWebhook)
+		api.GET("/sponsor/report", handlers.GetSponsorReport) // X-Sponsor-Token; aggregates only
 	}
 }
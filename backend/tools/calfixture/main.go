@@ -0,0 +1,126 @@
+// Command calfixture is a local stand-in for a psychologist's personal
+// calendar. It serves the same events as a plain ICS feed and as a CalDAV
+// collection, so calendar busy-time import can be tried without a Google or
+// Nextcloud account:
+//
+//	go run ./tools/calfixture -addr :9091
+//	POST /api/expert/calendar-sources {"email": "...", "kind": "ics", "url": "http://localhost:9091/calendar.ics"}
+//	POST /api/expert/calendar-sources {"email": "...", "kind": "caldav", "url": "http://localhost:9091/caldav/"}
+//
+// Without -file it serves a sample relative to today (WIB): a busy block
+// tomorrow 09:00-11:00, a weekly Monday/Wednesday 13:00-14:00 series, and a
+// free (transparent) and a cancelled event that must not block anything.
+// A -file is served as is, so its time zones, exceptions and overrides
+// reach the importer unchanged.
+package main
+
+import (
+	"bytes"
+	"counseling-webrtc/ical"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":9091", "listen address")
+	file := flag.String("file", "", "serve this .ics file instead of the sample")
+	user := flag.String("user", "", "require basic auth with this user")
+	pass := flag.String("pass", "", "password for -user")
+	flag.Parse()
+
+	wib := time.FixedZone("WIB", 7*60*60)
+	doc := (&ical.Calendar{Name: "Fixture", Events: sampleEvents(wib)}).Bytes()
+	if *file != "" {
+		var err error
+		if doc, err = os.ReadFile(*file); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Refuse to serve what the importer couldn't read either
+	events, err := ical.Parse(bytes.NewReader(doc), wib)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Calendar fixture listening on %s (%d events)\n", *addr, len(events))
+	log.Fatal(http.ListenAndServe(*addr, newHandler(doc, *user, *pass)))
+}
+
+// newHandler serves the calendar document doc as /calendar.ics and
+// /caldav/, behind basic auth when user is set
+func newHandler(doc []byte, user, pass string) http.Handler {
+	auth := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if user != "" {
+				u, p, ok := r.BasicAuth()
+				if !ok || u != user || p != pass {
+					w.Header().Set("WWW-Authenticate", `Basic realm="calfixture"`)
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+			}
+			log.Printf("%s %s", r.Method, r.URL.Path)
+			next(w, r)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/calendar.ics", auth(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Write(doc)
+	}))
+
+	// CalDAV: answers calendar-query REPORTs with the whole calendar as
+	// one resource (servers filter by time-range; returning more is still
+	// valid for a client)
+	mux.HandleFunc("/caldav/", auth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "REPORT" {
+			w.Header().Set("Allow", "REPORT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+		buf.WriteString(`<D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">` + "\n")
+		buf.WriteString("<D:response><D:href>/caldav/calendar.ics</D:href><D:propstat><D:prop><C:calendar-data>")
+		xml.EscapeText(&buf, doc)
+		buf.WriteString("</C:calendar-data></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>\n")
+		buf.WriteString("</D:multistatus>\n")
+
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write(buf.Bytes())
+	}))
+	return mux
+}
+
+func sampleEvents(loc *time.Location) []ical.Event {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	at := func(days, hour int) time.Time { return today.AddDate(0, 0, days).Add(time.Duration(hour) * time.Hour) }
+
+	return []ical.Event{
+		{
+			UID: "fixture-inperson@calfixture", Stamp: now, Summary: "Klien tatap muka",
+			Start: at(1, 9), End: at(1, 11), Status: ical.StatusConfirmed,
+		},
+		{
+			UID: "fixture-weekly@calfixture", Stamp: now, Summary: "Praktik klinik (mingguan)",
+			Start: at(0, 13), End: at(0, 14), RRule: "FREQ=WEEKLY;BYDAY=MO,WE",
+		},
+		{
+			UID: "fixture-free@calfixture", Stamp: now, Summary: "Pengingat (tidak memblokir)",
+			Start: at(1, 14), End: at(1, 15), Transparent: true,
+		},
+		{
+			UID: "fixture-cancelled@calfixture", Stamp: now, Summary: "Janji dibatalkan",
+			Start: at(2, 10), End: at(2, 11), Status: ical.StatusCancelled,
+		},
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"counseling-webrtc/calsync"
+	"counseling-webrtc/ical"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var wib = time.FixedZone("WIB", 7*60*60)
+
+// busy fetches the fixture's calendar the way the importer does and
+// formats the busy blocks in WIB
+func busy(t *testing.T, srv *httptest.Server, kind, user, pass string, from, to time.Time) ([]string, error) {
+	t.Helper()
+	url := srv.URL + "/calendar.ics"
+	if kind == calsync.KindCalDAV {
+		url = srv.URL + "/caldav/"
+	}
+	src := calsync.Source{Kind: kind, URL: url, Username: user, Password: pass}
+	periods, err := calsync.NewFetcher(wib).Busy(context.Background(), src, from, to)
+	var out []string
+	for _, p := range periods {
+		out = append(out, p.Start.In(wib).Format("2006-01-02 15:04")+" - "+p.End.In(wib).Format("2006-01-02 15:04"))
+	}
+	return out, err
+}
+
+func TestSample(t *testing.T) {
+	srv := httptest.NewServer(newHandler((&ical.Calendar{Events: sampleEvents(wib)}).Bytes(), "psikolog", "rahasia"))
+	defer srv.Close()
+
+	now := time.Now().In(wib)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, wib)
+	from, to := today, today.AddDate(0, 0, 7)
+	span := func(days, start, end int) string {
+		day := today.AddDate(0, 0, days)
+		return day.Add(time.Duration(start)*time.Hour).Format("2006-01-02 15:04") + " - " +
+			day.Add(time.Duration(end)*time.Hour).Format("2006-01-02 15:04")
+	}
+
+	// Tomorrow's block and the Monday/Wednesday series; the free and the
+	// cancelled event block nothing
+	var want []string
+	for d := 0; d < 7; d++ {
+		if d == 1 {
+			want = append(want, span(1, 9, 11))
+		}
+		if wd := today.AddDate(0, 0, d).Weekday(); wd == time.Monday || wd == time.Wednesday {
+			want = append(want, span(d, 13, 14))
+		}
+	}
+
+	tests := []struct {
+		name       string
+		kind       string
+		user, pass string
+		wantErr    bool
+	}{
+		{"ics", calsync.KindICS, "psikolog", "rahasia", false},
+		{"caldav", calsync.KindCalDAV, "psikolog", "rahasia", false},
+		{"ics wrong password", calsync.KindICS, "psikolog", "salah", true},
+		{"caldav without auth", calsync.KindCalDAV, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := busy(t, srv, tt.kind, tt.user, tt.pass, from, to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("busy =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+// calendar wraps VEVENT lines in a VCALENDAR
+func calendar(lines ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VCALENDAR\r\n"
+}
+
+func TestFile(t *testing.T) {
+	// Monday 2 to Monday 9 November 2026, WIB
+	from := time.Date(2026, 11, 2, 0, 0, 0, 0, wib)
+	to := from.AddDate(0, 0, 8)
+
+	tests := []struct {
+		name    string
+		doc     string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "utc times and folded lines",
+			doc: calendar("BEGIN:VEVENT", "UID:utc", "SUMMARY:Rapat yang judulnya", "  panjang sekali",
+				"DTSTART:20261102T020000Z", "DTEND:20261102T030000Z", "END:VEVENT"),
+			want: []string{"2026-11-02 09:00 - 2026-11-02 10:00"},
+		},
+		{
+			name: "tzid",
+			doc: calendar("BEGIN:VEVENT", "UID:tokyo",
+				"DTSTART;TZID=Asia/Tokyo:20261103T110000", "DTEND;TZID=Asia/Tokyo:20261103T120000", "END:VEVENT"),
+			want: []string{"2026-11-03 09:00 - 2026-11-03 10:00"},
+		},
+		{
+			name: "floating time is wib",
+			doc:  calendar("BEGIN:VEVENT", "UID:floating", "DTSTART:20261104T080000", "DTEND:20261104T090000", "END:VEVENT"),
+			want: []string{"2026-11-04 08:00 - 2026-11-04 09:00"},
+		},
+		{
+			name: "duration instead of dtend",
+			doc:  calendar("BEGIN:VEVENT", "UID:duration", "DTSTART:20261104T100000", "DURATION:PT1H30M", "END:VEVENT"),
+			want: []string{"2026-11-04 10:00 - 2026-11-04 11:30"},
+		},
+		{
+			name: "all day",
+			doc:  calendar("BEGIN:VEVENT", "UID:allday", "DTSTART;VALUE=DATE:20261105", "END:VEVENT"),
+			want: []string{"2026-11-05 00:00 - 2026-11-06 00:00"},
+		},
+		{
+			name: "alarm does not change the event",
+			doc: calendar("BEGIN:VEVENT", "UID:alarm", "DTSTART:20261106T130000",
+				"BEGIN:VALARM", "TRIGGER:-PT15M", "DURATION:PT5M", "ACTION:DISPLAY", "END:VALARM",
+				"DTEND:20261106T140000", "END:VEVENT"),
+			want: []string{"2026-11-06 13:00 - 2026-11-06 14:00"},
+		},
+		{
+			// 06:00 WIB is the previous day in UTC: BYDAY must still
+			// follow the calendar's own days
+			name: "weekly with an exception and a moved occurrence",
+			doc: calendar("BEGIN:VEVENT", "UID:weekly", "DTSTART:20261102T060000", "DTEND:20261102T070000",
+				"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4", "EXDATE:20261104T060000", "END:VEVENT",
+				"BEGIN:VEVENT", "UID:weekly", "RECURRENCE-ID:20261109T060000",
+				"DTSTART:20261109T070000", "DTEND:20261109T080000", "END:VEVENT"),
+			want: []string{"2026-11-02 06:00 - 2026-11-02 07:00", "2026-11-09 07:00 - 2026-11-09 08:00"},
+		},
+		{
+			name: "cancelled and free events",
+			doc: calendar("BEGIN:VEVENT", "UID:cancelled", "STATUS:CANCELLED", "DTSTART:20261103T100000", "DTEND:20261103T110000", "END:VEVENT",
+				"BEGIN:VEVENT", "UID:free", "TRANSP:TRANSPARENT", "DTSTART:20261103T120000", "DTEND:20261103T130000", "END:VEVENT"),
+		},
+		{
+			name: "outside the window",
+			doc:  calendar("BEGIN:VEVENT", "UID:later", "DTSTART:20261201T100000", "DTEND:20261201T110000", "END:VEVENT"),
+		},
+		{
+			name:    "not a calendar",
+			doc:     "<html>Sign in</html>",
+			wantErr: true,
+		},
+		{
+			name:    "bad date",
+			doc:     calendar("BEGIN:VEVENT", "UID:bad", "DTSTART:2026-11-02", "END:VEVENT"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		for _, kind := range []string{calsync.KindICS, calsync.KindCalDAV} {
+			t.Run(tt.name+"/"+kind, func(t *testing.T) {
+				srv := httptest.NewServer(newHandler([]byte(tt.doc), "", ""))
+				defer srv.Close()
+
+				got, err := busy(t, srv, kind, "", "", from, to)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+				}
+				if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+					t.Errorf("busy =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Command fakegateway is a stand-in for a WhatsApp/SMS provider. It accepts
+// the JSON posted by notify.HTTPGateway and prints every message, so the
+// messaging channel can be tried locally:
+//
+//	go run ./tools/fakegateway -addr :9090
+//	MESSAGING_GATEWAY_URL=http://localhost:9090/messages go run .
+//
+// -fail-first N rejects the first N messages, to watch the outbox retry
+// them.
+package main
+
+import (
+	"counseling-webrtc/notify"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	token := flag.String("token", "", "require this bearer token")
+	fail := flag.Bool("fail", false, "reject every message with 503 (to test error handling)")
+	failFirst := flag.Int("fail-first", 0, "reject the first N messages with 503 (to test retries)")
+	flag.Parse()
+
+	http.Handle("/messages", &gateway{token: *token, fail: *fail, failFirst: *failFirst})
+
+	fmt.Printf("Fake messaging gateway listening on %s\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// gateway accepts posted messages, after failing as configured
+type gateway struct {
+	token     string
+	fail      bool
+	failFirst int
+
+	mutex     sync.Mutex
+	attempts  int                  // Authorized posts, accepted or not
+	delivered []notify.TextMessage // Accepted messages
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if g.token != "" && r.Header.Get("Authorization") != "Bearer "+g.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.attempts++
+	if g.fail || g.attempts <= g.failFirst {
+		http.Error(w, "provider unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var m notify.TextMessage
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.delivered = append(g.delivered, m)
+
+	fmt.Printf("[%s] to=%s template=%q lang=%s\n%s\n\n", m.Channel, m.To, m.TemplateID, m.Language, m.Text)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "queued"})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"counseling-webrtc/notify"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type optIns map[string]bool
+
+func (o optIns) OptedIn(phone, channel string) (bool, error) {
+	return o[phone+"/"+channel], nil
+}
+
+func TestDelivery(t *testing.T) {
+	tests := []struct {
+		name      string
+		recipient string
+		token     string // Sent by the client; the gateway wants "rahasia"
+		fail      bool
+		failFirst int
+		tries     int // Attempts the outbox makes before giving up
+
+		wantErr       bool
+		wantAttempts  int
+		wantDelivered int
+	}{
+		{name: "delivered", recipient: "0812-3456-789", token: "rahasia", tries: 1, wantAttempts: 1, wantDelivered: 1},
+		{name: "international number", recipient: "+62 812 3456 789", token: "rahasia", tries: 1, wantAttempts: 1, wantDelivered: 1},
+		{name: "email contact is skipped", recipient: "klien@example.com", token: "rahasia", tries: 1},
+		{name: "not opted in", recipient: "0813-0000-000", token: "rahasia", tries: 1},
+		{name: "wrong token", recipient: "0812-3456-789", token: "salah", tries: 3, wantErr: true},
+		{name: "retried until the gateway recovers", recipient: "0812-3456-789", token: "rahasia", failFirst: 2, tries: 5, wantAttempts: 3, wantDelivered: 1},
+		{name: "recovers too late", recipient: "0812-3456-789", token: "rahasia", failFirst: 3, tries: 3, wantErr: true, wantAttempts: 3},
+		{name: "gateway down", recipient: "0812-3456-789", token: "rahasia", fail: true, tries: 3, wantErr: true, wantAttempts: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &gateway{token: "rahasia", fail: tt.fail, failFirst: tt.failFirst}
+			srv := httptest.NewServer(g)
+			defer srv.Close()
+
+			ch := notify.NewMessagingChannel(notify.ChannelWhatsApp, notify.NewHTTPGateway(srv.URL, tt.token),
+				optIns{"+628123456789/" + notify.ChannelWhatsApp: true}, map[string]string{"booking_updated": "tpl_booking_updated"})
+			n := notify.Notification{
+				Recipient: tt.recipient,
+				Event:     "booking_updated",
+				Language:  notify.LangIndonesian,
+				Data:      map[string]interface{}{"type": "booking_updated", "message": "Booking Anda disetujui", "status": "approved"},
+			}
+
+			// Like the outbox: a failed send is retried, a nil error is final
+			var err error
+			for i := 0; i < tt.tries; i++ {
+				if err = ch.Send(n); err == nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if g.attempts != tt.wantAttempts || len(g.delivered) != tt.wantDelivered {
+				t.Fatalf("attempts = %d, delivered = %d; want %d, %d", g.attempts, len(g.delivered), tt.wantAttempts, tt.wantDelivered)
+			}
+			if tt.wantDelivered == 0 {
+				return
+			}
+			m := g.delivered[0]
+			if m.Channel != notify.ChannelWhatsApp || m.To != "+628123456789" || m.TemplateID != "tpl_booking_updated" ||
+				m.Language != notify.LangIndonesian || m.Params["status"] != "approved" || m.Text == "" {
+				t.Errorf("delivered %+v", m)
+			}
+		})
+	}
+}
+
+func TestRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		auth       string
+		body       string
+		wantStatus int
+	}{
+		{"accepted", http.MethodPost, "Bearer rahasia", `{"channel":"sms","to":"+628123456789","text":"Halo"}`, http.StatusOK},
+		{"wrong method", http.MethodGet, "Bearer rahasia", "", http.StatusMethodNotAllowed},
+		{"missing token", http.MethodPost, "", `{"channel":"sms","to":"+628123456789","text":"Halo"}`, http.StatusUnauthorized},
+		{"invalid json", http.MethodPost, "Bearer rahasia", `{"channel":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &gateway{token: "rahasia"}
+			req := httptest.NewRequest(tt.method, "/messages", strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if want := tt.wantStatus == http.StatusOK; (len(g.delivered) == 1) != want {
+				t.Errorf("delivered = %d", len(g.delivered))
+			}
+		})
+	}
+}
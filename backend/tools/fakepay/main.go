@@ -0,0 +1,143 @@
+// Command fakepay is the checkout page of the fake payment provider
+// (payments.Fake). The backend sends clients here instead of to Midtrans;
+// "Bayar", "Gagalkan" or "Kedaluwarsa" posts a signed notification to the
+// backend's payment webhook, the same way a real provider would:
+//
+//	FAKEPAY_SECRET=dev-secret go run ./tools/fakepay -addr :9092
+//	PAYMENT_PROVIDER=fake FAKEPAY_SECRET=dev-secret go run .   # FAKEPAY_URL defaults to http://localhost:9092
+//
+// Use -webhook https://localhost:8080/api/payments/webhook/fake -insecure
+// when the backend runs with the self-signed certificate in certs/.
+package main
+
+import (
+	"bytes"
+	"counseling-webrtc/payments"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var checkoutPage = template.Must(template.New("pay").Parse(`<!DOCTYPE html>
+<html lang="id">
+<head><meta charset="utf-8"><title>Fake Pay</title></head>
+<body style="font-family: Arial, sans-serif; max-width: 420px; margin: 40px auto; color: #0f172a;">
+  <h2>Fake Pay</h2>
+  <p>{{.Description}}</p>
+  <p>Order: <code>{{.OrderID}}</code></p>
+  <p style="font-size: 1.5em;"><strong>Rp {{.Amount}}</strong></p>
+  {{if .Message}}<p style="color: #b91c1c;">{{.Message}}</p>{{end}}
+  <form method="POST" action="/pay">
+    <input type="hidden" name="order_id" value="{{.OrderID}}">
+    <input type="hidden" name="amount" value="{{.Amount}}">
+    <input type="hidden" name="description" value="{{.Description}}">
+    <input type="hidden" name="return_url" value="{{.ReturnURL}}">
+    <button name="status" value="paid">Bayar</button>
+    <button name="status" value="failed">Gagalkan</button>
+    <button name="status" value="expired">Kedaluwarsa</button>
+  </form>
+  <p style="color: #64748b;">Tidak ada uang yang berpindah. Halaman ini hanya untuk pengembangan.</p>
+</body>
+</html>
+`))
+
+type checkout struct {
+	OrderID, Description, ReturnURL, Message string
+	Amount                                   int
+}
+
+func main() {
+	addr := flag.String("addr", ":9092", "listen address")
+	webhook := flag.String("webhook", "http://localhost:8080/api/payments/webhook/fake", "backend payment webhook")
+	secret := flag.String("secret", os.Getenv("FAKEPAY_SECRET"), "shared secret (FAKEPAY_SECRET of the backend)")
+	insecure := flag.Bool("insecure", false, "don't verify the backend's TLS certificate")
+	flag.Parse()
+	if *secret == "" {
+		log.Fatal("-secret or FAKEPAY_SECRET is required")
+	}
+
+	provider := &payments.Fake{Secret: *secret}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if *insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	fmt.Printf("Fake payment checkout listening on %s, notifying %s\n", *addr, *webhook)
+	log.Fatal(http.ListenAndServe(*addr, newHandler(client, provider, *webhook)))
+}
+
+// newHandler serves the checkout page at /pay; its buttons notify webhook
+// signed by provider
+func newHandler(client *http.Client, provider *payments.Fake, webhook string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pay", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		amount, _ := strconv.Atoi(r.Form.Get("amount"))
+		co := checkout{
+			OrderID:     r.Form.Get("order_id"),
+			Description: r.Form.Get("description"),
+			ReturnURL:   r.Form.Get("return_url"),
+			Amount:      amount,
+		}
+		if co.OrderID == "" {
+			http.Error(w, "order_id is required", http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			status := r.Form.Get("status")
+			if err := notify(client, provider, webhook, payments.FakeNotification{OrderID: co.OrderID, Status: status, Amount: amount}); err != nil {
+				log.Printf("%s %s: %v", co.OrderID, status, err)
+				co.Message = "Webhook gagal: " + err.Error()
+			} else {
+				log.Printf("%s %s: delivered", co.OrderID, status)
+				if co.ReturnURL != "" {
+					http.Redirect(w, r, co.ReturnURL, http.StatusSeeOther)
+					return
+				}
+				fmt.Fprintf(w, "Notifikasi %s terkirim untuk %s.\n", status, co.OrderID)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		checkoutPage.Execute(w, co)
+	})
+	return mux
+}
+
+// notify posts a signed notification to the backend
+func notify(client *http.Client, provider *payments.Fake, url string, n payments.FakeNotification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(payments.FakeSignatureHeader, provider.Sign(body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("backend returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"counseling-webrtc/payments"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// backend stands in for the payment webhook: it checks notifications with
+// the backend's own provider and answers like handlers.HandlePaymentWebhook
+type backend struct {
+	provider *payments.Fake
+	mutex    sync.Mutex
+	events   []payments.Event
+}
+
+func (b *backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ev, err := b.provider.ParseWebhook(r)
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.mutex.Lock()
+	b.events = append(b.events, *ev)
+	b.mutex.Unlock()
+}
+
+func TestCheckout(t *testing.T) {
+	checkout := url.Values{"order_id": {"booking-7"}, "amount": {"150000"}, "description": {"Sesi Konseling"}}
+	withStatus := func(status, returnURL string) url.Values {
+		v := url.Values{"status": {status}, "return_url": {returnURL}}
+		for k, vals := range checkout {
+			v[k] = vals
+		}
+		return v
+	}
+
+	tests := []struct {
+		name   string
+		secret string // fakepay's; the backend uses "dev-secret"
+		method string
+		form   url.Values
+
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+		wantEvent    string // Status the backend received, "" for none
+	}{
+		{name: "checkout page", secret: "dev-secret", method: http.MethodGet, form: checkout,
+			wantStatus: http.StatusOK, wantBody: "Rp 150000"},
+		{name: "missing order", secret: "dev-secret", method: http.MethodGet, form: url.Values{"amount": {"150000"}},
+			wantStatus: http.StatusBadRequest},
+		{name: "paid returns to the booking", secret: "dev-secret", method: http.MethodPost, form: withStatus("paid", "http://localhost:3000/booking/7"),
+			wantStatus: http.StatusSeeOther, wantLocation: "http://localhost:3000/booking/7", wantEvent: payments.StatusPaid},
+		{name: "failed", secret: "dev-secret", method: http.MethodPost, form: withStatus("failed", ""),
+			wantStatus: http.StatusOK, wantBody: "Notifikasi failed terkirim", wantEvent: payments.StatusFailed},
+		{name: "expired", secret: "dev-secret", method: http.MethodPost, form: withStatus("expired", ""),
+			wantStatus: http.StatusOK, wantBody: "Notifikasi expired terkirim", wantEvent: payments.StatusExpired},
+		{name: "unknown status is refused", secret: "dev-secret", method: http.MethodPost, form: withStatus("refunded", ""),
+			wantStatus: http.StatusOK, wantBody: "Webhook gagal"},
+		{name: "other secret is refused", secret: "wrong-secret", method: http.MethodPost, form: withStatus("paid", "http://localhost:3000/booking/7"),
+			wantStatus: http.StatusOK, wantBody: "Webhook gagal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &backend{provider: &payments.Fake{Secret: "dev-secret"}}
+			webhook := httptest.NewServer(b)
+			defer webhook.Close()
+			h := newHandler(webhook.Client(), &payments.Fake{Secret: tt.secret}, webhook.URL)
+
+			var req *http.Request
+			if tt.method == http.MethodGet {
+				req = httptest.NewRequest(tt.method, "/pay?"+tt.form.Encode(), nil)
+			} else {
+				req = httptest.NewRequest(tt.method, "/pay", strings.NewReader(tt.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", loc, tt.wantLocation)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body does not contain %q:\n%s", tt.wantBody, rec.Body)
+			}
+
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			if tt.wantEvent == "" {
+				if len(b.events) != 0 {
+					t.Errorf("backend received %+v", b.events)
+				}
+				return
+			}
+			if len(b.events) != 1 {
+				t.Fatalf("backend received %d events, want 1", len(b.events))
+			}
+			ev := b.events[0]
+			if ev.OrderID != "booking-7" || ev.Status != tt.wantEvent || ev.Amount != 150000 || ev.ProviderRef != "fake-booking-7" {
+				t.Errorf("event = %+v", ev)
+			}
+		})
+	}
+}
@@ -12,6 +12,11 @@ import {
   User, CheckCircle, Clock
 } from "lucide-react";
 import "react-calendar/dist/Calendar.css";
+import { startPayment } from "@/lib/payments";
+import { buyPackage, fetchCredits, fetchPackages, type Credit, type SessionPackage } from "@/lib/packages";
+import { fetchSponsorship, requestSponsorEmailCode, type Sponsorship } from "@/lib/sponsors";
+import { fetchQuestionnaires, type Questionnaire } from "@/lib/questionnaires";
+import { crisisText } from "@/lib/crisis";
 
 // Types
 type Category = {
@@ -29,12 +34,31 @@ type Schedule = {
   is_active: boolean;
 };
 
+type SessionType = {
+  id: number; // 0: the default 60-minute video session
+  name: string;
+  duration_minutes: number;
+  price: number;
+  modality: "video" | "audio" | "chat";
+  available?: boolean; // For the selected date & time
+};
+
+const MODALITY_LABELS: Record<SessionType["modality"], string> = {
+  video: "Video call",
+  audio: "Panggilan suara",
+  chat: "Chat",
+};
+
+const formatRupiah = (amount: number) =>
+  amount === 0 ? "Gratis" : `Rp ${amount.toLocaleString("id-ID")}`;
+
 type Psychologist = {
   id: number;
   name: string;
   specialties: string;
   categories: Category[];
   schedules: Schedule[];
+  session_types: SessionType[];
   bio: string;
   is_available: boolean;
   is_booked: boolean;
@@ -46,9 +70,13 @@ type BookingState = {
   selectedDate: Date | null;
   selectedTime: string | null;
   selectedPsychologist: Psychologist | null;
+  selectedSessionType: SessionType | null;
   clientName: string;
   clientContact: string;
   additionalNotes: string;
+  sponsorCode: string;
+  sponsorEmailCode: string; // Mailed to the client's work email, instead of a voucher
+  intakeAnswers: Record<string, (number | null)[]>; // By questionnaire code
 };
 
 const TIME_SLOTS = Array.from({ length: 24 }, (_, i) =>
@@ -60,6 +88,10 @@ export default function BookingWizard() {
   const [categories, setCategories] = useState<Category[]>([]);
   const [psychologists, setPsychologists] = useState<Psychologist[]>([]);
   const [loading, setLoading] = useState(false);
+  const [packages, setPackages] = useState<SessionPackage[]>([]);
+  const [credits, setCredits] = useState<Credit[]>([]);
+  const [sponsorship, setSponsorship] = useState<Sponsorship | null>(null);
+  const [sponsorEmailNote, setSponsorEmailNote] = useState("");
 
   const [data, setData] = useState<BookingState>({
     step: 1,
@@ -67,10 +99,15 @@ export default function BookingWizard() {
     selectedDate: null,
     selectedTime: null,
     selectedPsychologist: null,
+    selectedSessionType: null,
     clientName: "",
     clientContact: "",
     additionalNotes: "",
+    sponsorCode: "",
+    sponsorEmailCode: "",
+    intakeAnswers: {},
   });
+  const [questionnaires, setQuestionnaires] = useState<Questionnaire[]>([]);
 
   // Check Auth & Load Categories on Mount
   useEffect(() => {
@@ -83,6 +120,7 @@ export default function BookingWizard() {
 
     setData(prev => ({ ...prev, clientContact: email }));
     fetchCategories();
+    fetchQuestionnaires().then(setQuestionnaires).catch(() => setQuestionnaires([]));
   }, [router]);
 
   // Fetch psychologists when category is selected and reaching step 3
@@ -92,6 +130,32 @@ export default function BookingWizard() {
     }
   }, [data.step, data.selectedCategory]);
 
+  // Packages of the chosen psychologist and the client's credits
+  useEffect(() => {
+    if (!data.selectedPsychologist || !data.clientContact) return;
+    fetchPackages(data.selectedPsychologist.id).then(setPackages).catch(() => setPackages([]));
+    fetchCredits(data.clientContact).then(setCredits).catch(() => setCredits([]));
+  }, [data.selectedPsychologist, data.clientContact]);
+
+  // Sponsor (employer/EAP) covering the client, by voucher code or emailed code
+  useEffect(() => {
+    if (!data.clientContact) return;
+    const timer = setTimeout(() => {
+      fetchSponsorship(data.clientContact, data.sponsorCode.trim(), data.sponsorEmailCode.trim())
+        .then(setSponsorship)
+        .catch(() => setSponsorship(null));
+    }, 400);
+    return () => clearTimeout(timer);
+  }, [data.clientContact, data.sponsorCode, data.sponsorEmailCode]);
+
+  const sendSponsorEmailCode = async () => {
+    try {
+      setSponsorEmailNote(await requestSponsorEmailCode(data.clientContact));
+    } catch (err) {
+      setSponsorEmailNote(err instanceof Error ? err.message : "Gagal mengirim kode");
+    }
+  };
+
   const fetchCategories = async () => {
     try {
       const protocol = window.location.protocol;
@@ -141,6 +205,19 @@ export default function BookingWizard() {
     const dateStr = format(data.selectedDate, "yyyy-MM-dd");
     const scheduleTime = `${dateStr}T${data.selectedTime}:00`;
 
+    // Questionnaires are optional, but one that was started must be finished
+    const intake: { code: string; answers: number[] }[] = [];
+    for (const q of questionnaires) {
+      const answers = data.intakeAnswers[q.code] || [];
+      const answered = answers.filter(a => a !== null && a !== undefined).length;
+      if (answered === 0) continue;
+      if (answered < q.items.length) {
+        alert(`Lengkapi semua pertanyaan ${q.name} atau kosongkan seluruhnya.`);
+        return;
+      }
+      intake.push({ code: q.code, answers: answers as number[] });
+    }
+
     try {
       setLoading(true);
       const protocol = window.location.protocol;
@@ -153,6 +230,10 @@ export default function BookingWizard() {
         complaint: data.additionalNotes,
         psychologist_id: data.selectedPsychologist.id,
         schedule_time: scheduleTime,
+        ...(data.selectedSessionType?.id ? { session_type_id: data.selectedSessionType.id } : {}),
+        ...(data.sponsorCode.trim() ? { sponsor_code: data.sponsorCode.trim() } : {}),
+        ...(!data.sponsorCode.trim() && data.sponsorEmailCode.trim() ? { sponsor_email_code: data.sponsorEmailCode.trim() } : {}),
+        ...(intake.length > 0 ? { questionnaires: intake } : {}),
       };
 
       const res = await fetch(`${protocol}//${host}:8080/api/public/booking`, {
@@ -161,9 +242,42 @@ export default function BookingWizard() {
         body: JSON.stringify(payload),
       });
 
-      if (!res.ok) throw new Error("Booking failed");
+      if (!res.ok) {
+        const body = await res.json().catch(() => ({}));
+        // Flagged clients get the hotlines even when the booking failed
+        if (body.crisis) {
+          alert(crisisText(body.crisis));
+        }
+        if (res.status === 409 || res.status === 400) {
+          alert(body.error);
+          return;
+        }
+        throw new Error("Booking failed");
+      }
+
+      const created = await res.json();
+      // Risk screening flagged the booking: crisis hotlines come first
+      if (created.crisis) {
+        alert(crisisText(created.crisis));
+      }
+      if (created.payment_required) {
+        // Priced session: pay first, the psychologist confirms once it is paid
+        try {
+          await startPayment(created.booking_id, data.clientContact);
+        } catch {
+          alert("Booking tersimpan, tetapi pembayaran gagal dibuat. Silakan bayar dari Dashboard.");
+          router.push("/dashboard/client");
+        }
+        return;
+      }
 
-      alert("Booking Request Sent! Please wait for approval.");
+      if (created.sponsored) {
+        alert("Booking terkirim dan ditanggung sponsor Anda. Silakan tunggu persetujuan psikolog.");
+      } else if (created.paid_with_credit) {
+        alert("Booking terkirim menggunakan 1 kredit paket. Silakan tunggu persetujuan psikolog.");
+      } else {
+        alert("Booking Request Sent! Please wait for approval.");
+      }
       router.push("/dashboard/client");
 
     } catch (err) {
@@ -271,7 +385,9 @@ export default function BookingWizard() {
                 key={psy.id}
                 onClick={() => {
                   if (isAvailable) {
-                    setData(prev => ({ ...prev, selectedPsychologist: psy }));
+                    // Preselect the first session type that fits the chosen slot
+                    const sessionType = (psy.session_types || []).find(t => t.available !== false) || null;
+                    setData(prev => ({ ...prev, selectedPsychologist: psy, selectedSessionType: sessionType }));
                     handleNext();
                   }
                 }}
@@ -373,6 +489,90 @@ export default function BookingWizard() {
         </div>
       </div>
 
+      {/* Session Type */}
+      {data.selectedPsychologist?.session_types && data.selectedPsychologist.session_types.length > 0 && (
+        <div className="space-y-2">
+          <label className="text-sm text-slate-300 block">Jenis Sesi</label>
+          {data.selectedPsychologist.session_types.map(t => {
+            const disabled = t.available === false;
+            return (
+              <button
+                key={t.id}
+                disabled={disabled}
+                onClick={() => setData(prev => ({ ...prev, selectedSessionType: t }))}
+                className={`w-full p-3 rounded-lg text-left border flex justify-between items-center ${data.selectedSessionType?.id === t.id
+                  ? "bg-sky-900/40 border-sky-500 text-white"
+                  : "bg-slate-800 border-slate-700 text-slate-300 hover:border-sky-500"
+                  } disabled:opacity-50 disabled:cursor-not-allowed`}
+              >
+                <span>
+                  <span className="font-medium">{t.name}</span>
+                  <span className="block text-xs text-slate-400">
+                    {t.duration_minutes} menit · {MODALITY_LABELS[t.modality]}
+                    {disabled && " · tidak tersedia di jam ini"}
+                  </span>
+                </span>
+                <span className="text-sm font-semibold">{formatRupiah(t.price)}</span>
+              </button>
+            );
+          })}
+        </div>
+      )}
+
+      {/* Sessions covered by the client's sponsor */}
+      {data.selectedSessionType && data.selectedSessionType.price > 0 && sponsorship && (
+        <p className={`text-sm ${sponsorship.eligible ? "text-emerald-400" : "text-red-400"}`}>
+          {sponsorship.eligible
+            ? "Sesi ini ditanggung sponsor Anda, tanpa pembayaran."
+            : "Kode sponsor tidak dapat digunakan untuk sesi ini."}
+        </p>
+      )}
+
+      {/* Packages & credits for the chosen session type */}
+      {data.selectedSessionType && !sponsorship?.eligible && (() => {
+        const typeId = data.selectedSessionType.id;
+        const remaining = credits
+          .filter(c => c.session_type_id === typeId && !c.expired)
+          .reduce((sum, c) => sum + c.remaining, 0);
+        const typePackages = packages.filter(p => p.session_type_id === typeId);
+        if (remaining === 0 && typePackages.length === 0) return null;
+        return (
+          <div className="space-y-2">
+            {remaining > 0 ? (
+              <p className="text-sm text-emerald-400">
+                Anda punya {remaining} kredit paket untuk sesi ini. Booking ini memakai 1 kredit, tanpa pembayaran.
+              </p>
+            ) : (
+              <>
+                <label className="text-sm text-slate-300 block">Hemat dengan paket sesi</label>
+                {typePackages.map(p => (
+                  <div key={p.id} className="p-3 rounded-lg border border-slate-700 bg-slate-800 flex justify-between items-center">
+                    <span>
+                      <span className="font-medium text-white">{p.name}</span>
+                      <span className="block text-xs text-slate-400">
+                        {p.session_count} sesi · berlaku {p.validity_days} hari
+                      </span>
+                    </span>
+                    <button
+                      onClick={async () => {
+                        try {
+                          await buyPackage(p.id, data.clientContact, data.clientName);
+                        } catch (err) {
+                          alert(err instanceof Error ? err.message : "Gagal membuat pembayaran paket");
+                        }
+                      }}
+                      className="text-sm font-semibold text-sky-400 hover:text-sky-300"
+                    >
+                      Beli {formatRupiah(p.price)}
+                    </button>
+                  </div>
+                ))}
+              </>
+            )}
+          </div>
+        );
+      })()}
+
       {/* Client Data Input */}
       <div className="space-y-4 border-t border-slate-800 pt-4">
 
@@ -388,6 +588,42 @@ export default function BookingWizard() {
           />
         </div>
 
+        <div>
+          <label className="text-sm text-slate-300 mb-1 block">Kode Sponsor Perusahaan (Opsional)</label>
+          <input
+            type="text"
+            className="w-full bg-slate-900 border border-slate-700 rounded-lg px-4 py-2 text-white uppercase focus:ring-2 focus:ring-sky-500 outline-none"
+            placeholder="Dari HR / program EAP kantor Anda"
+            value={data.sponsorCode}
+            onChange={e => setData(prev => ({ ...prev, sponsorCode: e.target.value }))}
+          />
+        </div>
+
+        {/* Without a voucher: prove the work email with a mailed code */}
+        {!data.sponsorCode.trim() && data.clientContact.includes("@") && (
+          <div>
+            <label className="text-sm text-slate-300 mb-1 block">Kode Verifikasi Email Kantor (Opsional)</label>
+            <div className="flex gap-2">
+              <input
+                type="text"
+                inputMode="numeric"
+                className="flex-1 bg-slate-900 border border-slate-700 rounded-lg px-4 py-2 text-white focus:ring-2 focus:ring-sky-500 outline-none"
+                placeholder="6 digit"
+                value={data.sponsorEmailCode}
+                onChange={e => setData(prev => ({ ...prev, sponsorEmailCode: e.target.value }))}
+              />
+              <button
+                type="button"
+                onClick={sendSponsorEmailCode}
+                className="px-4 py-2 rounded-lg border border-slate-600 text-sm text-slate-200 hover:bg-slate-800"
+              >
+                Kirim kode ke {data.clientContact}
+              </button>
+            </div>
+            {sponsorEmailNote && <p className="text-xs text-slate-400 mt-1">{sponsorEmailNote}</p>}
+          </div>
+        )}
+
         <div>
           <label className="text-sm text-slate-300 mb-1 block">Catatan Tambahan (Opsional)</label>
           <textarea
@@ -398,10 +634,63 @@ export default function BookingWizard() {
             onChange={e => setData(prev => ({ ...prev, additionalNotes: e.target.value }))}
           />
         </div>
+
+        {/* Intake questionnaires, scored for the psychologist */}
+        {questionnaires.length > 0 && (
+          <div className="space-y-2">
+            <label className="text-sm text-slate-300 block">Kuesioner Awal (Opsional)</label>
+            <p className="text-xs text-slate-500">Membantu psikolog memahami kondisi Anda sebelum sesi.</p>
+            {questionnaires.map(q => {
+              const answers = data.intakeAnswers[q.code] || [];
+              const answered = answers.filter(a => a !== null && a !== undefined).length;
+              const setAnswer = (index: number, value: number) =>
+                setData(prev => {
+                  const next = [...(prev.intakeAnswers[q.code] || Array(q.items.length).fill(null))];
+                  next[index] = value;
+                  return { ...prev, intakeAnswers: { ...prev.intakeAnswers, [q.code]: next } };
+                });
+              return (
+                <details key={q.code} className="rounded-lg border border-slate-700 bg-slate-800">
+                  <summary className="p-3 cursor-pointer text-sm text-white flex justify-between">
+                    <span>{q.title}</span>
+                    <span className="text-xs text-slate-400">{answered}/{q.items.length}</span>
+                  </summary>
+                  <div className="px-3 pb-3 space-y-3">
+                    <p className="text-xs text-slate-400">{q.instructions}</p>
+                    {q.items.map((item, i) => (
+                      <div key={item.id}>
+                        <p className="text-sm text-slate-200 mb-1">{i + 1}. {item.text}</p>
+                        {item.risk_min !== undefined && answers[i] !== null && answers[i] !== undefined && (answers[i] as number) >= item.risk_min && (
+                          <p className="text-xs text-red-300 mb-1">
+                            Jika Anda sedang dalam bahaya, jangan menunggu sesi: hubungi 112 atau layanan SEJIWA 119 ext. 8 sekarang.
+                          </p>
+                        )}
+                        <div className="grid grid-cols-2 gap-1">
+                          {q.options.map(o => (
+                            <button
+                              key={o.value}
+                              type="button"
+                              onClick={() => setAnswer(i, o.value)}
+                              className={`text-xs px-2 py-1 rounded border ${answers[i] === o.value
+                                ? "border-sky-500 bg-sky-900/30 text-sky-300"
+                                : "border-slate-700 text-slate-400 hover:border-slate-500"}`}
+                            >
+                              {o.label}
+                            </button>
+                          ))}
+                        </div>
+                      </div>
+                    ))}
+                  </div>
+                </details>
+              );
+            })}
+          </div>
+        )}
       </div>
 
       <button
-        disabled={!data.clientName || loading}
+        disabled={!data.clientName || !data.selectedSessionType || loading}
         onClick={submitBooking}
         className="w-full bg-emerald-600 hover:bg-emerald-500 disabled:opacity-50 text-white font-bold py-3 rounded-xl shadow-lg shadow-emerald-600/20 flex items-center justify-center gap-2 mt-4"
       >
@@ -5,32 +5,49 @@ import { useRouter } from "next/navigation";
 import Link from "next/link";
 import { format } from "date-fns";
 import { id } from "date-fns/locale";
-import { Calendar, Video, Clock, LogOut, Plus, AlertTriangle, User, Mail } from "lucide-react";
+import { Calendar, Video, Clock, LogOut, Plus, AlertTriangle, User, Mail, CreditCard, FileText } from "lucide-react";
 import { motion } from "framer-motion";
+import { registerPush } from "@/lib/push";
+import { startPayment, invoiceUrl } from "@/lib/payments";
+import { fetchCredits, type Credit } from "@/lib/packages";
 
 type Booking = {
     id: number;
     client_name: string;
     complaint: string;
     schedule_time: string;
-    status: "pending" | "approved" | "rejected" | "completed";
+    status: "pending" | "approved" | "rejected" | "completed" | "cancelled";
     room_id: string;
     psychologist_name: string;
     session_notes?: string;
     rejection_reason?: string;
+    session_type_name?: string;
+    duration_minutes?: number;
+    price?: number;
+    modality?: "video" | "audio" | "chat";
+    payment_status?: "not_required" | "unpaid" | "paid" | "credit" | "sponsored" | "refunded";
+    refund_status?: "none" | "pending" | "refunded" | "failed";
+    refund_amount?: number;
+    cancellation_fee?: number;
+    credit_status?: "none" | "reserved" | "used" | "returned" | "forfeited" | "released";
+    sponsor_status?: "none" | "reserved" | "used" | "released" | "forfeited";
+    sponsor_name?: string;
 };
 
-// Helper function to check if a booking session has expired (>1 hour from scheduled time)
-const isExpired = (scheduleTime: string) => {
+const rupiah = (amount = 0) => `Rp ${amount.toLocaleString("id-ID")}`;
+
+// Helper function to check if a booking session has expired (past its booked duration, 1 hour for older bookings)
+const isExpired = (scheduleTime: string, durationMinutes = 60) => {
     const scheduleDate = new Date(scheduleTime);
     const now = new Date();
-    const diffInHours = (now.getTime() - scheduleDate.getTime()) / (1000 * 60 * 60);
-    return diffInHours > 1;
+    const diffInMinutes = (now.getTime() - scheduleDate.getTime()) / (1000 * 60);
+    return diffInMinutes > durationMinutes;
 };
 
 export default function ClientDashboard() {
     const router = useRouter();
     const [bookings, setBookings] = useState<Booking[]>([]);
+    const [credits, setCredits] = useState<Credit[]>([]);
     const [loading, setLoading] = useState(true);
     const [clientName, setClientName] = useState("");
     const [clientEmail, setClientEmail] = useState("");
@@ -56,6 +73,7 @@ export default function ClientDashboard() {
                 console.log("[DEBUG] Bookings statuses:", data?.map((b: Booking) => ({ id: b.id, status: b.status })));
                 setBookings(data || []);
             }
+            setCredits(await fetchCredits(email));
         } catch (err) {
             console.error("Failed to fetch bookings:", err);
         } finally {
@@ -85,6 +103,70 @@ export default function ClientDashboard() {
         const wsUrl = `${protocol}//${host}:8080/api/notify?email=${encodeURIComponent(email)}`;
 
         let reconnectTimeout: NodeJS.Timeout | null = null;
+        let eventSource: EventSource | null = null;
+        let failedConnects = 0;
+
+        const handleMessage = (event: MessageEvent) => {
+            console.log("Notification received:", event.data);
+            try {
+                const msg = JSON.parse(event.data);
+
+                // Handle pong response
+                if (msg.type === "pong") {
+                    console.log("Pong received");
+                    return;
+                }
+
+                if (msg.type === "booking_updated") {
+                    console.log("Booking updated, refreshing...");
+                    // Refresh bookings on update - use the callback version to avoid stale closure
+                    fetchBookings();
+
+                    // Show browser notification if permitted
+                    if (Notification.permission === "granted") {
+                        new Notification("Status Booking Diperbarui", { body: msg.message });
+                    }
+                }
+
+                if (msg.type === "booking_rejected") {
+                    console.log("Booking rejected:", msg.reason);
+                    // Refresh bookings to remove rejected one
+                    fetchBookings();
+
+                    // Show alert with rejection reason
+                    alert(`Booking Anda ditolak.\n\nAlasan: ${msg.reason}`);
+
+                    // Show browser notification if permitted
+                    if (Notification.permission === "granted") {
+                        new Notification("Booking Ditolak", { body: msg.message });
+                    }
+                }
+
+                if (msg.type === "package_purchased") {
+                    fetchBookings();
+                    if (Notification.permission === "granted") {
+                        new Notification("Paket Sesi Aktif", { body: msg.message });
+                    }
+                }
+
+                if (msg.type === "session_reminder" && !msg.replayed) {
+                    if (Notification.permission === "granted") {
+                        new Notification("Pengingat Sesi", { body: msg.message });
+                    }
+                }
+            } catch (e) {
+                console.error("WS Parse Error", e);
+            }
+        };
+
+        // Some networks block WebSocket upgrades; after repeated failures to
+        // even open the socket, switch to the Server-Sent Events stream
+        const connectSse = () => {
+            const httpProtocol = window.location.protocol;
+            eventSource = new EventSource(`${httpProtocol}//${host}:8080/api/notify/stream?email=${encodeURIComponent(email)}`);
+            eventSource.onmessage = handleMessage;
+            eventSource.onopen = () => console.log("SSE connected for notifications");
+        };
 
         const connectWs = () => {
             // Close existing connection if any
@@ -95,7 +177,10 @@ export default function ClientDashboard() {
             const ws = new WebSocket(wsUrl);
             wsRef.current = ws;
 
+            let opened = false;
             ws.onopen = () => {
+                opened = true;
+                failedConnects = 0;
                 console.log("WebSocket connected for notifications");
 
                 // Start ping interval to keep connection alive (every 30 seconds)
@@ -110,45 +195,7 @@ export default function ClientDashboard() {
                 }, 30000);
             };
 
-            ws.onmessage = (event) => {
-                console.log("WebSocket message received:", event.data);
-                try {
-                    const msg = JSON.parse(event.data);
-
-                    // Handle pong response
-                    if (msg.type === "pong") {
-                        console.log("Pong received");
-                        return;
-                    }
-
-                    if (msg.type === "booking_updated") {
-                        console.log("Booking updated, refreshing...");
-                        // Refresh bookings on update - use the callback version to avoid stale closure
-                        fetchBookings();
-
-                        // Show browser notification if permitted
-                        if (Notification.permission === "granted") {
-                            new Notification("Status Booking Diperbarui", { body: msg.message });
-                        }
-                    }
-
-                    if (msg.type === "booking_rejected") {
-                        console.log("Booking rejected:", msg.reason);
-                        // Refresh bookings to remove rejected one
-                        fetchBookings();
-
-                        // Show alert with rejection reason
-                        alert(`Booking Anda ditolak.\n\nAlasan: ${msg.reason}`);
-
-                        // Show browser notification if permitted
-                        if (Notification.permission === "granted") {
-                            new Notification("Booking Ditolak", { body: msg.message });
-                        }
-                    }
-                } catch (e) {
-                    console.error("WS Parse Error", e);
-                }
-            };
+            ws.onmessage = handleMessage;
 
             ws.onerror = (error) => {
                 console.error("WebSocket error:", error);
@@ -163,6 +210,12 @@ export default function ClientDashboard() {
                     pingIntervalRef.current = null;
                 }
 
+                if (!opened && ++failedConnects >= 2) {
+                    console.log("WebSocket unavailable, falling back to SSE");
+                    connectSse();
+                    return;
+                }
+
                 // Reconnect after 3s (reduced from 5s for faster recovery)
                 reconnectTimeout = setTimeout(connectWs, 3000);
             };
@@ -171,14 +224,20 @@ export default function ClientDashboard() {
         connectWs();
 
         // Request notification permission
-        if (Notification.permission !== "granted" && Notification.permission !== "denied") {
-            Notification.requestPermission();
+        if (Notification.permission === "granted") {
+            registerPush(email);
+        } else if (Notification.permission !== "denied") {
+            Notification.requestPermission().then((p) => { if (p === "granted") registerPush(email); });
         }
 
         return () => {
             if (reconnectTimeout) clearTimeout(reconnectTimeout);
             if (pingIntervalRef.current) clearInterval(pingIntervalRef.current);
-            if (wsRef.current) wsRef.current.close();
+            if (wsRef.current) {
+                wsRef.current.onclose = null;
+                wsRef.current.close();
+            }
+            if (eventSource) eventSource.close();
         };
     }, [router, fetchBookings]);
 
@@ -190,10 +249,10 @@ export default function ClientDashboard() {
 
     // Filter bookings by category
     const pendingBookings = bookings.filter(b => b.status.toLowerCase() === 'pending');
-    const upcomingBookings = bookings.filter(b => b.status.toLowerCase() === 'approved' && !isExpired(b.schedule_time));
-    const expiredBookings = bookings.filter(b => b.status.toLowerCase() === 'approved' && isExpired(b.schedule_time));
-    const historyBookings = bookings.filter(b => ['completed', 'rejected'].includes(b.status.toLowerCase()));
-    const unknownBookings = bookings.filter(b => !['pending', 'approved', 'completed', 'rejected'].includes(b.status.toLowerCase()));
+    const upcomingBookings = bookings.filter(b => b.status.toLowerCase() === 'approved' && !isExpired(b.schedule_time, b.duration_minutes));
+    const expiredBookings = bookings.filter(b => b.status.toLowerCase() === 'approved' && isExpired(b.schedule_time, b.duration_minutes));
+    const historyBookings = bookings.filter(b => ['completed', 'rejected', 'cancelled'].includes(b.status.toLowerCase()));
+    const unknownBookings = bookings.filter(b => !['pending', 'approved', 'completed', 'rejected', 'cancelled'].includes(b.status.toLowerCase()));
 
     // Count only non-expired upcoming and pending for "Jadwal Mendatang"
     const upcomingCount = upcomingBookings.length + pendingBookings.length;
@@ -243,6 +302,34 @@ export default function ClientDashboard() {
                     </Link>
                 </div>
 
+                {/* Session credits from packages */}
+                {credits.length > 0 && (
+                    <section className="mb-8">
+                        <h2 className="text-xl font-bold text-white mb-4">Kredit Sesi</h2>
+                        <div className="grid grid-cols-1 md:grid-cols-2 gap-4">
+                            {credits.map(c => (
+                                <div key={c.id} className={`bg-slate-900 border border-slate-800 p-4 rounded-xl ${c.expired ? "opacity-60" : ""}`}>
+                                    <p className="font-semibold text-white">{c.package_name}</p>
+                                    <p className="text-xs text-slate-400">
+                                        {c.psychologist_name}{c.session_type_name && ` · ${c.session_type_name}`}
+                                    </p>
+                                    <p className="text-2xl font-bold text-white mt-2">
+                                        {c.remaining}<span className="text-sm text-slate-400 font-normal"> / {c.sessions_total} sesi tersisa</span>
+                                    </p>
+                                    {c.sessions_reserved > 0 && (
+                                        <p className="text-xs text-yellow-400">{c.sessions_reserved} dipakai booking yang menunggu konfirmasi</p>
+                                    )}
+                                    {c.expires_at && (
+                                        <p className={`text-xs mt-1 ${c.expired ? "text-red-400" : "text-slate-500"}`}>
+                                            {c.expired ? "Kedaluwarsa" : "Berlaku sampai"} {format(new Date(c.expires_at), "dd MMMM yyyy", { locale: id })}
+                                        </p>
+                                    )}
+                                </div>
+                            ))}
+                        </div>
+                    </section>
+                )}
+
                 {/* Bookings List */}
                 <h2 className="text-xl font-bold text-white mb-4">Riwayat Konsultasi</h2>
 
@@ -261,7 +348,7 @@ export default function ClientDashboard() {
                                     <Clock size={16} /> Menunggu Konfirmasi
                                 </h3>
                                 {pendingBookings.map(booking => (
-                                    <BookingCard key={booking.id} booking={booking} />
+                                    <BookingCard key={booking.id} booking={booking} onChange={fetchBookings} />
                                 ))}
                             </section>
                         )}
@@ -273,7 +360,7 @@ export default function ClientDashboard() {
                                     <Video size={16} /> Jadwal Akan Datang
                                 </h3>
                                 {upcomingBookings.map(booking => (
-                                    <BookingCard key={booking.id} booking={booking} />
+                                    <BookingCard key={booking.id} booking={booking} onChange={fetchBookings} />
                                 ))}
                             </section>
                         )}
@@ -285,7 +372,7 @@ export default function ClientDashboard() {
                                     <AlertTriangle size={16} /> Sesi Berakhir
                                 </h3>
                                 {expiredBookings.map(booking => (
-                                    <BookingCard key={booking.id} booking={booking} />
+                                    <BookingCard key={booking.id} booking={booking} onChange={fetchBookings} />
                                 ))}
                             </section>
                         )}
@@ -297,7 +384,7 @@ export default function ClientDashboard() {
                                     <LogOut size={16} /> Selesai / Ditolak
                                 </h3>
                                 {historyBookings.map(booking => (
-                                    <BookingCard key={booking.id} booking={booking} />
+                                    <BookingCard key={booking.id} booking={booking} onChange={fetchBookings} />
                                 ))}
                             </section>
                         )}
@@ -309,7 +396,7 @@ export default function ClientDashboard() {
                                     ? Status Tidak Diketahui
                                 </h3>
                                 {unknownBookings.map(booking => (
-                                    <BookingCard key={booking.id} booking={booking} />
+                                    <BookingCard key={booking.id} booking={booking} onChange={fetchBookings} />
                                 ))}
                             </section>
                         )}
@@ -320,17 +407,61 @@ export default function ClientDashboard() {
     );
 }
 
-function BookingCard({ booking }: { booking: Booking }) {
+function BookingCard({ booking, onChange }: { booking: Booking; onChange: () => void }) {
     const getStatusColor = (status: string) => {
         switch (status.toLowerCase()) {
             case "approved": return "text-emerald-400 bg-emerald-400/10 border-emerald-400/20";
             case "rejected": return "text-red-400 bg-red-400/10 border-red-400/20";
             case "completed": return "text-blue-400 bg-blue-400/10 border-blue-400/20";
+            case "cancelled": return "text-slate-400 bg-slate-400/10 border-slate-400/20";
             default: return "text-yellow-400 bg-yellow-400/10 border-yellow-400/20";
         }
     };
 
-    const expired = isExpired(booking.schedule_time);
+    const expired = isExpired(booking.schedule_time, booking.duration_minutes);
+    const unpaid = booking.status === "pending" && booking.payment_status === "unpaid";
+
+    const cancellable = (booking.status === "pending" || booking.status === "approved") && new Date(booking.schedule_time) > new Date();
+
+    const handleCancel = async () => {
+        const email = localStorage.getItem("client_email") || "";
+        const base = `${window.location.protocol}//${window.location.hostname}:8080/api/public/bookings/${booking.id}/cancel`;
+        try {
+            const quoteRes = await fetch(`${base}?email=${encodeURIComponent(email)}`);
+            const quote = await quoteRes.json();
+            if (!quoteRes.ok) throw new Error(quote.error);
+
+            let terms = "";
+            if (quote.paid > 0) {
+                terms = ` Dana yang kembali: ${rupiah(quote.refund_amount)}.`;
+                if (quote.cancellation_fee > 0) terms += ` Biaya pembatalan: ${rupiah(quote.cancellation_fee)}.`;
+            }
+            if (quote.credit === "returned") terms += " Kredit sesi dikembalikan ke paket Anda.";
+            if (quote.credit === "forfeited") terms += " Kredit sesi yang dipakai hangus.";
+            if (quote.sponsor === "forfeited") terms += " Sesi tetap terhitung dalam kuota sponsor Anda.";
+            if (!confirm(`Batalkan booking ini?${terms}`)) return;
+            const reason = prompt("Alasan pembatalan (opsional):") || "";
+
+            const res = await fetch(base, {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ email, reason }),
+            });
+            const data = await res.json();
+            if (!res.ok) throw new Error(data.error);
+            onChange();
+        } catch (err) {
+            alert(err instanceof Error && err.message ? err.message : "Gagal membatalkan booking");
+        }
+    };
+
+    const handlePay = async () => {
+        try {
+            await startPayment(booking.id, localStorage.getItem("client_email") || "");
+        } catch (err) {
+            alert(err instanceof Error ? err.message : "Gagal membuat pembayaran");
+        }
+    };
 
     return (
         <motion.div
@@ -351,6 +482,7 @@ function BookingCard({ booking }: { booking: Booking }) {
                         <div className="flex items-center gap-2 text-xs text-slate-500 mt-2">
                             <Clock size={12} />
                             {format(new Date(booking.schedule_time), "EEEE, dd MMMM yyyy - HH:mm", { locale: id })} WIB
+                            {booking.duration_minutes && <span>· {booking.session_type_name || "Sesi"} ({booking.duration_minutes} menit, {booking.modality})</span>}
                         </div>
                     </div>
                 </div>
@@ -360,6 +492,44 @@ function BookingCard({ booking }: { booking: Booking }) {
                         {booking.status.toUpperCase()}
                     </span>
 
+                    {booking.payment_status === "paid" && (
+                        <>
+                            <span className="text-xs text-emerald-400">Lunas</span>
+                            <a
+                                href={invoiceUrl(booking.id, localStorage.getItem("client_email") || "")}
+                                className="text-slate-300 hover:text-white text-sm flex items-center gap-1"
+                            >
+                                <FileText size={14} /> Kwitansi
+                            </a>
+                        </>
+                    )}
+
+                    {booking.payment_status === "credit" && (
+                        <span className="text-xs text-emerald-400">Kredit paket</span>
+                    )}
+
+                    {booking.payment_status === "sponsored" && (
+                        <span className="text-xs text-emerald-400">Ditanggung {booking.sponsor_name || "sponsor"}</span>
+                    )}
+
+                    {unpaid && (
+                        <button
+                            onClick={handlePay}
+                            className="bg-sky-600 hover:bg-sky-500 text-white px-4 py-2 rounded-lg text-sm flex items-center gap-2 transition-colors"
+                        >
+                            <CreditCard size={16} /> Bayar {rupiah(booking.price)}
+                        </button>
+                    )}
+
+                    {cancellable && (
+                        <button
+                            onClick={handleCancel}
+                            className="text-red-400 hover:text-red-300 text-sm px-3 py-2 rounded-lg border border-red-500/30 hover:bg-red-500/10 transition-colors"
+                        >
+                            Batalkan
+                        </button>
+                    )}
+
                     {booking.status === 'approved' && (
                         expired ? (
                             <span className="text-slate-500 text-sm font-medium px-4 py-2 border border-slate-700 rounded-lg bg-slate-800">
@@ -367,7 +537,7 @@ function BookingCard({ booking }: { booking: Booking }) {
                             </span>
                         ) : (
                             <Link
-                                href={`/session?room=${booking.room_id}&role=client`}
+                                href={`/waiting-room?room=${booking.room_id}`}
                                 className="bg-emerald-600 hover:bg-emerald-500 text-white px-4 py-2 rounded-lg text-sm flex items-center gap-2 transition-colors"
                             >
                                 <Video size={16} /> Masuk Room
@@ -391,6 +561,18 @@ function BookingCard({ booking }: { booking: Booking }) {
                 </div>
             )}
 
+            {booking.refund_status && booking.refund_status !== "none" && (
+                <div className="bg-slate-950/30 border border-slate-800 p-4 rounded-lg text-sm text-slate-300">
+                    {booking.refund_status === "pending" && <>Pengembalian dana {rupiah(booking.refund_amount)} sedang diproses.</>}
+                    {booking.refund_status === "refunded" && <>Dana {rupiah(booking.refund_amount)} telah dikembalikan.</>}
+                    {booking.refund_status === "failed" && <>Pengembalian dana {rupiah(booking.refund_amount)} tertunda, tim kami akan menghubungi Anda.</>}
+                </div>
+            )}
+
+            {(booking.cancellation_fee ?? 0) > 0 && (
+                <p className="text-xs text-slate-500">Biaya pembatalan: {rupiah(booking.cancellation_fee)}</p>
+            )}
+
             {booking.status === 'rejected' && booking.rejection_reason && (
                 <div className="bg-red-950/30 border border-red-800/30 p-4 rounded-lg">
                     <p className="text-xs text-red-400 font-bold mb-1 uppercase tracking-wider">Alasan Penolakan:</p>
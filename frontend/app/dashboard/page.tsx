@@ -10,6 +10,9 @@ import {
 } from "lucide-react";
 import { motion, AnimatePresence } from "framer-motion";
 import Link from "next/link";
+import { registerPush } from "@/lib/push";
+import { fetchEarnings, statementUrl, type EarningsStatement } from "@/lib/earnings";
+import { severityClass, type QuestionnaireResult } from "@/lib/questionnaires";
 
 type Booking = {
     id: number;
@@ -17,11 +20,20 @@ type Booking = {
     client_contact: string;
     complaint: string;
     schedule_time: string;
-    status: "pending" | "approved" | "rejected" | "completed";
+    status: "pending" | "approved" | "rejected" | "completed" | "cancelled";
     room_id: string;
     session_notes?: string;
+    session_type_name?: string;
+    duration_minutes?: number;
+    modality?: "video" | "audio" | "chat";
+    payment_status?: "not_required" | "unpaid" | "paid" | "credit" | "sponsored" | "refunded";
+    questionnaires?: QuestionnaireResult[];
+    risk_level?: "none" | "high";
+    risk_reasons?: string[];
 };
 
+const rupiah = (amount: number) => `Rp ${amount.toLocaleString("id-ID")}`;
+
 export default function ExpertDashboard() {
     const router = useRouter();
     const [bookings, setBookings] = useState<Booking[]>([]);
@@ -90,6 +102,24 @@ export default function ExpertDashboard() {
                                 new Notification("Booking Baru!", { body: msg.message });
                             }
                         }
+                        if (msg.type === "high_risk_booking") {
+                            fetchBookings();
+                            if (Notification.permission === "granted") {
+                                new Notification("Booking Berisiko Tinggi", { body: msg.message, requireInteraction: true });
+                            }
+                        }
+                        if (msg.type === "client_waiting") {
+                            if (Notification.permission === "granted") {
+                                new Notification("Klien Menunggu", { body: msg.message });
+                            }
+                            const admit = confirm(`${msg.message}.\n\nIzinkan klien masuk ke sesi?`);
+                            handleAdmission(msg.room_id, admit);
+                        }
+                        if (msg.type === "session_reminder" && !msg.replayed) {
+                            if (Notification.permission === "granted") {
+                                new Notification("Pengingat Sesi", { body: msg.message });
+                            }
+                        }
                     } catch (e) { console.error(e); }
                 };
 
@@ -97,7 +127,11 @@ export default function ExpertDashboard() {
             };
             connectWs();
 
-            if (Notification.permission !== "granted") Notification.requestPermission();
+            if (Notification.permission === "granted") {
+                registerPush(email);
+            } else {
+                Notification.requestPermission().then((p) => { if (p === "granted") registerPush(email); });
+            }
 
             return () => { if (ws) ws.close(); };
         }
@@ -136,12 +170,34 @@ export default function ExpertDashboard() {
 
             if (res.ok) {
                 setTimeout(fetchBookings, 500);
+            } else {
+                const data = await res.json();
+                alert(data.error || "Gagal menyetujui booking");
             }
         } catch (err) {
             alert("Gagal menyetujui booking");
         }
     };
 
+    const handleAdmission = async (roomId: string, admit: boolean) => {
+        try {
+            const protocol = window.location.protocol;
+            const host = window.location.hostname;
+            const email = localStorage.getItem("expert_email") || "";
+            const res = await fetch(`${protocol}//${host}:8080/api/expert/waiting-room/${roomId}`, {
+                method: "PUT",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ email, action: admit ? "admit" : "deny" }),
+            });
+
+            if (!res.ok) {
+                alert("Gagal memproses ruang tunggu");
+            }
+        } catch (err) {
+            alert("Gagal memproses ruang tunggu");
+        }
+    };
+
     const openRejectModal = (id: number) => {
         setRejectBookingId(id);
         setRejectReason("");
@@ -183,20 +239,24 @@ export default function ExpertDashboard() {
         router.push("/");
     };
 
-    const isExpired = (scheduleTime: string) => {
+    // A session expires at the end of its booked duration (1 hour for older bookings)
+    const isExpired = (scheduleTime: string, durationMinutes = 60) => {
         const scheduleDate = new Date(scheduleTime);
         const now = new Date();
-        const diffInHours = (now.getTime() - scheduleDate.getTime()) / (1000 * 60 * 60);
-        return diffInHours > 1;
+        const diffInMinutes = (now.getTime() - scheduleDate.getTime()) / (1000 * 60);
+        return diffInMinutes > durationMinutes;
     };
 
-    const pendingBookings = bookings.filter(b => b.status === "pending");
+    // High-risk requests (crisis screening) are reviewed first
+    const pendingBookings = bookings
+        .filter(b => b.status === "pending")
+        .sort((a, b) => Number(b.risk_level === "high") - Number(a.risk_level === "high"));
     // Only show approved bookings that are NOT expired in upcoming
-    const upcomingBookings = bookings.filter(b => b.status === "approved" && !isExpired(b.schedule_time));
+    const upcomingBookings = bookings.filter(b => b.status === "approved" && !isExpired(b.schedule_time, b.duration_minutes));
     // Move expired approved sessions and completed to history
     const pastBookings = bookings.filter(b =>
         b.status === "completed" ||
-        (b.status === "approved" && isExpired(b.schedule_time))
+        (b.status === "approved" && isExpired(b.schedule_time, b.duration_minutes))
     );
 
     return (
@@ -256,19 +316,35 @@ export default function ExpertDashboard() {
                                                         <h3 className="text-white font-bold text-lg">{booking.client_name}</h3>
                                                         <p className="text-slate-400 text-sm">{booking.client_contact}</p>
                                                     </div>
-                                                    <span className="text-xs bg-slate-800 px-3 py-1 rounded-full text-slate-400 border border-slate-700">
-                                                        Pending
-                                                    </span>
+                                                    <div className="flex gap-2">
+                                                        {booking.risk_level === "high" && (
+                                                            <span className="text-xs bg-red-900/40 px-3 py-1 rounded-full text-red-300 border border-red-700">
+                                                                Risiko tinggi
+                                                            </span>
+                                                        )}
+                                                        <span className="text-xs bg-slate-800 px-3 py-1 rounded-full text-slate-400 border border-slate-700">
+                                                            Pending
+                                                        </span>
+                                                    </div>
                                                 </div>
 
+                                                <RiskReasons booking={booking} />
+
                                                 <div className="bg-slate-950/50 p-3 rounded-lg border border-slate-800/50 mb-4">
                                                     <p className="text-slate-300 text-sm italic">"{booking.complaint}"</p>
+                                                    <IntakeScores results={booking.questionnaires} />
                                                 </div>
 
                                                 <div className="flex items-center justify-between">
                                                     <div className="flex items-center gap-2 text-sky-400 text-sm font-medium">
                                                         <Clock size={16} />
                                                         {format(new Date(booking.schedule_time), "EEEE, dd MMMM - HH:mm", { locale: id })}
+                                                        {booking.duration_minutes && <span className="text-slate-400">· {booking.session_type_name || "Sesi"} ({booking.duration_minutes} menit, {booking.modality})</span>}
+                                                        {booking.payment_status === "unpaid" && <span className="text-yellow-400">· Belum dibayar</span>}
+                                                        {booking.payment_status === "paid" && <span className="text-emerald-400">· Lunas</span>}
+                                                        {booking.payment_status === "credit" && <span className="text-emerald-400">· Kredit paket</span>}
+                                                        {booking.payment_status === "sponsored" && <span className="text-emerald-400">· Sponsor</span>}
+                                                        {booking.payment_status === "refunded" && <span className="text-slate-400">· Dana dikembalikan</span>}
                                                     </div>
 
                                                     <div className="flex gap-2">
@@ -280,7 +356,9 @@ export default function ExpertDashboard() {
                                                         </button>
                                                         <button
                                                             onClick={() => handleApprove(booking.id)}
-                                                            className="px-4 py-2 rounded-lg bg-emerald-600 hover:bg-emerald-500 text-white text-sm font-medium shadow-lg shadow-emerald-600/20 flex items-center gap-2 transition-colors"
+                                                            disabled={booking.payment_status === "unpaid"}
+                                                            title={booking.payment_status === "unpaid" ? "Menunggu pembayaran klien" : undefined}
+                                                            className="px-4 py-2 rounded-lg bg-emerald-600 hover:bg-emerald-500 text-white text-sm font-medium shadow-lg shadow-emerald-600/20 flex items-center gap-2 transition-colors disabled:opacity-50 disabled:cursor-not-allowed"
                                                         >
                                                             <Check size={16} /> Setujui
                                                         </button>
@@ -327,9 +405,11 @@ export default function ExpertDashboard() {
                                                     <FileText size={12} />
                                                     {booking.complaint}
                                                 </div>
+                                                <IntakeScores results={booking.questionnaires} />
+                                                <RiskReasons booking={booking} />
                                             </div>
 
-                                            {isExpired(booking.schedule_time) ? (
+                                            {isExpired(booking.schedule_time, booking.duration_minutes) ? (
                                                 <div className="flex gap-2">
                                                     <button
                                                         onClick={() => {
@@ -375,6 +455,11 @@ export default function ExpertDashboard() {
                             </div>
                         </div>
 
+                        <div className="bg-slate-900 border border-slate-800 rounded-xl p-6">
+                            <h2 className="text-white font-semibold mb-4">Pendapatan</h2>
+                            <Earnings expertEmail={expertName} />
+                        </div>
+
                         <div className="bg-slate-900 border border-slate-800 rounded-xl p-6 h-fit max-h-[500px] overflow-y-auto custom-scrollbar">
                             <h2 className="text-white font-semibold mb-4 text-sm uppercase tracking-wider text-slate-400">Riwayat Terakhir</h2>
                             <div className="space-y-3">
@@ -587,3 +672,96 @@ function ManageSchedule({ expertEmail }: { expertEmail: string }) {
         </div>
     );
 }
+
+// Earnings for a month, with the balance owed and statement downloads
+function Earnings({ expertEmail }: { expertEmail: string }) {
+    const [month, setMonth] = useState(() => format(new Date(), "yyyy-MM"));
+    const [statement, setStatement] = useState<EarningsStatement | null>(null);
+
+    const [year, mon] = month.split("-").map(Number);
+    const from = `${month}-01`;
+    const to = format(new Date(year, mon, 0), "yyyy-MM-dd");
+
+    useEffect(() => {
+        if (!expertEmail) return;
+        fetchEarnings(expertEmail, from, to).then(setStatement).catch(() => setStatement(null));
+    }, [expertEmail, from, to]);
+
+    return (
+        <div className="space-y-3 text-sm">
+            <input
+                type="month"
+                value={month}
+                onChange={e => e.target.value && setMonth(e.target.value)}
+                className="bg-slate-950 border border-slate-700 rounded px-2 py-1 text-slate-300 text-xs"
+            />
+            {statement ? (
+                <>
+                    <div className="flex justify-between text-slate-400">
+                        <span>{statement.sessions} sesi selesai</span>
+                        <span>{rupiah(statement.gross)}</span>
+                    </div>
+                    <div className="flex justify-between text-slate-400">
+                        <span>Komisi platform</span>
+                        <span>-{rupiah(statement.commission)}</span>
+                    </div>
+                    <div className="flex justify-between text-white font-semibold">
+                        <span>Pendapatan bersih</span>
+                        <span>{rupiah(statement.net)}</span>
+                    </div>
+                    {statement.paid_out > 0 && (
+                        <div className="flex justify-between text-slate-400">
+                            <span>Sudah dibayarkan</span>
+                            <span>{rupiah(statement.paid_out)}</span>
+                        </div>
+                    )}
+                    <div className="flex justify-between text-emerald-400 border-t border-slate-800 pt-2">
+                        <span>Saldo terutang</span>
+                        <span>{rupiah(statement.closing_balance)}</span>
+                    </div>
+                    <div className="flex gap-4 pt-1">
+                        <a href={statementUrl("pdf", expertEmail, from, to)} className="text-xs text-sky-400 hover:text-sky-300 flex items-center gap-1">
+                            <FileText size={12} /> Laporan PDF
+                        </a>
+                        <a href={statementUrl("csv", expertEmail, from, to)} className="text-xs text-sky-400 hover:text-sky-300">
+                            Unduh CSV
+                        </a>
+                    </div>
+                </>
+            ) : (
+                <span className="text-xs text-slate-600">Memuat pendapatan...</span>
+            )}
+        </div>
+    );
+}
+
+// Scored intake questionnaires; hover a badge for the answers per item
+function IntakeScores({ results }: { results?: QuestionnaireResult[] }) {
+    if (!results || results.length === 0) return null;
+    return (
+        <div className="flex flex-wrap gap-2 mt-2">
+            {results.map(r => (
+                <span
+                    key={r.code}
+                    title={`Jawaban: ${r.answers.join(", ")}`}
+                    className={`text-xs px-2 py-0.5 rounded-full ${severityClass(r.severity)}`}
+                >
+                    {r.name}: {r.score}/{r.max_score} · {r.severity_label}
+                </span>
+            ))}
+        </div>
+    );
+}
+
+// Why crisis screening flagged the booking
+function RiskReasons({ booking }: { booking: Booking }) {
+    if (booking.risk_level !== "high") return null;
+    return (
+        <div className="bg-red-950/40 border border-red-800/60 p-3 rounded-lg mt-2 mb-4 text-sm text-red-200">
+            <p className="font-semibold mb-1">Skrining risiko: perlu segera ditinjau</p>
+            <ul className="list-disc list-inside text-xs space-y-0.5">
+                {(booking.risk_reasons || []).map(r => <li key={r}>{r}</li>)}
+            </ul>
+        </div>
+    );
+}
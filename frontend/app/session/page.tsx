@@ -14,6 +14,7 @@ export default function SessionPage() {
   const [expired, setExpired] = useState(false);
   const [loading, setLoading] = useState(true);
   const [userRole, setUserRole] = useState<"client" | "expert" | null>(null);
+  const [modality, setModality] = useState<"video" | "audio" | "chat">("video");
 
   useEffect(() => {
     // Basic check: Ensure room ID exists
@@ -67,19 +68,18 @@ export default function SessionPage() {
           return;
         }
 
-        // Check if session is within 1 hour of scheduled time
-        const scheduleTime = new Date(data.schedule_time);
-        const now = new Date();
-        const diffInHours = (now.getTime() - scheduleTime.getTime()) / (1000 * 60 * 60);
-
-        // Session expired if more than 1 hour has passed since scheduled time
-        if (diffInHours > 1) {
+        // Session expired once the booked duration has passed (server decides, valid_until)
+        const validUntil = data.valid_until
+          ? new Date(data.valid_until)
+          : new Date(new Date(data.schedule_time).getTime() + 60 * 60 * 1000);
+        if (new Date() > validUntil) {
           setExpired(true);
           setLoading(false);
           return;
         }
 
         // All checks passed
+        if (data.modality) setModality(data.modality);
         setAuthorized(true);
         setUserRole(role);
         setLoading(false);
@@ -111,7 +111,7 @@ export default function SessionPage() {
           </div>
           <h1 className="text-2xl font-bold text-white mb-2">Sesi Telah Berakhir</h1>
           <p className="text-slate-400 mb-6">
-            Sesi konsultasi ini sudah melewati batas waktu (1 jam setelah jadwal).
+            Sesi konsultasi ini sudah melewati batas waktu (akhir durasi sesi).
             Silakan hubungi psikolog untuk menjadwalkan ulang.
           </p>
           <button
@@ -145,7 +145,7 @@ export default function SessionPage() {
         </button>
       </div>
 
-      {roomID && userRole && <VideoRoom roomID={roomID} userRole={userRole} />}
+      {roomID && userRole && <VideoRoom roomID={roomID} userRole={userRole} modality={modality} />}
     </main>
   );
 }
@@ -1,13 +1,70 @@
 "use client";
 
+import { useEffect, useState } from "react";
 import { useSearchParams, useRouter } from "next/navigation";
-import { Coffee, ArrowRight } from "lucide-react";
+import { Coffee, ArrowRight, XCircle } from "lucide-react";
 import { motion } from "framer-motion";
 
+type AdmissionStatus = "checking" | "waiting" | "admitted" | "denied" | "error";
+
 export default function WaitingRoomPage() {
   const params = useSearchParams();
   const router = useRouter();
   const room = params.get("room");
+  const [status, setStatus] = useState<AdmissionStatus>("checking");
+
+  useEffect(() => {
+    const email = localStorage.getItem("client_email");
+    if (!room || !email) {
+      router.push("/client-login");
+      return;
+    }
+
+    const protocol = window.location.protocol;
+    const host = window.location.hostname;
+    const baseUrl = `${protocol}//${host}:8080/api/public/waiting-room/${room}`;
+    let poll: NodeJS.Timeout | null = null;
+
+    // Knock once, then poll the server-side admission status
+    const enter = async () => {
+      try {
+        const res = await fetch(baseUrl, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ email }),
+        });
+        if (!res.ok) {
+          setStatus("error");
+          return;
+        }
+        const data = await res.json();
+        setStatus(data.status);
+        poll = setInterval(checkStatus, 3000);
+      } catch (err) {
+        console.error("Failed to enter waiting room:", err);
+        setStatus("error");
+      }
+    };
+
+    const checkStatus = async () => {
+      try {
+        const res = await fetch(`${baseUrl}?email=${encodeURIComponent(email)}`);
+        if (!res.ok) return;
+        const data = await res.json();
+        if (data.status === "waiting" || data.status === "admitted" || data.status === "denied") {
+          setStatus(data.status);
+        }
+      } catch (err) {
+        console.error("Failed to check waiting room:", err);
+      }
+    };
+
+    enter();
+
+    return () => {
+      if (poll) clearInterval(poll);
+    };
+  }, [room, router]);
 
   return (
     <main className="min-h-screen flex items-center justify-center bg-slate-950 p-4">
@@ -19,25 +76,51 @@ export default function WaitingRoomPage() {
         className="relative bg-slate-900 border border-slate-800 shadow-2xl rounded-2xl p-8 w-full max-w-md text-center"
       >
         <div className="flex items-center justify-center w-16 h-16 bg-amber-500/10 rounded-2xl mb-6 mx-auto">
-          <Coffee className="w-8 h-8 text-amber-500" />
+          {status === "denied" ? (
+            <XCircle className="w-8 h-8 text-red-500" />
+          ) : (
+            <Coffee className="w-8 h-8 text-amber-500" />
+          )}
         </div>
 
         <h1 className="text-2xl font-bold text-white mb-2">Ruang Tunggu</h1>
         <p className="text-slate-400 mb-6">
-          Sesi konseling Anda telah siap. Silakan masuk ketika Anda sudah siap.
+          {status === "checking" && "Memeriksa status ruang tunggu..."}
+          {status === "waiting" && "Psikolog Anda sudah diberi tahu. Mohon tunggu hingga Anda dipersilakan masuk."}
+          {status === "admitted" && "Psikolog telah mempersilakan Anda masuk. Silakan masuk ketika Anda sudah siap."}
+          {status === "denied" && "Psikolog belum dapat menerima Anda saat ini. Anda dapat mencoba masuk lagi dalam beberapa menit."}
+          {status === "error" && "Gagal masuk ke ruang tunggu. Pastikan sesi ini milik Anda dan sudah disetujui."}
         </p>
 
         <div className="bg-slate-800/50 rounded-lg p-3 mb-8 border border-white/5 font-mono text-sm text-slate-300">
           ID: {room}
         </div>
 
-        <button
-          onClick={() => router.push(`/session?room=${room}`)}
-          className="group w-full bg-green-600 hover:bg-green-500 text-white font-medium py-3 rounded-xl transition-all flex items-center justify-center gap-2 shadow-lg shadow-green-600/20"
-        >
-          <span>Masuk Sesi Konseling</span>
-          <ArrowRight className="w-4 h-4 group-hover:translate-x-1 transition-transform" />
-        </button>
+        {status === "waiting" && (
+          <div className="flex items-center justify-center gap-2 text-amber-400 text-sm">
+            <span className="w-2 h-2 bg-amber-400 rounded-full animate-pulse"></span>
+            Menunggu psikolog...
+          </div>
+        )}
+
+        {status === "admitted" && (
+          <button
+            onClick={() => router.push(`/session?room=${room}&role=client`)}
+            className="group w-full bg-green-600 hover:bg-green-500 text-white font-medium py-3 rounded-xl transition-all flex items-center justify-center gap-2 shadow-lg shadow-green-600/20"
+          >
+            <span>Masuk Sesi Konseling</span>
+            <ArrowRight className="w-4 h-4 group-hover:translate-x-1 transition-transform" />
+          </button>
+        )}
+
+        {(status === "denied" || status === "error") && (
+          <button
+            onClick={() => router.push("/dashboard/client")}
+            className="w-full bg-sky-600 hover:bg-sky-500 text-white font-medium py-3 rounded-xl transition-colors"
+          >
+            Kembali ke Dashboard
+          </button>
+        )}
       </motion.div>
     </main>
   );
@@ -6,14 +6,23 @@ import { Mic, MicOff, Video, VideoOff, PhoneOff, User, MonitorUp } from "lucide-
 import { motion } from "framer-motion";
 import { cn } from "@/lib/utils";
 
+// Signaling protocol version spoken by this client (see backend handlers/protocol.go)
+const PROTOCOL_VERSION = 1;
+
 type SignalMessage =
-  | { type: "join" }
+  | { type: "join"; data: { version: number; role: "client" | "expert"; email: string } }
+  | { type: "joined"; data: { version: number; peers: number } }
+  | { type: "waiting"; data: { version: number; peers: number } }
+  | { type: "admitted"; data: { peers: number } }
+  | { type: "denied" }
+  | { type: "client-waiting"; data: { booking_id: number; client_name: string } }
   | { type: "peer-joined" }
   | { type: "offer"; data: RTCSessionDescriptionInit }
   | { type: "answer"; data: RTCSessionDescriptionInit }
   | { type: "candidate"; data: RTCIceCandidateInit }
   | { type: "full" }
-  | { type: "peer-left" };
+  | { type: "peer-left" }
+  | { type: "error"; data: { code: string; message: string } };
 
 const ICE_SERVERS = {
   iceServers: [
@@ -25,9 +34,10 @@ const ICE_SERVERS = {
 type Props = {
   roomID: string;
   userRole: "client" | "expert";
+  modality?: "video" | "audio" | "chat"; // From the booked session type
 };
 
-export default function VideoRoom({ roomID, userRole }: Props) {
+export default function VideoRoom({ roomID, userRole, modality = "video" }: Props) {
   const router = useRouter();
   const room = roomID;
   // ... (rest of component)
@@ -37,7 +47,7 @@ export default function VideoRoom({ roomID, userRole }: Props) {
   const [isMuted, setIsMuted] = useState(true);
   const [isCameraOff, setIsCameraOff] = useState(true);
   const [remoteStream, setRemoteStream] = useState<MediaStream | null>(null);
-  const [connectionStatus, setConnectionStatus] = useState<"connecting" | "waiting" | "connected" | "disconnected">("connecting");
+  const [connectionStatus, setConnectionStatus] = useState<"connecting" | "lobby" | "waiting" | "connected" | "disconnected">("connecting");
   const [error, setError] = useState<string | null>(null);
 
 
@@ -270,6 +280,8 @@ export default function VideoRoom({ roomID, userRole }: Props) {
 
   const startCamera = async () => {
     if (!isMountedRef.current) return false;
+    // Chat-only sessions don't use the microphone or camera
+    if (modality === "chat") return false;
     try {
       // Defensive check for Insecure Context (HTTP on non-localhost)
       // On some browsers (Chrome), navigator.mediaDevices is undefined in insecure contexts
@@ -282,7 +294,7 @@ export default function VideoRoom({ roomID, userRole }: Props) {
       }
 
       const stream = await navigator.mediaDevices.getUserMedia({
-        video: true,
+        video: modality === "video",
         audio: true,
       });
 
@@ -334,7 +346,8 @@ export default function VideoRoom({ roomID, userRole }: Props) {
 
     ws.onopen = () => {
       socketRef.current = ws;
-      setConnectionStatus("waiting");
+      const email = localStorage.getItem(userRole === "expert" ? "expert_email" : "client_email") || "";
+      ws.send(JSON.stringify({ type: "join", data: { version: PROTOCOL_VERSION, role: userRole, email } }));
 
     };
 
@@ -343,6 +356,37 @@ export default function VideoRoom({ roomID, userRole }: Props) {
 
 
       switch (msg.type) {
+        case "joined":
+        case "admitted":
+          setConnectionStatus("waiting");
+          break;
+
+        case "waiting":
+          setConnectionStatus("lobby");
+          break;
+
+        case "denied":
+          setError("Psikolog belum dapat menerima Anda saat ini. Anda dapat mencoba masuk lagi dalam beberapa menit.");
+          setConnectionStatus("disconnected");
+          ws.close();
+          break;
+
+        case "client-waiting": {
+          const admit = confirm(`${msg.data.client_name} sedang menunggu di ruang tunggu.\n\nIzinkan klien masuk?`);
+          ws.send(JSON.stringify({ type: admit ? "admit" : "deny" }));
+          break;
+        }
+
+        case "error":
+          console.warn(`Signaling error (${msg.data.code}): ${msg.data.message}`);
+          if (msg.data.code === "unsupported_version") {
+            setError("Versi aplikasi tidak didukung server. Silakan muat ulang halaman.");
+          }
+          if (msg.data.code === "session_closed") {
+            setError("Sesi ini tidak aktif karena booking belum disetujui, sudah selesai, atau dibatalkan.");
+          }
+          break;
+
         case "full":
           setError("Ruangan penuh (Maksimal 2 orang).");
           setConnectionStatus("disconnected"); // Ensure UI reflects disconnection
@@ -471,7 +515,7 @@ export default function VideoRoom({ roomID, userRole }: Props) {
         ) : (
           <div className="w-full h-full flex flex-col items-center justify-center text-white/50 space-y-4">
             {/* Status Badge */}
-            <div className={`px-4 py-1 rounded-full text-xs font-medium ${connectionStatus === "waiting" ? "bg-sky-600/20 text-sky-400" :
+            <div className={`px-4 py-1 rounded-full text-xs font-medium ${connectionStatus === "waiting" || connectionStatus === "lobby" ? "bg-sky-600/20 text-sky-400" :
               connectionStatus === "connecting" ? "bg-yellow-600/20 text-yellow-400" :
                 "bg-slate-700 text-slate-400"
               }`}>
@@ -485,12 +529,13 @@ export default function VideoRoom({ roomID, userRole }: Props) {
               </div>
             </div>
             <p className="text-xl font-medium">
+              {connectionStatus === "lobby" && "Menunggu psikolog mempersilakan Anda masuk..."}
               {connectionStatus === "waiting" && "Menunggu partisipan lain..."}
               {connectionStatus === "connecting" && "Menghubungkan..."}
               {connectionStatus === "connected" && "Menunggu video stream..."}
               {connectionStatus === "disconnected" && "Partisipan keluar"}
             </p>
-            {!localStreamRef.current && (
+            {!localStreamRef.current && modality !== "chat" && (
               <button
                 onClick={() => {
                   startCamera().then(success => {
@@ -499,7 +544,7 @@ export default function VideoRoom({ roomID, userRole }: Props) {
                 }}
                 className="mt-4 px-6 py-2 bg-blue-600 text-white rounded-lg hover:bg-blue-700 transition"
               >
-                Nyalakan Kamera
+                {modality === "audio" ? "Nyalakan Mikrofon" : "Nyalakan Kamera"}
               </button>
             )}
           </div>
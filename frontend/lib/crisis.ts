@@ -0,0 +1,15 @@
+// Crisis support returned by the booking API when risk screening flags a
+// booking (self-harm keywords in the complaint, PHQ-9 item 9, ...).
+
+export type Hotline = { name: string; phone: string; description?: string };
+
+export type CrisisSupport = {
+  message: string;
+  hotlines: Hotline[];
+};
+
+// Plain-text version for alert()
+export function crisisText(crisis: CrisisSupport): string {
+  const lines = crisis.hotlines.map(h => `• ${h.name}: ${h.phone}${h.description ? ` (${h.description})` : ""}`);
+  return `${crisis.message}\n\n${lines.join("\n")}`;
+}
@@ -0,0 +1,33 @@
+// Psychologist earnings: completed paid sessions minus platform commission,
+// and the balance still owed after payouts.
+
+export type EarningsStatement = {
+  from: string;
+  to: string;
+  sessions: number;
+  gross: number;
+  commission: number;
+  net: number;
+  opening_balance: number;
+  paid_out: number;
+  closing_balance: number;
+};
+
+const apiBase = () => `${window.location.protocol}//${window.location.hostname}:8080/api`;
+
+const periodQuery = (email: string, from?: string, to?: string) => {
+  const params = new URLSearchParams({ email });
+  if (from) params.set("from", from);
+  if (to) params.set("to", to);
+  return params.toString();
+};
+
+export async function fetchEarnings(email: string, from?: string, to?: string): Promise<EarningsStatement | null> {
+  const res = await fetch(`${apiBase()}/expert/earnings?${periodQuery(email, from, to)}`);
+  return res.ok ? res.json() : null;
+}
+
+// Download link of the statement for the same period, as CSV or PDF
+export function statementUrl(kind: "csv" | "pdf", email: string, from?: string, to?: string): string {
+  return `${apiBase()}/expert/earnings/statement.${kind}?${periodQuery(email, from, to)}`;
+}
@@ -0,0 +1,52 @@
+// Session packages: prepaid bundles of one session type. Paying a package
+// grants credits; booking that session type then uses a credit instead of
+// a payment.
+
+export type SessionPackage = {
+  id: number;
+  session_type_id: number;
+  session_type_name?: string;
+  name: string;
+  session_count: number;
+  price: number;
+  validity_days: number;
+};
+
+export type Credit = {
+  id: number;
+  package_name: string;
+  session_type_id: number;
+  session_type_name?: string;
+  psychologist_name?: string;
+  sessions_total: number;
+  sessions_used: number;
+  sessions_reserved: number;
+  remaining: number;
+  expires_at?: string;
+  expired: boolean;
+};
+
+const apiBase = () => `${window.location.protocol}//${window.location.hostname}:8080/api`;
+
+export async function fetchPackages(psychologistId: number): Promise<SessionPackage[]> {
+  const res = await fetch(`${apiBase()}/public/psychologists/${psychologistId}/packages`);
+  return res.ok ? res.json() : [];
+}
+
+export async function fetchCredits(email: string): Promise<Credit[]> {
+  const res = await fetch(`${apiBase()}/public/credits?email=${encodeURIComponent(email)}`);
+  return res.ok ? res.json() : [];
+}
+
+// Sends the client to the checkout of a package. The checkout returns to
+// the client dashboard, where the credits show up once paid.
+export async function buyPackage(packageId: number, email: string, name: string): Promise<void> {
+  const res = await fetch(`${apiBase()}/public/packages/${packageId}/purchase`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ email, name, return_url: `${window.location.origin}/dashboard/client` }),
+  });
+  const data = await res.json();
+  if (!res.ok) throw new Error(data.error || "Gagal membuat pembayaran paket");
+  window.location.href = data.payment_url;
+}
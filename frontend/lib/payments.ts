@@ -0,0 +1,20 @@
+// Sends the client to the payment provider's checkout for a priced booking.
+// The checkout returns to the client dashboard afterwards.
+
+export async function startPayment(bookingId: number, email: string): Promise<void> {
+  const apiBase = `${window.location.protocol}//${window.location.hostname}:8080/api`;
+  const res = await fetch(`${apiBase}/public/bookings/${bookingId}/payment`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ email, return_url: `${window.location.origin}/dashboard/client` }),
+  });
+  const data = await res.json();
+  if (!res.ok) throw new Error(data.error || "Gagal membuat pembayaran");
+  window.location.href = data.payment_url;
+}
+
+// PDF invoice/receipt of a paid booking, for insurance or reimbursement claims
+export function invoiceUrl(bookingId: number, email: string): string {
+  const apiBase = `${window.location.protocol}//${window.location.hostname}:8080/api`;
+  return `${apiBase}/public/bookings/${bookingId}/invoice.pdf?email=${encodeURIComponent(email)}`;
+}
@@ -0,0 +1,37 @@
+// Registers the service worker and subscribes this browser to Web Push for
+// the given email, so booking notifications arrive even when no tab is open.
+
+function urlBase64ToUint8Array(base64: string): Uint8Array {
+  const padding = "=".repeat((4 - (base64.length % 4)) % 4);
+  const raw = atob((base64 + padding).replace(/-/g, "+").replace(/_/g, "/"));
+  return Uint8Array.from(raw, (c) => c.charCodeAt(0));
+}
+
+export async function registerPush(email: string): Promise<void> {
+  if (!("serviceWorker" in navigator) || !("PushManager" in window)) return;
+  if (Notification.permission !== "granted") return;
+
+  const apiBase = `${window.location.protocol}//${window.location.hostname}:8080/api`;
+  try {
+    const keyRes = await fetch(`${apiBase}/push/vapid-public-key`);
+    if (!keyRes.ok) return; // Web Push disabled on the server
+    const { public_key } = await keyRes.json();
+
+    const registration = await navigator.serviceWorker.register("/sw.js");
+    let subscription = await registration.pushManager.getSubscription();
+    if (!subscription) {
+      subscription = await registration.pushManager.subscribe({
+        userVisibleOnly: true,
+        applicationServerKey: urlBase64ToUint8Array(public_key) as BufferSource,
+      });
+    }
+
+    await fetch(`${apiBase}/push/subscriptions`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ email, subscription: subscription.toJSON() }),
+    });
+  } catch (err) {
+    console.error("Web Push registration failed", err);
+  }
+}
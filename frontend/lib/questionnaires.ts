@@ -0,0 +1,48 @@
+// Intake questionnaires (PHQ-9, GAD-7, ...) a client can answer when
+// booking. The backend scores them; the psychologist sees score and band.
+
+export type QuestionnaireOption = { value: number; label: string; label_en: string };
+
+export type Questionnaire = {
+  code: string;
+  version: number;
+  name: string;
+  title: string;
+  title_en: string;
+  instructions: string;
+  instructions_en: string;
+  options: QuestionnaireOption[];
+  items: { id: string; text: string; text_en: string; risk_min?: number }[];
+  bands: { min: number; max: number; severity: string; label: string; label_en: string }[];
+};
+
+export type QuestionnaireResult = {
+  code: string;
+  name: string;
+  score: number;
+  max_score: number;
+  severity: string;
+  severity_label: string;
+  answers: number[];
+  created_at: string;
+};
+
+export async function fetchQuestionnaires(): Promise<Questionnaire[]> {
+  const apiBase = `${window.location.protocol}//${window.location.hostname}:8080/api`;
+  const res = await fetch(`${apiBase}/public/questionnaires`);
+  return res.ok ? res.json() : [];
+}
+
+// Badge colour per severity band
+export function severityClass(severity: string): string {
+  switch (severity) {
+    case "minimal":
+      return "bg-slate-700/50 text-slate-300";
+    case "mild":
+      return "bg-sky-900/40 text-sky-300";
+    case "moderate":
+      return "bg-amber-900/40 text-amber-300";
+    default: // moderately_severe, severe
+      return "bg-red-900/40 text-red-300";
+  }
+}
@@ -0,0 +1,31 @@
+// Employer/EAP sponsorship: sessions covered by the client's company,
+// recognised by the voucher code they enter when booking, or by a code
+// mailed to their work email.
+
+export type Sponsorship = {
+  eligible: boolean;
+};
+
+const apiBase = () => `${window.location.protocol}//${window.location.hostname}:8080/api`;
+
+// Whether the voucher code, or else the emailed code, covers the client's
+// next session; null without either code
+export async function fetchSponsorship(email: string, code: string, emailCode = ""): Promise<Sponsorship | null> {
+  if (!code && !emailCode) return null;
+  const params = new URLSearchParams(code ? { email, code } : { email, email_code: emailCode });
+  const res = await fetch(`${apiBase()}/public/sponsorship?${params}`);
+  return res.ok ? res.json() : null;
+}
+
+// Mails a verification code if the email's domain has a sponsor. The
+// answer doesn't say whether it has.
+export async function requestSponsorEmailCode(email: string): Promise<string> {
+  const res = await fetch(`${apiBase()}/public/sponsorship/email-code`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ email }),
+  });
+  const body = await res.json();
+  if (!res.ok) throw new Error(body.error || "Gagal mengirim kode");
+  return body.message;
+}
@@ -0,0 +1,32 @@
+// Service worker for Web Push notifications (see lib/push.ts)
+
+self.addEventListener("push", (event) => {
+  let payload = { title: "SafeSpace", body: "", event: "", data: {} };
+  try {
+    payload = event.data ? event.data.json() : payload;
+  } catch (e) {
+    payload.body = event.data ? event.data.text() : "";
+  }
+
+  const url = payload.event === "new_booking" || payload.event === "client_waiting" ? "/dashboard" : "/dashboard/client";
+  event.waitUntil(
+    self.registration.showNotification(payload.title, {
+      body: payload.body,
+      tag: payload.data && payload.data.notification_id ? String(payload.data.notification_id) : undefined,
+      data: { url },
+    })
+  );
+});
+
+self.addEventListener("notificationclick", (event) => {
+  event.notification.close();
+  const url = (event.notification.data && event.notification.data.url) || "/";
+  event.waitUntil(
+    self.clients.matchAll({ type: "window", includeUncontrolled: true }).then((windows) => {
+      for (const w of windows) {
+        if (new URL(w.url).pathname === url && "focus" in w) return w.focus();
+      }
+      return self.clients.openWindow(url);
+    })
+  );
+});